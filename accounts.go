@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+const (
+	// gmPlayerID - ID мастера; игрокам сервер его не выдаёт
+	gmPlayerID = "мастер"
+	// clientIdentityPath - где клиент хранит выданные серверами ID и ключи
+	clientIdentityPath = "saves/identity.json"
+)
+
+// playerAccount - игрок, которого знает сервер. ID выдаёт сервер, ключ
+// подтверждает, что переподключается тот же игрок, а не кто-то под его ID.
+// Место фишки хранится здесь же: после переподключения игрок продолжает с
+// того места, где ушёл, а не с того, что назовёт клиент.
+type playerAccount struct {
+	ID     string `json:"id"`
+	Token  string `json:"token"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	online bool   // Игрок сейчас подключён
+}
+
+// clientIdentity - ID и ключ, выданные клиенту сервером
+type clientIdentity struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
+// joinPlayer впускает подключившегося игрока: узнаёт его по ID и ключу или
+// заводит нового. ID, место фишки и ключ назначает сервер. Вызывается под g.mu.
+func (g *Game) joinPlayer(req JoinRequest) (Player, string, error) {
+	acc := g.accounts[req.ID]
+	if acc == nil || subtle.ConstantTimeCompare([]byte(acc.Token), []byte(req.Token)) != 1 {
+		var err error
+		if acc, err = g.newAccount(); err != nil {
+			return Player{}, "", err
+		}
+	} else if acc.online {
+		return Player{}, "", fmt.Errorf("игрок %s уже в игре", acc.ID)
+	}
+	acc.online = true
+
+	player := req.Player
+	player.ID = acc.ID
+	player.Map = ""
+	player.X, player.Y = acc.X, acc.Y
+	// Мир могли сгенерировать заново меньшего размера
+	if w, h := g.mapBounds(worldMapKey); w > 0 && h > 0 {
+		player.X = clamp(player.X, 0, w-1)
+		player.Y = clamp(player.Y, 0, h-1)
+	}
+	return player, acc.Token, nil
+}
+
+// newAccount заводит игрока со следующим свободным ID. Вызывается под g.mu.
+func (g *Game) newAccount() (*playerAccount, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("ключ игрока: %w", err)
+	}
+	n := len(g.accounts) + 1
+	for g.accounts[fmt.Sprintf("игрок-%d", n)] != nil {
+		n++
+	}
+	acc := &playerAccount{ID: fmt.Sprintf("игрок-%d", n), Token: hex.EncodeToString(secret)}
+	g.accounts[acc.ID] = acc
+	g.worldDirty = true
+	if debugMode {
+		log.Printf("[DEBUG] Новый игрок %s", acc.ID)
+	}
+	return acc, nil
+}
+
+// leavePlayer запоминает, где игрок ушёл. Вызывается под g.mu.
+func (g *Game) leavePlayer(p Player) {
+	if acc := g.accounts[p.ID]; acc != nil {
+		acc.X, acc.Y = p.X, p.Y
+		acc.online = false
+		g.worldDirty = true
+	}
+}
+
+// accountsSave возвращает игроков для сохранения мира с местами фишек тех,
+// кто сейчас в игре. Вызывается под g.mu.
+func (g *Game) accountsSave() []playerAccount {
+	save := make([]playerAccount, 0, len(g.accounts))
+	for _, acc := range g.accounts {
+		a := *acc
+		if p, ok := g.players[a.ID]; ok {
+			a.X, a.Y = p.X, p.Y
+		}
+		save = append(save, a)
+	}
+	return save
+}
+
+func loadAccounts(save []playerAccount) map[string]*playerAccount {
+	accounts := make(map[string]*playerAccount, len(save))
+	for i := range save {
+		if save[i].ID != "" {
+			accounts[save[i].ID] = &save[i]
+		}
+	}
+	return accounts
+}
+
+// loadClientIdentity возвращает ID и ключ, выданные сервером address; для
+// незнакомого сервера - пустые
+func loadClientIdentity(address string) clientIdentity {
+	data, err := os.ReadFile(clientIdentityPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[ERROR] Ошибка чтения %s: %v", clientIdentityPath, err)
+		}
+		return clientIdentity{}
+	}
+	var identities map[string]clientIdentity
+	if err := json.Unmarshal(data, &identities); err != nil {
+		log.Printf("[ERROR] Ошибка разбора %s: %v", clientIdentityPath, err)
+		return clientIdentity{}
+	}
+	return identities[address]
+}
+
+// saveClientIdentity запоминает ID и ключ, выданные сервером address
+func saveClientIdentity(address string, id clientIdentity) {
+	identities := make(map[string]clientIdentity)
+	if data, err := os.ReadFile(clientIdentityPath); err == nil {
+		if err := json.Unmarshal(data, &identities); err != nil {
+			log.Printf("[ERROR] Ошибка разбора %s: %v", clientIdentityPath, err)
+			identities = make(map[string]clientIdentity)
+		}
+	}
+	identities[address] = id
+	content, err := json.MarshalIndent(identities, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(clientIdentityPath, content)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Ошибка сохранения %s: %v", clientIdentityPath, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import "image/color"
+
+// Biome - тип местности на карте мира
+type Biome int
+
+const (
+	BiomeWater Biome = iota
+	BiomeSand
+	BiomeGrass
+	BiomeMountain
+	BiomeSnow
+)
+
+var biomeColors = map[Biome]color.RGBA{
+	BiomeWater:    {0, 105, 148, 255},
+	BiomeSand:     {194, 178, 128, 255},
+	BiomeGrass:    {34, 139, 34, 255},
+	BiomeMountain: {100, 100, 100, 255},
+	BiomeSnow:     {220, 220, 220, 255},
+}
+
+var biomeNames = map[Biome]string{
+	BiomeWater:    "Вода",
+	BiomeSand:     "Побережье",
+	BiomeGrass:    "Равнины",
+	BiomeMountain: "Горы",
+	BiomeSnow:     "Снега",
+}
+
+func (b Biome) String() string {
+	return biomeNames[b]
+}
+
+// biomeForValue выбирает биом по нормализованному значению шума (0..1)
+func biomeForValue(value float64) Biome {
+	switch {
+	case value < 0.4:
+		return BiomeWater
+	case value < 0.5:
+		return BiomeSand
+	case value < 0.75:
+		return BiomeGrass
+	case value < 0.95:
+		return BiomeMountain
+	default:
+		return BiomeSnow
+	}
+}
+
+// biomeFromColor восстанавливает биом по цвету тайла. Клиенты получают от
+// сервера только цвета, поэтому биом нельзя брать из noiseMap.
+func biomeFromColor(c color.Color) Biome {
+	r, g, b, _ := c.RGBA()
+	best, bestDist := BiomeGrass, -1
+	for biome, bc := range biomeColors {
+		dr := int(r>>8) - int(bc.R)
+		dg := int(g>>8) - int(bc.G)
+		db := int(b>>8) - int(bc.B)
+		dist := dr*dr + dg*dg + db*db
+		if bestDist == -1 || dist < bestDist || (dist == bestDist && biome < best) {
+			best, bestDist = biome, dist
+		}
+	}
+	return best
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"test/internal/rules"
+)
+
+// ddbFormat - JSON персонажа D&D Beyond (character-service). Ответ сервиса
+// заворачивает персонажа в {"success": true, "data": {...}}, сохранённый
+// вручную файл бывает и без обёртки.
+//
+// Производных чисел в выгрузке нет, поэтому значения характеристик, хиты и КД
+// считаются здесь по базовым значениям и модификаторам расы, класса, черт и
+// предметов. Формат только читается: правки из игры в него не записываются.
+type ddbFormat struct{}
+
+// ddbModifier - запись из modifiers: бонус, владение, компетентность, язык
+type ddbModifier struct {
+	Type                string `json:"type"`
+	SubType             string `json:"subType"`
+	Value               *int   `json:"value"`
+	FriendlySubtypeName string `json:"friendlySubtypeName"`
+}
+
+type ddbStat struct {
+	ID    int  `json:"id"`
+	Value *int `json:"value"`
+}
+
+type ddbCharacter struct {
+	Name string `json:"name"`
+	Race struct {
+		FullName     string `json:"fullName"`
+		WeightSpeeds struct {
+			Normal struct {
+				Walk int `json:"walk"`
+			} `json:"normal"`
+		} `json:"weightSpeeds"`
+	} `json:"race"`
+	Classes []struct {
+		Level       int `json:"level"`
+		HitDiceUsed int `json:"hitDiceUsed"`
+		Definition  struct {
+			Name    string `json:"name"`
+			HitDice int    `json:"hitDice"`
+		} `json:"definition"`
+	} `json:"classes"`
+	Background struct {
+		Definition struct {
+			Name string `json:"name"`
+		} `json:"definition"`
+	} `json:"background"`
+	Stats              []ddbStat                `json:"stats"`
+	BonusStats         []ddbStat                `json:"bonusStats"`
+	OverrideStats      []ddbStat                `json:"overrideStats"`
+	BaseHitPoints      int                      `json:"baseHitPoints"`
+	BonusHitPoints     *int                     `json:"bonusHitPoints"`
+	OverrideHitPoints  *int                     `json:"overrideHitPoints"`
+	RemovedHitPoints   int                      `json:"removedHitPoints"`
+	TemporaryHitPoints int                      `json:"temporaryHitPoints"`
+	Modifiers          map[string][]ddbModifier `json:"modifiers"` // race, class, background, item, feat
+	Inventory          []struct {
+		Equipped   bool `json:"equipped"`
+		Quantity   int  `json:"quantity"`
+		Definition struct {
+			Name        string        `json:"name"`
+			FilterType  string        `json:"filterType"` // Weapon, Armor, Other Gear...
+			ArmorClass  int           `json:"armorClass"`
+			ArmorTypeID int           `json:"armorTypeId"` // 1 лёгкий, 2 средний, 3 тяжёлый, 4 щит
+			AttackType  int           `json:"attackType"`  // 1 рукопашная, 2 дальнобойная
+			DamageType  string        `json:"damageType"`
+			Damage      *ddbDamage    `json:"damage"`
+			Properties  []ddbProperty `json:"properties"`
+		} `json:"definition"`
+	} `json:"inventory"`
+	Currencies Coins `json:"currencies"`
+	Notes      struct {
+		Backstory           string `json:"backstory"`
+		OtherNotes          string `json:"otherNotes"`
+		PersonalPossessions string `json:"personalPossessions"`
+	} `json:"notes"`
+	DeathSaves struct {
+		FailCount    int `json:"failCount"`
+		SuccessCount int `json:"successCount"`
+	} `json:"deathSaves"`
+	SpellSlots []struct {
+		Level     int `json:"level"`
+		Used      int `json:"used"`
+		Available int `json:"available"`
+	} `json:"spellSlots"`
+	Conditions []struct {
+		ID int `json:"id"`
+	} `json:"conditions"`
+	ClassSpells []struct {
+		Spells []ddbSpell `json:"spells"`
+	} `json:"classSpells"`
+	Spells map[string][]ddbSpell `json:"spells"` // race, class, item, feat
+}
+
+type ddbDamage struct {
+	DiceString string `json:"diceString"`
+}
+
+type ddbProperty struct {
+	Name string `json:"name"`
+}
+
+type ddbSpell struct {
+	Definition struct {
+		Name string `json:"name"`
+	} `json:"definition"`
+}
+
+// ddbAbilities - коды характеристик по id и полному названию в D&D Beyond
+var ddbAbilities = map[int]string{1: "str", 2: "dex", 3: "con", 4: "int", 5: "wis", 6: "cha"}
+
+var ddbAbilityNames = map[string]string{
+	"strength": "str", "dexterity": "dex", "constitution": "con",
+	"intelligence": "int", "wisdom": "wis", "charisma": "cha",
+}
+
+// ddbSources - откуда берутся модификаторы и заклинания, в порядке листа
+var ddbSources = []string{"race", "class", "background", "item", "feat", "condition"}
+
+func (ddbFormat) Name() string { return "ddb" }
+
+// Detect смотрит только на поля classes и stats, не собирая персонажа целиком:
+// формат проверяется у каждого загружаемого файла
+func (ddbFormat) Detect(top map[string]json.RawMessage) bool {
+	fields := top
+	if data, ok := top["data"]; ok && len(data) > 0 && data[0] == '{' {
+		fields = nil // Новая карта: top разбирают и другие форматы
+		if json.Unmarshal(data, &fields) != nil {
+			return false
+		}
+	}
+	var classes []json.RawMessage
+	var stats []ddbStat
+	return json.Unmarshal(fields["classes"], &classes) == nil && classes != nil &&
+		json.Unmarshal(fields["stats"], &stats) == nil && len(stats) > 0
+}
+
+// ddbBody возвращает персонажа из обёртки сервиса или весь файл
+func ddbBody(top map[string]json.RawMessage) []byte {
+	if data, ok := top["data"]; ok && len(data) > 0 && data[0] == '{' {
+		return data
+	}
+	body, err := json.Marshal(top)
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+func (f ddbFormat) Import(data []byte) (*Character, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	var d ddbCharacter
+	if err := json.Unmarshal(ddbBody(top), &d); err != nil {
+		return nil, fmt.Errorf("ошибка разбора персонажа: %w", err)
+	}
+
+	char := &Character{
+		Name:             d.Name,
+		Race:             d.Race.FullName,
+		Background:       d.Background.Definition.Name,
+		Stats:            make(map[string]int),
+		SaveProficiency:  make(map[string]rules.Proficiency),
+		SkillProficiency: make(map[string]rules.Proficiency),
+		Description:      d.Notes.Backstory,
+		Notes:            d.Notes.OtherNotes,
+		Coins:            d.Currencies,
+		DeathSaves:       DeathSaves{Successes: d.DeathSaves.SuccessCount, Failures: d.DeathSaves.FailCount},
+	}
+
+	// Класс, уровень и кости хитов по всем классам
+	var classes, hitDice []string
+	for _, c := range d.Classes {
+		char.Level += c.Level
+		name := c.Definition.Name
+		if len(d.Classes) > 1 {
+			name += " " + strconv.Itoa(c.Level)
+		}
+		classes = append(classes, name)
+		if c.Definition.HitDice > 0 {
+			hitDice = append(hitDice, fmt.Sprintf("%dd%d", c.Level-c.HitDiceUsed, c.Definition.HitDice))
+		}
+	}
+	char.Class = strings.Join(classes, " / ")
+	char.HitDice = strings.Join(hitDice, " + ")
+
+	var modifiers []ddbModifier
+	for _, source := range ddbSources {
+		modifiers = append(modifiers, d.Modifiers[source]...)
+	}
+
+	// Характеристики: базовое значение, бонусы и модификаторы; ручное значение важнее
+	for _, s := range d.Stats {
+		if code := ddbAbilities[s.ID]; code != "" && s.Value != nil {
+			char.Stats[code] = *s.Value
+		}
+	}
+	for _, s := range d.BonusStats {
+		if code := ddbAbilities[s.ID]; code != "" && s.Value != nil {
+			char.Stats[code] += *s.Value
+		}
+	}
+	for _, m := range modifiers {
+		if name, ok := strings.CutSuffix(m.SubType, "-score"); ok && m.Type == "bonus" && m.Value != nil {
+			if code := ddbAbilityNames[name]; code != "" {
+				char.Stats[code] += *m.Value
+			}
+		}
+	}
+	for _, s := range d.OverrideStats {
+		if code := ddbAbilities[s.ID]; code != "" && s.Value != nil {
+			char.Stats[code] = *s.Value
+		}
+	}
+
+	f.importProficiencies(char, modifiers)
+
+	// Хиты: база класса, Телосложение за каждый уровень и бонусы вроде черты Крепкий
+	maxHP := d.BaseHitPoints + rules.Modifier(char.Stats["con"])*char.Level
+	if d.BonusHitPoints != nil {
+		maxHP += *d.BonusHitPoints
+	}
+	for _, m := range modifiers {
+		if m.Type == "bonus" && m.SubType == "hit-points-per-level" && m.Value != nil {
+			maxHP += *m.Value * char.Level
+		}
+	}
+	if d.OverrideHitPoints != nil {
+		maxHP = *d.OverrideHitPoints
+	}
+	char.MaxHP = strconv.Itoa(maxHP)
+	char.HP = strconv.Itoa(max(maxHP-d.RemovedHitPoints, 0))
+	char.TempHP = strconv.Itoa(d.TemporaryHitPoints)
+	if walk := d.Race.WeightSpeeds.Normal.Walk; walk > 0 {
+		char.Speed = strconv.Itoa(walk)
+	}
+
+	// Снаряжение, оружие и КД по надетым доспехам
+	dex := rules.Modifier(char.Stats["dex"])
+	ac, shield := 10+dex, 0
+	prof := rules.ProficiencyBonus(char.Level)
+	for _, item := range d.Inventory {
+		def := item.Definition
+		switch {
+		case def.FilterType == "Armor" && item.Equipped && def.ArmorTypeID == 4:
+			shield = def.ArmorClass
+		case def.FilterType == "Armor" && item.Equipped:
+			switch def.ArmorTypeID {
+			case 1:
+				ac = def.ArmorClass + dex
+			case 2:
+				ac = def.ArmorClass + min(dex, 2)
+			default:
+				ac = def.ArmorClass
+			}
+		}
+		if def.FilterType == "Weapon" {
+			char.Weapons = append(char.Weapons, ddbWeapon(def.Name, def.AttackType == 2, ddbFinesse(def.Properties),
+				def.Damage, def.DamageType, char.Stats, prof))
+			continue
+		}
+		name := def.Name
+		if item.Quantity > 1 {
+			name = fmt.Sprintf("%s x%d", name, item.Quantity)
+		}
+		char.Equipment = append(char.Equipment, name)
+	}
+	for _, m := range modifiers {
+		if m.Type == "bonus" && m.SubType == "armor-class" && m.Value != nil {
+			ac += *m.Value
+		}
+	}
+	char.AC = strconv.Itoa(ac + shield)
+	if d.Notes.PersonalPossessions != "" {
+		char.Equipment = append(char.Equipment, d.Notes.PersonalPossessions)
+	}
+
+	// Заклинания, ячейки и состояния; id состояний идут в порядке rules.Conditions
+	for _, cs := range d.ClassSpells {
+		for _, s := range cs.Spells {
+			char.Spells = append(char.Spells, s.Definition.Name)
+		}
+	}
+	for _, source := range ddbSources {
+		for _, s := range d.Spells[source] {
+			char.Spells = append(char.Spells, s.Definition.Name)
+		}
+	}
+	for _, s := range d.SpellSlots {
+		if s.Available > 0 {
+			char.SpellSlots = append(char.SpellSlots, SpellSlot{Level: s.Level, Total: s.Available, Used: clamp(s.Used, 0, s.Available)})
+		}
+	}
+	for _, c := range d.Conditions {
+		if c.ID >= 1 && c.ID <= len(rules.Conditions) {
+			char.Conditions = append(char.Conditions, rules.Conditions[c.ID-1].Code)
+		}
+	}
+	return char, nil
+}
+
+// importProficiencies раскладывает владения из модификаторов: спасброски,
+// навыки с компетентностью и мастером на все руки, прочие владения и языки
+func (ddbFormat) importProficiencies(char *Character, modifiers []ddbModifier) {
+	var languages, other []string
+	halfSkills := false
+	for _, m := range modifiers {
+		if name, ok := strings.CutSuffix(m.SubType, "-saving-throws"); ok && m.Type == "proficiency" {
+			if code := ddbAbilityNames[name]; code != "" {
+				char.SaveProficiency[code] = rules.Proficient
+			}
+			continue
+		}
+		skill := strings.ReplaceAll(m.SubType, "-", " ")
+		_, isSkill := rules.SkillAbility(skill)
+		switch {
+		case m.Type == "expertise" && isSkill:
+			char.SkillProficiency[skill] = rules.Expertise
+		case m.Type == "proficiency" && isSkill:
+			if char.SkillProficiency[skill] < rules.Proficient {
+				char.SkillProficiency[skill] = rules.Proficient
+			}
+		case m.Type == "half-proficiency" && m.SubType == "ability-checks":
+			halfSkills = true
+		case m.Type == "proficiency":
+			other = append(other, m.FriendlySubtypeName)
+		case m.Type == "language":
+			languages = append(languages, m.FriendlySubtypeName)
+		}
+	}
+	for _, skill := range rules.Skills {
+		p := char.SkillProficiency[skill.Code]
+		if halfSkills && p == rules.NotProficient {
+			char.SkillProficiency[skill.Code] = rules.HalfProficient
+		}
+		switch p {
+		case rules.Proficient:
+			char.Skills = append(char.Skills, skill.Name)
+		case rules.Expertise:
+			char.Skills = append(char.Skills, skill.Name+" (компетентность)")
+		}
+	}
+	if len(other) > 0 {
+		char.Proficiencies = append(char.Proficiencies, "Владения: "+strings.Join(other, ", "))
+	}
+	if len(languages) > 0 {
+		char.Proficiencies = append(char.Proficiencies, "Языки: "+strings.Join(languages, ", "))
+	}
+}
+
+func ddbFinesse(props []ddbProperty) bool {
+	for _, p := range props {
+		if p.Name == "Finesse" {
+			return true
+		}
+	}
+	return false
+}
+
+// ddbWeapon считает бонус атаки и урон оружия. Владение оружием считается
+// всегда: в выгрузке оно задано группами оружия, которые здесь не разбираются.
+func ddbWeapon(name string, ranged, finesse bool, damage *ddbDamage, damageType string, stats map[string]int, prof int) Weapon {
+	mod := rules.Modifier(stats["str"])
+	switch dex := rules.Modifier(stats["dex"]); {
+	case ranged:
+		mod = dex
+	case finesse:
+		mod = max(mod, dex)
+	}
+	w := Weapon{Name: name, Attack: formatBonus(mod + prof)}
+	if damage != nil && damage.DiceString != "" {
+		w.Damage = damage.DiceString
+		if mod != 0 {
+			w.Damage += formatBonus(mod)
+		}
+		if damageType != "" {
+			w.Damage += " " + damageType
+		}
+	}
+	return w
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"test/internal/rules"
+)
+
+// testdata/ddb.json - ответ сервиса D&D Beyond в обёртке {"data": ...}:
+// воин 3 / плут 2 с бонусами характеристик, ручным значением Харизмы,
+// доспехами со щитом и чертой на хиты
+func TestImportDDB(t *testing.T) {
+	char, err := (&Game{}).loadCharacterFromFile("testdata/ddb.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if char.Format != "ddb" {
+		t.Fatalf("формат %q, ожидался ddb", char.Format)
+	}
+	checkDDBCharacter(t, char)
+}
+
+// Сохранённый вручную файл без обёртки сервиса читается так же
+func TestImportDDBUnwrapped(t *testing.T) {
+	content, err := os.ReadFile("testdata/ddb.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wrapper struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(content, &wrapper); err != nil {
+		t.Fatal(err)
+	}
+	format, err := detectCharacterFormat(wrapper.Data)
+	if err != nil || format.Name() != "ddb" {
+		t.Fatalf("формат %v, ошибка %v; ожидался ddb", format, err)
+	}
+	char, err := format.Import(wrapper.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDDBCharacter(t, char)
+}
+
+func checkDDBCharacter(t *testing.T, char *Character) {
+	t.Helper()
+
+	if char.Name != "Бренна" || char.Race != "Горный дварф" || char.Background != "Солдат" {
+		t.Errorf("персонаж %q, %q, %q", char.Name, char.Race, char.Background)
+	}
+	if char.Class != "Fighter 3 / Rogue 2" || char.Level != 5 || char.HitDice != "2d10 + 2d8" {
+		t.Errorf("класс %q, уровень %d, кости хитов %q", char.Class, char.Level, char.HitDice)
+	}
+
+	// База + bonusStats + модификаторы "-score"; overrideStats заменяет всё
+	wantStats := map[string]int{"str": 17, "dex": 14, "con": 14, "int": 10, "wis": 12, "cha": 10}
+	if !reflect.DeepEqual(char.Stats, wantStats) {
+		t.Errorf("характеристики %v, ожидалось %v", char.Stats, wantStats)
+	}
+
+	// 30 + Телосложение 2 за 5 уровней + 3 бонуса + 1 за уровень от черты
+	if char.MaxHP != "48" || char.HP != "38" || char.TempHP != "4" || char.Speed != "25" {
+		t.Errorf("хиты %s/%s (+%s), скорость %s", char.HP, char.MaxHP, char.TempHP, char.Speed)
+	}
+	// Тяжёлый доспех 16 без Ловкости, щит 2 и бонус предмета 1; кожаный не надет
+	if char.AC != "19" {
+		t.Errorf("КД %s, ожидалось 19", char.AC)
+	}
+
+	wantSaves := map[string]rules.Proficiency{"str": rules.Proficient, "con": rules.Proficient}
+	if !reflect.DeepEqual(char.SaveProficiency, wantSaves) {
+		t.Errorf("спасброски %v, ожидалось %v", char.SaveProficiency, wantSaves)
+	}
+	// Мастер на все руки даёт половину владения остальным навыкам
+	for skill, want := range map[string]rules.Proficiency{
+		"athletics":       rules.Expertise,
+		"sleight of hand": rules.Proficient,
+		"perception":      rules.HalfProficient,
+	} {
+		if got := char.SkillProficiency[skill]; got != want {
+			t.Errorf("владение навыком %s = %d, ожидалось %d", skill, got, want)
+		}
+	}
+	if want := []string{"Атлетика (компетентность)", "Ловкость рук"}; !reflect.DeepEqual(char.Skills, want) {
+		t.Errorf("навыки %q, ожидалось %q", char.Skills, want)
+	}
+	if want := []string{"Владения: Воинское оружие", "Языки: Дварфийский, Общий"}; !reflect.DeepEqual(char.Proficiencies, want) {
+		t.Errorf("владения %q, ожидалось %q", char.Proficiencies, want)
+	}
+
+	// Фехтовальное оружие берёт лучшую из Силы и Ловкости, дальнобойное - Ловкость
+	wantWeapons := []Weapon{
+		{Name: "Rapier", Attack: "+6", Damage: "1d8+3 Piercing"},
+		{Name: "Longbow", Attack: "+5", Damage: "1d8+2 Piercing"},
+	}
+	if !reflect.DeepEqual(char.Weapons, wantWeapons) {
+		t.Errorf("оружие %+v, ожидалось %+v", char.Weapons, wantWeapons)
+	}
+	wantEquipment := []string{"Chain Mail", "Shield", "Leather", "Rope, Hempen (50 feet) x2", "Письмо от сестры"}
+	if !reflect.DeepEqual(char.Equipment, wantEquipment) {
+		t.Errorf("снаряжение %q, ожидалось %q", char.Equipment, wantEquipment)
+	}
+
+	if want := (Coins{CP: 5, GP: 12}); char.Coins != want {
+		t.Errorf("монеты %+v, ожидалось %+v", char.Coins, want)
+	}
+	if want := (DeathSaves{Successes: 2, Failures: 1}); char.DeathSaves != want {
+		t.Errorf("спасброски от смерти %+v, ожидалось %+v", char.DeathSaves, want)
+	}
+	if want := []SpellSlot{{Level: 1, Total: 2, Used: 2}}; !reflect.DeepEqual(char.SpellSlots, want) {
+		t.Errorf("ячейки %+v, ожидалось %+v", char.SpellSlots, want)
+	}
+	if want := []string{"Shield", "Light"}; !reflect.DeepEqual(char.Spells, want) {
+		t.Errorf("заклинания %q, ожидалось %q", char.Spells, want)
+	}
+	// id состояний - номера в rules.Conditions с единицы, незнакомые пропускаются
+	if want := []string{"blinded", "poisoned"}; !reflect.DeepEqual(char.Conditions, want) {
+		t.Errorf("состояния %q, ожидалось %q", char.Conditions, want)
+	}
+	if char.Description != "Служила в гарнизоне." || char.Notes != "Не любит эльфов." {
+		t.Errorf("описание %q, заметки %q", char.Description, char.Notes)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"image/color"
+	"log"
+	"slices"
+	"strconv"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const (
+	maxSheetNotes   = 4000
+	slotBoxSize     = 20
+	conditionChipW  = 130
+	conditionChipH  = 22
+	sheetNotesLineH = 18
+)
+
+// sheetButton - кнопка листа персонажа; active подсвечивает включённое
+// состояние, color задаёт цвет вместо обычного
+type sheetButton struct {
+	interiorButton
+	active bool
+	color  color.Color
+}
+
+// changeHP меняет текущие хиты в пределах от нуля до максимума
+func (c *Character) changeHP(delta int) {
+	hp := max(lssInt(c.HP)+delta, 0)
+	if maxHP := lssInt(c.MaxHP); maxHP > 0 {
+		hp = min(hp, maxHP)
+	}
+	c.HP = strconv.Itoa(hp)
+	c.dirty = true
+}
+
+// changeTempHP меняет временные хиты; меньше нуля их не бывает
+func (c *Character) changeTempHP(delta int) {
+	c.TempHP = strconv.Itoa(max(lssInt(c.TempHP)+delta, 0))
+	c.dirty = true
+}
+
+// toggleSlot отмечает ячейку потраченной или, если она последняя
+// потраченная, возвращает её
+func (c *Character) toggleSlot(level, box int) {
+	for i := range c.SpellSlots {
+		slot := &c.SpellSlots[i]
+		if slot.Level != level {
+			continue
+		}
+		if slot.Used == box+1 {
+			slot.Used = box
+		} else {
+			slot.Used = box + 1
+		}
+		c.dirty = true
+	}
+}
+
+func (c *Character) toggleCondition(code string) {
+	if i := slices.Index(c.Conditions, code); i >= 0 {
+		c.Conditions = slices.Delete(c.Conditions, i, i+1)
+	} else {
+		c.Conditions = append(c.Conditions, code)
+	}
+	c.dirty = true
+}
+
+// saveCharacterIfDirty записывает лист, если его правили; результат виден в окне
+func (g *Game) saveCharacterIfDirty(s *characterScene, char *Character) {
+	if char == nil || !char.dirty {
+		return
+	}
+	if err := saveCharacter(char); err != nil {
+		log.Printf("[ERROR] Ошибка сохранения персонажа %s: %v", char.Name, err)
+		s.status = "Не сохранено: " + err.Error()
+		return
+	}
+	s.status = "Сохранено в " + char.Path
+}
+
+// closeCharacterWindow записывает правки и закрывает окно персонажа
+func (g *Game) closeCharacterWindow(s *characterScene) {
+	g.saveCharacterIfDirty(s, g.currentCharacter)
+	if debugMode {
+		log.Printf("[DEBUG] Закрытие окна персонажа")
+	}
+	g.scenes.Pop()
+}
+
+// drawSheetButton рисует кнопку со сдвигом ox, oy: пустая подпись - клетка
+// ячейки заклинаний, active - включённое состояние
+func drawSheetButton(g *Game, dst *ebiten.Image, btn sheetButton, ox, oy int) {
+	var clr color.Color = color.RGBA{70, 70, 90, 255}
+	switch {
+	case btn.active && btn.label == "":
+		clr = color.RGBA{120, 120, 140, 255} // Потраченная ячейка
+	case btn.active:
+		clr = color.RGBA{150, 60, 60, 255}
+	case btn.color != nil:
+		clr = btn.color
+	}
+	x, y := btn.x+ox, btn.y+oy
+	ebitenutil.DrawRect(dst, float64(x), float64(y), float64(btn.w), float64(btn.h), clr)
+	if btn.label == "" {
+		drawRectOutline(dst, float64(x), float64(y), float64(btn.w), float64(btn.h), 1, color.RGBA{200, 200, 220, 255})
+		return
+	}
+	text.Draw(dst, truncateText(btn.label, btn.w-8, g), g.font, x+4, y+btn.h-6, color.White)
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"test/internal/pdf"
+	"test/internal/rules"
+)
+
+// exportDir - куда по умолчанию выгружаются листы для печати
+const exportDir = "exports"
+
+// sheetView - лист персонажа, подготовленный для печати: всё посчитано и
+// разложено по строкам, шаблону HTML и раскладке PDF остаётся только вывести
+type sheetView struct {
+	*Character
+	Derived    rules.Derived
+	Abilities  []abilityView
+	SkillLines []skillView
+	Passive    int
+	Spellcast  []string // Характеристика, сложность и атака заклинаний
+	Slots      string
+	CoinLine   string
+	Portrait   template.URL
+}
+
+type abilityView struct {
+	Name      string
+	Score     int
+	Mod, Save string
+	SaveMark  string
+}
+
+type skillView struct {
+	Name, Ability, Bonus, Mark string
+}
+
+func newSheetView(char *Character) sheetView {
+	d := char.Derived()
+	v := sheetView{Character: char, Derived: d, Passive: d.Passive["perception"]}
+	for _, code := range rules.Abilities {
+		v.Abilities = append(v.Abilities, abilityView{
+			Name:     rules.AbilityNames[code],
+			Score:    char.Stats[code],
+			Mod:      formatBonus(d.Modifiers[code]),
+			Save:     formatBonus(d.Saves[code]),
+			SaveMark: proficiencyMark(char.SaveProficiency[code]),
+		})
+	}
+	for _, skill := range rules.Skills {
+		v.SkillLines = append(v.SkillLines, skillView{
+			Name:    skill.Name,
+			Ability: abilityShort(skill.Ability),
+			Bonus:   formatBonus(d.Skills[skill.Code]),
+			Mark:    proficiencyMark(char.SkillProficiency[skill.Code]),
+		})
+	}
+	if d.SpellAbility != "" {
+		v.Spellcast = []string{
+			"Характеристика: " + rules.AbilityNames[d.SpellAbility],
+			fmt.Sprintf("Сложность спасброска: %d", d.SpellSaveDC),
+			"Бонус атаки: " + formatBonus(d.SpellAttack),
+		}
+	}
+	var slots []string
+	for _, s := range char.SpellSlots {
+		slots = append(slots, fmt.Sprintf("%d круг: %d/%d", s.Level, s.Total-s.Used, s.Total))
+	}
+	v.Slots = strings.Join(slots, ", ")
+
+	v.CoinLine = coinLine(char.Coins)
+	if len(char.Portrait) > 0 {
+		v.Portrait = template.URL("data:" + http.DetectContentType(char.Portrait) + ";base64," +
+			base64.StdEncoding.EncodeToString(char.Portrait))
+	}
+	return v
+}
+
+// coinLine - монеты одной строкой, от платины к меди; пусто, если монет нет
+func coinLine(c Coins) string {
+	var coins []string
+	for _, coin := range []struct {
+		name string
+		n    int
+	}{{"пм", c.PP}, {"зм", c.GP}, {"эм", c.EP}, {"см", c.SP}, {"мм", c.CP}} {
+		if coin.n != 0 {
+			coins = append(coins, fmt.Sprintf("%d %s", coin.n, coin.name))
+		}
+	}
+	return strings.Join(coins, ", ")
+}
+
+// abilityShort - сокращение характеристики: Сил, Лов...
+func abilityShort(code string) string {
+	name := []rune(rules.AbilityNames[code])
+	return string(name[:min(len(name), 3)])
+}
+
+// ConditionNames - названия состояний персонажа через запятую
+func (v sheetView) ConditionNames() string {
+	names := make([]string, 0, len(v.Conditions))
+	for _, c := range v.Conditions {
+		names = append(names, rules.ConditionName(c))
+	}
+	return strings.Join(names, ", ")
+}
+
+// exportFileName - имя файла выгрузки по имени персонажа без символов,
+// которые нельзя использовать в путях. Если файл листа назван иначе, его имя
+// добавляется в скобках, чтобы тёзки не затирали выгрузки друг друга.
+func exportFileName(char *Character) string {
+	name := safeFileName(char.Name)
+	if name == "" {
+		name = "персонаж"
+	}
+	if char.Path != "" {
+		stem := safeFileName(strings.TrimSuffix(filepath.Base(char.Path), filepath.Ext(char.Path)))
+		if stem != "" && stem != name {
+			name += " (" + stem + ")"
+		}
+	}
+	return name
+}
+
+// safeFileName заменяет недопустимые в путях символы; имя из одних точек
+// ("." и "..") вывело бы за пределы каталога, для него возвращается пустая строка
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if strings.Trim(name, ".") == "" {
+		return ""
+	}
+	return name
+}
+
+// exportCharacter пишет лист в каталог dir; format - html, pdf или all.
+// Возвращает пути записанных файлов.
+func exportCharacter(char *Character, dir, format string) ([]string, error) {
+	base := filepath.Join(dir, exportFileName(char))
+	var paths []string
+	if format == "html" || format == "all" {
+		content, err := characterHTML(char)
+		if err != nil {
+			return paths, fmt.Errorf("HTML: %w", err)
+		}
+		if err := writeFileAtomic(base+".html", content); err != nil {
+			return paths, err
+		}
+		paths = append(paths, base+".html")
+	}
+	if format == "pdf" || format == "all" {
+		fontData, err := os.ReadFile(fontPath)
+		if err != nil {
+			return paths, fmt.Errorf("шрифт для PDF: %w", err)
+		}
+		content, err := characterPDF(char, fontData)
+		if err != nil {
+			return paths, fmt.Errorf("PDF: %w", err)
+		}
+		if err := writeFileAtomic(base+".pdf", content); err != nil {
+			return paths, err
+		}
+		paths = append(paths, base+".pdf")
+	}
+	if paths == nil {
+		return nil, fmt.Errorf("неизвестный формат выгрузки %q", format)
+	}
+	return paths, nil
+}
+
+// exportCurrentCharacter выгружает открытый лист из окна персонажа
+func (g *Game) exportCurrentCharacter(s *characterScene) {
+	char := g.currentCharacter
+	if char == nil {
+		return
+	}
+	paths, err := exportCharacter(char, exportDir, "all")
+	if err != nil {
+		log.Printf("[ERROR] Ошибка выгрузки персонажа %s: %v", char.Name, err)
+		s.status = "Не выгружено: " + err.Error()
+		return
+	}
+	log.Printf("Лист %s выгружен: %s", char.Name, strings.Join(paths, ", "))
+	s.status = "Выгружено в " + exportDir
+}
+
+// runExport выгружает все листы из characters без окна игры:
+//
+//	game export [-out exports] [-format all|html|pdf]
+//
+// Возвращает код выхода.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := fs.String("out", exportDir, "каталог для выгрузки")
+	format := fs.String("format", "all", "формат: html, pdf или all")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	g := &Game{}
+	if err := g.loadAllCharacters(); err != nil {
+		log.Printf("[ERROR] Ошибка загрузки персонажей: %v", err)
+		return 1
+	}
+	code := 0
+	for _, char := range g.characters {
+		paths, err := exportCharacter(char, *out, *format)
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		if err != nil {
+			log.Printf("[ERROR] Ошибка выгрузки персонажа %s: %v", char.Name, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// characterHTML - лист для печати из браузера
+func characterHTML(char *Character) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := sheetTemplate.Execute(&buf, newSheetView(char)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var sheetTemplate = template.Must(template.New("sheet").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+@page { size: A4; margin: 12mm; }
+body { font-family: "Noto Sans", Arial, sans-serif; font-size: 10pt; color: #000; margin: 0; }
+h1 { font-size: 18pt; margin: 0; }
+h2 { font-size: 10pt; text-transform: uppercase; margin: 0 0 4px; border-bottom: 1px solid #000; }
+.header { display: flex; gap: 12px; align-items: center; border: 2px solid #000; padding: 6px 10px; margin-bottom: 8px; }
+.header img { width: 72px; height: 72px; object-fit: cover; border: 1px solid #000; }
+.sheet { display: grid; grid-template-columns: 90px 1fr 1.4fr; gap: 8px; }
+.box { border: 1px solid #000; border-radius: 4px; padding: 4px 6px; margin-bottom: 8px; break-inside: avoid; }
+.ability { text-align: center; }
+.ability .mod { font-size: 18pt; font-weight: bold; }
+.ability .name { font-size: 8pt; text-transform: uppercase; }
+.row { display: flex; justify-content: space-between; }
+.stats { display: flex; gap: 6px; }
+.stats .box { flex: 1; text-align: center; }
+.stats .value { font-size: 14pt; font-weight: bold; }
+table { width: 100%; border-collapse: collapse; }
+td, th { text-align: left; padding: 1px 2px; }
+ul { margin: 0; padding-left: 16px; }
+.text { white-space: pre-wrap; }
+.page { break-before: page; }
+</style>
+</head>
+<body>
+<div class="header">
+{{if .Portrait}}<img src="{{.Portrait}}" alt="">{{end}}
+<div>
+<h1>{{.Name}}</h1>
+<div>{{.Class}}, {{.Level}} уровень{{if .Race}} · {{.Race}}{{end}}{{if .Background}} · {{.Background}}{{end}}</div>
+</div>
+</div>
+<div class="sheet">
+<div>
+{{range .Abilities}}<div class="box ability"><div class="name">{{.Name}}</div><div class="mod">{{.Mod}}</div><div>{{.Score}}</div></div>
+{{end}}</div>
+<div>
+<div class="box row"><span>Бонус мастерства</span><b>+{{.Derived.Proficiency}}</b></div>
+<div class="box"><h2>Спасброски</h2>
+{{range .Abilities}}<div class="row"><span>{{.Name}}</span><span>{{.Save}}{{.SaveMark}}</span></div>
+{{end}}</div>
+<div class="box"><h2>Навыки</h2>
+{{range .SkillLines}}<div class="row"><span>{{.Name}} <small>({{.Ability}})</small></span><span>{{.Bonus}}{{.Mark}}</span></div>
+{{end}}</div>
+<div class="box row"><span>Пассивная внимательность</span><b>{{.Passive}}</b></div>
+</div>
+<div>
+<div class="stats">
+<div class="box">КД<div class="value">{{.AC}}</div></div>
+<div class="box">Инициатива<div class="value">{{printf "%+d" .Derived.Initiative}}</div></div>
+<div class="box">Скорость<div class="value">{{.Speed}}</div></div>
+</div>
+<div class="box"><h2>Хиты</h2>
+<div class="row"><span>Текущие</span><b>{{.HP}}{{if .MaxHP}} / {{.MaxHP}}{{end}}</b></div>
+<div class="row"><span>Временные</span><span>{{.TempHP}}</span></div>
+<div class="row"><span>Кости хитов</span><span>{{.HitDice}}</span></div>
+<div class="row"><span>Спасброски от смерти</span><span>успехи {{.DeathSaves.Successes}}, провалы {{.DeathSaves.Failures}}</span></div>
+{{with .ConditionNames}}<div class="row"><span>Состояния</span><span>{{.}}</span></div>{{end}}
+</div>
+<div class="box"><h2>Атаки</h2>
+<table>
+<tr><th>Оружие</th><th>Атака</th><th>Урон</th></tr>
+{{range .Weapons}}<tr><td>{{.Name}}</td><td>{{.Attack}}</td><td>{{.Damage}}</td></tr>
+{{end}}</table>
+</div>
+<div class="box"><h2>Снаряжение</h2>
+{{with .CoinLine}}<div>{{.}}</div>{{end}}
+<ul>{{range .Equipment}}<li>{{.}}</li>{{end}}</ul>
+</div>
+{{if .Proficiencies}}<div class="box"><h2>Владения и языки</h2>
+{{range .Proficiencies}}<div>{{.}}</div>{{end}}
+</div>{{end}}
+</div>
+</div>
+<div class="page">
+{{if or .Spells .Spellcast}}<div class="box"><h2>Заклинания</h2>
+{{range .Spellcast}}<div>{{.}}</div>{{end}}
+{{with .Slots}}<div>Ячейки: {{.}}</div>{{end}}
+<ul>{{range .Spells}}<li>{{.}}</li>{{end}}</ul>
+</div>{{end}}
+{{if .Skills}}<div class="box"><h2>Умения</h2><ul>{{range .Skills}}<li>{{.}}</li>{{end}}</ul></div>{{end}}
+{{with .Description}}<div class="box"><h2>Описание</h2><div class="text">{{.}}</div></div>{{end}}
+{{with .Notes}}<div class="box"><h2>Заметки</h2><div class="text">{{.}}</div></div>{{end}}
+</div>
+</body>
+</html>
+`))
+
+// Раскладка PDF в пунктах
+const (
+	pdfMargin   = 36.0
+	pdfLineH    = 12.0
+	pdfTextSize = 9.0
+)
+
+// characterPDF - лист на A4 по образцу стандартного: характеристики слева,
+// спасброски и навыки в середине, бой и снаряжение справа; заклинания,
+// описание и заметки на следующих страницах
+func characterPDF(char *Character, fontData []byte) ([]byte, error) {
+	doc, err := pdf.New(fontData)
+	if err != nil {
+		return nil, err
+	}
+	v := newSheetView(char)
+	page := doc.AddPage()
+	right := pdf.A4Width - pdfMargin
+
+	// Шапка
+	page.Rect(pdfMargin, pdfMargin, right-pdfMargin, 56, 1.5)
+	page.Text(pdfMargin+10, pdfMargin+24, 18, v.Name)
+	info := fmt.Sprintf("%s, %d уровень", v.Class, v.Level)
+	for _, s := range []string{v.Race, v.Background} {
+		if s != "" {
+			info += " · " + s
+		}
+	}
+	page.Text(pdfMargin+10, pdfMargin+44, 10, info)
+
+	// Характеристики
+	top := pdfMargin + 68
+	for i, a := range v.Abilities {
+		y := top + float64(i)*70
+		page.Rect(pdfMargin, y, 80, 62, 1)
+		centerText(doc, page, pdfMargin+40, y+12, 7, strings.ToUpper(a.Name))
+		centerText(doc, page, pdfMargin+40, y+36, 18, a.Mod)
+		centerText(doc, page, pdfMargin+40, y+54, 10, fmt.Sprint(a.Score))
+	}
+
+	// Мастерство, спасброски и навыки
+	x, w := pdfMargin+90, 170.0
+	y := top
+	pdfRow(doc, page, x, y, w, "Бонус мастерства", formatBonus(v.Derived.Proficiency))
+	page.Rect(x, y, w, 18, 1)
+	y += 26
+	y = pdfBox(page, x, y, w, "Спасброски", func(y float64) float64 {
+		for _, a := range v.Abilities {
+			pdfRow(doc, page, x+6, y, w-12, a.Name, a.Save+a.SaveMark)
+			y += pdfLineH
+		}
+		return y
+	})
+	y = pdfBox(page, x, y, w, "Навыки", func(y float64) float64 {
+		for _, s := range v.SkillLines {
+			pdfRow(doc, page, x+6, y, w-12, s.Name+" ("+s.Ability+")", s.Bonus+s.Mark)
+			y += pdfLineH
+		}
+		return y
+	})
+	pdfRow(doc, page, x, y, w, "Пассивная внимательность", fmt.Sprint(v.Passive))
+	page.Rect(x, y, w, 18, 1)
+
+	// Бой
+	x = pdfMargin + 270
+	w = right - x
+	y = top
+	cellW := (w - 12) / 3
+	for i, cell := range []struct{ title, value string }{
+		{"КД", v.AC}, {"Инициатива", formatBonus(v.Derived.Initiative)}, {"Скорость", v.Speed},
+	} {
+		cx := x + float64(i)*(cellW+6)
+		page.Rect(cx, y, cellW, 44, 1)
+		centerText(doc, page, cx+cellW/2, y+12, 7, strings.ToUpper(cell.title))
+		centerText(doc, page, cx+cellW/2, y+34, 16, cell.value)
+	}
+	y += 52
+	hp := orUnknown(v.HP)
+	if v.MaxHP != "" {
+		hp += " / " + v.MaxHP
+	}
+	y = pdfBox(page, x, y, w, "Хиты", func(y float64) float64 {
+		rows := [][2]string{
+			{"Текущие", hp}, {"Временные", v.TempHP}, {"Кости хитов", v.HitDice},
+			{"Спасброски от смерти", fmt.Sprintf("%d усп. / %d пров.", v.DeathSaves.Successes, v.DeathSaves.Failures)},
+		}
+		if c := v.ConditionNames(); c != "" {
+			rows = append(rows, [2]string{"Состояния", c})
+		}
+		for _, r := range rows {
+			pdfRow(doc, page, x+6, y, w-12, r[0], r[1])
+			y += pdfLineH
+		}
+		return y
+	})
+	y = pdfBox(page, x, y, w, "Атаки", func(y float64) float64 {
+		for _, wpn := range v.Weapons {
+			page.Text(x+6, y+pdfTextSize, pdfTextSize, fitPDF(doc, wpn.Name, 100, pdfTextSize))
+			page.Text(x+110, y+pdfTextSize, pdfTextSize, wpn.Attack)
+			page.Text(x+145, y+pdfTextSize, pdfTextSize, fitPDF(doc, wpn.Damage, w-151, pdfTextSize))
+			y += pdfLineH
+		}
+		return y
+	})
+
+	// Снаряжение: то, что не поместилось справа, переносится на следующую страницу
+	var equipment []string
+	if v.CoinLine != "" {
+		equipment = append(equipment, v.CoinLine)
+	}
+	for _, item := range v.Equipment {
+		equipment = append(equipment, wrapPDF(doc, "• "+item, w-12, pdfTextSize)...)
+	}
+	bottom := pdf.A4Height - pdfMargin
+	fit := clamp(int((bottom-y-20)/pdfLineH), 0, len(equipment))
+	pdfBox(page, x, y, w, "Снаряжение", func(y float64) float64 {
+		for _, line := range equipment[:fit] {
+			page.Text(x+6, y+pdfTextSize, pdfTextSize, line)
+			y += pdfLineH
+		}
+		return y
+	})
+
+	// Остальное - текстом на следующих страницах
+	flow := &pdfFlow{doc: doc}
+	if rest := equipment[fit:]; len(rest) > 0 {
+		flow.section("Снаряжение (продолжение)", rest)
+	}
+	if len(v.Spellcast) > 0 || len(v.Spells) > 0 {
+		lines := append([]string{}, v.Spellcast...)
+		if v.Slots != "" {
+			lines = append(lines, "Ячейки: "+v.Slots)
+		}
+		for _, s := range v.Spells {
+			lines = append(lines, "• "+s)
+		}
+		flow.section("Заклинания", lines)
+	}
+	flow.section("Владения и языки", v.Proficiencies)
+	flow.section("Умения", v.Skills)
+	flow.section("Описание", strings.Split(v.Description, "\n"))
+	flow.section("Заметки", strings.Split(v.Notes, "\n"))
+	return doc.Bytes(), nil
+}
+
+// pdfBox рисует рамку с заголовком вокруг того, что выводит body;
+// возвращает Y под рамкой
+func pdfBox(page *pdf.Page, x, y, w float64, title string, body func(y float64) float64) float64 {
+	page.FillRect(x, y, w, 14, 0.85)
+	page.Text(x+6, y+10, 8, strings.ToUpper(title))
+	end := body(y+18) + 2
+	page.Rect(x, y, w, end-y, 1)
+	return end + 8
+}
+
+// pdfRow пишет подпись слева и значение справа
+func pdfRow(doc *pdf.Document, page *pdf.Page, x, y, w float64, label, value string) {
+	page.Text(x+4, y+pdfTextSize+2, pdfTextSize, fitPDF(doc, label, w-50, pdfTextSize))
+	page.Text(x+w-4-doc.TextWidth(value, pdfTextSize), y+pdfTextSize+2, pdfTextSize, value)
+}
+
+func centerText(doc *pdf.Document, page *pdf.Page, cx, y, size float64, s string) {
+	page.Text(cx-doc.TextWidth(s, size)/2, y, size, s)
+}
+
+// fitPDF обрезает строку по ширине, добавляя многоточие
+func fitPDF(doc *pdf.Document, s string, w, size float64) string {
+	if doc.TextWidth(s, size) <= w {
+		return s
+	}
+	for s != "" && doc.TextWidth(s+"…", size) > w {
+		_, n := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-n]
+	}
+	return s + "…"
+}
+
+// wrapPDF переносит строку по словам в пределах ширины w
+func wrapPDF(doc *pdf.Document, s string, w, size float64) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		switch {
+		case line == "":
+			line = word
+		case doc.TextWidth(line+" "+word, size) <= w:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, fitPDF(doc, line, w, size))
+	}
+	return lines
+}
+
+// pdfFlow выводит разделы текстом сверху вниз, добавляя страницы по мере надобности
+type pdfFlow struct {
+	doc  *pdf.Document
+	page *pdf.Page
+	y    float64
+}
+
+func (f *pdfFlow) line(s string, size float64) {
+	if f.page == nil || f.y+pdfLineH > pdf.A4Height-pdfMargin {
+		f.page = f.doc.AddPage()
+		f.y = pdfMargin
+	}
+	f.page.Text(pdfMargin, f.y+size, size, s)
+	f.y += pdfLineH
+}
+
+// section выводит заголовок и абзацы; пустой раздел пропускается
+func (f *pdfFlow) section(title string, paragraphs []string) {
+	if strings.TrimSpace(strings.Join(paragraphs, "")) == "" {
+		return
+	}
+	if f.page != nil {
+		f.y += pdfLineH / 2
+	}
+	f.line(strings.ToUpper(title), 10)
+	f.page.Line(pdfMargin, f.y, pdf.A4Width-pdfMargin, f.y, 0.5)
+	f.y += 4
+	for _, p := range paragraphs {
+		for _, l := range wrapPDF(f.doc, p, pdf.A4Width-2*pdfMargin, pdfTextSize) {
+			f.line(l, pdfTextSize)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestExportFileName(t *testing.T) {
+	tests := []struct {
+		name, path, want string
+	}{
+		{"Ирвен", "", "Ирвен"},
+		{"Ирвен", "characters/Ирвен.json", "Ирвен"},
+		{"Ирвен", "characters/irven-copy.json", "Ирвен (irven-copy)"},
+		{" A/B:C ", "", "A_B_C"},
+		{"", "", "персонаж"},
+		{".", "", "персонаж"},
+		{"..", "characters/hero.json", "персонаж (hero)"},
+		{"...", "characters/...json", "персонаж"},
+		{"Д'Арт.", "", "Д'Арт."},
+	}
+	for _, tt := range tests {
+		char := &Character{Name: tt.name, Path: tt.path}
+		if got := exportFileName(char); got != tt.want {
+			t.Errorf("exportFileName(%q, %q) = %q, ожидалось %q", tt.name, tt.path, got, tt.want)
+		}
+	}
+}
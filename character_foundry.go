@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"test/internal/rules"
+)
+
+// foundryFormat - экспорт актёра Foundry VTT из системы dnd5e («Export Data»).
+// Данные актёра и предметов лежат в поле system, в выгрузках до Foundry v10 -
+// в поле data. Хиты, КД и ячейки в выгрузке уже посчитаны или заданы
+// вручную; если КД считается по доспехам, он считается здесь. Формат только
+// читается: правки из игры в него не записываются.
+type foundryFormat struct{}
+
+type foundryActor struct {
+	Name   string          `json:"name"`
+	Type   string          `json:"type"`
+	Img    string          `json:"img"`
+	System json.RawMessage `json:"system"`
+	Data   json.RawMessage `json:"data"` // До Foundry v10
+	Items  []foundryItem   `json:"items"`
+}
+
+type foundryItem struct {
+	Name   string          `json:"name"`
+	Type   string          `json:"type"` // class, weapon, equipment, loot, consumable, spell...
+	System json.RawMessage `json:"system"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type foundryActorData struct {
+	Abilities map[string]struct {
+		Value      int     `json:"value"`
+		Proficient float64 `json:"proficient"`
+	} `json:"abilities"`
+	Skills map[string]struct {
+		Value float64 `json:"value"` // 0, 0.5, 1 или 2
+	} `json:"skills"`
+	Attributes struct {
+		AC struct {
+			Flat  *int `json:"flat"`
+			Value *int `json:"value"`
+		} `json:"ac"`
+		HP struct {
+			Value int  `json:"value"`
+			Max   *int `json:"max"`
+			Temp  *int `json:"temp"`
+		} `json:"hp"`
+		Death struct {
+			Success int `json:"success"`
+			Failure int `json:"failure"`
+		} `json:"death"`
+		Movement struct {
+			Walk *float64 `json:"walk"`
+		} `json:"movement"`
+		Spellcasting string `json:"spellcasting"`
+	} `json:"attributes"`
+	Details struct {
+		Race       json.RawMessage `json:"race"`       // Строка или id предмета расы
+		Background json.RawMessage `json:"background"` // Так же
+		Biography  struct {
+			Value string `json:"value"`
+		} `json:"biography"`
+	} `json:"details"`
+	Traits   map[string]json.RawMessage `json:"traits"`
+	Currency Coins                      `json:"currency"`
+	Spells   map[string]struct {
+		Value    int  `json:"value"`
+		Max      *int `json:"max"`
+		Override *int `json:"override"`
+	} `json:"spells"`
+}
+
+type foundryItemData struct {
+	Levels      int    `json:"levels"`
+	HitDice     string `json:"hitDice"`
+	HitDiceUsed int    `json:"hitDiceUsed"`
+	HD          struct {
+		Denomination string `json:"denomination"`
+		Spent        int    `json:"spent"`
+	} `json:"hd"` // dnd5e 4.x
+	Quantity   int             `json:"quantity"`
+	Equipped   bool            `json:"equipped"`
+	ActionType string          `json:"actionType"` // mwak, rwak...
+	Properties json.RawMessage `json:"properties"` // Список кодов или объект код: true
+	Damage     struct {
+		Parts [][]string `json:"parts"`
+	} `json:"damage"`
+	Armor struct {
+		Value *int   `json:"value"`
+		Type  string `json:"type"` // light, medium, heavy, shield
+		Dex   *int   `json:"dex"`
+	} `json:"armor"`
+}
+
+// foundrySkills - коды навыков dnd5e и навыки rules.Skills
+var foundrySkills = map[string]string{
+	"acr": "acrobatics", "ani": "animal handling", "arc": "arcana", "ath": "athletics",
+	"dec": "deception", "his": "history", "ins": "insight", "itm": "intimidation",
+	"inv": "investigation", "med": "medicine", "nat": "nature", "prc": "perception",
+	"prf": "performance", "per": "persuasion", "rel": "religion", "slt": "sleight of hand",
+	"ste": "stealth", "sur": "survival",
+}
+
+// foundryProficiency - уровень владения навыком dnd5e
+func foundryProficiency(v float64) rules.Proficiency {
+	switch {
+	case v >= 2:
+		return rules.Expertise
+	case v >= 1:
+		return rules.Proficient
+	case v > 0:
+		return rules.HalfProficient
+	}
+	return rules.NotProficient
+}
+
+// foundrySystem - данные актёра или предмета: system или data в старых выгрузках
+func foundrySystem(system, data json.RawMessage) json.RawMessage {
+	if len(system) > 0 && system[0] == '{' {
+		return system
+	}
+	return data
+}
+
+func (foundryFormat) Name() string { return "foundry" }
+
+func (foundryFormat) Detect(top map[string]json.RawMessage) bool {
+	var kind string
+	if json.Unmarshal(top["type"], &kind) != nil || kind != "character" {
+		return false
+	}
+	var probe struct {
+		Abilities json.RawMessage `json:"abilities"`
+	}
+	body := foundrySystem(top["system"], top["data"])
+	return json.Unmarshal(body, &probe) == nil && probe.Abilities != nil
+}
+
+func (foundryFormat) Import(data []byte) (*Character, error) {
+	var actor foundryActor
+	if err := json.Unmarshal(data, &actor); err != nil {
+		return nil, err
+	}
+	var d foundryActorData
+	if err := json.Unmarshal(foundrySystem(actor.System, actor.Data), &d); err != nil {
+		return nil, fmt.Errorf("ошибка разбора актёра: %w", err)
+	}
+
+	char := &Character{
+		Name:             actor.Name,
+		Race:             foundryDetail(d.Details.Race),
+		Background:       foundryDetail(d.Details.Background),
+		Stats:            make(map[string]int),
+		SaveProficiency:  make(map[string]rules.Proficiency),
+		SkillProficiency: make(map[string]rules.Proficiency),
+		Description:      stripHTML(d.Details.Biography.Value),
+		SpellAbility:     d.Attributes.Spellcasting,
+		HP:               strconv.Itoa(d.Attributes.HP.Value),
+		Coins:            d.Currency,
+		DeathSaves:       DeathSaves{Successes: d.Attributes.Death.Success, Failures: d.Attributes.Death.Failure},
+	}
+	if d.Attributes.HP.Max != nil {
+		char.MaxHP = strconv.Itoa(*d.Attributes.HP.Max)
+	}
+	if d.Attributes.HP.Temp != nil {
+		char.TempHP = strconv.Itoa(*d.Attributes.HP.Temp)
+	}
+	if walk := d.Attributes.Movement.Walk; walk != nil {
+		char.Speed = strconv.FormatFloat(*walk, 'f', -1, 64)
+	}
+	if portrait, err := decodeDataURL(actor.Img); err == nil {
+		char.Portrait = portrait
+	}
+
+	for _, code := range rules.Abilities {
+		a, ok := d.Abilities[code]
+		if !ok {
+			continue
+		}
+		char.Stats[code] = a.Value
+		if a.Proficient > 0 {
+			char.SaveProficiency[code] = rules.Proficient
+		}
+	}
+	for short, code := range foundrySkills {
+		if p := foundryProficiency(d.Skills[short].Value); p != rules.NotProficient {
+			char.SkillProficiency[code] = p
+		}
+	}
+	for _, skill := range rules.Skills {
+		switch char.SkillProficiency[skill.Code] {
+		case rules.Proficient:
+			char.Skills = append(char.Skills, skill.Name)
+		case rules.Expertise:
+			char.Skills = append(char.Skills, skill.Name+" (компетентность)")
+		}
+	}
+	char.Proficiencies = foundryTraits(d.Traits)
+
+	// Ячейки: ручное значение, затем максимум из выгрузки; value - оставшиеся
+	for level := 1; level <= 9; level++ {
+		s, ok := d.Spells["spell"+strconv.Itoa(level)]
+		if !ok {
+			continue
+		}
+		total := s.Value
+		switch {
+		case s.Override != nil:
+			total = *s.Override
+		case s.Max != nil:
+			total = *s.Max
+		}
+		if total > 0 {
+			char.SpellSlots = append(char.SpellSlots, SpellSlot{Level: level, Total: total, Used: clamp(total-s.Value, 0, total)})
+		}
+	}
+
+	// Предметы: классы, оружие, снаряжение и заклинания
+	var classes, hitDice []string
+	var classLevels []int
+	var items []foundryItem
+	var itemData []foundryItemData
+	for _, item := range actor.Items {
+		var it foundryItemData
+		if err := json.Unmarshal(foundrySystem(item.System, item.Data), &it); err != nil {
+			continue // Незнакомый предмет не должен ломать весь лист
+		}
+		switch item.Type {
+		case "class":
+			char.Level += it.Levels
+			classes = append(classes, item.Name)
+			classLevels = append(classLevels, it.Levels)
+			die, used := it.HitDice, it.HitDiceUsed
+			if it.HD.Denomination != "" {
+				die, used = it.HD.Denomination, it.HD.Spent
+			}
+			if die != "" {
+				hitDice = append(hitDice, fmt.Sprintf("%d%s", it.Levels-used, die))
+			}
+		case "race":
+			char.Race = item.Name
+		case "background":
+			char.Background = item.Name
+		case "spell":
+			char.Spells = append(char.Spells, item.Name)
+		case "weapon", "equipment", "loot", "consumable", "tool", "backpack", "container":
+			items = append(items, item)
+			itemData = append(itemData, it)
+		}
+	}
+	if len(classes) > 1 {
+		for i := range classes {
+			classes[i] += " " + strconv.Itoa(classLevels[i])
+		}
+	}
+	char.Class = strings.Join(classes, " / ")
+	char.HitDice = strings.Join(hitDice, " + ")
+
+	prof := rules.ProficiencyBonus(char.Level)
+	dex := rules.Modifier(char.Stats["dex"])
+	ac, shield := 10+dex, 0
+	for i, item := range items {
+		it := itemData[i]
+		if item.Type == "weapon" {
+			char.Weapons = append(char.Weapons, foundryWeapon(item.Name, it, char.Stats, prof))
+			continue
+		}
+		if item.Type == "equipment" && it.Equipped && it.Armor.Value != nil {
+			switch it.Armor.Type {
+			case "shield":
+				shield = *it.Armor.Value
+			case "light", "medium", "heavy":
+				bonus := dex
+				if it.Armor.Dex != nil {
+					bonus = min(dex, *it.Armor.Dex)
+				}
+				if it.Armor.Type == "heavy" {
+					bonus = 0
+				}
+				ac = *it.Armor.Value + bonus
+			}
+		}
+		name := item.Name
+		if it.Quantity > 1 {
+			name = fmt.Sprintf("%s x%d", name, it.Quantity)
+		}
+		char.Equipment = append(char.Equipment, name)
+	}
+	switch a := d.Attributes.AC; {
+	case a.Flat != nil:
+		char.AC = strconv.Itoa(*a.Flat)
+	case a.Value != nil:
+		char.AC = strconv.Itoa(*a.Value)
+	default:
+		char.AC = strconv.Itoa(ac + shield)
+	}
+	return char, nil
+}
+
+// foundryDetail читает раса или предыстория, если это строка, а не id предмета
+func foundryDetail(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return ""
+	}
+	return s
+}
+
+// foundryTraits собирает владения и языки из traits: коды и свои строки
+func foundryTraits(traits map[string]json.RawMessage) []string {
+	var lines []string
+	for _, t := range []struct{ key, title string }{
+		{"weaponProf", "Оружие"}, {"armorProf", "Доспехи"}, {"languages", "Языки"},
+	} {
+		var trait struct {
+			Value  []string `json:"value"`
+			Custom string   `json:"custom"`
+		}
+		if json.Unmarshal(traits[t.key], &trait) != nil {
+			continue
+		}
+		values := trait.Value
+		for _, c := range strings.Split(trait.Custom, ";") {
+			if c = strings.TrimSpace(c); c != "" {
+				values = append(values, c)
+			}
+		}
+		if len(values) > 0 {
+			lines = append(lines, t.title+": "+strings.Join(values, ", "))
+		}
+	}
+	return lines
+}
+
+// foundryHasProperty проверяет свойство оружия: список кодов или объект код: true
+func foundryHasProperty(raw json.RawMessage, code string) bool {
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		for _, p := range list {
+			if p == code {
+				return true
+			}
+		}
+		return false
+	}
+	var set map[string]bool
+	return json.Unmarshal(raw, &set) == nil && set[code]
+}
+
+// foundryWeapon считает бонус атаки и урон; @mod в формуле урона заменяется
+// модификатором характеристики
+func foundryWeapon(name string, it foundryItemData, stats map[string]int, prof int) Weapon {
+	mod := rules.Modifier(stats["str"])
+	switch dex := rules.Modifier(stats["dex"]); {
+	case it.ActionType == "rwak":
+		mod = dex
+	case foundryHasProperty(it.Properties, "fin"):
+		mod = max(mod, dex)
+	}
+	w := Weapon{Name: name, Attack: formatBonus(mod + prof)}
+	var parts []string
+	for _, part := range it.Damage.Parts {
+		if len(part) == 0 || part[0] == "" {
+			continue
+		}
+		formula := strings.ReplaceAll(part[0], "@mod", strconv.Itoa(mod))
+		formula = strings.ReplaceAll(formula, "+ -", "- ")
+		if len(part) > 1 && part[1] != "" {
+			formula += " " + part[1]
+		}
+		parts = append(parts, formula)
+	}
+	w.Damage = strings.Join(parts, ", ")
+	return w
+}
+
+// stripHTML превращает HTML описания в текст: абзацы и переносы - в строки
+func stripHTML(s string) string {
+	for _, tag := range []string{"</p>", "<br>", "<br/>", "<br />", "</li>", "</h1>", "</h2>", "</h3>"} {
+		s = strings.ReplaceAll(s, tag, "\n")
+	}
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(html.UnescapeString(b.String()))
+}
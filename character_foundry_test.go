@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"test/internal/rules"
+)
+
+// testdata/foundry_v10.json - актёр dnd5e с данными в system (Foundry v10+,
+// кости хитов в hd как в dnd5e 4.x): бард 5 с посчитанным по доспехам КД
+func TestImportFoundryV10(t *testing.T) {
+	char, err := (&Game{}).loadCharacterFromFile("testdata/foundry_v10.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if char.Format != "foundry" {
+		t.Fatalf("формат %q, ожидался foundry", char.Format)
+	}
+
+	if char.Name != "Лира" || char.Race != "Полуэльф" || char.Background != "Артист" {
+		t.Errorf("персонаж %q, %q, %q", char.Name, char.Race, char.Background)
+	}
+	if char.Class != "Бард" || char.Level != 5 || char.HitDice != "3d8" || char.SpellAbility != "cha" {
+		t.Errorf("класс %q, уровень %d, кости хитов %q, заклинания %q", char.Class, char.Level, char.HitDice, char.SpellAbility)
+	}
+	wantStats := map[string]int{"str": 8, "dex": 16, "con": 12, "int": 13, "wis": 10, "cha": 17}
+	if !reflect.DeepEqual(char.Stats, wantStats) {
+		t.Errorf("характеристики %v, ожидалось %v", char.Stats, wantStats)
+	}
+	wantSaves := map[string]rules.Proficiency{"dex": rules.Proficient, "cha": rules.Proficient}
+	if !reflect.DeepEqual(char.SaveProficiency, wantSaves) {
+		t.Errorf("спасброски %v, ожидалось %v", char.SaveProficiency, wantSaves)
+	}
+	wantSkills := map[string]rules.Proficiency{
+		"stealth":     rules.Proficient,
+		"performance": rules.Expertise,
+		"athletics":   rules.HalfProficient,
+	}
+	if !reflect.DeepEqual(char.SkillProficiency, wantSkills) {
+		t.Errorf("навыки %v, ожидалось %v", char.SkillProficiency, wantSkills)
+	}
+
+	if char.HP != "20" || char.MaxHP != "24" || char.TempHP != "0" || char.Speed != "30" {
+		t.Errorf("хиты %s/%s (+%s), скорость %s", char.HP, char.MaxHP, char.TempHP, char.Speed)
+	}
+	// Лёгкий доспех 11 + Ловкость 3 + щит 2
+	if char.AC != "16" {
+		t.Errorf("КД %s, ожидалось 16", char.AC)
+	}
+	if want := (DeathSaves{Successes: 1}); char.DeathSaves != want {
+		t.Errorf("спасброски от смерти %+v, ожидалось %+v", char.DeathSaves, want)
+	}
+
+	// Ячейки: override важнее max, value - сколько осталось
+	wantSlots := []SpellSlot{{Level: 1, Total: 4, Used: 2}, {Level: 2, Total: 3, Used: 2}}
+	if !reflect.DeepEqual(char.SpellSlots, wantSlots) {
+		t.Errorf("ячейки %+v, ожидалось %+v", char.SpellSlots, wantSlots)
+	}
+
+	// Свойства оружия бывают списком и объектом; @mod подставляется со знаком
+	wantWeapons := []Weapon{
+		{Name: "Рапира", Attack: "+6", Damage: "1d8 + 3 piercing"},
+		{Name: "Кинжал", Attack: "+6", Damage: "1d4 + 3 piercing"},
+		{Name: "Дубинка", Attack: "+2", Damage: "1d4 - 1 bludgeoning"},
+	}
+	if !reflect.DeepEqual(char.Weapons, wantWeapons) {
+		t.Errorf("оружие %+v, ожидалось %+v", char.Weapons, wantWeapons)
+	}
+	wantEquipment := []string{"Кожаный доспех", "Щит", "Лютня", "Зелье лечения x2"}
+	if !reflect.DeepEqual(char.Equipment, wantEquipment) {
+		t.Errorf("снаряжение %q, ожидалось %q", char.Equipment, wantEquipment)
+	}
+	if want := []string{"Оружие: sim", "Языки: common, elvish, Воровской жаргон"}; !reflect.DeepEqual(char.Proficiencies, want) {
+		t.Errorf("владения %q, ожидалось %q", char.Proficiencies, want)
+	}
+	if want := (Coins{SP: 3, GP: 7}); char.Coins != want {
+		t.Errorf("монеты %+v, ожидалось %+v", char.Coins, want)
+	}
+	if want := []string{"Насмешка"}; !reflect.DeepEqual(char.Spells, want) {
+		t.Errorf("заклинания %q, ожидалось %q", char.Spells, want)
+	}
+	if want := "Бродячая & певица.\nИщет голос"; char.Description != want {
+		t.Errorf("описание %q, ожидалось %q", char.Description, want)
+	}
+	if want := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}; !reflect.DeepEqual(char.Portrait, want) {
+		t.Errorf("портрет % x, ожидался % x", char.Portrait, want)
+	}
+}
+
+// testdata/foundry_v9.json - та же схема, но данные в data (до Foundry v10):
+// мультикласс и КД, заданный вручную
+func TestImportFoundryV9(t *testing.T) {
+	char, err := (&Game{}).loadCharacterFromFile("testdata/foundry_v9.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if char.Format != "foundry" {
+		t.Fatalf("формат %q, ожидался foundry", char.Format)
+	}
+
+	if char.Name != "Борг" || char.Race != "Дварф" || char.Background != "Солдат" {
+		t.Errorf("персонаж %q, %q, %q", char.Name, char.Race, char.Background)
+	}
+	if char.Class != "Воин 3 / Жрец 1" || char.Level != 4 || char.HitDice != "3d10 + 0d8" {
+		t.Errorf("класс %q, уровень %d, кости хитов %q", char.Class, char.Level, char.HitDice)
+	}
+	if char.Stats["str"] != 16 || char.Stats["con"] != 15 {
+		t.Errorf("характеристики %v", char.Stats)
+	}
+	if char.HP != "30" || char.MaxHP != "34" || char.TempHP != "" || char.Speed != "25" {
+		t.Errorf("хиты %s/%s (+%s), скорость %s", char.HP, char.MaxHP, char.TempHP, char.Speed)
+	}
+	// flat важнее посчитанного по кольчуге
+	if char.AC != "17" {
+		t.Errorf("КД %s, ожидалось 17", char.AC)
+	}
+	if want := []string{"Атлетика", "Запугивание"}; !reflect.DeepEqual(char.Skills, want) {
+		t.Errorf("навыки %q, ожидалось %q", char.Skills, want)
+	}
+	if want := []Weapon{{Name: "Боевой топор", Attack: "+5", Damage: "1d8 + 3 slashing"}}; !reflect.DeepEqual(char.Weapons, want) {
+		t.Errorf("оружие %+v, ожидалось %+v", char.Weapons, want)
+	}
+	if want := []string{"Доспехи: hvy, shl"}; !reflect.DeepEqual(char.Proficiencies, want) {
+		t.Errorf("владения %q, ожидалось %q", char.Proficiencies, want)
+	}
+	if want := []SpellSlot{{Level: 1, Total: 2, Used: 0}}; !reflect.DeepEqual(char.SpellSlots, want) {
+		t.Errorf("ячейки %+v, ожидалось %+v", char.SpellSlots, want)
+	}
+	if want := (DeathSaves{Failures: 2}); char.DeathSaves != want {
+		t.Errorf("спасброски от смерти %+v, ожидалось %+v", char.DeathSaves, want)
+	}
+	// Портрет - путь внутри Foundry, а не data URL: его нет
+	if char.Portrait != nil {
+		t.Errorf("портрет %d байт, ожидалось без портрета", len(char.Portrait))
+	}
+}
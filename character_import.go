@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"test/internal/richtext"
+)
+
+// CharacterFormat - формат файла персонажа. Формат узнаёт свой файл по
+// верхнему уровню JSON и приводит его к Character.
+type CharacterFormat interface {
+	Name() string
+	Detect(top map[string]json.RawMessage) bool
+	Import(data []byte) (*Character, error)
+}
+
+// characterWriter - формат, в который можно записать правки, сделанные в игре
+type characterWriter interface {
+	Write(char *Character) error
+}
+
+// characterFormats проверяются по порядку, первый узнавший файл его и читает.
+// Новый формат достаточно добавить сюда.
+var characterFormats = []CharacterFormat{
+	nativeFormat{},
+	lssFormat{},
+	ddbFormat{},
+	foundryFormat{},
+}
+
+// errUnknownFormat - файл не похож ни на один из известных форматов
+var errUnknownFormat = errors.New("неизвестный формат персонажа")
+
+// detectCharacterFormat находит формат файла персонажа
+func detectCharacterFormat(data []byte) (CharacterFormat, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга JSON: %w", err)
+	}
+	for _, format := range characterFormats {
+		if format.Detect(top) {
+			return format, nil
+		}
+	}
+	return nil, errUnknownFormat
+}
+
+func characterFormatByName(name string) CharacterFormat {
+	for _, format := range characterFormats {
+		if format.Name() == name {
+			return format
+		}
+	}
+	return nil
+}
+
+func (g *Game) loadCharacterFromFile(filename string) (*Character, error) {
+	fullPath := filepath.Clean(filename)
+	if debugMode {
+		log.Printf("[DEBUG] Начало загрузки персонажа из файла: %s", fullPath)
+	}
+
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		log.Printf("[ERROR] Ошибка чтения файла %s: %v", fullPath, err)
+		return nil, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	// Удаляем BOM маркер если есть
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+
+	format, err := detectCharacterFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	if debugMode {
+		log.Printf("[DEBUG] Формат файла %s: %s", fullPath, format.Name())
+	}
+	char, err := format.Import(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", format.Name(), err)
+	}
+	char.Path = fullPath
+	char.Format = format.Name()
+	if char.Stats == nil {
+		char.Stats = make(map[string]int)
+	}
+	// Форматы без разметки показывают описание и заметки простым текстом
+	if char.DescriptionDoc == nil {
+		char.DescriptionDoc = richtext.FromText(char.Description)
+	}
+	if char.NotesDoc == nil {
+		char.NotesDoc = richtext.FromText(char.Notes)
+	}
+	// Портрета в листе нет - ищем картинку рядом с файлом
+	if char.Portrait == nil {
+		char.Portrait = loadPortraitFile(fullPath)
+	}
+
+	if debugMode {
+		log.Printf("[DEBUG] Успешно загружен персонаж: %s", char.Name)
+	}
+	return char, nil
+}
+
+// saveCharacter записывает правки, сделанные в игре, обратно в файл листа в
+// его же формате
+func saveCharacter(char *Character) error {
+	format := characterFormatByName(char.Format)
+	writer, ok := format.(characterWriter)
+	if !ok {
+		return fmt.Errorf("запись в формат %q не поддерживается", char.Format)
+	}
+	if err := writer.Write(char); err != nil {
+		return err
+	}
+
+	char.dirty = false
+	if debugMode {
+		log.Printf("[DEBUG] Персонаж %s записан в %s", char.Name, char.Path)
+	}
+	return nil
+}
+
+// nativeFormatID - значение поля format в файле собственного формата
+const nativeFormatID = "character"
+
+// nativeFormat - собственный формат персонажа:
+//
+//	{
+//	  "format": "character",
+//	  "version": 1,
+//	  "character": {
+//	    "name": "Арвен", "class": "Следопыт", "level": 3, "race": "Эльф",
+//	    "stats": {"str": 10, "dex": 16, "con": 12, "int": 10, "wis": 14, "cha": 8},
+//	    "hp": "24", "maxHp": "27", "tempHp": "0", "ac": "15", "speed": "35",
+//	    "skillProficiency": {"stealth": 3, "survival": 2},
+//	    "spellSlots": [{"level": 1, "total": 3, "used": 1}],
+//	    "coins": {"gp": 40}
+//	  }
+//	}
+//
+// Поля character - json-теги Character. Коды характеристик - rules.Abilities,
+// навыков - rules.Skills, состояний - rules.Conditions; владение: 0 - нет,
+// 1 - половина бонуса, 2 - владение, 3 - компетентность. Портрет - base64.
+// Правки из игры переписывают файл целиком.
+type nativeFormat struct{}
+
+// nativeFile - файл собственного формата
+type nativeFile struct {
+	Format    string     `json:"format"`
+	Version   int        `json:"version"`
+	Character *Character `json:"character"`
+}
+
+const nativeVersion = 1
+
+func (nativeFormat) Name() string { return "native" }
+
+func (nativeFormat) Detect(top map[string]json.RawMessage) bool {
+	var format string
+	return json.Unmarshal(top["format"], &format) == nil && format == nativeFormatID
+}
+
+func (nativeFormat) Import(data []byte) (*Character, error) {
+	var file nativeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Version > nativeVersion {
+		return nil, fmt.Errorf("версия %d новее поддерживаемой %d", file.Version, nativeVersion)
+	}
+	if file.Character == nil {
+		return nil, errors.New("нет поля character")
+	}
+	return file.Character, nil
+}
+
+func (nativeFormat) Write(char *Character) error {
+	content, err := json.MarshalIndent(nativeFile{Format: nativeFormatID, Version: nativeVersion, Character: char}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(char.Path, content)
+}
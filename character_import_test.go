@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Каждый образец узнаёт ровно свой формат: проверяются все форматы по
+// порядку characterFormats, а не только первый подошедший
+func TestDetectCharacterFormat(t *testing.T) {
+	tests := []struct {
+		file, want string
+	}{
+		{"native.json", "native"},
+		{"lss_string.json", "lss"},
+		{"lss_object.json", "lss"},
+		{"lss_bare.json", "lss"},
+		{"ddb.json", "ddb"},
+		{"foundry_v10.json", "foundry"},
+		{"foundry_v9.json", "foundry"}, // data-объект не путается с LSS и D&D Beyond
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			content, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			format, err := detectCharacterFormat(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format.Name() != tt.want {
+				t.Errorf("формат %s, ожидался %s", format.Name(), tt.want)
+			}
+
+			var claimed []string
+			for _, f := range characterFormats {
+				if detectWith(t, f, content) {
+					claimed = append(claimed, f.Name())
+				}
+			}
+			if !reflect.DeepEqual(claimed, []string{tt.want}) {
+				t.Errorf("файл узнают форматы %q, ожидался только %s", claimed, tt.want)
+			}
+		})
+	}
+
+	for _, content := range []string{`{"name": "никто"}`, `{"data": 5}`, `{"type": "npc", "system": {"abilities": {}}}`} {
+		if _, err := detectCharacterFormat([]byte(content)); !errors.Is(err, errUnknownFormat) {
+			t.Errorf("%s: ошибка %v, ожидалась errUnknownFormat", content, err)
+		}
+	}
+}
+
+func detectWith(t *testing.T, f CharacterFormat, content []byte) bool {
+	t.Helper()
+	saved := characterFormats
+	defer func() { characterFormats = saved }()
+	characterFormats = []CharacterFormat{f}
+	_, err := detectCharacterFormat(content)
+	return err == nil
+}
+
+func TestImportNative(t *testing.T) {
+	char, err := (&Game{}).loadCharacterFromFile("testdata/native.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if char.Format != "native" || char.Name != "Мира" || char.Level != 2 || char.HitDice != "2d6" {
+		t.Errorf("формат %q, персонаж %q, уровень %d, кости хитов %q", char.Format, char.Name, char.Level, char.HitDice)
+	}
+	if want := []SpellSlot{{Level: 1, Total: 3, Used: 1}}; !reflect.DeepEqual(char.SpellSlots, want) {
+		t.Errorf("ячейки %+v, ожидалось %+v", char.SpellSlots, want)
+	}
+	if char.Notes != "Ищет учителя" || len(char.NotesDoc.Lines()) != 1 {
+		t.Errorf("заметки %q, документ %v", char.Notes, char.NotesDoc)
+	}
+}
+
+// Лист любого формата, сохранённый в собственном, читается обратно без потерь
+func TestNativeRoundTrip(t *testing.T) {
+	for _, file := range []string{"lss_object.json", "ddb.json", "foundry_v10.json", "native.json"} {
+		t.Run(file, func(t *testing.T) {
+			char, err := (&Game{}).loadCharacterFromFile(filepath.Join("testdata", file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			char.Path = filepath.Join(t.TempDir(), "sheet.json")
+			if err := (nativeFormat{}).Write(char); err != nil {
+				t.Fatal(err)
+			}
+			reloaded, err := (&Game{}).loadCharacterFromFile(char.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reloaded.Format != "native" {
+				t.Errorf("формат %q, ожидался native", reloaded.Format)
+			}
+
+			// Разметка описания и заметок в собственном формате не хранится
+			for _, c := range []*Character{char, reloaded} {
+				c.DescriptionDoc, c.NotesDoc, c.Format = nil, nil, ""
+			}
+			if !reflect.DeepEqual(reloaded, char) {
+				t.Errorf("после записи:\n%+v\nбыло\n%+v", reloaded, char)
+			}
+		})
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"test/internal/richtext"
+	"test/internal/rules"
 )
 
 type CharacterFile struct {
-	Data   string `json:"data"` // Теперь обрабатываем как строку
+	Data   json.RawMessage `json:"data"` // Строка JSON, в старых выгрузках - объект
 	Spells struct {
 		Prepared []string `json:"prepared"`
 		Book     []string `json:"book"`
@@ -38,59 +43,103 @@ type CharacterData struct {
 	Stats map[string]struct {
 		Score int `json:"score"`
 	} `json:"stats"`
+	Saves map[string]struct {
+		IsProf interface{} `json:"isProf"` // true/false
+	} `json:"saves"`
+	Skills map[string]struct {
+		IsProf interface{} `json:"isProf"` // 0, 1 или 2 - компетентность
+	} `json:"skills"`
+	SpellsInfo struct {
+		Base struct {
+			Code string `json:"code"` // Характеристика заклинаний
+		} `json:"base"`
+	} `json:"spellsInfo"`
+	// Числа в vitality бывают и числами, и строками, а иногда лежат в {"value": ...},
+	// поэтому читаются через lssString и lssInt
 	Vitality struct {
-		HPCurrent struct {
-			Value interface{} `json:"value"` // Может быть int или string
-		} `json:"hp-current"`
-		AC struct {
-			Value interface{} `json:"value"` // Может быть int или string
-		} `json:"ac"`
+		HPCurrent      interface{} `json:"hp-current"`
+		HPMax          interface{} `json:"hp-max"`
+		HPTemp         interface{} `json:"hp-temp"`
+		AC             interface{} `json:"ac"`
+		Speed          interface{} `json:"speed"`
+		HitDie         interface{} `json:"hit-die"`         // Вид кости: d8
+		HitDiceCurrent interface{} `json:"hp-dice-current"` // Сколько костей осталось
+		DeathSuccesses interface{} `json:"deathSuccesses"`
+		DeathFails     interface{} `json:"deathFails"`
 	} `json:"vitality"`
-	Text struct {
+	WeaponsList []struct {
+		Name  interface{} `json:"name"`
+		Mod   interface{} `json:"mod"`
+		Dmg   interface{} `json:"dmg"`
+		Notes interface{} `json:"notes"`
+	} `json:"weaponsList"`
+	Coins map[string]interface{} `json:"coins"` // cp, sp, ep, gp, pp
+	// Ячейки заклинаний: "slots-1": {"value": всего, "filled": потрачено}.
+	// Формат этих полей не проверяем жёстко, чтобы лист не отвергался целиком.
+	Spells     interface{} `json:"spells"`
+	Conditions interface{} `json:"conditions"` // Массив кодов состояний
+	Text       struct {
 		Background struct {
 			Value json.RawMessage `json:"value"`
 		} `json:"background"`
 		Notes1 struct {
 			Value json.RawMessage `json:"value"`
 		} `json:"notes-1"`
+		Equipment struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"equipment"`
+		Prof struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"prof"` // Владения и языки
 	} `json:"text"`
+	Avatar struct {
+		Jpeg string `json:"jpeg"` // data:image/jpeg;base64,...
+		Webp string `json:"webp"`
+	} `json:"avatar"`
 }
 
-func (g *Game) loadCharacterFromFile(filename string) (*Character, error) {
-	if debugMode {
-		log.Printf("[DEBUG] Начало загрузки персонажа из файла: %s", filename)
-	}
+// lssFormat - экспорт Long Story Short: лист лежит в поле data строкой JSON.
+// Старые выгрузки кладут в data сам объект, их читаем так же.
+type lssFormat struct{}
 
-	fullPath := filepath.Clean(filename)
-	if debugMode {
-		log.Printf("[DEBUG] Обработка файла по пути: %s", fullPath)
-	}
+func (lssFormat) Name() string { return "lss" }
 
-	data, err := os.ReadFile(fullPath)
-	if err != nil {
-		log.Printf("[ERROR] Ошибка чтения файла %s: %v", fullPath, err)
-		return nil, fmt.Errorf("ошибка чтения файла: %v", err)
+func (lssFormat) Detect(top map[string]json.RawMessage) bool {
+	var probe struct {
+		Name struct {
+			Value *string `json:"value"`
+		} `json:"name"`
 	}
+	data, err := lssData(top["data"])
+	return err == nil && json.Unmarshal(data, &probe) == nil && probe.Name.Value != nil
+}
 
-	// Удаляем BOM маркер если есть
-	data = []byte(strings.TrimPrefix(string(data), "\ufeff"))
-	if debugMode && len(data) > 0 {
-		log.Printf("[DEBUG] Прочитано %d байт из файла", len(data))
+// lssData достаёт лист из поля data: строку JSON или объект
+func lssData(raw json.RawMessage) ([]byte, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s), nil
+	}
+	if len(raw) == 0 || raw[0] != '{' {
+		return nil, errors.New("поле data не строка и не объект")
 	}
+	return raw, nil
+}
 
+func (lssFormat) Import(data []byte) (*Character, error) {
 	var raw CharacterFile
 	if err := json.Unmarshal(data, &raw); err != nil {
-		log.Printf("[ERROR] Ошибка парсинга JSON из файла %s: %v", fullPath, err)
+		log.Printf("[ERROR] Ошибка парсинга JSON: %v", err)
 		return nil, fmt.Errorf("ошибка парсинга JSON: %v", err)
 	}
 
-	if debugMode {
-		log.Printf("[DEBUG] Успешно распарсен файл, начало обработки данных персонажа")
-	}
-
 	// Теперь парсим вложенный JSON из поля data
+	inner, err := lssData(raw.Data)
+	if err != nil {
+		return nil, err
+	}
 	var charData CharacterData
-	if err := json.Unmarshal([]byte(raw.Data), &charData); err != nil {
+	if err := json.Unmarshal(inner, &charData); err != nil {
 		log.Printf("[ERROR] Ошибка парсинга вложенного JSON: %v", err)
 		return nil, fmt.Errorf("ошибка парсинга вложенного JSON: %v", err)
 	}
@@ -102,8 +151,6 @@ func (g *Game) loadCharacterFromFile(filename string) (*Character, error) {
 		Background: charData.Info.Background.Value,
 		Stats:      make(map[string]int),
 		Spells:     append(raw.Spells.Prepared, raw.Spells.Book...),
-		Skills:     []string{},
-		Equipment:  []string{},
 	}
 
 	if debugMode {
@@ -121,24 +168,20 @@ func (g *Game) loadCharacterFromFile(filename string) (*Character, error) {
 		log.Printf("[DEBUG] Установлен уровень персонажа: %d", char.Level)
 	}
 
-	// Обрабатываем HP
-	switch v := charData.Vitality.HPCurrent.Value.(type) {
-	case float64:
-		char.HP = fmt.Sprintf("%d", int(v))
-	case string:
-		char.HP = v
-	}
-
-	// Обрабатываем AC
-	switch v := charData.Vitality.AC.Value.(type) {
-	case float64:
-		char.AC = fmt.Sprintf("%d", int(v))
-	case string:
-		char.AC = v
+	// Обрабатываем хиты, КД, скорость и кости хитов
+	vit := charData.Vitality
+	char.HP = lssString(vit.HPCurrent)
+	char.MaxHP = lssString(vit.HPMax)
+	char.TempHP = lssString(vit.HPTemp)
+	char.AC = lssString(vit.AC)
+	char.Speed = lssString(vit.Speed)
+	if die := lssString(vit.HitDie); die != "" {
+		char.HitDice = lssString(vit.HitDiceCurrent) + die
 	}
+	char.DeathSaves = DeathSaves{Successes: lssInt(vit.DeathSuccesses), Failures: lssInt(vit.DeathFails)}
 
 	if debugMode {
-		log.Printf("[DEBUG] Установлены HP: %s и AC: %s", char.HP, char.AC)
+		log.Printf("[DEBUG] Установлены HP: %s/%s (+%s) и AC: %s", char.HP, char.MaxHP, char.TempHP, char.AC)
 	}
 
 	// Заполняем характеристики
@@ -149,54 +192,156 @@ func (g *Game) loadCharacterFromFile(filename string) (*Character, error) {
 		log.Printf("[DEBUG] Установлены характеристики: %+v", char.Stats)
 	}
 
+	// Владения спасбросками и навыками для производных чисел
+	char.SaveProficiency = make(map[string]rules.Proficiency, len(charData.Saves))
+	for stat, save := range charData.Saves {
+		char.SaveProficiency[stat] = lssProficiency(save.IsProf)
+	}
+	char.SkillProficiency = make(map[string]rules.Proficiency, len(charData.Skills))
+	for skill, data := range charData.Skills {
+		char.SkillProficiency[skill] = lssProficiency(data.IsProf)
+	}
+	char.SpellAbility = charData.SpellsInfo.Base.Code
+	for _, skill := range rules.Skills {
+		switch char.SkillProficiency[skill.Code] {
+		case rules.Proficient:
+			char.Skills = append(char.Skills, skill.Name)
+		case rules.Expertise:
+			char.Skills = append(char.Skills, skill.Name+" (компетентность)")
+		}
+	}
+	char.Proficiencies = proseMirrorLines(charData.Text.Prof.Value)
+
+	// Оружие, снаряжение и монеты
+	for _, w := range charData.WeaponsList {
+		weapon := Weapon{
+			Name:   lssString(w.Name),
+			Attack: lssString(w.Mod),
+			Damage: lssString(w.Dmg),
+			Notes:  lssString(w.Notes),
+		}
+		if weapon != (Weapon{}) {
+			char.Weapons = append(char.Weapons, weapon)
+		}
+	}
+	char.Equipment = proseMirrorLines(charData.Text.Equipment.Value)
+	char.SpellSlots = lssSpellSlots(charData.Spells)
+	if list, ok := charData.Conditions.([]interface{}); ok {
+		for _, c := range list {
+			if code, ok := c.(string); ok && code != "" {
+				char.Conditions = append(char.Conditions, code)
+			}
+		}
+	}
+	char.Coins = Coins{
+		CP: lssInt(charData.Coins["cp"]),
+		SP: lssInt(charData.Coins["sp"]),
+		EP: lssInt(charData.Coins["ep"]),
+		GP: lssInt(charData.Coins["gp"]),
+		PP: lssInt(charData.Coins["pp"]),
+	}
+	if debugMode {
+		log.Printf("[DEBUG] Навыков: %d, оружия: %d, предметов: %d, монет: %+v",
+			len(char.Skills), len(char.Weapons), len(char.Equipment), char.Coins)
+	}
+
 	// Обрабатываем описание
-	char.Description = extractTextContent(charData.Text.Background.Value)
+	char.DescriptionDoc = parseProseMirror(charData.Text.Background.Value)
+	char.Description = strings.Join(char.DescriptionDoc.Lines(), "\n")
 	if debugMode {
 		log.Printf("[DEBUG] Длина описания: %d символов", len(char.Description))
 	}
 
+	// Портрет лежит в листе как data URL
+	for _, url := range []string{charData.Avatar.Jpeg, charData.Avatar.Webp} {
+		if char.Portrait != nil || url == "" {
+			continue
+		}
+		if portrait, err := decodeDataURL(url); err != nil {
+			log.Printf("[ERROR] Ошибка чтения портрета %s: %v", char.Name, err)
+		} else {
+			char.Portrait = portrait
+		}
+	}
 	// Обрабатываем заметки
-	char.Notes = extractTextContent(charData.Text.Notes1.Value)
+	char.NotesDoc = parseProseMirror(charData.Text.Notes1.Value)
+	char.Notes = strings.Join(char.NotesDoc.Lines(), "\n")
 	if debugMode {
 		log.Printf("[DEBUG] Длина заметок: %d символов", len(char.Notes))
-		log.Printf("[DEBUG] Успешно загружен персонаж: %s", char.Name)
 	}
 
 	return char, nil
 }
 
+// extractTextContent достаёт текст из поля листа: документ редактора
+// (ProseMirror) или простую строку. Абзацы разделяются переводом строки.
 func extractTextContent(raw json.RawMessage) string {
-	// Пытаемся распарсить как сложную структуру
-	var complexStruct struct {
-		Data struct {
-			Content []struct {
-				Content []struct {
-					Text string `json:"text"`
-				} `json:"content"`
-			} `json:"content"`
-		} `json:"data"`
-	}
-
-	if err := json.Unmarshal(raw, &complexStruct); err == nil {
-		var builder strings.Builder
-		for _, content := range complexStruct.Data.Content {
-			for _, textContent := range content.Content {
-				if textContent.Text != "" {
-					builder.WriteString(textContent.Text)
-					builder.WriteString("\n")
-				}
-			}
+	return strings.Join(proseMirrorLines(raw), "\n")
+}
+
+// proseMirrorLines возвращает непустые абзацы документа, включая пункты
+// списков; простая строка делится по строкам
+func proseMirrorLines(raw json.RawMessage) []string {
+	return parseProseMirror(raw).Lines()
+}
+
+// parseProseMirror разбирает поле листа: документ редактора в обёртке
+// {"data": {...}} или простую строку. Если поле не разобрать, документ пустой.
+func parseProseMirror(raw json.RawMessage) *richtext.Doc {
+	var wrapper struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &wrapper); err == nil && wrapper.Data != nil {
+		if doc, err := richtext.Parse(wrapper.Data); err == nil {
+			return doc
 		}
-		return strings.TrimSpace(builder.String())
 	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return richtext.FromText(s)
+	}
+	return &richtext.Doc{}
+}
 
-	// Пытаемся распарсить как простую строку
-	var simpleStr string
-	if err := json.Unmarshal(raw, &simpleStr); err == nil {
-		return simpleStr
+// lssString читает значение листа: число, строку или {"value": ...}
+func lssString(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number: // Так читает числа запись листа, см. lssFormat.Write
+		return v.String()
+	case map[string]interface{}:
+		return lssString(v["value"])
+	default:
+		return ""
 	}
+}
+
+// lssSpellSlots собирает ячейки заклинаний по кругам 1-9
+func lssSpellSlots(v interface{}) []SpellSlot {
+	spells, _ := v.(map[string]interface{})
+	var slots []SpellSlot
+	for level := 1; level <= 9; level++ {
+		slot, ok := spells[fmt.Sprintf("slots-%d", level)].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		total := lssInt(slot["value"])
+		if total <= 0 {
+			continue
+		}
+		used := clamp(lssInt(slot["filled"]), 0, total)
+		slots = append(slots, SpellSlot{Level: level, Total: total, Used: used})
+	}
+	return slots
+}
 
-	return ""
+// lssInt читает целое из листа; нечисловое значение - ноль
+func lssInt(v interface{}) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(lssString(v), "+"))
+	return n
 }
 
 func (g *Game) loadAllCharacters() error {
@@ -206,7 +351,7 @@ func (g *Game) loadAllCharacters() error {
 	if _, err := os.Stat(charactersDir); os.IsNotExist(err) {
 		msg := fmt.Sprintf("Директория не найдена: %s", charactersDir)
 		log.Printf("[ERROR] %s", msg)
-		return fmt.Errorf(msg)
+		return errors.New(msg)
 	}
 
 	files, err := os.ReadDir(charactersDir)
@@ -254,7 +399,7 @@ func (g *Game) loadAllCharacters() error {
 	if loadedChars == 0 {
 		msg := "Не найдено валидных файлов персонажей"
 		log.Printf("[ERROR] %s", msg)
-		return fmt.Errorf(msg)
+		return errors.New(msg)
 	}
 
 	g.currentCharacter = g.characters[0]
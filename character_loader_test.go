@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"test/internal/rules"
+)
+
+var (
+	jpegAvatar = []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F'}
+	webpAvatar = []byte("RIFF")
+)
+
+// Выгрузки LSS в testdata: data строкой JSON, data объектом и файл с BOM.
+// Лист во всех один и тот же, у файла с BOM портрет только в webp.
+func TestLoadLSSFixtures(t *testing.T) {
+	tests := []struct {
+		file     string
+		portrait []byte
+	}{
+		{"testdata/lss_string.json", jpegAvatar},
+		{"testdata/lss_object.json", jpegAvatar},
+		{"testdata/lss_bom.json", webpAvatar},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			char, err := (&Game{}).loadCharacterFromFile(tt.file)
+			if err != nil {
+				t.Fatalf("загрузка: %v", err)
+			}
+			if char.Format != "lss" {
+				t.Errorf("формат %q, ожидался lss", char.Format)
+			}
+			if char.Name != "Ирвен" || char.Class != "Паладин" || char.Level != 3 {
+				t.Errorf("персонаж %q, %q, уровень %d", char.Name, char.Class, char.Level)
+			}
+			if char.Stats["str"] != 16 || char.Stats["cha"] != 15 {
+				t.Errorf("характеристики %v", char.Stats)
+			}
+			checkLSSSheet(t, char)
+			if !bytes.Equal(char.Portrait, tt.portrait) {
+				t.Errorf("портрет % x, ожидался % x", char.Portrait, tt.portrait)
+			}
+		})
+	}
+}
+
+func checkLSSSheet(t *testing.T, char *Character) {
+	t.Helper()
+
+	wantProf := map[string]rules.Proficiency{
+		"athletics": rules.Proficient,
+		"religion":  rules.Expertise,
+		"stealth":   rules.NotProficient,
+	}
+	if !reflect.DeepEqual(char.SkillProficiency, wantProf) {
+		t.Errorf("владение навыками %v, ожидалось %v", char.SkillProficiency, wantProf)
+	}
+	if char.SaveProficiency["cha"] != rules.Proficient || char.SaveProficiency["str"] != rules.NotProficient {
+		t.Errorf("владение спасбросками %v", char.SaveProficiency)
+	}
+	if want := []string{"Атлетика", "Религия (компетентность)"}; !reflect.DeepEqual(char.Skills, want) {
+		t.Errorf("навыки %q, ожидалось %q", char.Skills, want)
+	}
+
+	// Пустая строка оружия пропускается, числа читаются как строки
+	wantWeapons := []Weapon{
+		{Name: "Длинный меч", Attack: "+5", Damage: "1d8+3 руб.", Notes: "универсальное"},
+		{Name: "Метательное копьё", Attack: "5", Damage: "1d6+3 кол."},
+	}
+	if !reflect.DeepEqual(char.Weapons, wantWeapons) {
+		t.Errorf("оружие %+v, ожидалось %+v", char.Weapons, wantWeapons)
+	}
+
+	// Монеты бывают числами, строками с плюсом и {"value": ...}; мусор - ноль
+	if want := (Coins{CP: 12, SP: 7, EP: 1, GP: 15}); char.Coins != want {
+		t.Errorf("монеты %+v, ожидалось %+v", char.Coins, want)
+	}
+
+	// Потраченных ячеек не больше, чем всего; круги без ячеек пропускаются
+	wantSlots := []SpellSlot{{Level: 1, Total: 3, Used: 3}, {Level: 2, Total: 2, Used: 1}}
+	if !reflect.DeepEqual(char.SpellSlots, wantSlots) {
+		t.Errorf("ячейки %+v, ожидалось %+v", char.SpellSlots, wantSlots)
+	}
+
+	if char.HitDice != "3d10" {
+		t.Errorf("кости хитов %q, ожидалось 3d10", char.HitDice)
+	}
+	if want := (DeathSaves{Successes: 2, Failures: 1}); char.DeathSaves != want {
+		t.Errorf("спасброски от смерти %+v, ожидалось %+v", char.DeathSaves, want)
+	}
+	if char.HP != "24" || char.MaxHP != "28" || char.TempHP != "5" || char.AC != "18" || char.Speed != "30" {
+		t.Errorf("хиты %s/%s (+%s), КД %s, скорость %s", char.HP, char.MaxHP, char.TempHP, char.AC, char.Speed)
+	}
+
+	if want := []string{"poisoned", "prone"}; !reflect.DeepEqual(char.Conditions, want) {
+		t.Errorf("состояния %q, ожидалось %q", char.Conditions, want)
+	}
+	if want := []string{"Все доспехи, щиты", "Языки: общий, небесный"}; !reflect.DeepEqual(char.Proficiencies, want) {
+		t.Errorf("владения %q, ожидалось %q", char.Proficiencies, want)
+	}
+	if want := []string{"Кольчуга", "Щит"}; !reflect.DeepEqual(char.Equipment, want) {
+		t.Errorf("снаряжение %q, ожидалось %q", char.Equipment, want)
+	}
+	if char.Description != "Вырос при храме." || char.Notes != "Должен гильдии 10 зм." {
+		t.Errorf("описание %q, заметки %q", char.Description, char.Notes)
+	}
+	if want := []string{"Благословение", "Лечение ран"}; !reflect.DeepEqual(char.Spells, want) {
+		t.Errorf("заклинания %q, ожидалось %q", char.Spells, want)
+	}
+}
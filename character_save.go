@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Write записывает правки, сделанные в игре, обратно в лист Long Story Short.
+// Файл читается заново, и меняются только поля, которые можно править в
+// игре и которые действительно изменились. Поле пишется в том же виде, в
+// каком лежало: голое число остаётся числом, {"value": ...} - объектом, а
+// отсутствующее появляется, только если в нём есть что записать. Всё, чего
+// загрузчик не понимает, остаётся как было, и лист по-прежнему
+// импортируется обратно в веб-сервис.
+func (lssFormat) Write(char *Character) error {
+	if char.Path == "" {
+		return fmt.Errorf("у персонажа %s нет файла", char.Name)
+	}
+	content, err := os.ReadFile(char.Path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	bom := bytes.HasPrefix(content, []byte("\ufeff"))
+	content = bytes.TrimPrefix(content, []byte("\ufeff"))
+
+	// Снаружи - объект, в поле data которого лежит лист строкой JSON
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("ошибка парсинга JSON: %w", err)
+	}
+	inner, err := lssData(file["data"])
+	if err != nil {
+		return err
+	}
+	asString := file["data"][0] == '"'
+	// UseNumber сохраняет числа как есть, без округления через float64
+	dec := json.NewDecoder(bytes.NewReader(inner))
+	dec.UseNumber()
+	var data map[string]interface{}
+	if err := dec.Decode(&data); err != nil {
+		return fmt.Errorf("ошибка парсинга вложенного JSON: %w", err)
+	}
+
+	setLSSField(data, "vitality", "hp-current", char.HP)
+	setLSSField(data, "vitality", "hp-temp", char.TempHP)
+
+	// Ячейки пишем только в круги, которые уже есть в листе, и только если
+	// загрузчик прочёл бы из них другое число
+	spells, _ := data["spells"].(map[string]interface{})
+	for _, slot := range char.SpellSlots {
+		s, ok := spells[fmt.Sprintf("slots-%d", slot.Level)].(map[string]interface{})
+		if ok && clamp(lssInt(s["filled"]), 0, lssInt(s["value"])) != slot.Used {
+			s["filled"] = lssShaped(s["filled"], strconv.Itoa(slot.Used))
+		}
+	}
+
+	var old []string
+	list, hasConditions := data["conditions"].([]interface{})
+	for _, c := range list {
+		if code, ok := c.(string); ok && code != "" {
+			old = append(old, code)
+		}
+	}
+	if !slices.Equal(old, char.Conditions) && (hasConditions || len(char.Conditions) > 0) {
+		conditions := make([]interface{}, 0, len(char.Conditions))
+		for _, c := range char.Conditions {
+			conditions = append(conditions, c)
+		}
+		data["conditions"] = conditions
+	}
+
+	// Заметки переписываем, только если их правили: иначе потеряется разметка
+	text, _ := data["text"].(map[string]interface{})
+	notes, _ := text["notes-1"].(map[string]interface{})
+	oldNotes, _ := marshalJSON(notes["value"])
+	if extractTextContent(oldNotes) != char.Notes {
+		jsonObject(jsonObject(data, "text"), "notes-1")["value"] = proseMirrorDoc(char.Notes)
+	}
+
+	encoded, err := marshalJSON(data)
+	if err != nil {
+		return err
+	}
+	file["data"] = encoded
+	if asString {
+		if file["data"], err = marshalJSON(string(encoded)); err != nil {
+			return err
+		}
+	}
+	out, err := marshalJSON(file)
+	if err != nil {
+		return err
+	}
+	if bom {
+		out = append([]byte("\ufeff"), out...)
+	}
+	return writeFileAtomic(char.Path, out)
+}
+
+// jsonObject возвращает вложенный объект, создавая его, если поля нет
+func jsonObject(parent map[string]interface{}, key string) map[string]interface{} {
+	if obj, ok := parent[key].(map[string]interface{}); ok {
+		return obj
+	}
+	obj := make(map[string]interface{})
+	parent[key] = obj
+	return obj
+}
+
+// setLSSField пишет значение поля key раздела section в том виде, в каком
+// оно уже лежит в листе: голым числом, строкой или {"value": ...}. Поле без
+// изменений не трогается, а отсутствующее добавляется в виде {"value": ...},
+// только если значение не пустое.
+func setLSSField(data map[string]interface{}, section, key, value string) {
+	obj, _ := data[section].(map[string]interface{})
+	old, exists := obj[key]
+	if lssString(old) == value || (!exists && value == "") {
+		return
+	}
+	if wrapped, ok := old.(map[string]interface{}); ok {
+		wrapped["value"] = lssShaped(wrapped["value"], value)
+		return
+	}
+	if !exists {
+		jsonObject(data, section)[key] = map[string]interface{}{"value": lssNumber(value)}
+		return
+	}
+	obj[key] = lssShaped(old, value)
+}
+
+// lssShaped возвращает значение того же вида, что old: строка остаётся
+// строкой, а число - числом, если новое значение тоже целое
+func lssShaped(old interface{}, value string) interface{} {
+	if _, ok := old.(string); ok {
+		return value
+	}
+	return lssNumber(value)
+}
+
+// lssNumber возвращает число, если строка - целое, иначе саму строку
+func lssNumber(s string) interface{} {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return s
+}
+
+// proseMirrorDoc превращает текст в документ редактора листа: абзац на строку
+func proseMirrorDoc(s string) map[string]interface{} {
+	var paragraphs []interface{}
+	for _, line := range strings.Split(s, "\n") {
+		p := map[string]interface{}{"type": "paragraph"}
+		if line != "" {
+			p["content"] = []interface{}{map[string]interface{}{"type": "text", "text": line}}
+		}
+		paragraphs = append(paragraphs, p)
+	}
+	return map[string]interface{}{
+		"data": map[string]interface{}{"type": "doc", "content": paragraphs},
+	}
+}
+
+// marshalJSON кодирует без экранирования <, > и &, как их и пишет веб-сервис
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
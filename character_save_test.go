@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// copyFixture копирует лист из testdata во временный каталог, чтобы запись
+// не портила образцы
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readLSS читает лист как есть, с числами json.Number; data-строка
+// раскрывается, а её вид возвращается отдельно
+func readLSS(t *testing.T, path string) (file, data map[string]interface{}, bom, asString bool) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bom = bytes.HasPrefix(content, []byte("\ufeff"))
+	decode := func(b []byte, v interface{}) {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	decode(bytes.TrimPrefix(content, []byte("\ufeff")), &file)
+	if s, ok := file["data"].(string); ok {
+		asString = true
+		decode([]byte(s), &data)
+	} else {
+		data, _ = file["data"].(map[string]interface{})
+	}
+	delete(file, "data")
+	return file, data, bom, asString
+}
+
+// Запись без правок не меняет лист: ни вида полей, ни BOM, ни формы data
+func TestLSSWriteRoundTrip(t *testing.T) {
+	for _, name := range []string{"lss_string.json", "lss_object.json", "lss_bom.json", "lss_bare.json"} {
+		t.Run(name, func(t *testing.T) {
+			path := copyFixture(t, name)
+			wantFile, wantData, wantBOM, wantString := readLSS(t, path)
+
+			char, err := (&Game{}).loadCharacterFromFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := (lssFormat{}).Write(char); err != nil {
+				t.Fatal(err)
+			}
+
+			file, data, bom, asString := readLSS(t, path)
+			if bom != wantBOM || asString != wantString {
+				t.Errorf("BOM %v, data строкой %v; было %v, %v", bom, asString, wantBOM, wantString)
+			}
+			if !reflect.DeepEqual(file, wantFile) {
+				t.Errorf("внешний объект изменился:\n%v\nбыло\n%v", file, wantFile)
+			}
+			if !reflect.DeepEqual(data, wantData) {
+				t.Errorf("лист изменился:\n%v\nбыло\n%v", data, wantData)
+			}
+		})
+	}
+}
+
+// Правки пишутся в поля того же вида, что были в листе
+func TestLSSWriteKeepsShape(t *testing.T) {
+	path := copyFixture(t, "lss_object.json")
+	char, err := (&Game{}).loadCharacterFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	char.HP = "20"
+	char.TempHP = "7"
+	char.SpellSlots = []SpellSlot{{Level: 1, Total: 3, Used: 1}, {Level: 2, Total: 2, Used: 2}}
+	char.Conditions = []string{"prone"}
+	char.Notes = "Долг уплачен"
+	if err := (lssFormat{}).Write(char); err != nil {
+		t.Fatal(err)
+	}
+
+	_, data, _, _ := readLSS(t, path)
+	vit := data["vitality"].(map[string]interface{})
+	spells := data["spells"].(map[string]interface{})
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"hp-current", vit["hp-current"], map[string]interface{}{"value": "20"}},
+		{"hp-temp", vit["hp-temp"], map[string]interface{}{"value": json.Number("7")}},
+		{"hp-max", vit["hp-max"], json.Number("28")},
+		{"slots-1", spells["slots-1"], map[string]interface{}{"value": json.Number("3"), "filled": json.Number("1")}},
+		{"slots-2", spells["slots-2"], map[string]interface{}{"value": "2", "filled": "2"}},
+		{"conditions", data["conditions"], []interface{}{"prone"}},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s = %#v, ожидалось %#v", c.name, c.got, c.want)
+		}
+	}
+
+	reloaded, err := (&Game{}).loadCharacterFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.HP != "20" || reloaded.TempHP != "7" || reloaded.Notes != "Долг уплачен" {
+		t.Errorf("после записи: хиты %s (+%s), заметки %q", reloaded.HP, reloaded.TempHP, reloaded.Notes)
+	}
+}
+
+// Голые числа остаются числами, а отсутствующие поля не появляются
+func TestLSSWriteBareFields(t *testing.T) {
+	path := copyFixture(t, "lss_bare.json")
+	char, err := (&Game{}).loadCharacterFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	char.HP = "11"
+	if err := (lssFormat{}).Write(char); err != nil {
+		t.Fatal(err)
+	}
+
+	_, data, _, _ := readLSS(t, path)
+	vit := data["vitality"].(map[string]interface{})
+	if vit["hp-current"] != json.Number("11") {
+		t.Errorf("hp-current = %#v, ожидалось голое число 11", vit["hp-current"])
+	}
+	if _, ok := vit["hp-temp"]; ok {
+		t.Errorf("появилось hp-temp: %#v", vit["hp-temp"])
+	}
+	if _, ok := data["conditions"]; ok {
+		t.Errorf("появились conditions: %#v", data["conditions"])
+	}
+	if _, ok := data["spells"]; ok {
+		t.Errorf("появились ячейки заклинаний: %#v", data["spells"])
+	}
+
+	// Состояние, наложенное в игре, всё же добавляет поле
+	char.Conditions = []string{"stunned"}
+	if err := (lssFormat{}).Write(char); err != nil {
+		t.Fatal(err)
+	}
+	_, data, _, _ = readLSS(t, path)
+	if want := []interface{}{"stunned"}; !reflect.DeepEqual(data["conditions"], want) {
+		t.Errorf("conditions = %#v, ожидалось %#v", data["conditions"], want)
+	}
+}
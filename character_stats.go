@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"test/internal/rules"
+)
+
+// Derived считает производные числа персонажа по правилам 5e
+func (c *Character) Derived() rules.Derived {
+	return rules.Derive(rules.Sheet{
+		Level:        c.Level,
+		Class:        c.Class,
+		Scores:       c.Stats,
+		Saves:        c.SaveProficiency,
+		Skills:       c.SkillProficiency,
+		SpellAbility: c.SpellAbility,
+	})
+}
+
+// lssProficiency переводит isProf из листа Long Story Short: там бывает
+// true/false, а у навыков 0, 1 или 2 (компетентность)
+func lssProficiency(v interface{}) rules.Proficiency {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return rules.Proficient
+		}
+	case float64:
+		switch {
+		case v >= 2:
+			return rules.Expertise
+		case v >= 1:
+			return rules.Proficient
+		}
+	}
+	return rules.NotProficient
+}
+
+// proficiencyMark - пометка владения рядом с бонусом в окне персонажа
+func proficiencyMark(p rules.Proficiency) string {
+	switch p {
+	case rules.Proficient:
+		return "*"
+	case rules.Expertise:
+		return "**"
+	default:
+		return ""
+	}
+}
+
+func formatBonus(n int) string {
+	return fmt.Sprintf("%+d", n)
+}
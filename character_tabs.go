@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+
+	"test/internal/rules"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+// sheetPage - содержимое вкладки в координатах от левого верхнего угла
+// области прокрутки. По одной и той же странице вкладка рисуется и
+// проверяются щелчки.
+type sheetPage struct {
+	g       *Game
+	width   int
+	y       int // Верх следующей строки
+	texts   []sheetText
+	boxes   []sheetBox
+	rich    []sheetRich
+	buttons []sheetButton
+	notes   image.Rectangle // Поле заметок: щелчок в нём начинает правку
+	height  int
+}
+
+type sheetText struct {
+	x, y int // y - базовая линия
+	text string
+	clr  color.Color
+}
+
+type sheetBox struct {
+	rect    image.Rectangle
+	clr     color.Color
+	outline color.Color // nil - без рамки
+}
+
+// sheetRich - разложенный документ с левым верхним углом в x, y
+type sheetRich struct {
+	x, y  int
+	lines []richLine
+}
+
+var (
+	sheetHeaderClr = color.RGBA{255, 255, 0, 255}
+	sheetDimClr    = color.RGBA{200, 200, 200, 255}
+	sheetEmptyClr  = color.RGBA{150, 150, 150, 255}
+)
+
+// textAt пишет строку в строке страницы с верхом top, обрезая по ширине
+func (p *sheetPage) textAt(x, top int, s string, clr color.Color) {
+	p.texts = append(p.texts, sheetText{x, top + 15, truncateText(s, p.width-x, p.g), clr})
+}
+
+// line пишет строку и переходит к следующей
+func (p *sheetPage) line(s string, clr color.Color) {
+	p.textAt(0, p.y, s, clr)
+	p.y += sheetLineH
+}
+
+// header - заголовок раздела с отступом сверху
+func (p *sheetPage) header(s string) {
+	if p.y > 0 {
+		p.y += 8
+	}
+	p.line(s, sheetHeaderClr)
+}
+
+// para пишет абзац с переносом по словам
+func (p *sheetPage) para(s string, indent int, clr color.Color) {
+	for _, l := range wrapText(s, p.width-indent, p.g.font) {
+		p.textAt(indent, p.y, l, clr)
+		p.y += sheetLineH
+	}
+}
+
+// list пишет пункты с маркерами или empty, если пунктов нет
+func (p *sheetPage) list(items []string, empty string) {
+	if len(items) == 0 {
+		p.line(empty, sheetEmptyClr)
+		return
+	}
+	for _, item := range items {
+		p.para("• "+item, 0, color.White)
+	}
+}
+
+func (p *sheetPage) button(x, y, w, h int, label string, active bool, action func(*Game)) {
+	p.buttons = append(p.buttons, sheetButton{interiorButton: interiorButton{x, y, w, h, label, action}, active: active})
+}
+
+// draw рисует страницу со сдвигом ox, oy; dst обрезан областью прокрутки
+func (p *sheetPage) draw(g *Game, dst *ebiten.Image, ox, oy int) {
+	for _, b := range p.boxes {
+		r := b.rect.Add(image.Pt(ox, oy))
+		ebitenutil.DrawRect(dst, float64(r.Min.X), float64(r.Min.Y), float64(r.Dx()), float64(r.Dy()), b.clr)
+		if b.outline != nil {
+			drawRectOutline(dst, float64(r.Min.X), float64(r.Min.Y), float64(r.Dx()), float64(r.Dy()), 1, b.outline)
+		}
+	}
+	for _, t := range p.texts {
+		text.Draw(dst, t.text, g.font, ox+t.x, oy+t.y, t.clr)
+	}
+	for _, r := range p.rich {
+		drawRichLines(dst, r.lines, ox+r.x, oy+r.y)
+	}
+	for _, btn := range p.buttons {
+		drawSheetButton(g, dst, btn, ox, oy)
+	}
+}
+
+// buildPage раскладывает текущую вкладку под ширину области content
+func (s *characterScene) buildPage(g *Game, char *Character, content image.Rectangle) sheetPage {
+	p := &sheetPage{g: g, width: content.Dx()}
+	switch s.tab {
+	case tabOverview:
+		s.overviewPage(p, char)
+	case tabSkills:
+		skillsPage(p, char)
+	case tabCombat:
+		combatPage(p, char)
+	case tabSpells:
+		spellsPage(p, char)
+	case tabInventory:
+		inventoryPage(p, char)
+	case tabNotes:
+		s.notesPage(p, char, content.Dy())
+	}
+	p.height = p.y + 8
+	return *p
+}
+
+// hpLine - хиты вида 24/27 (+5)
+func hpLine(char *Character) string {
+	hp := orUnknown(char.HP)
+	if char.MaxHP != "" {
+		hp += "/" + char.MaxHP
+	}
+	if t := lssInt(char.TempHP); t > 0 {
+		hp += fmt.Sprintf(" (+%d)", t)
+	}
+	return hp
+}
+
+func conditionNames(codes []string) string {
+	names := make([]string, 0, len(codes))
+	for _, c := range codes {
+		names = append(names, rules.ConditionName(c))
+	}
+	return strings.Join(names, ", ")
+}
+
+// overviewPage - основное: раса, хиты, характеристики, производные числа и описание
+func (s *characterScene) overviewPage(p *sheetPage, char *Character) {
+	d := char.Derived()
+	p.line("Раса: "+orUnknown(char.Race), color.White)
+	p.line("Предыстория: "+orUnknown(char.Background), color.White)
+	p.line(fmt.Sprintf("Хиты: %s | КД: %s | Скорость: %s", hpLine(char), orUnknown(char.AC), orUnknown(char.Speed)), color.White)
+	if len(char.Conditions) > 0 {
+		p.para("Состояния: "+conditionNames(char.Conditions), 0, color.RGBA{230, 120, 120, 255})
+	}
+
+	// Характеристики: значение, модификатор и спасбросок; * - владение
+	p.header("Характеристики (спасбросок):")
+	colW := p.width / 2
+	for i, stat := range rules.Abilities {
+		line := fmt.Sprintf("%s: %d %s (%s%s)", rules.AbilityNames[stat], char.Stats[stat],
+			formatBonus(d.Modifiers[stat]), formatBonus(d.Saves[stat]), proficiencyMark(char.SaveProficiency[stat]))
+		p.textAt((i%2)*colW, p.y+(i/2)*sheetLineH, truncateText(line, colW-5, p.g), color.White)
+	}
+	p.y += (len(rules.Abilities) + 1) / 2 * sheetLineH
+
+	p.y += 6
+	p.para(fmt.Sprintf("Мастерство %s | Инициатива %s | Атака: ближ. %s, дальн. %s",
+		formatBonus(d.Proficiency), formatBonus(d.Initiative), formatBonus(d.MeleeAttack), formatBonus(d.RangedAttack)), 0, sheetDimClr)
+	p.para(fmt.Sprintf("Пассивные: внимательность %d, анализ %d, проницательность %d",
+		d.Passive["perception"], d.Passive["investigation"], d.Passive["insight"]), 0, sheetDimClr)
+	if d.SpellAbility != "" {
+		p.para(fmt.Sprintf("Заклинания (%s): СЛ спасброска %d, атака %s",
+			rules.AbilityNames[d.SpellAbility], d.SpellSaveDC, formatBonus(d.SpellAttack)), 0, sheetDimClr)
+	}
+
+	p.header("Описание:")
+	if len(char.DescriptionDoc.Lines()) == 0 {
+		p.line("Нет", sheetEmptyClr)
+		return
+	}
+	s.description.update(p.g, char.DescriptionDoc, p.width)
+	p.rich = append(p.rich, sheetRich{0, p.y, s.description.lines})
+	p.y += s.description.height
+}
+
+// skillsPage - навыки с бонусами, пассивные чувства, владения и языки
+func skillsPage(p *sheetPage, char *Character) {
+	d := char.Derived()
+	p.header("Навыки (* владение, ** компетентность):")
+	cols := 1
+	if p.width >= 440 {
+		cols = 2
+	}
+	colW := p.width / cols
+	rows := (len(rules.Skills) + cols - 1) / cols
+	for i, skill := range rules.Skills {
+		x, top := (i/rows)*colW, p.y+(i%rows)*sheetLineH
+		bonus := formatBonus(d.Skills[skill.Code]) + proficiencyMark(char.SkillProficiency[skill.Code])
+		bonusX := x + colW - 20 - text.BoundString(p.g.font, bonus).Dx()
+		clr := color.Color(color.White)
+		if char.SkillProficiency[skill.Code] == rules.NotProficient {
+			clr = sheetDimClr
+		}
+		p.textAt(x, top, truncateText(fmt.Sprintf("%s (%s)", skill.Name, abilityShort(skill.Ability)), bonusX-x-8, p.g), clr)
+		p.textAt(bonusX, top, bonus, clr)
+	}
+	p.y += rows * sheetLineH
+
+	p.y += 6
+	p.para(fmt.Sprintf("Пассивные: внимательность %d, анализ %d, проницательность %d",
+		d.Passive["perception"], d.Passive["investigation"], d.Passive["insight"]), 0, sheetDimClr)
+
+	p.header("Владения и языки:")
+	if len(char.Proficiencies) == 0 {
+		p.line("Нет", sheetEmptyClr)
+	}
+	for _, line := range char.Proficiencies {
+		p.para(line, 0, color.White)
+	}
+}
+
+// combatPage - КД, хиты с кнопками правки, состояния и оружие
+func combatPage(p *sheetPage, char *Character) {
+	d := char.Derived()
+	p.line(fmt.Sprintf("КД: %s | Инициатива: %s | Скорость: %s", orUnknown(char.AC), formatBonus(d.Initiative),
+		orUnknown(char.Speed)), color.White)
+	p.line(fmt.Sprintf("Кости хитов: %s | Мастерство: %s", orUnknown(char.HitDice), formatBonus(d.Proficiency)), color.White)
+	p.line(fmt.Sprintf("Спасброски от смерти: успехи %d/3, провалы %d/3", char.DeathSaves.Successes, char.DeathSaves.Failures),
+		color.White)
+
+	// Хиты и временные хиты с кнопками
+	const buttonsX = 170
+	p.y += 8
+	hp := "Хиты: " + orUnknown(char.HP)
+	if char.MaxHP != "" {
+		hp += "/" + char.MaxHP
+	}
+	p.textAt(0, p.y+2, hp, color.White)
+	for i, delta := range []int{-5, -1, 1, 5} {
+		delta := delta
+		p.button(buttonsX+i*55, p.y, 50, 24, fmt.Sprintf("%+d", delta), false, func(*Game) { char.changeHP(delta) })
+	}
+	p.y += 30
+	p.textAt(0, p.y+2, "Временные: "+orUnknown(char.TempHP), color.White)
+	for i, delta := range []int{-1, 1, 5} {
+		delta := delta
+		p.button(buttonsX+i*55, p.y, 50, 24, fmt.Sprintf("%+d", delta), false, func(*Game) { char.changeTempHP(delta) })
+	}
+	p.button(buttonsX+3*55, p.y, 50, 24, "0", false, func(*Game) { char.changeTempHP(-lssInt(char.TempHP)) })
+	p.y += 30
+
+	// Состояния переключаются щелчком
+	p.header("Состояния (щелчок - вкл/выкл):")
+	cols := max(p.width/(conditionChipW+5), 1)
+	for i, cond := range rules.Conditions {
+		code := cond.Code
+		p.button((i%cols)*(conditionChipW+5), p.y+(i/cols)*(conditionChipH+4), conditionChipW, conditionChipH, cond.Name,
+			slices.Contains(char.Conditions, code), func(*Game) { char.toggleCondition(code) })
+	}
+	p.y += (len(rules.Conditions) + cols - 1) / cols * (conditionChipH + 4)
+
+	p.header("Оружие:")
+	if len(char.Weapons) == 0 {
+		p.line("Нет", sheetEmptyClr)
+	}
+	nameW := p.width * 2 / 5
+	for _, w := range char.Weapons {
+		p.textAt(0, p.y, truncateText(w.Name, nameW-8, p.g), color.White)
+		p.textAt(nameW, p.y, w.Attack, color.White)
+		p.textAt(nameW+60, p.y, w.Damage, color.White)
+		p.y += sheetLineH
+		if w.Notes != "" {
+			p.para(w.Notes, 16, sheetDimClr)
+		}
+	}
+}
+
+// spellsPage - заклинательная характеристика, ячейки с отметками и список заклинаний
+func spellsPage(p *sheetPage, char *Character) {
+	d := char.Derived()
+	if d.SpellAbility != "" {
+		p.para(fmt.Sprintf("Характеристика: %s | СЛ спасброска %d | Атака %s",
+			rules.AbilityNames[d.SpellAbility], d.SpellSaveDC, formatBonus(d.SpellAttack)), 0, sheetDimClr)
+	}
+
+	p.header("Ячейки заклинаний (щелчок - потратить):")
+	if len(char.SpellSlots) == 0 {
+		p.line("Нет", sheetEmptyClr)
+	}
+	for _, slot := range char.SpellSlots {
+		p.textAt(0, p.y, fmt.Sprintf("%d круг: %d/%d", slot.Level, slot.Total-slot.Used, slot.Total), color.White)
+		for box := 0; box < slot.Total; box++ {
+			level, b := slot.Level, box
+			p.button(150+box*(slotBoxSize+4), p.y, slotBoxSize, slotBoxSize, "", box < slot.Used,
+				func(*Game) { char.toggleSlot(level, b) })
+		}
+		p.y += slotBoxSize + 6
+	}
+
+	p.header("Заклинания:")
+	p.list(char.Spells, "Нет")
+}
+
+// inventoryPage - монеты и снаряжение
+func inventoryPage(p *sheetPage, char *Character) {
+	p.header("Монеты:")
+	if coins := coinLine(char.Coins); coins != "" {
+		p.line(coins, color.White)
+	} else {
+		p.line("Нет", sheetEmptyClr)
+	}
+	p.header("Снаряжение:")
+	p.list(char.Equipment, "Нет")
+}
+
+// notesPage - заметки: с разметкой, пока их не правят, и простым текстом с
+// курсором при наборе. Поле занимает хотя бы всю видимую часть вкладки.
+func (s *characterScene) notesPage(p *sheetPage, char *Character, visibleH int) {
+	p.header("Заметки (щелчок - править, Esc - закончить):")
+	top := p.y
+	textH := 0
+	if s.editingNotes {
+		var lines []string
+		for _, para := range strings.Split(char.Notes+"_", "\n") {
+			wrapped := wrapText(para, p.width-10, p.g.font)
+			if len(wrapped) == 0 {
+				wrapped = []string{""}
+			}
+			lines = append(lines, wrapped...)
+		}
+		for i, line := range lines {
+			p.textAt(5, top+4+i*sheetNotesLineH, line, color.White)
+		}
+		textH = len(lines) * sheetNotesLineH
+	} else {
+		s.notes.update(p.g, char.NotesDoc, p.width-10)
+		p.rich = append(p.rich, sheetRich{5, top + 4, s.notes.lines})
+		textH = s.notes.height
+	}
+
+	h := max(textH+10, visibleH-top-8)
+	p.notes = image.Rect(0, top, p.width, top+h)
+	var outline color.Color
+	if s.editingNotes {
+		outline = sheetHeaderClr
+	}
+	p.boxes = append(p.boxes, sheetBox{p.notes, color.RGBA{20, 20, 25, 255}, outline})
+	p.y = top + h
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"image"
 	"image/color"
+	"math"
 	"strings"
 
+	"test/internal/richtext"
+
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
@@ -13,11 +17,17 @@ import (
 )
 
 const (
-	characterWindowWidth  = 500
-	characterWindowHeight = 600
-	buttonHeight          = 30
-	buttonWidth           = 80
-	navButtonWidth        = 40
+	sheetDefaultW = 620
+	sheetDefaultH = 760
+	sheetMinW     = 470 // Чтобы поместились кнопки внизу
+	sheetMinH     = 360
+	sheetTitleH   = 34
+	sheetTabH     = 28
+	sheetFooterH  = 64 // Строка состояния и кнопки
+	sheetPadding  = 12
+	sheetGripSize = 16
+	sheetLineH    = 20
+	sheetScroll   = 40 // Шаг прокрутки колесом и стрелками
 )
 
 // Вспомогательные функции
@@ -35,33 +45,6 @@ func min(a, b int) int {
 	return b
 }
 
-// Выносим отрисовку статистики в отдельный метод
-func (g *Game) drawCharacterStats(screen *ebiten.Image, char *Character, padding int) {
-	// Основная информация
-	infoY := 60
-	text.Draw(screen, fmt.Sprintf("Раса: %s", char.Race), g.font, padding, infoY, color.White)
-	text.Draw(screen, fmt.Sprintf("Предыстория: %s", char.Background), g.font, padding, infoY+20, color.White)
-
-	// Характеристики
-	statsY := infoY + 60
-	text.Draw(screen, "Характеристики:", g.font, padding, statsY, color.RGBA{255, 255, 0, 255})
-	statsY += 20
-
-	// Оптимизация: используем итерацию по карте для характеристик
-	statsOrder := []string{"str", "dex", "con", "int", "wis", "cha"}
-	statNames := map[string]string{
-		"str": "Сила", "dex": "Ловкость", "con": "Телосложение",
-		"int": "Интеллект", "wis": "Мудрость", "cha": "Харизма",
-	}
-
-	for i, stat := range statsOrder {
-		x := padding + (i%3)*140
-		y := statsY + (i/3)*20
-		text.Draw(screen, fmt.Sprintf("%s: %d", statNames[stat], char.Stats[stat]),
-			g.font, x, y, color.White)
-	}
-}
-
 // wrapText разбивает текст на строки по ширине
 func wrapText(textStr string, maxWidth int, font font.Face) []string {
 	var result []string
@@ -96,121 +79,316 @@ func wrapText(textStr string, maxWidth int, font font.Face) []string {
 	return result
 }
 
-func (g *Game) handleCharacterWindowInput() {
-	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
-		mx, my := ebiten.CursorPosition()
+// characterTab - вкладка листа персонажа
+type characterTab int
 
-		// Проверка кнопки закрытия
-		buttonX := characterWindowWidth - buttonWidth - 10
-		buttonY := characterWindowHeight - buttonHeight - 10
-		if mx >= buttonX && mx <= buttonX+buttonWidth &&
-			my >= buttonY && my <= buttonY+buttonHeight {
-			g.characterWindowOpen = false
-			return
-		}
+const (
+	tabOverview characterTab = iota
+	tabSkills
+	tabCombat
+	tabSpells
+	tabInventory
+	tabNotes
+	tabCount
+)
 
-		// Проверка кнопок навигации (если персонажей больше одного)
-		if len(g.characters) > 1 {
-			prevBtnX := 10
-			nextBtnX := characterWindowWidth - navButtonWidth - 10
-			navBtnY := characterWindowHeight - buttonHeight - 10
-
-			if mx >= prevBtnX && mx <= prevBtnX+navButtonWidth &&
-				my >= navBtnY && my <= navBtnY+buttonHeight {
-				g.characterIndex--
-				if g.characterIndex < 0 {
-					g.characterIndex = len(g.characters) - 1
-				}
-				g.currentCharacter = g.characters[g.characterIndex]
-			}
+var characterTabNames = [tabCount]string{"Обзор", "Навыки", "Бой", "Заклинания", "Снаряжение", "Заметки"}
 
-			if mx >= nextBtnX && mx <= nextBtnX+navButtonWidth &&
-				my >= navBtnY && my <= navBtnY+buttonHeight {
-				g.characterIndex++
-				if g.characterIndex >= len(g.characters) {
-					g.characterIndex = 0
-				}
-				g.currentCharacter = g.characters[g.characterIndex]
-			}
-		}
+// windowDrag - что сейчас тянут мышью: окно за заголовок или его угол
+type windowDrag int
+
+const (
+	dragNone windowDrag = iota
+	dragMove
+	dragResize
+)
+
+// characterScene - лист персонажа в окне поверх текущей сцены. Лист разбит
+// на вкладки с прокруткой; окно двигается за заголовок и растягивается за
+// правый нижний угол. Правки записываются в файл листа при закрытии окна и
+// при переключении на другого персонажа.
+type characterScene struct {
+	x, y, w, h   int
+	tab          characterTab
+	scroll       [tabCount]int
+	pageHeight   int // Высота содержимого вкладки при последней отрисовке
+	drag         windowDrag
+	dragX, dragY int // Курсор относительно угла окна в начале перетаскивания
+	editingNotes bool
+	status       string // Итог последнего сохранения или выгрузки
+	description  richLayout
+	notes        richLayout
+}
+
+func newCharacterScene() *characterScene {
+	return &characterScene{x: 40, y: 40, w: sheetDefaultW, h: sheetDefaultH}
+}
+
+func (*characterScene) Overlay() bool { return true }
+
+// Геометрия окна. Одни и те же прямоугольники используются и для
+// отрисовки, и для проверки щелчков.
+
+func (s *characterScene) titleRect() image.Rectangle {
+	return image.Rect(s.x, s.y, s.x+s.w, s.y+sheetTitleH)
+}
+
+func (s *characterScene) tabRect(tab characterTab) image.Rectangle {
+	tabW := (s.w - sheetPadding*2) / int(tabCount)
+	x := s.x + sheetPadding + int(tab)*tabW
+	return image.Rect(x, s.y+sheetTitleH, x+tabW-4, s.y+sheetTitleH+sheetTabH)
+}
+
+// contentRect - область прокрутки вкладки без полосы прокрутки
+func (s *characterScene) contentRect() image.Rectangle {
+	top := s.y + sheetTitleH + sheetTabH + 8
+	return image.Rect(s.x+sheetPadding, top, s.x+s.w-sheetPadding-richScrollbarW-4, s.y+s.h-sheetFooterH)
+}
+
+func (s *characterScene) gripRect() image.Rectangle {
+	return image.Rect(s.x+s.w-sheetGripSize, s.y+s.h-sheetGripSize, s.x+s.w, s.y+s.h)
+}
+
+// footerButtons - кнопки внизу окна: переключение персонажей слева,
+// выгрузка, сохранение и закрытие справа
+func (s *characterScene) footerButtons(g *Game) []sheetButton {
+	y := s.y + s.h - 40
+	var buttons []sheetButton
+	if len(g.characters) > 1 {
+		buttons = append(buttons,
+			sheetButton{interiorButton: interiorButton{s.x + sheetPadding, y, 40, 30, "<", func(g *Game) { s.switchCharacter(g, -1) }}},
+			sheetButton{interiorButton: interiorButton{s.x + sheetPadding + 100, y, 40, 30, ">", func(g *Game) { s.switchCharacter(g, 1) }}})
 	}
+	right := s.x + s.w - sheetPadding
+	green := color.RGBA{0, 100, 0, 255}
+	return append(buttons,
+		sheetButton{interiorButton: interiorButton{right - 282, y, 80, 30, "Печать", func(g *Game) { g.exportCurrentCharacter(s) }},
+			color: green},
+		sheetButton{interiorButton: interiorButton{right - 196, y, 100, 30, "Сохранить", func(g *Game) {
+			g.currentCharacter.dirty = true
+			g.saveCharacterIfDirty(s, g.currentCharacter)
+		}}, color: green},
+		sheetButton{interiorButton: interiorButton{right - 90, y, 90, 30, "Закрыть", func(g *Game) { g.closeCharacterWindow(s) }},
+			color: color.RGBA{100, 0, 0, 255}})
 }
 
-func (g *Game) drawCharacterDescription(screen *ebiten.Image, char *Character, padding int) {
-	descY := 200 // Начальная позиция по Y
-	text.Draw(screen, "Описание:", g.font, padding, descY, color.RGBA{255, 255, 0, 255})
-	descY += 20
+// clampWindow держит окно на экране так, чтобы заголовок оставался досягаем
+func (s *characterScene) clampWindow() {
+	s.w = clamp(s.w, sheetMinW, screenWidth)
+	s.h = clamp(s.h, sheetMinH, screenHeight)
+	s.x = clamp(s.x, 60-s.w, screenWidth-60)
+	s.y = clamp(s.y, 0, screenHeight-sheetTitleH)
+}
+
+// switchCharacter записывает правки и показывает предыдущего или следующего персонажа
+func (s *characterScene) switchCharacter(g *Game, delta int) {
+	if len(g.characters) < 2 {
+		return
+	}
+	g.saveCharacterIfDirty(s, g.currentCharacter)
+	s.editingNotes = false
+	s.scroll = [tabCount]int{}
+	g.characterIndex = (g.characterIndex + delta + len(g.characters)) % len(g.characters)
+	g.currentCharacter = g.characters[g.characterIndex]
+}
 
-	// Разбиваем описание на строки для правильного отображения
-	descLines := strings.Split(char.Description, "\n")
-	for _, line := range descLines {
-		if line == "" {
-			continue
+func (s *characterScene) Update(g *Game) {
+	char := g.currentCharacter
+	if char == nil {
+		g.scenes.Pop()
+		return
+	}
+	// Пока набираются заметки, клавиши идут в текст, а Esc только завершает набор
+	if s.editingNotes {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			s.editingNotes = false
+			return
 		}
-		wrapped := wrapText(line, characterWindowWidth-padding*2, g.font)
-		for _, wrappedLine := range wrapped {
-			if descY > characterWindowHeight-60 { // Оставляем место для кнопки
-				break
-			}
-			text.Draw(screen, wrappedLine, g.font, padding, descY, color.White)
-			descY += 20
+		before := char.Notes
+		editText(&char.Notes, maxSheetNotes, true)
+		if char.Notes != before {
+			char.dirty = true
+			// Правленые заметки записываются простым текстом, так их и показываем
+			char.NotesDoc = richtext.FromText(char.Notes)
+			s.scroll[tabNotes] = math.MaxInt32 // Следим за концом текста, Draw прижмёт прокрутку
 		}
+	} else if s.handleKeys(g) {
+		return
 	}
+	s.handleMouse(g)
 }
 
-// drawNavigationButtons рисует кнопки навигации между персонажами
-func (g *Game) drawNavigationButtons(screen *ebiten.Image) {
-	if len(g.characters) <= 1 {
-		return
+// handleKeys - горячие клавиши окна; true, если окно закрыто:
+//
+//	P, Esc                      закрыть
+//	Влево, Вправо               предыдущий и следующий персонаж
+//	Tab, Shift+Tab, 1-6         вкладки
+//	Вверх, Вниз, PgUp, PgDn, Home, End - прокрутка
+//	E                           выгрузить лист для печати
+func (s *characterScene) handleKeys(g *Game) bool {
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) || inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.closeCharacterWindow(s)
+		return true
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		g.exportCurrentCharacter(s)
+	}
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyLeft):
+		s.switchCharacter(g, -1)
+	case inpututil.IsKeyJustPressed(ebiten.KeyRight):
+		s.switchCharacter(g, 1)
+	case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+		step := characterTab(1)
+		if ebiten.IsKeyPressed(ebiten.KeyShift) {
+			step = tabCount - 1
+		}
+		s.tab = (s.tab + step) % tabCount
+	}
+	for tab := characterTab(0); tab < tabCount; tab++ {
+		if inpututil.IsKeyJustPressed(ebiten.Key1 + ebiten.Key(tab)) {
+			s.tab = tab
+		}
 	}
 
-	// Кнопка "Назад"
-	prevBtnX := 10
-	prevBtnY := characterWindowHeight - buttonHeight - 10
-	ebitenutil.DrawRect(screen, float64(prevBtnX), float64(prevBtnY), navButtonWidth, buttonHeight, color.RGBA{70, 70, 90, 255})
-	text.Draw(screen, "<", g.font, prevBtnX+15, prevBtnY+20, color.White)
+	page := s.contentRect().Dy() - sheetLineH
+	scroll := &s.scroll[s.tab]
+	switch {
+	case repeatingKeyPressed(ebiten.KeyUp):
+		*scroll -= sheetScroll
+	case repeatingKeyPressed(ebiten.KeyDown):
+		*scroll += sheetScroll
+	case repeatingKeyPressed(ebiten.KeyPageUp):
+		*scroll -= page
+	case repeatingKeyPressed(ebiten.KeyPageDown):
+		*scroll += page
+	case inpututil.IsKeyJustPressed(ebiten.KeyHome):
+		*scroll = 0
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnd):
+		*scroll = s.pageHeight
+	}
+	return false
+}
 
-	// Информация о текущем персонаже
-	charInfo := fmt.Sprintf("%d/%d", g.characterIndex+1, len(g.characters))
-	text.Draw(screen, charInfo, g.font, characterWindowWidth/2-10, prevBtnY+20, color.White)
+func (s *characterScene) handleMouse(g *Game) {
+	mx, my := ebiten.CursorPosition()
+	cursor := image.Pt(mx, my)
 
-	// Кнопка "Вперед"
-	nextBtnX := characterWindowWidth - navButtonWidth - 10
-	nextBtnY := prevBtnY
-	ebitenutil.DrawRect(screen, float64(nextBtnX), float64(nextBtnY), navButtonWidth, buttonHeight, color.RGBA{70, 70, 90, 255})
-	text.Draw(screen, ">", g.font, nextBtnX+15, nextBtnY+20, color.White)
+	// Перетаскивание продолжается, пока кнопка мыши нажата
+	if s.drag != dragNone {
+		if !ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+			s.drag = dragNone
+			return
+		}
+		if s.drag == dragMove {
+			s.x, s.y = mx-s.dragX, my-s.dragY
+		} else {
+			s.w, s.h = mx-s.x+s.dragX, my-s.y+s.dragY
+		}
+		s.clampWindow()
+		return
+	}
 
-	// Кнопка закрытия
-	closeBtnX := characterWindowWidth - buttonWidth - 10
-	closeBtnY := prevBtnY
-	ebitenutil.DrawRect(screen, float64(closeBtnX), float64(closeBtnY), buttonWidth, buttonHeight, color.RGBA{100, 0, 0, 255})
-	text.Draw(screen, "Закрыть", g.font, closeBtnX+10, closeBtnY+20, color.White)
-}
+	content := s.contentRect()
+	if _, dy := ebiten.Wheel(); dy != 0 && cursor.In(content) {
+		s.scroll[s.tab] -= int(dy * sheetScroll)
+	}
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return
+	}
 
-// Обновляем метод drawCharacterWindow для использования новых методов
-func (g *Game) drawCharacterWindow(screen *ebiten.Image) {
-	if g.currentCharacter == nil || g.font == nil {
+	for _, btn := range s.footerButtons(g) {
+		if btn.contains(mx, my) {
+			btn.action(g)
+			return
+		}
+	}
+	for tab := characterTab(0); tab < tabCount; tab++ {
+		if cursor.In(s.tabRect(tab)) {
+			s.tab = tab
+			s.editingNotes = false
+			return
+		}
+	}
+	switch {
+	case cursor.In(s.gripRect()):
+		s.drag, s.dragX, s.dragY = dragResize, s.x+s.w-mx, s.y+s.h-my
+		return
+	case cursor.In(s.titleRect()):
+		s.drag, s.dragX, s.dragY = dragMove, mx-s.x, my-s.y
+		return
+	case !cursor.In(content):
+		s.editingNotes = false
 		return
 	}
 
+	// Щелчок по вкладке: переводим курсор в координаты страницы
+	page := s.buildPage(g, g.currentCharacter, content)
+	px, py := mx-content.Min.X, my-content.Min.Y+s.scroll[s.tab]
+	s.editingNotes = image.Pt(px, py).In(page.notes)
+	for _, btn := range page.buttons {
+		if btn.contains(px, py) {
+			btn.action(g)
+			return
+		}
+	}
+}
+
+func (s *characterScene) Draw(g *Game, screen *ebiten.Image) {
 	char := g.currentCharacter
-	padding := 20
+	if char == nil || g.font == nil {
+		return
+	}
 
-	// Фон окна
-	ebitenutil.DrawRect(screen, 0, 0, characterWindowWidth, characterWindowHeight, color.RGBA{30, 30, 40, 230})
+	// Окно и заголовок (Имя - Класс Уровня)
+	ebitenutil.DrawRect(screen, float64(s.x), float64(s.y), float64(s.w), float64(s.h), color.RGBA{30, 30, 40, 235})
+	drawRectOutline(screen, float64(s.x), float64(s.y), float64(s.w), float64(s.h), 1, color.RGBA{90, 90, 110, 255})
+	title := s.titleRect()
+	ebitenutil.DrawRect(screen, float64(title.Min.X), float64(title.Min.Y), float64(title.Dx()), float64(title.Dy()),
+		color.RGBA{45, 45, 60, 255})
+	name := fmt.Sprintf("%s - %s %d уровня", char.Name, char.Class, char.Level)
+	if char.dirty {
+		name += " (не сохранено)"
+	}
+	text.Draw(screen, truncateText(name, s.w-sheetPadding*2, g), g.font, s.x+sheetPadding, s.y+23, color.White)
 
-	// Заголовок (Имя - Класс Уровня)
-	title := fmt.Sprintf("%s - %s %d уровня", char.Name, char.Class, char.Level)
-	bounds := text.BoundString(g.font, title)
-	titleX := max(characterWindowWidth/2-bounds.Max.X/2, padding)
-	text.Draw(screen, title, g.font, titleX, 30, color.White)
+	for tab := characterTab(0); tab < tabCount; tab++ {
+		r := s.tabRect(tab)
+		clr := color.RGBA{60, 60, 80, 255}
+		if tab == s.tab {
+			clr = color.RGBA{90, 90, 130, 255}
+		}
+		ebitenutil.DrawRect(screen, float64(r.Min.X), float64(r.Min.Y), float64(r.Dx()), float64(r.Dy()), clr)
+		label := truncateText(characterTabNames[tab], r.Dx()-8, g)
+		text.Draw(screen, label, g.font, r.Min.X+(r.Dx()-text.BoundString(g.font, label).Dx())/2, r.Max.Y-9, color.White)
+	}
 
-	// Отрисовка характеристик
-	g.drawCharacterStats(screen, char, padding)
+	// Вкладка рисуется в подобласти экрана: что не поместилось, обрезается
+	content := s.contentRect()
+	page := s.buildPage(g, char, content)
+	s.pageHeight = page.height
+	s.scroll[s.tab] = clamp(s.scroll[s.tab], 0, max(page.height-content.Dy(), 0))
+	if view, ok := screen.SubImage(content).(*ebiten.Image); ok {
+		page.draw(g, view, content.Min.X, content.Min.Y-s.scroll[s.tab])
+	}
+	drawScrollbar(screen, content.Max.X+4, content.Min.Y, content.Dy(), page.height, s.scroll[s.tab])
 
-	// Отрисовка описания
-	g.drawCharacterDescription(screen, char, padding)
+	footer := s.y + s.h - sheetFooterH
+	if s.status != "" {
+		text.Draw(screen, truncateText(s.status, s.w-sheetPadding*2, g), g.font, s.x+sheetPadding, footer+16,
+			color.RGBA{180, 180, 180, 255})
+	}
+	if len(g.characters) > 1 {
+		counter := fmt.Sprintf("%d/%d", g.characterIndex+1, len(g.characters))
+		text.Draw(screen, counter, g.font, s.x+sheetPadding+70-text.BoundString(g.font, counter).Dx()/2, s.y+s.h-20, color.White)
+	}
+	for _, btn := range s.footerButtons(g) {
+		drawSheetButton(g, screen, btn, 0, 0)
+	}
 
-	// Отрисовка кнопок навигации
-	g.drawNavigationButtons(screen)
+	grip := s.gripRect()
+	for i := 4; i < sheetGripSize; i += 4 {
+		ebitenutil.DrawLine(screen, float64(grip.Max.X-i), float64(grip.Max.Y-2), float64(grip.Max.X-2), float64(grip.Max.Y-i),
+			color.RGBA{150, 150, 170, 255})
+	}
 }
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const (
+	maxChatLog     = 500 // Столько сообщений хранит сервер и сохранение
+	maxChatText    = 300
+	chatJoinLog    = 100 // Столько последних сообщений получает новый игрок
+	chatFadeTime   = 15 * time.Second
+	chatRecent     = 6 // Строк в свёрнутом чате
+	chatPanelW     = 640
+	chatPanelLines = 20
+	chatLineH      = 18
+	gmChatName     = "Мастер"
+)
+
+// chatHelp - подсказка по командам чата, /help
+var chatHelp = []string{
+	"/w <игрок> <текст> - шёпот: игрок по ID или имени персонажа, мастеру - gm",
+	"/me <действие> - действие от третьего лица",
+	"/roll <формула> - бросок костей, например /roll 1d20+5",
+	"/a <текст> - объявление мастера",
+}
+
+// sendChat разбирает набранную строку: команды выполняются сразу, остальное
+// уходит серверу. Ошибки в командах видит только сам игрок.
+func (g *Game) sendChat(input string) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return
+	}
+
+	msg := ChatMessage{Kind: ChatSay, Text: input}
+	if strings.HasPrefix(input, "/") {
+		cmd, rest, _ := strings.Cut(input[1:], " ")
+		rest = strings.TrimSpace(rest)
+		switch strings.ToLower(cmd) {
+		case "roll", "r":
+			if rest == "" {
+				g.tellChat(g.me.ID, "Укажите формулу: /roll 1d20+5")
+				return
+			}
+			g.requestRoll("", rest, false)
+			return
+		case "w", "whisper":
+			msg.Kind = ChatWhisper
+		case "me":
+			msg.Kind = ChatEmote
+		case "a", "announce":
+			msg.Kind = ChatAnnounce
+		case "help", "?":
+			for _, line := range chatHelp {
+				g.tellChat(g.me.ID, line)
+			}
+			return
+		default:
+			g.tellChat(g.me.ID, fmt.Sprintf("Неизвестная команда /%s, список команд - /help", cmd))
+			return
+		}
+		msg.Text = rest
+	}
+
+	if g.isGM() {
+		g.postChat(g.me.ID, msg)
+		return
+	}
+	if err := g.encoder.Encode(NetMessage{Kind: MsgChat, Chat: &msg}); err != nil {
+		log.Println("Ошибка отправки сообщения:", err)
+	}
+}
+
+// postChat проверяет сообщение на сервере, записывает его в журнал и
+// рассылает: шёпот - только отправителю и адресату, остальное - всем
+func (g *Game) postChat(from string, req ChatMessage) {
+	msg := ChatMessage{Kind: req.Kind, From: from, Text: strings.TrimSpace(req.Text), Time: time.Now()}
+	var problem string
+
+	g.mu.Lock()
+	msg.Name = g.chatName(from)
+	switch msg.Kind {
+	case ChatSay, ChatEmote:
+	case ChatAnnounce:
+		if from != gmPlayerID {
+			problem = "Объявления делает только мастер"
+		}
+	case ChatWhisper:
+		to, rest, ok := g.chatTarget(msg.Text)
+		switch {
+		case !ok:
+			problem = "Нет такого игрока. Шёпот: /w <игрок> <текст>"
+		case to == from:
+			problem = "Шептать самому себе незачем"
+		}
+		msg.To, msg.ToName, msg.Text = to, g.chatName(to), rest
+	default:
+		problem = "Неизвестный вид сообщения"
+	}
+	switch n := len([]rune(msg.Text)); {
+	case problem != "":
+	case n == 0:
+		problem = "Пустое сообщение"
+	case n > maxChatText:
+		problem = fmt.Sprintf("Сообщение длиннее %d символов", maxChatText)
+	}
+	if problem == "" {
+		g.addChat(msg)
+		g.worldDirty = true
+	}
+	g.mu.Unlock()
+
+	if problem != "" {
+		g.tellChat(from, problem)
+		return
+	}
+	if debugMode {
+		log.Printf("[DEBUG] Чат %s -> %q: %s", msg.Name, msg.To, msg.Text)
+	}
+	if msg.Kind == ChatWhisper {
+		g.sendTo(map[string]NetMessage{
+			from:   {Kind: MsgChat, Chat: &msg},
+			msg.To: {Kind: MsgChat, Chat: &msg},
+		})
+		return
+	}
+	g.broadcast(NetMessage{Kind: MsgChat, Chat: &msg}, "")
+}
+
+// tellChat показывает игроку служебную строку: себе - сразу, другому - через сеть
+func (g *Game) tellChat(to, line string) {
+	msg := ChatMessage{Kind: ChatSystem, To: to, Text: line, Time: time.Now()}
+	if to != g.me.ID {
+		g.sendTo(map[string]NetMessage{to: {Kind: MsgChat, Chat: &msg}})
+		return
+	}
+	g.mu.Lock()
+	g.addChat(msg)
+	g.mu.Unlock()
+}
+
+// chatName - имя, под которым игрок пишет в чат. Вызывается под g.mu.
+func (g *Game) chatName(id string) string {
+	if id == gmPlayerID {
+		return gmChatName
+	}
+	if p, ok := g.players[id]; ok && p.Character.Name != "" {
+		return p.Character.Name
+	}
+	return id
+}
+
+// chatTarget отделяет адресата шёпота от текста. Адресат - ID игрока, имя
+// его персонажа (в нём могут быть пробелы) или gm для мастера. Вызывается под g.mu.
+func (g *Game) chatTarget(s string) (id, rest string, ok bool) {
+	lower := strings.ToLower(s)
+	best := -1
+	try := func(name, pid string) {
+		name = strings.ToLower(name)
+		if name == "" || len(name) <= best {
+			return
+		}
+		if lower == name || strings.HasPrefix(lower, name+" ") {
+			id, best = pid, len(name)
+		}
+	}
+	if g.isGM() {
+		try("gm", gmPlayerID)
+		try(gmChatName, gmPlayerID)
+	}
+	for pid, p := range g.players {
+		try(pid, pid)
+		try(p.Character.Name, pid)
+	}
+	if best < 0 {
+		return "", "", false
+	}
+	return id, strings.TrimSpace(s[best:]), true
+}
+
+// chatVisible сообщает, видит ли игрок сообщение. ID отправителя и адресата
+// выдаёт сервер, а переподключение под ID подтверждается ключом, см.
+// joinPlayer: чужой шёпот, назвавшись чужим ID, не получить.
+func chatVisible(id string, msg ChatMessage) bool {
+	switch msg.Kind {
+	case ChatWhisper:
+		return msg.From == id || msg.To == id
+	case ChatSystem:
+		return msg.To == id
+	default:
+		return true
+	}
+}
+
+// addChat добавляет сообщение в журнал. Вызывается под g.mu.
+func (g *Game) addChat(msg ChatMessage) {
+	msg.seen = time.Now()
+	g.chatLog = append(g.chatLog, msg)
+	if len(g.chatLog) > maxChatLog {
+		g.chatLog = g.chatLog[len(g.chatLog)-maxChatLog:]
+	}
+}
+
+// chatHistory возвращает последние сообщения, которые видит игрок, для
+// нового подключения. Служебные строки не пересылаются. Вызывается под g.mu.
+func (g *Game) chatHistory(id string) []ChatMessage {
+	var history []ChatMessage
+	for i := len(g.chatLog) - 1; i >= 0 && len(history) < chatJoinLog; i-- {
+		if msg := g.chatLog[i]; msg.Kind != ChatSystem && chatVisible(id, msg) {
+			history = append(history, msg)
+		}
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
+
+// loadChat возвращает сохранённый журнал без шёпота игроков, которых сервер
+// не знает: в сохранениях до выдачи ID сервером игроки выбирали ID сами, и
+// новичок с тем же ID получил бы чужой шёпот. Вызывается под g.mu после
+// загрузки игроков.
+func (g *Game) loadChat(save []ChatMessage) []ChatMessage {
+	known := func(id string) bool { return id == gmPlayerID || g.accounts[id] != nil }
+	var chat []ChatMessage
+	for _, msg := range save {
+		if msg.Kind != ChatWhisper || known(msg.From) && known(msg.To) {
+			chat = append(chat, msg)
+		}
+	}
+	return chat
+}
+
+// chatSave возвращает журнал для сохранения мира без служебных строк. Вызывается под g.mu.
+func (g *Game) chatSave() []ChatMessage {
+	var saved []ChatMessage
+	for _, msg := range g.chatLog {
+		if msg.Kind != ChatSystem {
+			saved = append(saved, msg)
+		}
+	}
+	return saved
+}
+
+// chatEntry - строка чата на экране. Броски из журнала бросков тоже
+// показываются в чате, вперемешку с сообщениями по времени.
+type chatEntry struct {
+	time time.Time
+	seen time.Time
+	text string
+	clr  color.RGBA
+	roll bool
+}
+
+// chatEntries собирает сообщения и броски, которые видит игрок, по порядку
+func (g *Game) chatEntries() []chatEntry {
+	g.mu.Lock()
+	entries := make([]chatEntry, 0, len(g.chatLog)+len(g.rollLog))
+	for _, msg := range g.chatLog {
+		if chatVisible(g.me.ID, msg) {
+			line, clr := g.chatLine(msg)
+			entries = append(entries, chatEntry{msg.Time, msg.seen, line, clr, false})
+		}
+	}
+	for _, rec := range g.rollLog {
+		head, body, clr := rollLines(rec)
+		entries = append(entries, chatEntry{rec.Time, rec.seen, head + ": " + body, clr, true})
+	}
+	g.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].time.Before(entries[j].time) })
+	return entries
+}
+
+// chatLine - текст и цвет сообщения в чате
+func (g *Game) chatLine(msg ChatMessage) (string, color.RGBA) {
+	stamp := "[" + msg.Time.Format("15:04") + "] "
+	switch msg.Kind {
+	case ChatEmote:
+		return stamp + "* " + msg.Name + " " + msg.Text, color.RGBA{150, 220, 150, 255}
+	case ChatWhisper:
+		if msg.From == g.me.ID {
+			return stamp + "-> " + msg.ToName + ": " + msg.Text, color.RGBA{230, 150, 255, 255}
+		}
+		return stamp + msg.Name + " шепчет: " + msg.Text, color.RGBA{230, 150, 255, 255}
+	case ChatAnnounce:
+		return stamp + "ОБЪЯВЛЕНИЕ: " + msg.Text, color.RGBA{255, 170, 60, 255}
+	case ChatSystem:
+		return msg.Text, color.RGBA{160, 160, 160, 255}
+	default:
+		return stamp + msg.Name + ": " + msg.Text, color.RGBA{255, 255, 255, 255}
+	}
+}
+
+// chatScene - развёрнутый чат с полем ввода; Enter отправляет и закрывает
+type chatScene struct {
+	input  string
+	scroll int // На сколько строк история прокручена вверх
+}
+
+func (*chatScene) Overlay() bool { return true }
+
+// openChat открывает поле ввода чата поверх текущей сцены
+func (g *Game) openChat() {
+	g.scenes.Push(&chatScene{})
+}
+
+func (s *chatScene) Update(g *Game) {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.sendChat(s.input)
+		g.scenes.Pop()
+		return
+	}
+	editText(&s.input, maxChatText, false)
+
+	_, dy := ebiten.Wheel()
+	switch {
+	case dy > 0 || repeatingKeyPressed(ebiten.KeyPageUp):
+		s.scroll += 3
+	case dy < 0 || repeatingKeyPressed(ebiten.KeyPageDown):
+		s.scroll = max(s.scroll-3, 0)
+	}
+}
+
+func (s *chatScene) Draw(g *Game, screen *ebiten.Image) {
+	lines := g.wrapChat(g.chatEntries(), nil)
+	s.scroll = min(s.scroll, max(len(lines)-chatPanelLines, 0))
+	end := len(lines) - s.scroll
+	lines = lines[max(end-chatPanelLines, 0):end]
+
+	x, bottom := 10, screenHeight-60
+	h := chatPanelLines*chatLineH + 60
+	top := bottom - h
+	ebitenutil.DrawRect(screen, float64(x), float64(top), chatPanelW, float64(h), color.RGBA{20, 20, 30, 230})
+	drawRectOutline(screen, float64(x), float64(top), chatPanelW, float64(h), 1, color.RGBA{120, 120, 140, 255})
+
+	y := top + 20 + (chatPanelLines-len(lines))*chatLineH
+	for _, line := range lines {
+		text.Draw(screen, line.text, g.font, x+10, y, line.clr)
+		y += chatLineH
+	}
+	if s.scroll > 0 {
+		text.Draw(screen, fmt.Sprintf("(ещё %d строк ниже)", s.scroll), g.font, x+chatPanelW-180, top+20,
+			color.RGBA{150, 150, 150, 255})
+	}
+
+	g.drawTextBox(screen, x+10, bottom-34, chatPanelW-20, 26, s.input+"_", true)
+	text.Draw(screen, "Enter - отправить, Esc - закрыть, колесо - прокрутка, /help - команды", g.font,
+		x+10, bottom+16, color.RGBA{180, 180, 180, 255})
+}
+
+// wrapChat разбивает строки чата по ширине панели; keep отбирает нужные строки
+func (g *Game) wrapChat(entries []chatEntry, keep func(chatEntry) bool) []chatEntry {
+	var lines []chatEntry
+	for _, e := range entries {
+		if keep != nil && !keep(e) {
+			continue
+		}
+		for _, part := range wrapText(e.text, chatPanelW-20, g.font) {
+			line := e
+			line.text = part
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// drawRecentChat показывает последние сообщения в углу экрана, пока чат
+// свёрнут. Свежие броски и так видны справа, здесь только сообщения.
+func (g *Game) drawRecentChat(screen *ebiten.Image) {
+	if _, open := g.scenes.Top().(*chatScene); open {
+		return
+	}
+	lines := g.wrapChat(g.chatEntries(), func(e chatEntry) bool {
+		return !e.roll && time.Since(e.seen) < chatFadeTime
+	})
+	if len(lines) == 0 {
+		return
+	}
+	lines = lines[max(len(lines)-chatRecent, 0):]
+
+	x, y := 10, screenHeight-60-len(lines)*chatLineH
+	ebitenutil.DrawRect(screen, float64(x), float64(y), chatPanelW, float64(len(lines)*chatLineH+8), color.RGBA{0, 0, 0, 150})
+	for _, line := range lines {
+		y += chatLineH
+		text.Draw(screen, line.text, g.font, x+10, y, line.clr)
+	}
+}
@@ -41,6 +41,7 @@ func (g *Game) generateCities() {
 }
 func (g *Game) createCityAt(x, y int) {
 	city := &City{
+		ID:         len(g.cityList),
 		Name:       generateCityName(),
 		X:          x,
 		Y:          y,
@@ -48,19 +49,42 @@ func (g *Game) createCityAt(x, y int) {
 		Population: rand.Intn(90000) + 10000,
 	}
 	g.cityList = append(g.cityList, city)
+	g.markCityCells(city)
+}
 
-	cols := screenWidth / cellSize
-	rows := screenHeight / cellSize
+// markCityCells отмечает клетки мира, занятые городом
+func (g *Game) markCityCells(city *City) {
 	for dy := -city.Size; dy <= city.Size; dy++ {
 		for dx := -city.Size; dx <= city.Size; dx++ {
-			ny, nx := y+dy, x+dx
-			if ny >= 0 && ny < rows && nx >= 0 && nx < cols {
+			ny, nx := city.Y+dy, city.X+dx
+			if ny >= 0 && ny < len(g.cities) && nx >= 0 && nx < len(g.cities[ny]) {
 				g.cities[ny][nx] = true
 			}
 		}
 	}
 }
 
+// rebuildCityCells заново отмечает клетки городов после правок списка
+func (g *Game) rebuildCityCells() {
+	for y := range g.cities {
+		for x := range g.cities[y] {
+			g.cities[y][x] = false
+		}
+	}
+	for _, city := range g.cityList {
+		g.markCityCells(city)
+	}
+}
+
+func (g *Game) findCityByID(id int) *City {
+	for _, city := range g.cityList {
+		if city.ID == id {
+			return city
+		}
+	}
+	return nil
+}
+
 func generateCityName() string {
 	name1 := cityNames[rand.Intn(len(cityNames))]
 	name2 := cityNames[rand.Intn(len(cityNames))]
@@ -15,8 +15,7 @@ func (g *Game) drawCities(screen *ebiten.Image) {
 	}
 
 	for _, city := range g.cityList {
-		screenX := city.X*cellSize - g.cameraX
-		screenY := city.Y*cellSize - g.cameraY
+		screenX, screenY := g.worldToScreen(city.X, city.Y)
 
 		// Простая проверка видимости
 		if screenX < -cellSize || screenX > screenWidth ||
@@ -55,8 +54,7 @@ func (g *Game) drawCities(screen *ebiten.Image) {
 }
 
 func (g *Game) drawCityHighlight(screen *ebiten.Image, city *City) {
-	screenX := city.X*cellSize - g.cameraX
-	screenY := city.Y*cellSize - g.cameraY
+	screenX, screenY := g.worldToScreen(city.X, city.Y)
 	citySize := cellSize * (city.Size + 1)
 
 	// Рисуем рамку выделения
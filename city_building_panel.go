@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const (
+	buildingPanelHeight = 360
+	notesBoxHeight      = 150
+	maxNotesLength      = 500
+)
+
+// buildingPanelRect возвращает прямоугольник панели сведений о здании
+func buildingPanelRect() (x, y, w, h int) {
+	return screenWidth - cityPanelW - 10, 10, cityPanelW, buildingPanelHeight
+}
+
+func notesBoxRect() (x, y, w, h int) {
+	px, py, pw, _ := buildingPanelRect()
+	return px + 10, py + 150, pw - 20, notesBoxHeight
+}
+
+// buildingPanelButtons - кнопки панели; одни и те же прямоугольники для отрисовки и кликов
+func (g *Game) buildingPanelButtons() []interiorButton {
+	px, py, pw, ph := buildingPanelRect()
+	y := py + ph - 35
+	return []interiorButton{
+		{px + 10, y, 120, 25, "План здания", func(g *Game) {
+			g.finishNotes()
+			g.openInterior(*g.cityMap.building(g.cityMap.Selected))
+		}},
+		{px + pw - 90, y, 80, 25, "Закрыть", func(g *Game) {
+			g.finishNotes()
+			g.cityMap.Selected = -1
+		}},
+	}
+}
+
+// updateBuildingPanel обрабатывает ввод панели сведений. Возвращает true,
+// если ввод поглощён панелью и карте его передавать не нужно.
+func (g *Game) updateBuildingPanel() bool {
+	m := g.cityMap
+	b := m.building(m.Selected)
+	if b == nil {
+		m.Selected, m.Editing = -1, false
+		return false
+	}
+
+	if m.Editing {
+		editText(&m.Notes, maxNotesLength, true)
+		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			g.finishNotes()
+			return true
+		}
+	}
+
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return m.Editing
+	}
+
+	mx, my := ebiten.CursorPosition()
+	for _, btn := range g.buildingPanelButtons() {
+		if mx >= btn.x && mx <= btn.x+btn.w && my >= btn.y && my <= btn.y+btn.h {
+			btn.action(g)
+			return true
+		}
+	}
+
+	// Заметки пишет только мастер: игроки их не получают
+	nx, ny, nw, nh := notesBoxRect()
+	switch inNotes := mx >= nx && mx <= nx+nw && my >= ny && my <= ny+nh; {
+	case m.Editing && !inNotes:
+		g.finishNotes()
+	case !m.Editing && inNotes && g.isGM():
+		m.Editing, m.Notes = true, b.Notes
+	}
+
+	px, py, pw, ph := buildingPanelRect()
+	return mx >= px && mx <= px+pw && my >= py && my <= py+ph
+}
+
+func (g *Game) drawBuildingPanel(screen *ebiten.Image) {
+	m := g.cityMap
+	b := m.building(m.Selected)
+	if b == nil {
+		return
+	}
+	px, py, pw, ph := buildingPanelRect()
+
+	ebitenutil.DrawRect(screen, float64(px), float64(py), float64(pw), float64(ph), color.RGBA{30, 30, 40, 235})
+	drawRectOutline(screen, float64(px), float64(py), float64(pw), float64(ph), 1, color.RGBA{120, 120, 140, 255})
+
+	title := fmt.Sprintf("%s #%d", buildingTypeNames[b.Type], b.ID)
+	text.Draw(screen, title, g.font, px+10, py+25, color.RGBA{255, 255, 0, 255})
+	info := []string{
+		"Владелец: " + b.Owner,
+		fmt.Sprintf("Этажей: %d", b.Level),
+		fmt.Sprintf("Размер: %dx%d", b.Width, b.Height),
+		fmt.Sprintf("Положение: %d, %d", b.X, b.Y),
+	}
+	for i, line := range info {
+		text.Draw(screen, line, g.font, px+10, py+50+i*20, color.White)
+	}
+
+	// Заметки мастера
+	if g.isGM() {
+		nx, ny, nw, nh := notesBoxRect()
+		text.Draw(screen, "Заметки:", g.font, nx, ny-8, color.RGBA{255, 255, 0, 255})
+		notes := b.Notes
+		if m.Editing {
+			notes = m.Notes + "_"
+		} else if notes == "" {
+			notes = "Щёлкните, чтобы добавить заметку"
+		}
+		g.drawTextBox(screen, nx, ny, nw, nh, notes, m.Editing)
+	}
+
+	for _, btn := range g.buildingPanelButtons() {
+		clr := color.RGBA{70, 70, 90, 255}
+		if btn.label == "Закрыть" {
+			clr = color.RGBA{100, 0, 0, 255}
+		}
+		ebitenutil.DrawRect(screen, float64(btn.x), float64(btn.y), float64(btn.w), float64(btn.h), clr)
+		text.Draw(screen, btn.label, g.font, btn.x+8, btn.y+18, color.White)
+	}
+}
+
+// finishNotes заканчивает ввод заметок и, если они изменились, сохраняет их
+// правкой здания: так они попадают в журнал правок города и в сохранение мира
+func (g *Game) finishNotes() {
+	m := g.cityMap
+	if !m.Editing {
+		return
+	}
+	m.Editing = false
+	if e, ok := g.notesEdit(cityMapKey(m.City), m.Selected, m.Notes); ok {
+		g.commitEdit(e)
+	}
+}
+
+// editText дописывает в строку набранные символы и обрабатывает Backspace;
+// в многострочном поле Enter переводит строку
+func editText(s *string, limit int, multiline bool) {
+	for _, r := range ebiten.AppendInputChars(nil) {
+		if len([]rune(*s)) < limit {
+			*s += string(r)
+		}
+	}
+	if repeatingKeyPressed(ebiten.KeyBackspace) && *s != "" {
+		runes := []rune(*s)
+		*s = string(runes[:len(runes)-1])
+	}
+	if multiline && inpututil.IsKeyJustPressed(ebiten.KeyEnter) && len([]rune(*s)) < limit {
+		*s += "\n"
+	}
+}
+
+// repeatingKeyPressed срабатывает при нажатии и затем периодически при удержании клавиши
+func repeatingKeyPressed(key ebiten.Key) bool {
+	const (
+		delay    = 30
+		interval = 3
+	)
+	d := inpututil.KeyPressDuration(key)
+	return d == 1 || (d >= delay && (d-delay)%interval == 0)
+}
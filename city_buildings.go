@@ -0,0 +1,272 @@
+package main
+
+import "sort"
+
+// Типы зданий
+const (
+	BuildingHouse     = "house"
+	BuildingTavern    = "tavern"
+	BuildingSmithy    = "smithy"
+	BuildingTemple    = "temple"
+	BuildingGuildhall = "guildhall"
+	BuildingKeep      = "keep"
+)
+
+var buildingTypeNames = map[string]string{
+	BuildingHouse:     "Жилой дом",
+	BuildingTavern:    "Таверна",
+	BuildingSmithy:    "Кузница",
+	BuildingTemple:    "Храм",
+	BuildingGuildhall: "Гильдия",
+	BuildingKeep:      "Замок",
+}
+
+const (
+	maxFootprint    = 4 // Максимальная сторона здания в тайлах
+	tavernChance    = 0.3
+	guildhallChance = 0.3 // Для крупных зданий помимо замка
+	templeChance    = 0.4 // Для крупных зданий у площади
+	smithyChance    = 0.35
+	smithyGateRange = 8 // Кузницы тянутся к воротам
+	templePlazaDist = 8 // Храмы ставятся у главной площади
+)
+
+// extractBuildings объединяет клетки застройки в прямоугольные здания,
+// классифицирует их и назначает этажность
+func (cg *CityGenerator) extractBuildings(grid [][]int) []Building {
+	var buildings []Building
+	for _, lot := range buildingLots(grid) {
+		for _, rect := range splitLot(lot) {
+			rect.ID = len(buildings)
+			rect.Size = footprintSize(rect.Width, rect.Height)
+			buildings = append(buildings, rect)
+		}
+	}
+
+	// Крупные здания классифицируем первыми, чтобы замок достался самому большому
+	order := make([]int, len(buildings))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := buildings[order[i]], buildings[order[j]]
+		return a.Width*a.Height > b.Width*b.Height
+	})
+
+	features := cg.findFeatures(grid)
+	hasKeep := false
+	for _, i := range order {
+		b := &buildings[i]
+		b.Type = cg.classifyBuilding(grid, b, features, &hasKeep)
+		b.Level = cg.buildingLevel(grid, b)
+		b.Owner = cg.ownerName(b.Type)
+	}
+	return buildings
+}
+
+// splitLot жадно разбивает связный участок на прямоугольники не больше maxFootprint
+func splitLot(lot []point) []Building {
+	remaining := make(map[point]bool, len(lot))
+	for _, c := range lot {
+		remaining[c] = true
+	}
+
+	sort.Slice(lot, func(i, j int) bool {
+		if lot[i].y != lot[j].y {
+			return lot[i].y < lot[j].y
+		}
+		return lot[i].x < lot[j].x
+	})
+
+	var rects []Building
+	for _, start := range lot {
+		if !remaining[start] {
+			continue
+		}
+
+		w := 1
+		for w < maxFootprint && remaining[point{start.x + w, start.y}] {
+			w++
+		}
+		h := 1
+		for h < maxFootprint && rowFree(remaining, start.x, start.y+h, w) {
+			h++
+		}
+
+		for dy := 0; dy < h; dy++ {
+			for dx := 0; dx < w; dx++ {
+				delete(remaining, point{start.x + dx, start.y + dy})
+			}
+		}
+		rects = append(rects, Building{X: start.x, Y: start.y, Width: w, Height: h})
+	}
+	return rects
+}
+
+func rowFree(cells map[point]bool, x, y, w int) bool {
+	for dx := 0; dx < w; dx++ {
+		if !cells[point{x + dx, y}] {
+			return false
+		}
+	}
+	return true
+}
+
+func footprintSize(w, h int) int {
+	side := max(w, h)
+	switch {
+	case side >= 3:
+		return BuildingLarge
+	case side == 2:
+		return BuildingMedium
+	default:
+		return BuildingSmall
+	}
+}
+
+// cityFeatures - ориентиры, от которых зависит назначение зданий
+type cityFeatures struct {
+	plaza []point
+	gates []point
+}
+
+func (cg *CityGenerator) findFeatures(grid [][]int) cityFeatures {
+	return cityFeatures{
+		plaza: cg.collectTiles(grid, TilePlaza),
+		gates: cg.collectTiles(grid, TileGate),
+	}
+}
+
+// classifyBuilding выбирает назначение здания по размеру и окружению
+func (cg *CityGenerator) classifyBuilding(grid [][]int, b *Building, f cityFeatures, hasKeep *bool) string {
+	nearPlaza := distanceTo(b, f.plaza) <= templePlazaDist
+	nearGate := distanceTo(b, f.gates) <= smithyGateRange
+
+	switch b.Size {
+	case BuildingLarge:
+		if !*hasKeep {
+			*hasKeep = true
+			return BuildingKeep
+		}
+		if nearPlaza && cg.rng.Float64() < templeChance {
+			return BuildingTemple
+		}
+		if cg.rng.Float64() < guildhallChance {
+			return BuildingGuildhall
+		}
+	case BuildingMedium:
+		if fronts(grid, b, isStreetTile) && cg.rng.Float64() < tavernChance {
+			return BuildingTavern
+		}
+	}
+
+	if cg.rng.Float64() < specialBuildingChance {
+		return cg.specialType()
+	}
+	if nearGate && cg.rng.Float64() < smithyChance {
+		return BuildingSmithy
+	}
+	if nearPlaza && fronts(grid, b, isStreetTile) && cg.rng.Float64() < tavernChance {
+		return BuildingTavern
+	}
+	return BuildingHouse
+}
+
+var (
+	ownerFirstNames = []string{
+		"Аларик", "Бертрам", "Велена", "Гордей", "Дарина", "Ефим", "Злата",
+		"Ивор", "Лада", "Мирон", "Олеся", "Ратибор", "Светлана", "Тихон",
+	}
+	ownerLastNames = []string{
+		"Бондарь", "Вельский", "Горн", "Дубов", "Железнов", "Каменев",
+		"Медов", "Остролист", "Речной", "Соколов", "Тарн", "Ясень",
+	}
+	// Владельцы общественных зданий носят титул
+	ownerTitles = map[string]string{
+		BuildingTemple:    "Настоятель",
+		BuildingGuildhall: "Глава гильдии",
+		BuildingKeep:      "Лорд",
+	}
+)
+
+// ownerName придумывает владельца здания
+func (cg *CityGenerator) ownerName(buildingType string) string {
+	name := ownerFirstNames[cg.rng.Intn(len(ownerFirstNames))] + " " +
+		ownerLastNames[cg.rng.Intn(len(ownerLastNames))]
+	if title, ok := ownerTitles[buildingType]; ok {
+		return title + " " + name
+	}
+	return name
+}
+
+func (cg *CityGenerator) specialType() string {
+	if cg.rng.Intn(2) == 0 {
+		return BuildingTemple
+	}
+	return BuildingGuildhall
+}
+
+// buildingLevel назначает этажность: крупные и плотно окружённые здания выше
+func (cg *CityGenerator) buildingLevel(grid [][]int, b *Building) int {
+	level := b.Size
+	switch b.Type {
+	case BuildingKeep:
+		return maxBuildingLevel
+	case BuildingTemple, BuildingGuildhall:
+		level++
+	case BuildingSmithy:
+		return 1
+	}
+
+	// Плотная застройка вокруг добавляет этаж
+	neighbors := 0
+	for y := b.Y - 1; y <= b.Y+b.Height; y++ {
+		for x := b.X - 1; x <= b.X+b.Width; x++ {
+			inside := x >= b.X && x < b.X+b.Width && y >= b.Y && y < b.Y+b.Height
+			if !inside && inCity(grid, x, y) && isBuildingTile(grid[y][x]) {
+				neighbors++
+			}
+		}
+	}
+	if neighbors >= 2*(b.Width+b.Height) {
+		level++
+	}
+	level += cg.rng.Intn(2)
+
+	return clamp(level, 1, maxBuildingLevel)
+}
+
+// fronts сообщает, выходит ли здание фасадом на подходящий тайл
+func fronts(grid [][]int, b *Building, match func(tile int) bool) bool {
+	for y := b.Y - 1; y <= b.Y+b.Height; y++ {
+		for x := b.X - 1; x <= b.X+b.Width; x++ {
+			edge := x == b.X-1 || x == b.X+b.Width || y == b.Y-1 || y == b.Y+b.Height
+			corner := (x == b.X-1 || x == b.X+b.Width) && (y == b.Y-1 || y == b.Y+b.Height)
+			if edge && !corner && inCity(grid, x, y) && match(grid[y][x]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// distanceTo возвращает манхэттенское расстояние от центра здания до ближайшей точки
+func distanceTo(b *Building, points []point) int {
+	cx, cy := b.X+b.Width/2, b.Y+b.Height/2
+	best := -1
+	for _, p := range points {
+		d := abs(p.x-cx) + abs(p.y-cy)
+		if best == -1 || d < best {
+			best = d
+		}
+	}
+	if best == -1 {
+		return citySize * 2
+	}
+	return best
+}
+
+// Contains сообщает, занимает ли здание клетку (x, y)
+func (b *Building) Contains(x, y int) bool {
+	return x >= b.X && x < b.X+b.Width && y >= b.Y && y < b.Y+b.Height
+}
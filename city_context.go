@@ -0,0 +1,213 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// Direction - сторона, с которой что-то подходит к городу
+type Direction int
+
+const (
+	DirNorth Direction = iota
+	DirEast
+	DirSouth
+	DirWest
+)
+
+// Смещения в том же порядке, что и направления в пакете wfc
+var directionOffsets = [4][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}
+
+var directionNames = [4]string{"север", "восток", "юг", "запад"}
+
+func (d Direction) String() string {
+	return directionNames[d]
+}
+
+// RiverCrossing описывает реку, пересекающую город
+type RiverCrossing struct {
+	Vertical bool    // Река течёт с севера на юг
+	Offset   float64 // Смещение русла от центра: -1..1
+}
+
+// CityContext описывает окружение города на карте мира
+type CityContext struct {
+	Biome Biome
+	Coast []Direction    // Стороны, где к городу подходит вода
+	River *RiverCrossing // Река через город, если есть
+	Roads []Direction    // Стороны, откуда в город входят дороги
+	Seed  int64
+}
+
+const (
+	contextRadius     = 6   // Радиус осмотра окрестностей города в клетках мира
+	coastThreshold    = 0.3 // Доля воды в секторе, начиная с которой сторона считается берегом
+	maxRoadsPerCity   = 3
+	minRoadsPerCity   = 1
+	riverSearchRadius = 3
+)
+
+// citySeed связывает сид города с сидом мира и самим городом, чтобы
+// одинаковые по населению города не совпадали
+func citySeed(worldSeed int64, city *City) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(city.Name))
+	h.Write([]byte{byte(city.X), byte(city.X >> 8), byte(city.Y), byte(city.Y >> 8)})
+	return worldSeed ^ int64(h.Sum64())
+}
+
+// cityContext собирает сведения об окрестностях города по тайлам мира
+func (g *Game) cityContext(city *City) CityContext {
+	ctx := CityContext{
+		Biome: BiomeGrass,
+		Seed:  citySeed(g.seed, city),
+	}
+	if !g.inWorld(city.X, city.Y) {
+		return ctx
+	}
+
+	ctx.Biome = biomeFromColor(g.tiles[city.Y][city.X])
+	ctx.Coast = g.coastDirections(city)
+	ctx.River = g.riverCrossing(city)
+	ctx.Roads = g.roadDirections(city, ctx.Coast)
+	return ctx
+}
+
+func (g *Game) inWorld(x, y int) bool {
+	return y >= 0 && y < len(g.tiles) && x >= 0 && x < len(g.tiles[y])
+}
+
+func (g *Game) isWaterAt(x, y int) bool {
+	return g.inWorld(x, y) && biomeFromColor(g.tiles[y][x]) == BiomeWater
+}
+
+// coastDirections возвращает стороны, в секторах которых много воды
+func (g *Game) coastDirections(city *City) []Direction {
+	var water, total [4]int
+	for dy := -contextRadius; dy <= contextRadius; dy++ {
+		for dx := -contextRadius; dx <= contextRadius; dx++ {
+			if dx == 0 && dy == 0 || !g.inWorld(city.X+dx, city.Y+dy) {
+				continue
+			}
+
+			d := sectorDirection(dx, dy)
+			total[d]++
+			if g.isWaterAt(city.X+dx, city.Y+dy) {
+				water[d]++
+			}
+		}
+	}
+
+	var coast []Direction
+	for d := range water {
+		if total[d] > 0 && float64(water[d])/float64(total[d]) >= coastThreshold {
+			coast = append(coast, Direction(d))
+		}
+	}
+	return coast
+}
+
+// sectorDirection относит смещение к одной из четырёх сторон по преобладающей оси
+func sectorDirection(dx, dy int) Direction {
+	if abs(dx) > abs(dy) {
+		if dx > 0 {
+			return DirEast
+		}
+		return DirWest
+	}
+	if dy > 0 {
+		return DirSouth
+	}
+	return DirNorth
+}
+
+// riverCrossing ищет узкую полосу воды, пересекающую город при суше по обе стороны
+func (g *Game) riverCrossing(city *City) *RiverCrossing {
+	r := riverSearchRadius
+	for _, vertical := range []bool{true, false} {
+		for offset := -city.Size; offset <= city.Size; offset++ {
+			// Для вертикальной реки идём по горизонтали поперёк русла
+			x, y := city.X+offset, city.Y
+			if !vertical {
+				x, y = city.X, city.Y+offset
+			}
+			if !g.isWaterAt(x, y) {
+				continue
+			}
+
+			landBefore, landAfter := false, false
+			for step := 1; step <= r; step++ {
+				bx, by, ax, ay := x-step, y, x+step, y
+				if !vertical {
+					bx, by, ax, ay = x, y-step, x, y+step
+				}
+				landBefore = landBefore || (g.inWorld(bx, by) && !g.isWaterAt(bx, by))
+				landAfter = landAfter || (g.inWorld(ax, ay) && !g.isWaterAt(ax, ay))
+			}
+
+			if landBefore && landAfter {
+				return &RiverCrossing{
+					Vertical: vertical,
+					Offset:   float64(offset) / float64(city.Size+1),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// roadDirections направляет дороги к ближайшим городам, не выводя их в море
+func (g *Game) roadDirections(city *City, coast []Direction) []Direction {
+	isCoast := func(d Direction) bool {
+		for _, c := range coast {
+			if c == d {
+				return true
+			}
+		}
+		return false
+	}
+
+	others := make([]*City, 0, len(g.cityList))
+	for _, other := range g.cityList {
+		if other != city {
+			others = append(others, other)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return cityDistance(city, others[i]) < cityDistance(city, others[j])
+	})
+
+	var roads []Direction
+	seen := make(map[Direction]bool)
+	for _, other := range others {
+		if len(roads) >= maxRoadsPerCity {
+			break
+		}
+		d := sectorDirection(other.X-city.X, other.Y-city.Y)
+		if seen[d] || isCoast(d) {
+			continue
+		}
+		seen[d] = true
+		roads = append(roads, d)
+	}
+
+	// Хотя бы одна дорога должна вести на сушу
+	for d := DirNorth; len(roads) < minRoadsPerCity && d <= DirWest; d++ {
+		if !isCoast(d) {
+			roads = append(roads, d)
+		}
+	}
+	return roads
+}
+
+func cityDistance(a, b *City) float64 {
+	return math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
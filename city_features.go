@@ -0,0 +1,174 @@
+package main
+
+import (
+	"test/internal/wfc"
+)
+
+const (
+	plazaRadius  = 1 // Площадь 3x3 в центре города
+	gateSpread   = 6 // Насколько ворота могут сместиться от середины стены
+	dockLength   = 3
+	dockInterval = 8
+)
+
+// toCityTiles переводит ID тайлов wfc в типы тайлов карты города
+func toCityTiles(grid [][]int) [][]int {
+	city := make([][]int, len(grid))
+	for y := range grid {
+		city[y] = make([]int, len(grid[y]))
+		for x, tile := range grid[y] {
+			switch tile {
+			case wfc.TileWater:
+				city[y][x] = TileWater
+			case wfc.TileGrass:
+				city[y][x] = TileGrass
+			case wfc.TileResidential1x1:
+				city[y][x] = TileResidential
+			case wfc.TileResidential2x2:
+				city[y][x] = TileCommercial
+			default:
+				city[y][x] = TileEmpty
+			}
+		}
+	}
+	return city
+}
+
+// edgeCell возвращает клетку у края d: p - позиция вдоль края, depth - отступ внутрь
+func edgeCell(d Direction, p, depth int) point {
+	switch d {
+	case DirNorth:
+		return point{p, depth}
+	case DirSouth:
+		return point{p, citySize - 1 - depth}
+	case DirWest:
+		return point{depth, p}
+	default:
+		return point{citySize - 1 - depth, p}
+	}
+}
+
+// placeContextFeatures размещает площадь, ворота с главными улицами
+// и причалы в соответствии с окружением города
+func (cg *CityGenerator) placeContextFeatures(grid [][]int) {
+	center := cg.findPlazaCenter(grid)
+	for dy := -plazaRadius; dy <= plazaRadius; dy++ {
+		for dx := -plazaRadius; dx <= plazaRadius; dx++ {
+			grid[center.y+dy][center.x+dx] = TilePlaza
+		}
+	}
+
+	if cg.context == nil {
+		return
+	}
+
+	for _, d := range cg.context.Roads {
+		gate := edgeCell(d, citySize/2+cg.rng.Intn(gateSpread*2+1)-gateSpread, 0)
+		grid[gate.y][gate.x] = TileGate
+		cg.layMainStreet(grid, gate, center)
+	}
+
+	for _, d := range cg.context.Coast {
+		cg.placeHarbor(grid, d, center)
+	}
+}
+
+// findPlazaCenter ищет ближайшее к центру место, где площадь не попадает в воду
+func (cg *CityGenerator) findPlazaCenter(grid [][]int) point {
+	mid := citySize / 2
+	for r := 0; r < mid-plazaRadius; r++ {
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				c := point{mid + dx, mid + dy}
+				if cg.isDryArea(grid, c, plazaRadius) {
+					return c
+				}
+			}
+		}
+	}
+	return point{mid, mid}
+}
+
+func (cg *CityGenerator) isDryArea(grid [][]int, c point, radius int) bool {
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			x, y := c.x+dx, c.y+dy
+			if x < 0 || y < 0 || x >= citySize || y >= citySize || grid[y][x] == TileWater {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// layMainStreet прокладывает слегка петляющую улицу от from до to.
+// Через воду улица идёт мостом.
+func (cg *CityGenerator) layMainStreet(grid [][]int, from, to point) {
+	x, y := from.x, from.y
+	for x != to.x || y != to.y {
+		dx, dy := to.x-x, to.y-y
+
+		// Обычно идём по длинной оси, иногда сворачиваем для естественности
+		alongX := abs(dx) > abs(dy)
+		if dx != 0 && dy != 0 && cg.rng.Float32() < 0.2 {
+			alongX = !alongX
+		}
+		if alongX {
+			x += sign(dx)
+		} else {
+			y += sign(dy)
+		}
+
+		switch grid[y][x] {
+		case TilePlaza, TileGate, TileDock:
+		case TileWater, TileBridge:
+			grid[y][x] = TileBridge
+		default:
+			grid[y][x] = TileRoad
+		}
+	}
+}
+
+// placeHarbor ведёт улицу от площади к берегу d и ставит причалы вдоль него
+func (cg *CityGenerator) placeHarbor(grid [][]int, d Direction, center point) {
+	for _, offset := range []int{0, -dockInterval, dockInterval} {
+		p := citySize/2 + offset
+		if p < 0 || p >= citySize {
+			continue
+		}
+
+		// Идём от края внутрь до первой суши
+		shore := -1
+		for depth := 0; depth < citySize/2; depth++ {
+			c := edgeCell(d, p, depth)
+			if grid[c.y][c.x] != TileWater {
+				shore = depth
+				break
+			}
+		}
+		if shore <= 0 {
+			continue
+		}
+
+		for i := 1; i <= dockLength && shore-i >= 0; i++ {
+			c := edgeCell(d, p, shore-i)
+			grid[c.y][c.x] = TileDock
+		}
+		if offset == 0 {
+			start := edgeCell(d, p, shore)
+			grid[start.y][start.x] = TileRoad
+			cg.layMainStreet(grid, start, center)
+		}
+	}
+}
+
+func sign(v int) int {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	default:
+		return 0
+	}
+}
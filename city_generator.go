@@ -1,18 +1,37 @@
 package main
 
 import (
+	"log"
+	"math"
 	"math/rand"
 	"test/internal/wfc"
 )
 
 const (
-	citySize     = 50
-	urbanDensity = 0.45
+	citySize       = 50
+	urbanDensity   = 0.45
+	citySamplePath = "assets/city_sample.png"
+	samplePatternN = 3
+	coastDepth     = 5 // Средняя глубина прибрежной воды в клетках города
+	riverWidth     = 2
+)
+
+// CityBackend определяет, каким алгоритмом строится раскладка города
+type CityBackend int
+
+const (
+	BackendTiled       CityBackend = iota // Простая tiled-модель с ручными правилами
+	BackendOverlapping                    // Overlapping-модель, обученная на образце
 )
 
 type CityGenerator struct {
-	seed int64
-	rng  *rand.Rand
+	seed      int64
+	rng       *rand.Rand
+	backend   CityBackend
+	sample    [][]int
+	context   *CityContext
+	streets   *StreetGraph
+	buildings []Building
 }
 
 func NewCityGenerator(seed int64) *CityGenerator {
@@ -22,7 +41,79 @@ func NewCityGenerator(seed int64) *CityGenerator {
 	}
 }
 
+// UseSample переключает генератор на overlapping-модель с образцом из PNG.
+// Цвета образца сопоставляются с цветами тайлов из CreateOrganicCityTiles.
+func (cg *CityGenerator) UseSample(path string) error {
+	palette := wfc.PaletteFromTiles(wfc.CreateOrganicCityTiles())
+	sample, err := wfc.LoadSample(path, palette)
+	if err != nil {
+		return err
+	}
+
+	cg.sample = sample
+	cg.backend = BackendOverlapping
+	return nil
+}
+
+// SetContext учитывает окружение города на карте мира: биом, берег, реку и дороги
+func (cg *CityGenerator) SetContext(ctx CityContext) {
+	cg.context = &ctx
+}
+
+// Generate строит карту города. Результат записан в типах тайлов
+// из types.go (TileRoad, TileWater и т.д.), а не в ID тайлов wfc.
 func (cg *CityGenerator) Generate() [][]int {
+	var grid [][]int
+	switch cg.backend {
+	case BackendOverlapping:
+		grid = cg.generateOverlapping()
+		cg.stampWater(grid)
+	default:
+		grid = cg.generateTiled()
+	}
+
+	cg.organicPostProcessing(grid)
+
+	cityGrid := toCityTiles(grid)
+	cg.placeContextFeatures(cityGrid)
+	cg.streets = cg.generateStreets(cityGrid)
+	cg.buildings = cg.extractBuildings(cityGrid)
+	return cityGrid
+}
+
+// Streets возвращает граф улиц, построенный последним вызовом Generate
+func (cg *CityGenerator) Streets() *StreetGraph {
+	return cg.streets
+}
+
+// Buildings возвращает здания, выделенные последним вызовом Generate
+func (cg *CityGenerator) Buildings() []Building {
+	return cg.buildings
+}
+
+func (cg *CityGenerator) generateOverlapping() [][]int {
+	model, err := wfc.NewOverlapping(wfc.OverlappingOptions{
+		Sample:        cg.sample,
+		N:             samplePatternN,
+		Width:         citySize,
+		Height:        citySize,
+		Seed:          cg.seed,
+		PeriodicInput: true,
+		Symmetry:      8,
+		MaxAttempts:   10,
+	})
+	if err != nil {
+		log.Printf("[ERROR] Ошибка overlapping-модели, используем tiled: %v", err)
+		return cg.generateTiled()
+	}
+
+	if debugMode {
+		log.Printf("[DEBUG] Извлечено паттернов из образца: %d", model.PatternCount())
+	}
+	return cg.unwrapResult(model.Run())
+}
+
+func (cg *CityGenerator) generateTiled() [][]int {
 	tiles := wfc.CreateOrganicCityTiles()
 
 	options := wfc.WFCOptions{
@@ -33,12 +124,99 @@ func (cg *CityGenerator) Generate() [][]int {
 		Iterations:   30000,
 		UrbanDensity: urbanDensity,
 	}
+	cg.applyContext(&options)
 
-	wfc := wfc.NewWFC(options)
-	grid := wfc.Run()
+	return cg.unwrapResult(wfc.NewWFC(options).Run())
+}
 
-	cg.organicPostProcessing(grid)
-	return grid
+// applyContext подстраивает плотность застройки под биом и
+// заранее закрепляет воду у берега и в русле реки
+func (cg *CityGenerator) applyContext(options *wfc.WFCOptions) {
+	if cg.context == nil {
+		return
+	}
+
+	switch cg.context.Biome {
+	case BiomeSand:
+		options.UrbanDensity = urbanDensity + 0.1 // Портовые города плотнее
+	case BiomeMountain:
+		options.UrbanDensity = urbanDensity - 0.15
+		for i := range options.Tiles {
+			if options.Tiles[i].ID == wfc.TileEmpty {
+				options.Tiles[i].Weight *= 3 // Больше скальных пустырей
+			}
+		}
+	case BiomeSnow:
+		options.UrbanDensity = urbanDensity - 0.2
+	}
+
+	for _, cell := range cg.waterCells() {
+		options.Masks = append(options.Masks, wfc.CellMask{
+			X:       cell.x,
+			Y:       cell.y,
+			Allowed: []int{wfc.TileWater},
+		})
+	}
+}
+
+// stampWater переносит воду из окружения прямо в сетку,
+// когда бэкенд не поддерживает ограничения
+func (cg *CityGenerator) stampWater(grid [][]int) {
+	for _, cell := range cg.waterCells() {
+		grid[cell.y][cell.x] = wfc.TileWater
+	}
+}
+
+// waterCells возвращает клетки берега и русла реки по контексту
+func (cg *CityGenerator) waterCells() []point {
+	if cg.context == nil {
+		return nil
+	}
+
+	// Отдельный генератор, чтобы форма воды не зависела от остальной генерации
+	rng := rand.New(rand.NewSource(cg.seed ^ 0x5eed))
+	var cells []point
+
+	for _, d := range cg.context.Coast {
+		phase := rng.Float64() * math.Pi * 2
+		for p := 0; p < citySize; p++ {
+			depth := coastDepth + int(math.Round(math.Sin(float64(p)*0.25+phase)*1.5))
+			for i := 0; i < depth; i++ {
+				cells = append(cells, edgeCell(d, p, i))
+			}
+		}
+	}
+
+	if river := cg.context.River; river != nil {
+		center := citySize/2 + int(river.Offset*citySize/3)
+		phase := rng.Float64() * math.Pi * 2
+		for p := 0; p < citySize; p++ {
+			bend := center + int(math.Round(math.Sin(float64(p)*0.15+phase)*3))
+			for w := 0; w < riverWidth; w++ {
+				x, y := bend+w, p
+				if !river.Vertical {
+					x, y = p, bend+w
+				}
+				if x >= 0 && y >= 0 && x < citySize && y < citySize {
+					cells = append(cells, point{x, y})
+				}
+			}
+		}
+	}
+
+	return cells
+}
+
+func (cg *CityGenerator) unwrapResult(result wfc.Result) [][]int {
+	if !result.Success {
+		log.Printf("[WARN] WFC не смог собрать город без нарушений: принудительно заполнено %d клеток",
+			len(result.Forced))
+	}
+	if debugMode {
+		log.Printf("[DEBUG] WFC: успех=%v, попыток=%d, коллапсов=%d",
+			result.Success, result.Attempts, result.Collapses)
+	}
+	return result.Grid
 }
 
 func (cg *CityGenerator) organicPostProcessing(grid [][]int) {
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+
+	"test/internal/interior"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const (
+	interiorCellsPerTile = 6  // Клеток боевой карты (по 5 футов) на тайл города
+	interiorMaxCellPx    = 32 // Крупнее клетку на экране не рисуем
+	interiorExportCellPx = 32
+	interiorExportDir    = "exports"
+	interiorPanelHeight  = 60 // Полоса кнопок и подсказок под планом
+)
+
+// InteriorView - открытый план здания
+type InteriorView struct {
+	Building Building
+	Plan     *interior.Plan
+	Floor    int
+	Status   string // Результат последнего экспорта
+}
+
+// interiorButton - кнопка панели плана; одни и те же прямоугольники
+// используются и для отрисовки, и для проверки кликов
+type interiorButton struct {
+	x, y, w, h int
+	label      string
+	action     func(g *Game)
+}
+
+// contains - попадает ли точка в кнопку
+func (b interiorButton) contains(x, y int) bool {
+	return x >= b.x && x <= b.x+b.w && y >= b.y && y <= b.y+b.h
+}
+
+// interiorSeed выводит сид здания из сида города, чтобы план не менялся между открытиями
+func interiorSeed(citySeed int64, b *Building) int64 {
+	return citySeed ^ (int64(b.ID+1) * 0x5DEECE66D) ^ int64(b.X<<16|b.Y)
+}
+
+// buildingFront выбирает сторону здания, выходящую на улицу
+func buildingFront(grid [][]int, b *Building) interior.Side {
+	best, bestCount := interior.SideNone, 0
+	for d := DirNorth; d <= DirWest; d++ {
+		count := 0
+		for _, c := range buildingEdge(b, d) {
+			if inCity(grid, c.x, c.y) && isStreetTile(grid[c.y][c.x]) {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = interior.Side(d), count
+		}
+	}
+	return best
+}
+
+// buildingEdge возвращает клетки снаружи здания вдоль стороны d
+func buildingEdge(b *Building, d Direction) []point {
+	var cells []point
+	switch d {
+	case DirNorth, DirSouth:
+		y := b.Y - 1
+		if d == DirSouth {
+			y = b.Y + b.Height
+		}
+		for x := b.X; x < b.X+b.Width; x++ {
+			cells = append(cells, point{x, y})
+		}
+	default:
+		x := b.X - 1
+		if d == DirEast {
+			x = b.X + b.Width
+		}
+		for y := b.Y; y < b.Y+b.Height; y++ {
+			cells = append(cells, point{x, y})
+		}
+	}
+	return cells
+}
+
+// withPlanSeed - здание с сидом плана для отправки игроку, без заметок
+// мастера. Вызывается под g.mu.
+func (g *Game) withPlanSeed(key string, b Building) Building {
+	b.Notes = ""
+	if m := g.cityTemplates[key]; m != nil {
+		b.PlanSeed = interiorSeed(m.Context.Seed, &b)
+	}
+	return b
+}
+
+// planSeed - сид плана здания: у клиента сида города нет, он берёт сид,
+// присланный сервером вместе со зданием
+func (g *Game) planSeed(b *Building) int64 {
+	if g.mode == "client" {
+		return b.PlanSeed
+	}
+	return interiorSeed(g.cityMap.Context.Seed, b)
+}
+
+func (g *Game) openInterior(b Building) {
+	plan, err := interior.Generate(interior.Options{
+		Type:   b.Type,
+		Width:  b.Width * interiorCellsPerTile,
+		Height: b.Height * interiorCellsPerTile,
+		Floors: b.Level,
+		Front:  buildingFront(g.cityMap.Grid, &b),
+		Seed:   g.planSeed(&b),
+	})
+	if err != nil {
+		log.Printf("[ERROR] Ошибка генерации плана здания %d: %v", b.ID, err)
+		return
+	}
+
+	g.scenes.Push(interiorScene{&InteriorView{Building: b, Plan: plan}})
+	if debugMode {
+		log.Printf("[DEBUG] План здания %d (%s): %dx%d, этажей %d",
+			b.ID, b.Type, plan.Width, plan.Height, len(plan.Floors))
+	}
+}
+
+// buildingAt возвращает здание под клеткой карты города
+func (m *CityMap) buildingAt(x, y int) *Building {
+	for i := range m.Buildings {
+		if m.Buildings[i].Contains(x, y) {
+			return &m.Buildings[i]
+		}
+	}
+	return nil
+}
+
+// interiorScene - план здания во весь экран; закрывается обратно в карту города
+type interiorScene struct {
+	view *InteriorView
+}
+
+func (interiorScene) Overlay() bool { return false }
+
+func (s interiorScene) Update(g *Game) {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return
+	}
+	g.updateInterior(s.view)
+}
+
+func (s interiorScene) Draw(g *Game, screen *ebiten.Image) { g.drawInterior(screen, s.view) }
+
+// building возвращает здание по номеру; после правок мастера номер не совпадает с индексом
+func (m *CityMap) building(id int) *Building {
+	for i := range m.Buildings {
+		if m.Buildings[i].ID == id {
+			return &m.Buildings[i]
+		}
+	}
+	return nil
+}
+
+// interiorCellPx подбирает размер клетки так, чтобы план поместился в область карты
+func interiorCellPx(plan *interior.Plan) int {
+	w, h := cityViewport()
+	px := min((w-20)/plan.Width, (h-interiorPanelHeight)/plan.Height)
+	return clamp(px, 1, interiorMaxCellPx)
+}
+
+func (g *Game) interiorButtons(v *InteriorView) []interiorButton {
+	right, y := cityViewport()
+	y += 10
+
+	buttons := []interiorButton{
+		{right - 80, y, 70, 20, "Закрыть", func(g *Game) { g.scenes.Pop() }},
+		{right - 170, y, 80, 20, "JSON", func(g *Game) { g.exportInterior(v, false) }},
+		{right - 260, y, 80, 20, "PNG", func(g *Game) { g.exportInterior(v, true) }},
+	}
+	if len(v.Plan.Floors) > 1 {
+		buttons = append(buttons,
+			interiorButton{10, y, 30, 20, "<", func(g *Game) {
+				v.Floor = max(v.Floor-1, 0)
+			}},
+			interiorButton{150, y, 30, 20, ">", func(g *Game) {
+				v.Floor = min(v.Floor+1, len(v.Plan.Floors)-1)
+			}},
+		)
+	}
+	return buttons
+}
+
+// updateInterior обрабатывает ввод открытого плана здания
+func (g *Game) updateInterior(v *InteriorView) {
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return
+	}
+	mx, my := ebiten.CursorPosition()
+	for _, b := range g.interiorButtons(v) {
+		if b.contains(mx, my) {
+			b.action(g)
+			return
+		}
+	}
+}
+
+func (g *Game) drawInterior(screen *ebiten.Image, v *InteriorView) {
+	w, h := cityViewport()
+	ebitenutil.DrawRect(screen, 0, 0, screenWidth, screenHeight, color.RGBA{20, 20, 25, 255})
+
+	floor := &v.Plan.Floors[v.Floor]
+	cell := interiorCellPx(v.Plan)
+	ox := (w - v.Plan.Width*cell) / 2
+	oy := (h - v.Plan.Height*cell) / 2
+
+	for y, row := range floor.Cells {
+		for x, c := range row {
+			px, py := float64(ox+x*cell), float64(oy+y*cell)
+			ebitenutil.DrawRect(screen, px, py, float64(cell), float64(cell), interiorCellColor(c))
+			if c != interior.CellWall && cell >= 8 {
+				drawRectOutline(screen, px, py, float64(cell), float64(cell), 1, color.RGBA{0, 0, 0, 40})
+			}
+		}
+	}
+	for _, f := range floor.Furniture {
+		inset := float64(cell) / 6
+		ebitenutil.DrawRect(screen, float64(ox+f.X*cell)+inset, float64(oy+f.Y*cell)+inset,
+			float64(cell)-2*inset, float64(cell)-2*inset, furnitureColor(f.Kind))
+	}
+
+	title := fmt.Sprintf("%s #%d", buildingTypeNames[v.Building.Type], v.Building.ID)
+	text.Draw(screen, title, g.font, 10, 20, color.White)
+
+	// Подсказка о комнате и мебели под курсором
+	mx, my := ebiten.CursorPosition()
+	cx, cy := (mx-ox)/cell, (my-oy)/cell
+	if mx >= ox && my >= oy && cx < v.Plan.Width && cy < v.Plan.Height {
+		hint := ""
+		if room := floor.RoomAt(cx, cy); room != nil {
+			hint = interior.RoomNames[room.Kind]
+		}
+		for _, f := range floor.Furniture {
+			if f.X == cx && f.Y == cy {
+				hint += ": " + interior.FurnitureNames[f.Kind]
+			}
+		}
+		text.Draw(screen, hint, g.font, 10, 40, color.RGBA{255, 255, 0, 255})
+	}
+
+	for _, b := range g.interiorButtons(v) {
+		clr := color.RGBA{70, 70, 90, 255}
+		if b.label == "Закрыть" {
+			clr = color.RGBA{100, 0, 0, 255}
+		}
+		ebitenutil.DrawRect(screen, float64(b.x), float64(b.y), float64(b.w), float64(b.h), clr)
+		text.Draw(screen, b.label, g.font, b.x+8, b.y+15, color.White)
+	}
+	if len(v.Plan.Floors) > 1 {
+		text.Draw(screen, fmt.Sprintf("Этаж %d/%d", v.Floor+1, len(v.Plan.Floors)),
+			g.font, 50, h+25, color.White)
+	}
+	if v.Status != "" {
+		text.Draw(screen, v.Status, g.font, 200, h+25, color.RGBA{180, 180, 180, 255})
+	}
+}
+
+func interiorCellColor(c interior.Cell) color.RGBA {
+	switch c {
+	case interior.CellWall:
+		return color.RGBA{60, 50, 45, 255}
+	case interior.CellDoor:
+		return color.RGBA{150, 100, 50, 255}
+	case interior.CellEntrance:
+		return color.RGBA{200, 140, 60, 255}
+	case interior.CellStairs:
+		return color.RGBA{130, 130, 160, 255}
+	default:
+		return color.RGBA{190, 170, 130, 255}
+	}
+}
+
+func furnitureColor(kind string) color.RGBA {
+	switch kind {
+	case "bed", "bench":
+		return color.RGBA{150, 60, 60, 255}
+	case "fireplace", "hearth", "forge":
+		return color.RGBA{220, 90, 30, 255}
+	case "altar", "throne":
+		return color.RGBA{230, 200, 60, 255}
+	case "barrel", "crate", "chest":
+		return color.RGBA{110, 75, 40, 255}
+	case "anvil", "rack":
+		return color.RGBA{90, 90, 100, 255}
+	default: // столы, полки, стойки
+		return color.RGBA{130, 90, 55, 255}
+	}
+}
+
+// renderInteriorFloor рисует этаж в изображение с сеткой боевой карты
+func renderInteriorFloor(floor *interior.Floor, cellPx int) *image.RGBA {
+	height := len(floor.Cells)
+	width := len(floor.Cells[0])
+	img := image.NewRGBA(image.Rect(0, 0, width*cellPx, height*cellPx))
+
+	fill := func(x0, y0, x1, y1 int, clr color.RGBA) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img.SetRGBA(x, y, clr)
+			}
+		}
+	}
+
+	grid := color.RGBA{150, 130, 100, 255}
+	for y, row := range floor.Cells {
+		for x, c := range row {
+			px, py := x*cellPx, y*cellPx
+			fill(px, py, px+cellPx, py+cellPx, interiorCellColor(c))
+			if c != interior.CellWall {
+				fill(px, py, px+cellPx, py+1, grid)
+				fill(px, py, px+1, py+cellPx, grid)
+			}
+		}
+	}
+	inset := cellPx / 6
+	for _, f := range floor.Furniture {
+		px, py := f.X*cellPx, f.Y*cellPx
+		fill(px+inset, py+inset, px+cellPx-inset, py+cellPx-inset, furnitureColor(f.Kind))
+	}
+	return img
+}
+
+// interiorExport - содержимое JSON-экспорта плана
+type interiorExport struct {
+	City     string         `json:"city"`
+	Building Building       `json:"building"`
+	Plan     *interior.Plan `json:"plan"`
+}
+
+// exportInterior сохраняет план в exports/: PNG по файлу на этаж или один JSON
+func (g *Game) exportInterior(v *InteriorView, asPNG bool) {
+	if err := os.MkdirAll(interiorExportDir, 0755); err != nil {
+		log.Printf("[ERROR] Ошибка создания папки экспорта: %v", err)
+		v.Status = "Ошибка экспорта"
+		return
+	}
+	base := filepath.Join(interiorExportDir,
+		fmt.Sprintf("%s_%s_%d", g.cityMap.City.Name, v.Building.Type, v.Building.ID))
+
+	var err error
+	var path string
+	if asPNG {
+		path = fmt.Sprintf("%s_floor%d.png", base, v.Floor+1)
+		err = writeInteriorPNG(path, &v.Plan.Floors[v.Floor])
+	} else {
+		path = base + ".json"
+		err = writeInteriorJSON(path, interiorExport{
+			City:     g.cityMap.City.Name,
+			Building: v.Building,
+			Plan:     v.Plan,
+		})
+	}
+	if err != nil {
+		log.Printf("[ERROR] Ошибка экспорта плана: %v", err)
+		v.Status = "Ошибка экспорта"
+		return
+	}
+	v.Status = "Сохранено: " + path
+}
+
+func writeInteriorPNG(path string, floor *interior.Floor) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return png.Encode(file, renderInteriorFloor(floor, interiorExportCellPx))
+}
+
+func writeInteriorJSON(path string, data interiorExport) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"image/color"
+	"log"
+	"math"
+	"os"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
@@ -9,89 +13,243 @@ import (
 	"github.com/hajimehoshi/ebiten/v2/text"
 )
 
+// initCityMap открывает карту города. Мастер видит город целиком; игрок
+// просит у сервера открытую ему часть и ждёт ответа MsgCityState.
 func (g *Game) initCityMap(city *City) {
+	if !g.isGM() {
+		g.requestCity(city)
+		return
+	}
+
 	g.mu.Lock()
-	defer g.mu.Unlock()
+	m := g.cityMapFor(city)
+	m.View = CityView{Zoom: cityMinZoom}
+	m.Selected = -1
+	m.Editing = false
+	m.clampView()
+	g.cityMap = m
+	g.mu.Unlock()
+	g.scenes.Push(cityScene{})
+}
 
-	generator := NewCityGenerator(int64(city.Population))
+// cityMapFor возвращает карту города из кэша сервера, при необходимости
+// генерируя её. Вызывается под g.mu.
+func (g *Game) cityMapFor(city *City) *CityMap {
+	key := cityMapKey(city)
+	if m := g.cityTemplates[key]; m != nil {
+		return m
+	}
+	m := g.buildCityMap(city)
+	g.cityTemplates[key] = m
+	return m
+}
+
+// cityMapByKey ищет город по ключу карты. Вызывается под g.mu.
+func (g *Game) cityMapByKey(key string) *CityMap {
+	if m := g.cityTemplates[key]; m != nil {
+		return m
+	}
+	for _, city := range g.cityList {
+		if cityMapKey(city) == key {
+			return g.cityMapFor(city)
+		}
+	}
+	return nil
+}
+
+// buildCityMap генерирует карту города и повторяет на ней правки мастера. Вызывается под g.mu.
+func (g *Game) buildCityMap(city *City) *CityMap {
+	context, ok := g.cityLayouts[cityMapKey(city)]
+	if !ok {
+		context = g.cityContext(city)
+	}
+	generator := NewCityGenerator(context.Seed)
+	generator.SetContext(context)
+	if _, err := os.Stat(citySamplePath); err == nil {
+		if err := generator.UseSample(citySamplePath); err != nil {
+			log.Printf("[ERROR] Ошибка загрузки образца города: %v", err)
+		}
+	}
 	cityGrid := generator.Generate()
 
-	g.cityMap = &CityMap{
+	m := &CityMap{
 		City:      city,
-		Tiles:     make([][]color.Color, cityMapSize),
-		Buildings: make([]Building, 0),
-		Open:      true,
+		Tiles:     make([][]color.Color, len(cityGrid)),
+		Buildings: generator.Buildings(),
 		Grid:      cityGrid,
+		Context:   context,
+		Streets:   generator.Streets(),
+		View:      CityView{Zoom: cityMinZoom},
+		Selected:  -1,
+	}
+
+	for y := range cityGrid {
+		m.Tiles[y] = make([]color.Color, len(cityGrid[y]))
+		for x := range cityGrid[y] {
+			m.Tiles[y][x] = g.getEnhancedTileColor(cityGrid, x, y)
+		}
+	}
+	// Генерация повторяется с теми же окрестностями и сидом, поэтому правки
+	// мастера ложатся на те же клетки
+	for _, e := range g.cityEdits[cityMapKey(city)] {
+		g.applyCityMapEdit(m, e)
 	}
+	return m
+}
 
-	for y := 0; y < cityMapSize; y++ {
-		g.cityMap.Tiles[y] = make([]color.Color, cityMapSize)
-		for x := 0; x < cityMapSize; x++ {
-			if y < len(cityGrid) && x < len(cityGrid[y]) {
-				g.cityMap.Tiles[y][x] = g.getEnhancedTileColor(cityGrid, x, y)
-			} else {
-				g.cityMap.Tiles[y][x] = color.RGBA{80, 80, 80, 255}
+// cityEntry выбирает клетку, где игрок появляется в городе: ворота или центр
+func cityEntry(m *CityMap) (int, int) {
+	for y := range m.Grid {
+		for x := range m.Grid[y] {
+			if m.Grid[y][x] == TileGate {
+				return x, y
 			}
 		}
 	}
+	return len(m.Grid[0]) / 2, len(m.Grid) / 2
+}
 
-	g.generateStructuredBuildings(cityGrid)
+// requestCity просит у сервера карту города, в который входит игрок
+func (g *Game) requestCity(city *City) {
+	if err := g.encoder.Encode(NetMessage{Kind: MsgEnterCity, ID: cityMapKey(city)}); err != nil {
+		log.Printf("[ERROR] Ошибка запроса города %s: %v", city.Name, err)
+	}
 }
 
-func (g *Game) generateStructuredBuildings(grid [][]int) {
-	g.cityMap.Buildings = make([]Building, 0)
+// enterCity ставит фишку игрока в город и отправляет ему открытую часть карты
+func (g *Game) enterCity(pid, key string) {
+	g.mu.Lock()
+	m := g.cityMapByKey(key)
+	if m == nil || !g.cityKnown(pid, m.City) {
+		g.mu.Unlock()
+		log.Printf("[ERROR] Игрок %s просит неизвестный ему город %q", pid, key)
+		return
+	}
 
-	for y := 0; y < len(grid) && y < cityMapSize; y++ {
-		for x := 0; x < len(grid[y]) && x < cityMapSize; x++ {
-			tile := grid[y][x]
+	p := g.players[pid]
+	p.Map = key
+	p.MapX, p.MapY = cityEntry(m)
+	g.players[pid] = p
+	g.revealAround(pid, p)
+	state := CityState{
+		Map:    key,
+		Width:  len(m.Grid[0]),
+		Height: len(m.Grid),
+		Entry:  [2]int{p.MapX, p.MapY},
+		Fog:    g.fogCells(key, g.exploredCells(pid, key)),
+	}
+	g.mu.Unlock()
 
-			if tile == TileResidential || tile == TileCommercial {
-				building := Building{
-					X:     x,
-					Y:     y,
-					Type:  getBuildingType(tile),
-					Level: g.calculateBuildingLevel(grid, x, y),
-				}
-				g.cityMap.Buildings = append(g.cityMap.Buildings, building)
-			}
+	g.sendTo(map[string]NetMessage{pid: {Kind: MsgCityState, City: &state}})
+	g.broadcast(NetMessage{Kind: MsgPlayer, Player: &p}, pid)
+}
+
+// openCityState открывает у игрока карту города, присланную сервером;
+// неоткрытые клетки остаются в тумане
+func (g *Game) openCityState(s CityState) {
+	if _, onWorld := g.scenes.Top().(worldScene); !onWorld {
+		return // Игрок успел уйти в другое окно
+	}
+
+	g.mu.Lock()
+	var city *City
+	for _, c := range g.cityList {
+		if cityMapKey(c) == s.Map {
+			city = c
 		}
 	}
+	if city == nil {
+		g.mu.Unlock()
+		log.Printf("[ERROR] Сервер прислал неизвестный город %q", s.Map)
+		return
+	}
+
+	m := &CityMap{
+		City:     city,
+		Tiles:    make([][]color.Color, s.Height),
+		Grid:     make([][]int, s.Height),
+		View:     CityView{Zoom: cityMinZoom},
+		Selected: -1,
+	}
+	for y := range m.Grid {
+		m.Grid[y] = make([]int, s.Width)
+		m.Tiles[y] = make([]color.Color, s.Width)
+		for x := range m.Grid[y] {
+			m.Grid[y][x] = TileUnknown
+			m.Tiles[y][x] = fogColor
+		}
+	}
+	m.clampView()
+	g.cityMap = m
+	g.mu.Unlock()
+
+	g.applyFog(s.Fog)
+	g.me.Map = s.Map
+	g.me.MapX, g.me.MapY = s.Entry[0], s.Entry[1]
+	g.scenes.Push(cityScene{})
 }
 
-func getBuildingType(tile int) string {
-	switch tile {
-	case TileResidential:
-		return "residential"
-	case TileCommercial:
-		return "commercial"
-	default:
-		return ""
+// leaveCity закрывает карту города; фишка игрока возвращается на карту мира
+func (g *Game) leaveCity() {
+	g.me.Map = ""
+	g.scenes.Pop()
+}
+
+// moveCityToken двигает фишку игрока по карте города
+func (g *Game) moveCityToken(m *CityMap) {
+	if g.me.Map != cityMapKey(m.City) {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyW) || inpututil.IsKeyJustPressed(ebiten.KeyUp) {
+		g.me.MapY--
 	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyS) || inpututil.IsKeyJustPressed(ebiten.KeyDown) {
+		g.me.MapY++
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyA) || inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+		g.me.MapX--
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyD) || inpututil.IsKeyJustPressed(ebiten.KeyRight) {
+		g.me.MapX++
+	}
+	g.me.MapX = clamp(g.me.MapX, 0, len(m.Grid[0])-1)
+	g.me.MapY = clamp(g.me.MapY, 0, len(m.Grid)-1)
 }
 
-func (g *Game) calculateBuildingLevel(grid [][]int, x, y int) int {
-	count := 0
-	for dy := -1; dy <= 1; dy++ {
-		for dx := -1; dx <= 1; dx++ {
-			nx, ny := x+dx, y+dy
-			if nx >= 0 && nx < len(grid[0]) && ny >= 0 && ny < len(grid) {
-				if grid[ny][nx] == grid[y][x] {
-					count++
-				}
-			}
+// drawCityTokens рисует фишки игроков, стоящих в этом городе
+func (g *Game) drawCityTokens(screen *ebiten.Image) {
+	m := g.cityMap
+	key := cityMapKey(m.City)
+	size := m.tilePx()
+	inset := size / 5
+
+	g.mu.Lock()
+	tokens := make([]Player, 0, len(g.players)+1)
+	for _, p := range g.players {
+		if p.ID != g.me.ID && p.Map == key {
+			tokens = append(tokens, p)
 		}
 	}
+	g.mu.Unlock()
+	if g.me.Map == key {
+		tokens = append(tokens, g.me)
+	}
 
-	switch {
-	case count > 6:
-		return 3
-	case count > 3:
-		return 2
-	default:
-		return 1
+	for _, p := range tokens {
+		x, y := m.cellToScreen(p.MapX, p.MapY)
+		g.drawToken(screen, p, x+inset, y+inset, size-2*inset, true)
 	}
 }
 
+// cityScene - карта открытого города во весь экран
+type cityScene struct{}
+
+func (cityScene) Overlay() bool { return false }
+
+func (cityScene) Update(g *Game) { g.updateCityMap() }
+
+func (cityScene) Draw(g *Game, screen *ebiten.Image) { g.drawCityMap(screen) }
+
 func (g *Game) getEnhancedTileColor(grid [][]int, x, y int) color.RGBA {
 	baseColor := getBaseTileColor(grid[y][x])
 	variation := (x + y) % 5
@@ -116,6 +274,23 @@ func (g *Game) getEnhancedTileColor(grid [][]int, x, y int) color.RGBA {
 	}
 }
 
+var tileNames = map[int]string{
+	TileEmpty:       "Пустырь",
+	TileRoad:        "Улица",
+	TileResidential: "Жилая застройка",
+	TileCommercial:  "Торговая застройка",
+	TilePark:        "Парк",
+	TileWater:       "Вода",
+	TilePath:        "Тропа",
+	TileSpecial:     "Особое здание",
+	TilePlaza:       "Площадь",
+	TileGate:        "Ворота",
+	TileDock:        "Причал",
+	TileBridge:      "Мост",
+	TileGrass:       "Луг",
+	TileUnknown:     "Неизвестно",
+}
+
 func getBaseTileColor(tile int) color.RGBA {
 	switch tile {
 	case TileRoad:
@@ -132,59 +307,319 @@ func getBaseTileColor(tile int) color.RGBA {
 		return color.RGBA{150, 150, 0, 255}
 	case TilePlaza:
 		return color.RGBA{200, 180, 120, 255}
+	case TileGate:
+		return color.RGBA{110, 80, 50, 255}
+	case TileDock:
+		return color.RGBA{140, 100, 60, 255}
+	case TileBridge:
+		return color.RGBA{170, 130, 90, 255}
+	case TileGrass:
+		return color.RGBA{100, 180, 60, 255}
+	case TileEmpty:
+		return color.RGBA{140, 140, 140, 255}
+	case TileUnknown:
+		return fogColor
 	default:
 		return color.RGBA{0, 0, 0, 255}
 	}
 }
 
+const (
+	cityMinZoom   = 1.0
+	cityMaxZoom   = 6.0
+	cityZoomStep  = 1.15
+	cityPanelW    = 320 // Ширина панели сведений о здании
+	cityBarHeight = 40  // Полоса под картой с кнопкой закрытия
+)
+
+// CityView - масштаб и сдвиг карты города. Zoom 1 вписывает весь город в область карты.
+type CityView struct {
+	Zoom             float64
+	OffsetX, OffsetY float64 // Сдвиг карты в пикселях экрана
+	dragging         bool
+	dragX, dragY     int
+}
+
+// cityViewport - область экрана над нижней полосой, в которой рисуется карта
+func cityViewport() (w, h int) {
+	return screenWidth, screenHeight - cityBarHeight
+}
+
+// tilePx возвращает размер тайла города на экране с учётом масштаба
+func (m *CityMap) tilePx() float64 {
+	w, h := cityViewport()
+	return float64(min(w, h)) / float64(len(m.Grid)) * m.View.Zoom
+}
+
+// screenToTile переводит координаты экрана в клетку города
+func (m *CityMap) screenToTile(mx, my int) (int, int, bool) {
+	w, h := cityViewport()
+	if mx < 0 || my < 0 || mx >= w || my >= h {
+		return 0, 0, false
+	}
+	size := m.tilePx()
+	x := int((float64(mx) - m.View.OffsetX) / size)
+	y := int((float64(my) - m.View.OffsetY) / size)
+	if float64(mx) < m.View.OffsetX || float64(my) < m.View.OffsetY || !inCity(m.Grid, x, y) {
+		return 0, 0, false
+	}
+	return x, y, true
+}
+
+// cellToScreen возвращает левый верхний угол клетки города на экране
+func (m *CityMap) cellToScreen(x, y int) (float64, float64) {
+	size := m.tilePx()
+	return m.View.OffsetX + float64(x)*size, m.View.OffsetY + float64(y)*size
+}
+
+// zoomAt меняет масштаб, оставляя точку под курсором на месте
+func (m *CityMap) zoomAt(factor float64, mx, my int) {
+	zoom := math.Max(cityMinZoom, math.Min(cityMaxZoom, m.View.Zoom*factor))
+	factor = zoom / m.View.Zoom
+	m.View.OffsetX = float64(mx) - (float64(mx)-m.View.OffsetX)*factor
+	m.View.OffsetY = float64(my) - (float64(my)-m.View.OffsetY)*factor
+	m.View.Zoom = zoom
+	m.clampView()
+}
+
+// clampView не даёт увести карту за пределы области; если карта меньше
+// области, она выравнивается по центру
+func (m *CityMap) clampView() {
+	w, h := cityViewport()
+	size := m.tilePx() * float64(len(m.Grid))
+	m.View.OffsetX = clampOffset(m.View.OffsetX, float64(w), size)
+	m.View.OffsetY = clampOffset(m.View.OffsetY, float64(h), size)
+}
+
+func clampOffset(offset, area, size float64) float64 {
+	if size <= area {
+		return (area - size) / 2
+	}
+	return math.Max(area-size, math.Min(0, offset))
+}
+
+// cityCloseButton возвращает прямоугольник кнопки возврата на карту мира
+func cityCloseButton() (x, y, w, h int) {
+	vw, vh := cityViewport()
+	return vw - 80, vh + 10, 70, 20
+}
+
 func (g *Game) updateCityMap() {
-	if g.cityMap == nil || !g.cityMap.Open {
+	m := g.cityMap
+	if m.Selected >= 0 && g.updateBuildingPanel() {
+		return
+	}
+	if g.isGM() && inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		g.toggleEditor()
+		m.Selected = -1
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		if g.editor.Active {
+			g.toggleEditor()
+		} else if m.Selected >= 0 {
+			m.Selected = -1
+		} else {
+			g.leaveCity()
+		}
 		return
 	}
 
+	mx, my := ebiten.CursorPosition()
+	vw, vh := cityViewport()
+	if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+		if x, y, ok := m.screenToTile(mx, my); ok {
+			g.placeMarker(cityMapKey(m.City), x, y)
+			return
+		}
+	}
+	if _, dy := ebiten.Wheel(); dy != 0 {
+		m.zoomAt(math.Pow(cityZoomStep, dy), mx, my)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEqual) || inpututil.IsKeyJustPressed(ebiten.KeyKPAdd) {
+		m.zoomAt(cityZoomStep, vw/2, vh/2)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyMinus) || inpututil.IsKeyJustPressed(ebiten.KeyKPSubtract) {
+		m.zoomAt(1/cityZoomStep, vw/2, vh/2)
+	}
+
+	g.moveCityToken(m)
+	g.updatePartyPanel()
+	if inpututil.IsKeyJustPressed(ebiten.KeyL) {
+		g.openRollWindow()
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.openChat()
+		return
+	}
+
+	// Перетаскивание правой кнопкой сдвигает карту
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) {
+		m.View.dragging = true
+		m.View.dragX, m.View.dragY = mx, my
+	}
+	if m.View.dragging {
+		if ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) {
+			m.View.OffsetX += float64(mx - m.View.dragX)
+			m.View.OffsetY += float64(my - m.View.dragY)
+			m.View.dragX, m.View.dragY = mx, my
+			m.clampView()
+		} else {
+			m.View.dragging = false
+		}
+	}
+
 	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
-		mx, my := ebiten.CursorPosition()
-		buttonX := cityMapSize*buildingSize*cityMapScale - 80
-		buttonY := cityMapSize*buildingSize*cityMapScale + 10
-		buttonWidth := 70
-		buttonHeight := 20
+		bx, by, bw, bh := cityCloseButton()
+		if mx >= bx && mx <= bx+bw && my >= by && my <= by+bh {
+			g.leaveCity()
+			return
+		}
+	}
+	if g.editor.Active {
+		x, y, ok := m.screenToTile(mx, my)
+		g.updateEditor(cityMapKey(m.City), x, y, ok)
+		return
+	}
 
-		if mx >= buttonX && mx <= buttonX+buttonWidth &&
-			my >= buttonY && my <= buttonY+buttonHeight {
-			g.cityMap.Open = false
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		// Клик по метке открывает её, по зданию - панель сведений о нём
+		m.Selected = -1
+		if x, y, ok := m.screenToTile(mx, my); ok {
+			if marker, found := g.markerAt(cityMapKey(m.City), x, y); found {
+				g.openMarker(marker, false)
+			} else if b := m.buildingAt(x, y); b != nil {
+				m.Selected = b.ID
+			}
 		}
 	}
 }
 
 func (g *Game) drawCityMap(screen *ebiten.Image) {
-	if g.cityMap == nil || !g.cityMap.Open {
-		return
+	m := g.cityMap
+	vw, vh := cityViewport()
+	w, h := float64(vw), float64(vh)
+	ebitenutil.DrawRect(screen, 0, 0, screenWidth, screenHeight, color.RGBA{20, 20, 25, 255})
+
+	size := m.tilePx()
+	for y := range m.Grid {
+		for x := range m.Grid[y] {
+			px := m.View.OffsetX + float64(x)*size
+			py := m.View.OffsetY + float64(y)*size
+			if px+size < 0 || py+size < 0 || px > w || py > h {
+				continue
+			}
+			ebitenutil.DrawRect(screen, px, py, size+1, size+1, m.Tiles[y][x])
+		}
+	}
+
+	g.drawCityBuildings(screen)
+	g.drawMarkers(screen, cityMapKey(m.City), size, m.cellToScreen)
+	g.drawCityTokens(screen)
+	g.drawCityHover(screen)
+	if g.editor.Active {
+		x, y, ok := m.screenToTile(ebiten.CursorPosition())
+		g.drawEditor(screen, cityMapKey(m.City), size, m.cellToScreen, x, y, ok)
+	}
+
+	// Полоса под картой: название города и кнопка возврата
+	ebitenutil.DrawRect(screen, 0, h, w, cityBarHeight, color.RGBA{30, 30, 40, 255})
+	text.Draw(screen, g.cityMap.City.Name, g.font, 10, vh+25, color.White)
+	hint := "Колесо - масштаб, ПКМ - сдвиг, Esc - назад, L - броски, Enter - чат"
+	if g.me.Map == cityMapKey(m.City) {
+		hint += ", WASD - ход"
+	}
+	if g.isGM() {
+		hint += ", N - метка, E - правка, O - отряд"
 	}
+	text.Draw(screen, hint, g.font, 250, vh+25,
+		color.RGBA{180, 180, 180, 255})
+	bx, by, bw, bh := cityCloseButton()
+	ebitenutil.DrawRect(screen, float64(bx), float64(by), float64(bw), float64(bh), color.RGBA{100, 0, 0, 255})
+	text.Draw(screen, "Закрыть", g.font, bx+8, by+15, color.White)
 
-	// Draw tiles
-	for y := 0; y < cityMapSize; y++ {
-		for x := 0; x < cityMapSize; x++ {
-			col := getBaseTileColor(g.cityMap.Grid[y][x])
-			ebitenutil.DrawRect(screen,
-				float64(x*buildingSize*cityMapScale),
-				float64(y*buildingSize*cityMapScale),
-				buildingSize*cityMapScale,
-				buildingSize*cityMapScale,
-				col)
+	if m.Selected >= 0 {
+		g.drawBuildingPanel(screen)
+	} else {
+		g.drawPartyPanel(screen, 10)
+	}
+}
+
+// drawCityBuildings рисует здания залитыми прямоугольниками с обводкой;
+// чем выше здание, тем толще обводка
+func (g *Game) drawCityBuildings(screen *ebiten.Image) {
+	m := g.cityMap
+	size := m.tilePx()
+	vw, vh := cityViewport()
+	for _, b := range m.Buildings {
+		x := m.View.OffsetX + float64(b.X)*size
+		y := m.View.OffsetY + float64(b.Y)*size
+		w := float64(b.Width) * size
+		h := float64(b.Height) * size
+		if x+w < 0 || y+h < 0 || x > float64(vw) || y > float64(vh) {
+			continue
+		}
+
+		inset := size / 16
+		ebitenutil.DrawRect(screen, x+2*inset, y+2*inset, w-4*inset, h-4*inset, getBuildingColor(b.Type))
+		outline := color.RGBA{40, 30, 20, 255}
+		if b.ID == m.Selected {
+			outline = color.RGBA{255, 255, 0, 255}
 		}
+		drawRectOutline(screen, x+inset, y+inset, w-2*inset, h-2*inset, math.Max(1, inset*float64(1+b.Level)/2), outline)
+	}
+}
+
+// drawCityHover показывает подсказку о тайле и здании под курсором
+func (g *Game) drawCityHover(screen *ebiten.Image) {
+	m := g.cityMap
+	mx, my := ebiten.CursorPosition()
+	x, y, ok := m.screenToTile(mx, my)
+	if !ok {
+		return
+	}
+
+	size := m.tilePx()
+	drawRectOutline(screen, m.View.OffsetX+float64(x)*size, m.View.OffsetY+float64(y)*size, size, size, 1,
+		color.RGBA{255, 255, 255, 200})
+
+	lines := []string{fmt.Sprintf("%s (%d, %d)", tileNames[m.Grid[y][x]], x, y)}
+	if b := m.buildingAt(x, y); b != nil {
+		lines = append(lines,
+			fmt.Sprintf("%s #%d, этажей: %d", buildingTypeNames[b.Type], b.ID, b.Level),
+			"Владелец: "+b.Owner)
 	}
 
-	// Draw city name
-	text.Draw(screen, g.cityMap.City.Name, g.font, 10, 20, color.White)
+	tx, ty := mx+16, my+8
+	if vw, _ := cityViewport(); tx+260 > vw {
+		tx = mx - 270
+	}
+	ebitenutil.DrawRect(screen, float64(tx-4), float64(ty-4), 260, float64(len(lines)*18+8), color.RGBA{0, 0, 0, 200})
+	for i, line := range lines {
+		text.Draw(screen, line, g.font, tx, ty+14+i*18, color.White)
+	}
+}
+
+func drawRectOutline(screen *ebiten.Image, x, y, w, h, thickness float64, clr color.Color) {
+	ebitenutil.DrawRect(screen, x, y, w, thickness, clr)
+	ebitenutil.DrawRect(screen, x, y+h-thickness, w, thickness, clr)
+	ebitenutil.DrawRect(screen, x, y, thickness, h, clr)
+	ebitenutil.DrawRect(screen, x+w-thickness, y, thickness, h, clr)
 }
 
 func getBuildingColor(buildingType string) color.RGBA {
 	switch buildingType {
-	case "commercial":
+	case BuildingTavern:
+		return color.RGBA{170, 110, 60, 255}
+	case BuildingSmithy:
+		return color.RGBA{90, 80, 80, 255}
+	case BuildingTemple:
+		return color.RGBA{230, 220, 170, 255}
+	case BuildingGuildhall:
 		return color.RGBA{120, 90, 90, 255}
-	case "special":
+	case BuildingKeep:
 		return color.RGBA{150, 150, 0, 255}
-	default: // residential
+	default: // house
 		return color.RGBA{180, 180, 180, 255} // Светло-серый для жилых
 	}
 }
@@ -0,0 +1,551 @@
+package main
+
+import "math"
+
+const (
+	branchChance      = 0.15 // Шанс ответвления на каждой клетке улицы
+	secondaryMinLen   = 5
+	secondaryMaxLen   = 12
+	alleyMinLen       = 3
+	alleyMaxLen       = 7
+	minBranchSpacing  = 3 // Минимальное расстояние между соседними ответвлениями
+	streetBranchDepth = 2 // Главные улицы -> переулки второго уровня -> проулки
+)
+
+// StreetNode - перекрёсток, тупик или ворота в уличной сети
+type StreetNode struct {
+	ID   int
+	X, Y int
+	Gate bool
+}
+
+// StreetEdge - участок улицы между двумя узлами
+type StreetEdge struct {
+	From, To int
+	Cells    []point // Клетки участка без начального узла, включая конечный
+	Kind     int     // TileRoad или TilePath - по преобладающему покрытию
+}
+
+// StreetGraph - граф улиц города для поиска пути
+type StreetGraph struct {
+	Nodes []StreetNode
+	Edges []StreetEdge
+	adj   map[int][]int // Узел -> индексы рёбер
+}
+
+// isStreetTile сообщает, можно ли ходить по тайлу как по улице
+func isStreetTile(tile int) bool {
+	switch tile {
+	case TileRoad, TilePath, TilePlaza, TileGate, TileBridge, TileDock:
+		return true
+	}
+	return false
+}
+
+func isBuildingTile(tile int) bool {
+	return tile == TileResidential || tile == TileCommercial
+}
+
+func inCity(grid [][]int, x, y int) bool {
+	return y >= 0 && y < len(grid) && x >= 0 && x < len(grid[y])
+}
+
+// generateStreets достраивает уличную сеть от главных улиц по упрощённой
+// L-системе, подводит проезды ко всем зданиям и строит граф улиц
+func (cg *CityGenerator) generateStreets(grid [][]int) *StreetGraph {
+	roads := cg.collectTiles(grid, TileRoad)
+	for depth := 1; depth <= streetBranchDepth; depth++ {
+		roads = cg.growBranches(grid, roads, depth)
+	}
+
+	cg.connectBuildings(grid)
+	return buildStreetGraph(grid)
+}
+
+func (cg *CityGenerator) collectTiles(grid [][]int, tile int) []point {
+	var cells []point
+	for y := range grid {
+		for x := range grid[y] {
+			if grid[y][x] == tile {
+				cells = append(cells, point{x, y})
+			}
+		}
+	}
+	return cells
+}
+
+// growBranches пускает перпендикулярные ответвления от клеток улиц.
+// Возвращает клетки новых ответвлений для следующего уровня.
+func (cg *CityGenerator) growBranches(grid [][]int, from []point, depth int) []point {
+	tile, minLen, maxLen := TileRoad, secondaryMinLen, secondaryMaxLen
+	if depth > 1 {
+		tile, minLen, maxLen = TilePath, alleyMinLen, alleyMaxLen
+	}
+
+	var grown []point
+	lastBranch := make(map[point]bool)
+	for _, c := range from {
+		if cg.rng.Float64() >= branchChance || nearBranch(lastBranch, c) {
+			continue
+		}
+
+		// Ответвление идёт поперёк улицы
+		var dirs []Direction
+		if streetAt(grid, c.x-1, c.y) || streetAt(grid, c.x+1, c.y) {
+			dirs = []Direction{DirNorth, DirSouth}
+		} else {
+			dirs = []Direction{DirEast, DirWest}
+		}
+		d := dirs[cg.rng.Intn(2)]
+
+		length := minLen + cg.rng.Intn(maxLen-minLen+1)
+		cells := cg.extendStreet(grid, c, d, length, tile)
+		if len(cells) > 0 {
+			lastBranch[c] = true
+			grown = append(grown, cells...)
+		}
+	}
+	return grown
+}
+
+func nearBranch(branches map[point]bool, c point) bool {
+	for b := range branches {
+		if abs(b.x-c.x)+abs(b.y-c.y) < minBranchSpacing {
+			return true
+		}
+	}
+	return false
+}
+
+func streetAt(grid [][]int, x, y int) bool {
+	return inCity(grid, x, y) && isStreetTile(grid[y][x])
+}
+
+// extendStreet ведёт улицу от start в направлении d. Улица останавливается
+// у воды, у края карты и при встрече с другой улицей, не образуя двойных полос.
+func (cg *CityGenerator) extendStreet(grid [][]int, start point, d Direction, length, tile int) []point {
+	off := directionOffsets[d]
+	var cells []point
+	x, y := start.x, start.y
+	for i := 0; i < length; i++ {
+		x, y = x+off[0], y+off[1]
+		if !inCity(grid, x, y) || grid[y][x] == TileWater {
+			break
+		}
+		if isStreetTile(grid[y][x]) {
+			break // Влились в существующую улицу
+		}
+
+		// Не прокладываем улицу вплотную вдоль другой
+		if i > 0 && (streetAt(grid, x+off[1], y+off[0]) || streetAt(grid, x-off[1], y-off[0])) {
+			break
+		}
+
+		grid[y][x] = tile
+		cells = append(cells, point{x, y})
+	}
+	return cells
+}
+
+// connectBuildings гарантирует, что к каждому зданию и причалу можно пройти
+// от ворот: от изолированных участков прокладываются проулки к ближайшей улице
+func (cg *CityGenerator) connectBuildings(grid [][]int) {
+	reachable := reachableStreets(grid)
+
+	lots := buildingLots(grid)
+	for _, docks := range tileLots(grid, func(tile int) bool { return tile == TileDock }) {
+		if !reachable[docks[0]] {
+			lots = append(lots, docks)
+		}
+	}
+
+	for _, lot := range lots {
+		if reachable[lot[0]] {
+			continue // Причал уже подключён через соседний участок
+		}
+		if lotTouches(grid, lot, reachable) {
+			continue
+		}
+
+		// Сначала ищем путь по свободной земле, затем сквозь дворы, затем мостом через воду
+		for pass := 0; pass < 3; pass++ {
+			path := findAccessPath(grid, lot, reachable, pass)
+			if path == nil {
+				continue
+			}
+			for _, c := range lot {
+				if isStreetTile(grid[c.y][c.x]) {
+					reachable[c] = true
+				}
+			}
+			for _, c := range path {
+				if grid[c.y][c.x] == TileWater {
+					grid[c.y][c.x] = TileBridge
+				} else {
+					grid[c.y][c.x] = TilePath
+				}
+				reachable[c] = true
+			}
+			break
+		}
+	}
+}
+
+// reachableStreets возвращает клетки улиц, связанные с воротами
+// (или с площадью, если ворот нет)
+func reachableStreets(grid [][]int) map[point]bool {
+	var roots []point
+	for y := range grid {
+		for x := range grid[y] {
+			if grid[y][x] == TileGate {
+				roots = append(roots, point{x, y})
+			}
+		}
+	}
+	if len(roots) == 0 {
+		for y := range grid {
+			for x := range grid[y] {
+				if grid[y][x] == TilePlaza {
+					roots = append(roots, point{x, y})
+				}
+			}
+		}
+	}
+
+	reachable := make(map[point]bool)
+	queue := roots
+	for _, r := range roots {
+		reachable[r] = true
+	}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, off := range directionOffsets {
+			n := point{c.x + off[0], c.y + off[1]}
+			if !reachable[n] && streetAt(grid, n.x, n.y) {
+				reachable[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+	return reachable
+}
+
+// buildingLots разбивает клетки зданий на связные участки
+func buildingLots(grid [][]int) [][]point {
+	return tileLots(grid, isBuildingTile)
+}
+
+// tileLots разбивает клетки, подходящие под условие, на связные группы
+func tileLots(grid [][]int, match func(tile int) bool) [][]point {
+	seen := make(map[point]bool)
+	var lots [][]point
+	for y := range grid {
+		for x := range grid[y] {
+			start := point{x, y}
+			if seen[start] || !match(grid[y][x]) {
+				continue
+			}
+
+			var lot []point
+			queue := []point{start}
+			seen[start] = true
+			for len(queue) > 0 {
+				c := queue[0]
+				queue = queue[1:]
+				lot = append(lot, c)
+				for _, off := range directionOffsets {
+					n := point{c.x + off[0], c.y + off[1]}
+					if !seen[n] && inCity(grid, n.x, n.y) && match(grid[n.y][n.x]) {
+						seen[n] = true
+						queue = append(queue, n)
+					}
+				}
+			}
+			lots = append(lots, lot)
+		}
+	}
+	return lots
+}
+
+func lotTouches(grid [][]int, lot []point, reachable map[point]bool) bool {
+	for _, c := range lot {
+		for _, off := range directionOffsets {
+			if reachable[point{c.x + off[0], c.y + off[1]}] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findAccessPath ищет кратчайший путь от участка до доступной улицы.
+// pass 0 - только по свободной земле, 1 - и через чужие здания, 2 - и через воду.
+// Возвращает клетки, которые нужно превратить в проход.
+func findAccessPath(grid [][]int, lot []point, reachable map[point]bool, pass int) []point {
+	inLot := make(map[point]bool, len(lot))
+	for _, c := range lot {
+		inLot[c] = true
+	}
+
+	passable := func(c point) bool {
+		tile := grid[c.y][c.x]
+		switch {
+		case inLot[c]:
+			return false
+		case tile == TileWater:
+			return pass >= 2
+		case isBuildingTile(tile):
+			return pass >= 1
+		}
+		return true
+	}
+
+	parent := make(map[point]point)
+	visited := make(map[point]bool)
+	var queue []point
+	for _, c := range lot {
+		visited[c] = true
+		queue = append(queue, c)
+	}
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, off := range directionOffsets {
+			n := point{c.x + off[0], c.y + off[1]}
+			if visited[n] || !inCity(grid, n.x, n.y) {
+				continue
+			}
+			if reachable[n] {
+				var path []point
+				for p := c; !inLot[p]; p = parent[p] {
+					path = append(path, p)
+				}
+				return path
+			}
+			if !passable(n) {
+				continue
+			}
+			visited[n] = true
+			parent[n] = c
+			queue = append(queue, n)
+		}
+	}
+	return nil
+}
+
+// buildStreetGraph сжимает клетки улиц в граф: узлами становятся перекрёстки,
+// тупики и ворота, рёбрами - участки улиц между ними
+func buildStreetGraph(grid [][]int) *StreetGraph {
+	sg := &StreetGraph{adj: make(map[int][]int)}
+	index := make(map[point]int)
+
+	degree := func(c point) int {
+		n := 0
+		for _, off := range directionOffsets {
+			if streetAt(grid, c.x+off[0], c.y+off[1]) {
+				n++
+			}
+		}
+		return n
+	}
+
+	for y := range grid {
+		for x := range grid[y] {
+			c := point{x, y}
+			if !isStreetTile(grid[y][x]) {
+				continue
+			}
+			if degree(c) != 2 || grid[y][x] == TileGate {
+				index[c] = len(sg.Nodes)
+				sg.Nodes = append(sg.Nodes, StreetNode{
+					ID:   len(sg.Nodes),
+					X:    x,
+					Y:    y,
+					Gate: grid[y][x] == TileGate,
+				})
+			}
+		}
+	}
+
+	// Кольцо из одних клеток со степенью 2 не получило узла и выпало бы из
+	// графа: ставим узел в первую клетку каждого такого куска улиц
+	for _, lot := range tileLots(grid, isStreetTile) {
+		hasNode := false
+		for _, c := range lot {
+			if _, ok := index[c]; ok {
+				hasNode = true
+				break
+			}
+		}
+		if !hasNode {
+			c := lot[0]
+			index[c] = len(sg.Nodes)
+			sg.Nodes = append(sg.Nodes, StreetNode{ID: len(sg.Nodes), X: c.x, Y: c.y})
+		}
+	}
+
+	// Каждое ребро трассируется один раз: запоминаем пары (узел, направление выхода)
+	traced := make(map[[2]int]bool)
+	for _, node := range sg.Nodes {
+		start := point{node.X, node.Y}
+		for d, off := range directionOffsets {
+			if traced[[2]int{node.ID, d}] || !streetAt(grid, start.x+off[0], start.y+off[1]) {
+				continue
+			}
+			traced[[2]int{node.ID, d}] = true
+
+			prev, cur := start, point{start.x + off[0], start.y + off[1]}
+			cells := []point{cur}
+			roads := 0
+			for {
+				if grid[cur.y][cur.x] != TilePath {
+					roads++
+				}
+				if end, ok := index[cur]; ok {
+					back := directionBetween(cur, prev)
+					traced[[2]int{end, back}] = true
+
+					kind := TilePath
+					if roads*2 >= len(cells) {
+						kind = TileRoad
+					}
+					sg.addEdge(StreetEdge{From: node.ID, To: end, Cells: cells, Kind: kind})
+					break
+				}
+
+				next, ok := nextStreetCell(grid, cur, prev)
+				if !ok {
+					break
+				}
+				prev, cur = cur, next
+				cells = append(cells, cur)
+			}
+		}
+	}
+	return sg
+}
+
+func directionBetween(from, to point) int {
+	for d, off := range directionOffsets {
+		if from.x+off[0] == to.x && from.y+off[1] == to.y {
+			return d
+		}
+	}
+	return -1
+}
+
+// nextStreetCell продолжает улицу через клетку со степенью 2
+func nextStreetCell(grid [][]int, cur, prev point) (point, bool) {
+	for _, off := range directionOffsets {
+		n := point{cur.x + off[0], cur.y + off[1]}
+		if n != prev && streetAt(grid, n.x, n.y) {
+			return n, true
+		}
+	}
+	return point{}, false
+}
+
+func (sg *StreetGraph) addEdge(e StreetEdge) {
+	sg.Edges = append(sg.Edges, e)
+	i := len(sg.Edges) - 1
+	sg.adj[e.From] = append(sg.adj[e.From], i)
+	if e.To != e.From {
+		sg.adj[e.To] = append(sg.adj[e.To], i)
+	}
+}
+
+// NearestNode возвращает ближайший к клетке узел или -1 для пустого графа
+func (sg *StreetGraph) NearestNode(x, y int) int {
+	best, bestDist := -1, math.MaxInt
+	for _, n := range sg.Nodes {
+		d := abs(n.X-x) + abs(n.Y-y)
+		if d < bestDist {
+			best, bestDist = n.ID, d
+		}
+	}
+	return best
+}
+
+// ShortestPath ищет кратчайший путь между узлами алгоритмом Дейкстры.
+// Возвращает индексы рёбер по порядку и длину пути в клетках; ok=false, если пути нет.
+func (sg *StreetGraph) ShortestPath(from, to int) (edges []int, length int, ok bool) {
+	dist := make([]int, len(sg.Nodes))
+	via := make([]int, len(sg.Nodes))
+	done := make([]bool, len(sg.Nodes))
+	for i := range dist {
+		dist[i] = math.MaxInt
+		via[i] = -1
+	}
+	dist[from] = 0
+
+	for {
+		cur := -1
+		for i := range dist {
+			if !done[i] && dist[i] != math.MaxInt && (cur == -1 || dist[i] < dist[cur]) {
+				cur = i
+			}
+		}
+		if cur == -1 || cur == to {
+			break
+		}
+		done[cur] = true
+
+		for _, ei := range sg.adj[cur] {
+			e := sg.Edges[ei]
+			next := e.To
+			if next == cur {
+				next = e.From
+			}
+			if d := dist[cur] + len(e.Cells); d < dist[next] {
+				dist[next] = d
+				via[next] = ei
+			}
+		}
+	}
+
+	if dist[to] == math.MaxInt {
+		return nil, 0, false
+	}
+	for n := to; n != from; {
+		e := sg.Edges[via[n]]
+		edges = append([]int{via[n]}, edges...)
+		if e.To == n {
+			n = e.From
+		} else {
+			n = e.To
+		}
+	}
+	return edges, dist[to], true
+}
+
+// Route возвращает клетки пути по улицам между ближайшими к точкам узлами
+func (sg *StreetGraph) Route(fromX, fromY, toX, toY int) []point {
+	from, to := sg.NearestNode(fromX, fromY), sg.NearestNode(toX, toY)
+	if from == -1 || to == -1 {
+		return nil
+	}
+	edges, _, ok := sg.ShortestPath(from, to)
+	if !ok {
+		return nil
+	}
+
+	route := []point{{sg.Nodes[from].X, sg.Nodes[from].Y}}
+	at := from
+	for _, ei := range edges {
+		e := sg.Edges[ei]
+		if e.From == at {
+			route = append(route, e.Cells...)
+			at = e.To
+			continue
+		}
+		// Ребро проходим в обратную сторону
+		for i := len(e.Cells) - 2; i >= 0; i-- {
+			route = append(route, e.Cells[i])
+		}
+		route = append(route, point{sg.Nodes[e.From].X, sg.Nodes[e.From].Y})
+		at = e.From
+	}
+	return route
+}
@@ -18,19 +18,25 @@ const (
 func (g *Game) initCityWindow() {
 	g.cityWindow = &CityWindow{
 		game: g,
-		open: false,
 	}
 }
 
-func (w *CityWindow) Update() {
-	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
-		w.open = !w.open
-	}
+// cityListScene - список городов поверх карты мира
+type cityListScene struct{}
+
+func (cityListScene) Overlay() bool { return true }
 
-	if !w.open {
+func (cityListScene) Update(g *Game) {
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) || inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
 		return
 	}
+	g.cityWindow.Update()
+}
+
+func (cityListScene) Draw(g *Game, screen *ebiten.Image) { g.cityWindow.Draw(screen) }
 
+func (w *CityWindow) Update() {
 	_, dy := ebiten.Wheel()
 	w.scrollY += int(dy * 20)
 	if w.scrollY < 0 {
@@ -56,10 +62,6 @@ func (w *CityWindow) Update() {
 }
 
 func (w *CityWindow) Draw(screen *ebiten.Image) {
-	if !w.open {
-		return
-	}
-
 	ebitenutil.DrawRect(screen, 0, 0, cityWindowWidth, cityWindowHeight, color.RGBA{30, 30, 40, 230})
 
 	title := "Список городов"
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math/rand"
+
+	"test/internal/dungeon"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const (
+	dungeonCount        = 8
+	dungeonPlaceTries   = 300
+	dungeonMinSpacing   = 6 // Минимальное расстояние между входами в клетках мира
+	dungeonWidth        = 64
+	dungeonHeight       = 40
+	dungeonSecretChance = 0.1
+	dungeonSeedSalt     = 0x64756e67656f6e // Отделяет подземелья от остального мира
+)
+
+var dungeonNames = []string{
+	"Забытые катакомбы", "Логово гоблинов", "Чёрная шахта", "Склеп королей",
+	"Затопленный храм", "Пещера эха", "Крепость теней", "Гнездо виверны",
+	"Старые копи", "Башня некроманта", "Змеиные норы", "Руины монастыря",
+}
+
+// generateDungeons расставляет входы в подземелья по сиду и тайлам мира.
+// Расставляет только сервер; клиент получает входы без сидов, а карту
+// подземелья - по запросу, см. playerDungeon.
+func (g *Game) generateDungeons() {
+	rng := rand.New(rand.NewSource(g.seed ^ dungeonSeedSalt))
+	g.dungeonList = make([]*DungeonSite, 0, dungeonCount)
+	if len(g.tiles) == 0 || len(g.tiles[0]) == 0 {
+		return
+	}
+
+	rows, cols := len(g.tiles), len(g.tiles[0])
+	for try := 0; try < dungeonPlaceTries && len(g.dungeonList) < dungeonCount; try++ {
+		x, y := rng.Intn(cols), rng.Intn(rows)
+		biome := biomeFromColor(g.tiles[y][x])
+		if biome == BiomeWater || g.dungeonNear(x, y) {
+			continue
+		}
+
+		g.dungeonList = append(g.dungeonList, &DungeonSite{
+			Name:  dungeonNames[rng.Intn(len(dungeonNames))],
+			X:     x,
+			Y:     y,
+			Style: dungeonStyleFor(biome, rng),
+			Seed:  rng.Int63(),
+		})
+	}
+
+	if debugMode {
+		log.Printf("[DEBUG] Размещено подземелий: %d", len(g.dungeonList))
+	}
+}
+
+// dungeonStyleFor выбирает стиль по местности: в горах пещеры, на равнинах
+// комнаты, на побережье лабиринты; иногда стиль выбирается случайно
+func dungeonStyleFor(biome Biome, rng *rand.Rand) dungeon.Style {
+	if rng.Float64() < 0.25 {
+		return dungeon.Style(rng.Intn(3))
+	}
+	switch biome {
+	case BiomeMountain, BiomeSnow:
+		return dungeon.StyleCaves
+	case BiomeSand:
+		return dungeon.StyleWFC
+	default:
+		return dungeon.StyleRooms
+	}
+}
+
+func (g *Game) dungeonNear(x, y int) bool {
+	for _, site := range g.dungeonList {
+		if abs(site.X-x) < dungeonMinSpacing && abs(site.Y-y) < dungeonMinSpacing {
+			return true
+		}
+	}
+	return false
+}
+
+// dungeonKey - ключ подземелья в сетевых сообщениях: на клетке мира не больше одного входа
+func dungeonKey(site *DungeonSite) string {
+	return fmt.Sprintf("dungeon:%d,%d", site.X, site.Y)
+}
+
+func (g *Game) findDungeonByKey(key string) *DungeonSite {
+	for _, site := range g.dungeonList {
+		if dungeonKey(site) == key {
+			return site
+		}
+	}
+	return nil
+}
+
+// playerSite - вход в подземелье для отправки игроку, без сида
+func playerSite(site *DungeonSite) DungeonSite {
+	s := *site
+	s.Seed = 0
+	return s
+}
+
+func (g *Game) findDungeonAt(x, y int) *DungeonSite {
+	for _, site := range g.dungeonList {
+		if site.X == x && site.Y == y {
+			return site
+		}
+	}
+	return nil
+}
+
+// dungeonScene - карта подземелья во весь экран
+type dungeonScene struct{}
+
+func (dungeonScene) Overlay() bool { return false }
+
+func (dungeonScene) Update(g *Game) { g.updateDungeonMap() }
+
+func (dungeonScene) Draw(g *Game, screen *ebiten.Image) { g.drawDungeonMap(screen) }
+
+func generateDungeon(site *DungeonSite) (*dungeon.Dungeon, error) {
+	return dungeon.Generate(dungeon.Options{
+		Style:            site.Style,
+		Width:            dungeonWidth,
+		Height:           dungeonHeight,
+		Seed:             site.Seed,
+		SecretDoorChance: dungeonSecretChance,
+	})
+}
+
+// initDungeonMap открывает карту подземелья. Мастер генерирует её сам, игрок
+// просит у сервера и ждёт ответа MsgDungeon.
+func (g *Game) initDungeonMap(site *DungeonSite) {
+	if !g.isGM() {
+		if err := g.encoder.Encode(NetMessage{Kind: MsgEnterDungeon, ID: dungeonKey(site)}); err != nil {
+			log.Printf("[ERROR] Ошибка запроса подземелья %s: %v", site.Name, err)
+		}
+		return
+	}
+
+	d, err := generateDungeon(site)
+	if err != nil {
+		log.Printf("[ERROR] Ошибка генерации подземелья %s: %v", site.Name, err)
+		return
+	}
+	g.openDungeon(site, d)
+}
+
+func (g *Game) openDungeon(site *DungeonSite, d *dungeon.Dungeon) {
+	g.mu.Lock()
+	g.dungeonMap = &DungeonMap{Site: site, Dungeon: d}
+	g.mu.Unlock()
+	g.scenes.Push(dungeonScene{})
+
+	if debugMode {
+		log.Printf("[DEBUG] Подземелье %s (%s): комнат %d, дверей %d",
+			site.Name, site.Style, len(d.Rooms), len(d.Doors))
+	}
+}
+
+// enterDungeon отправляет игроку карту подземелья, вход в которое он видел
+func (g *Game) enterDungeon(pid, key string) {
+	g.mu.Lock()
+	site := g.findDungeonByKey(key)
+	known := site != nil && g.explored(pid, worldMapKey, site.X, site.Y)
+	g.mu.Unlock()
+	if !known {
+		log.Printf("[ERROR] Игрок %s просит неизвестное ему подземелье %q", pid, key)
+		return
+	}
+
+	d, err := generateDungeon(site)
+	if err != nil {
+		log.Printf("[ERROR] Ошибка генерации подземелья %s: %v", site.Name, err)
+		return
+	}
+	g.sendTo(map[string]NetMessage{pid: {Kind: MsgDungeon, ID: key, Dungeon: playerDungeon(d)}})
+}
+
+// playerDungeon убирает из подземелья то, что знает только мастер: сид,
+// потайные двери и назначение комнат, кроме входа
+func playerDungeon(d *dungeon.Dungeon) *dungeon.Dungeon {
+	d.Seed = 0
+	for y := range d.Tiles {
+		for x, tile := range d.Tiles[y] {
+			if tile == dungeon.TileSecretDoor {
+				d.Tiles[y][x] = dungeon.TileWall
+			}
+		}
+	}
+	doors := d.Doors[:0]
+	for _, door := range d.Doors {
+		if !door.Secret {
+			doors = append(doors, door)
+		}
+	}
+	d.Doors = doors
+	for i := range d.Rooms {
+		if d.Rooms[i].Tag != dungeon.TagEntrance {
+			d.Rooms[i].Tag = dungeon.TagNone
+		}
+	}
+	return d
+}
+
+// dungeonCloseButton возвращает прямоугольник кнопки закрытия карты подземелья
+func dungeonCloseButton() (x, y, w, h int) {
+	return screenWidth - 110, 10, 100, 30
+}
+
+// dungeonLayout возвращает размер клетки и отступ, при которых подземелье помещается на экран
+func dungeonLayout(d *dungeon.Dungeon) (cell, ox, oy int) {
+	cell = min((screenWidth-40)/d.Width, (screenHeight-100)/d.Height)
+	ox = (screenWidth - d.Width*cell) / 2
+	oy = 50 + (screenHeight-50-d.Height*cell)/2
+	return cell, ox, oy
+}
+
+func (g *Game) updateDungeonMap() {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		mx, my := ebiten.CursorPosition()
+		bx, by, bw, bh := dungeonCloseButton()
+		if mx >= bx && mx <= bx+bw && my >= by && my <= by+bh {
+			g.scenes.Pop()
+		}
+	}
+}
+
+func (g *Game) drawDungeonSites(screen *ebiten.Image) {
+	for _, site := range g.dungeonList {
+		screenX, screenY := g.worldToScreen(site.X, site.Y)
+		if !g.isVisible(screenX, screenY, cellSize, cellSize) {
+			continue
+		}
+
+		ebitenutil.DrawRect(screen, float64(screenX), float64(screenY), cellSize, cellSize, color.RGBA{40, 20, 50, 255})
+		ebitenutil.DrawRect(screen, float64(screenX+4), float64(screenY+4), cellSize-8, cellSize-8, color.RGBA{150, 60, 200, 255})
+	}
+
+	if site := g.hoverDungeon; site != nil {
+		screenX, screenY := g.worldToScreen(site.X, site.Y)
+		drawRectOutline(screen, float64(screenX-2), float64(screenY-2), cellSize+4, cellSize+4, 2, color.RGBA{255, 255, 0, 255})
+		info := fmt.Sprintf("%s\n%s", site.Name, site.Style)
+		text.Draw(screen, info, g.font, screenX-len(site.Name)*3, screenY-25, color.RGBA{255, 255, 0, 255})
+	}
+}
+
+func (g *Game) drawDungeonMap(screen *ebiten.Image) {
+	if g.dungeonMap == nil {
+		return
+	}
+
+	d := g.dungeonMap.Dungeon
+	ebitenutil.DrawRect(screen, 0, 0, screenWidth, screenHeight, color.RGBA{10, 10, 15, 255})
+
+	cell, ox, oy := dungeonLayout(d)
+	for y := 0; y < d.Height; y++ {
+		for x := 0; x < d.Width; x++ {
+			tile := d.Tiles[y][x]
+			if tile == dungeon.TileSecretDoor && !g.isGM() {
+				tile = dungeon.TileWall // Игроки видят потайную дверь стеной
+			}
+			clr := dungeonTileColor(tile)
+			if room := d.RoomAt(x, y); room != nil && tile == dungeon.TileFloor {
+				clr = roomTagColor(g.visibleRoomTag(room.Tag))
+			}
+			ebitenutil.DrawRect(screen, float64(ox+x*cell), float64(oy+y*cell), float64(cell), float64(cell), clr)
+		}
+	}
+
+	// Спуск в подземелье
+	ex, ey := d.Entrance[0], d.Entrance[1]
+	ebitenutil.DrawRect(screen, float64(ox+ex*cell+cell/4), float64(oy+ey*cell+cell/4),
+		float64(cell/2), float64(cell/2), color.RGBA{255, 255, 255, 255})
+
+	title := fmt.Sprintf("%s - %s", g.dungeonMap.Site.Name, d.Style)
+	text.Draw(screen, title, g.font, 10, 30, color.White)
+	g.drawDungeonLegend(screen)
+
+	// Подсказка о комнате под курсором
+	mx, my := ebiten.CursorPosition()
+	if mx >= ox && my >= oy {
+		if room := d.RoomAt((mx-ox)/cell, (my-oy)/cell); room != nil {
+			hint := fmt.Sprintf("%s #%d, клеток: %d", dungeon.TagNames[g.visibleRoomTag(room.Tag)], room.ID, room.Size)
+			text.Draw(screen, hint, g.font, mx+12, my, color.RGBA{255, 255, 0, 255})
+		}
+	}
+
+	bx, by, bw, bh := dungeonCloseButton()
+	ebitenutil.DrawRect(screen, float64(bx), float64(by), float64(bw), float64(bh), color.RGBA{100, 0, 0, 255})
+	text.Draw(screen, "Закрыть", g.font, bx+20, by+20, color.White)
+}
+
+// visibleRoomTag - пометка комнаты, которую видит игрок: назначение комнат,
+// кроме входа, знает только мастер
+func (g *Game) visibleRoomTag(tag dungeon.Tag) dungeon.Tag {
+	if g.isGM() || tag == dungeon.TagEntrance {
+		return tag
+	}
+	return dungeon.TagNone
+}
+
+func (g *Game) drawDungeonLegend(screen *ebiten.Image) {
+	if !g.isGM() {
+		return
+	}
+	x := 400
+	for _, tag := range []dungeon.Tag{dungeon.TagEntrance, dungeon.TagBoss, dungeon.TagTreasure, dungeon.TagTrap} {
+		ebitenutil.DrawRect(screen, float64(x), 17, 14, 14, roomTagColor(tag))
+		text.Draw(screen, dungeon.TagNames[tag], g.font, x+20, 30, color.White)
+		x += 160
+	}
+	ebitenutil.DrawRect(screen, float64(x), 17, 14, 14, dungeonTileColor(dungeon.TileSecretDoor))
+	text.Draw(screen, "Потайная дверь", g.font, x+20, 30, color.White)
+}
+
+func dungeonTileColor(t dungeon.Tile) color.RGBA {
+	switch t {
+	case dungeon.TileFloor:
+		return color.RGBA{90, 85, 80, 255}
+	case dungeon.TileDoor:
+		return color.RGBA{160, 110, 50, 255}
+	case dungeon.TileSecretDoor:
+		return color.RGBA{200, 60, 160, 255}
+	default:
+		return color.RGBA{30, 28, 35, 255}
+	}
+}
+
+func roomTagColor(tag dungeon.Tag) color.RGBA {
+	switch tag {
+	case dungeon.TagEntrance:
+		return color.RGBA{80, 130, 90, 255}
+	case dungeon.TagBoss:
+		return color.RGBA{150, 50, 50, 255}
+	case dungeon.TagTreasure:
+		return color.RGBA{170, 150, 60, 255}
+	case dungeon.TagTrap:
+		return color.RGBA{140, 90, 140, 255}
+	default:
+		return color.RGBA{115, 110, 100, 255}
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math/rand"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+// EditTool - инструмент режима правки карты
+type EditTool int
+
+const (
+	ToolBrush EditTool = iota
+	ToolMove           // Поставить или перенести город/здание
+	ToolErase
+	ToolReveal // Открыть клетки игрокам
+	ToolHide   // Снова скрыть клетки в тумане
+	editToolCount
+)
+
+var editToolNames = map[EditTool]string{
+	ToolBrush:  "Кисть",
+	ToolMove:   "Объекты",
+	ToolErase:  "Удаление",
+	ToolReveal: "Открыть",
+	ToolHide:   "Скрыть",
+}
+
+const (
+	maxBrushRadius = 5
+	maxEditHistory = 100
+	editBarX       = 420 // Левее выводится отладочная информация мира
+	editBarY       = 8
+	editBarH       = 28
+)
+
+// Цвета кисти: биомы на карте мира и типы тайлов на карте города
+var (
+	worldPalette = []int{int(BiomeWater), int(BiomeSand), int(BiomeGrass), int(BiomeMountain), int(BiomeSnow)}
+	cityPalette  = []int{TileGrass, TileEmpty, TileRoad, TilePath, TilePlaza, TileResidential, TileCommercial, TilePark, TileWater}
+)
+
+// Editor - режим правки карты мастером: кисть, перенос и удаление городов и
+// зданий, история отмены
+type Editor struct {
+	Active     bool
+	Tool       EditTool
+	Biome      Biome // Цвет кисти на карте мира
+	CityTile   int   // Цвет кисти на карте города
+	Radius     int   // 0 - одна клетка, 1 - квадрат 3x3 и т.д.
+	undo, redo []MapEdit
+	stroke     *MapEdit // Мазок, пока зажата кнопка мыши
+	painted    map[[2]int]bool
+	drag       *editDrag
+}
+
+// editDrag - перетаскиваемый город или здание
+type editDrag struct {
+	id           int
+	grabX, grabY int // Смещение клетки, за которую взяли объект, от его угла
+}
+
+func editPalette(mapKey string) []int {
+	if mapKey == worldMapKey {
+		return worldPalette
+	}
+	return cityPalette
+}
+
+func paletteColor(mapKey string, value int) color.RGBA {
+	if mapKey == worldMapKey {
+		return biomeColors[Biome(value)]
+	}
+	return getBaseTileColor(value)
+}
+
+func paletteName(mapKey string, value int) string {
+	if mapKey == worldMapKey {
+		return biomeNames[Biome(value)]
+	}
+	return tileNames[value]
+}
+
+func (e *Editor) brush(mapKey string) int {
+	if mapKey == worldMapKey {
+		return int(e.Biome)
+	}
+	return e.CityTile
+}
+
+func (e *Editor) setBrush(mapKey string, value int) {
+	if mapKey == worldMapKey {
+		e.Biome = Biome(value)
+	} else {
+		e.CityTile = value
+	}
+	e.Tool = ToolBrush
+}
+
+// toggleEditor включает и выключает режим правки; править может только мастер
+func (g *Game) toggleEditor() {
+	if !g.isGM() {
+		return
+	}
+	g.finishStroke()
+	g.editor.drag = nil
+	g.editor.Active = !g.editor.Active
+}
+
+// cellValue возвращает биом клетки мира или тип тайла города
+func (g *Game) cellValue(mapKey string, x, y int) (int, bool) {
+	if mapKey == worldMapKey {
+		if !g.inWorld(x, y) {
+			return 0, false
+		}
+		return int(biomeFromColor(g.tiles[y][x])), true
+	}
+	if g.cityMap == nil || !inCity(g.cityMap.Grid, x, y) {
+		return 0, false
+	}
+	return g.cityMap.Grid[y][x], true
+}
+
+// paintCell перекрашивает клетку сразу, до конца мазка; в журнал правок мазок
+// попадает целиком, когда кнопку отпустят
+func (g *Game) paintCell(mapKey string, c CellEdit) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if mapKey == worldMapKey {
+		g.setWorldBiome(c.X, c.Y, Biome(c.After))
+	} else if g.cityMap != nil {
+		g.applyCityMapEdit(g.cityMap, MapEdit{Cells: []CellEdit{c}})
+	}
+}
+
+func (g *Game) finishStroke() {
+	e := &g.editor
+	if e.stroke != nil && len(e.stroke.Cells) > 0 {
+		g.commitEdit(*e.stroke)
+	}
+	e.stroke, e.painted = nil, nil
+}
+
+// editObjectAt возвращает номер города или здания под клеткой
+func (g *Game) editObjectAt(mapKey string, x, y int) (int, bool) {
+	if mapKey == worldMapKey {
+		if city := g.findCityAt(x, y); city != nil {
+			return city.ID, true
+		}
+		return 0, false
+	}
+	if b := g.cityMap.buildingAt(x, y); b != nil {
+		return b.ID, true
+	}
+	return 0, false
+}
+
+// editObjectRect возвращает занимаемые объектом клетки
+func (g *Game) editObjectRect(mapKey string, id int) (x, y, w, h int, ok bool) {
+	if mapKey == worldMapKey {
+		city := g.findCityByID(id)
+		if city == nil {
+			return 0, 0, 0, 0, false
+		}
+		return city.X - city.Size, city.Y - city.Size, 2*city.Size + 1, 2*city.Size + 1, true
+	}
+	b := g.cityMap.building(id)
+	if b == nil {
+		return 0, 0, 0, 0, false
+	}
+	return b.X, b.Y, b.Width, b.Height, true
+}
+
+// newObjectEdit ставит в клетку новый город или одноклеточный дом
+func (g *Game) newObjectEdit(mapKey string, x, y int) MapEdit {
+	if mapKey == worldMapKey {
+		id := 0
+		for _, city := range g.cityList {
+			id = max(id, city.ID+1)
+		}
+		city := &City{
+			ID:         id,
+			Name:       generateCityName(),
+			X:          x,
+			Y:          y,
+			Size:       1,
+			Population: rand.Intn(90000) + 10000,
+		}
+		return MapEdit{Map: mapKey, City: &CityChange{After: city}}
+	}
+
+	id := 0
+	for _, b := range g.cityMap.Buildings {
+		id = max(id, b.ID+1)
+	}
+	b := &Building{
+		ID:     id,
+		X:      x,
+		Y:      y,
+		Width:  1,
+		Height: 1,
+		Size:   BuildingSmall,
+		Type:   BuildingHouse,
+		Level:  1,
+		Owner:  NewCityGenerator(rand.Int63()).ownerName(BuildingHouse),
+	}
+	return MapEdit{Map: mapKey, Building: &BuildingChange{After: b}}
+}
+
+// moveObjectEdit переносит объект так, чтобы его угол оказался в клетке x, y
+func (g *Game) moveObjectEdit(mapKey string, id, x, y int) (MapEdit, bool) {
+	if mapKey == worldMapKey {
+		city := g.findCityByID(id)
+		if city == nil || len(g.tiles) == 0 {
+			return MapEdit{}, false
+		}
+		before, after := *city, *city
+		after.X = clamp(x+city.Size, 0, len(g.tiles[0])-1)
+		after.Y = clamp(y+city.Size, 0, len(g.tiles)-1)
+		if after.X == before.X && after.Y == before.Y {
+			return MapEdit{}, false
+		}
+		return MapEdit{Map: mapKey, City: &CityChange{Before: &before, After: &after}}, true
+	}
+
+	b := g.cityMap.building(id)
+	if b == nil {
+		return MapEdit{}, false
+	}
+	before, after := *b, *b
+	after.X = clamp(x, 0, len(g.cityMap.Grid[0])-b.Width)
+	after.Y = clamp(y, 0, len(g.cityMap.Grid)-b.Height)
+	if after.X == before.X && after.Y == before.Y {
+		return MapEdit{}, false
+	}
+	return MapEdit{Map: mapKey, Building: &BuildingChange{Before: &before, After: &after}}, true
+}
+
+// notesEdit меняет заметки мастера к зданию
+func (g *Game) notesEdit(mapKey string, id int, notes string) (MapEdit, bool) {
+	b := g.cityMap.building(id)
+	if b == nil || b.Notes == notes {
+		return MapEdit{}, false
+	}
+	before, after := *b, *b
+	after.Notes = notes
+	return MapEdit{Map: mapKey, Building: &BuildingChange{Before: &before, After: &after}}, true
+}
+
+func (g *Game) deleteObjectEdit(mapKey string, id int) (MapEdit, bool) {
+	if mapKey == worldMapKey {
+		city := g.findCityByID(id)
+		if city == nil {
+			return MapEdit{}, false
+		}
+		before := *city
+		return MapEdit{Map: mapKey, City: &CityChange{Before: &before}}, true
+	}
+	b := g.cityMap.building(id)
+	if b == nil {
+		return MapEdit{}, false
+	}
+	before := *b
+	return MapEdit{Map: mapKey, Building: &BuildingChange{Before: &before}}, true
+}
+
+// editorLayout возвращает кнопки панели инструментов и образцы цветов кисти;
+// одни и те же прямоугольники используются для отрисовки и кликов
+func (g *Game) editorLayout(mapKey string) (buttons, swatches []interiorButton) {
+	x, y, h := editBarX, editBarY, editBarH
+	for t := EditTool(0); t < editToolCount; t++ {
+		tool := t
+		buttons = append(buttons, interiorButton{x, y, 90, h, editToolNames[t], func(g *Game) {
+			g.editor.Tool = tool
+			g.editor.drag = nil
+		}})
+		x += 95
+	}
+
+	x += 10
+	for _, v := range editPalette(mapKey) {
+		value := v
+		swatches = append(swatches, interiorButton{x, y, h, h, paletteName(mapKey, v), func(g *Game) {
+			g.editor.setBrush(mapKey, value)
+		}})
+		x += h + 4
+	}
+
+	x += 10
+	buttons = append(buttons,
+		interiorButton{x, y, h, h, "-", func(g *Game) { g.editor.Radius = max(g.editor.Radius-1, 0) }},
+		interiorButton{x + h + 70, y, h, h, "+", func(g *Game) { g.editor.Radius = min(g.editor.Radius+1, maxBrushRadius) }},
+	)
+	x += 2*h + 80
+	buttons = append(buttons,
+		interiorButton{x, y, 95, h, "Отменить", func(g *Game) { g.undoEdit() }},
+		interiorButton{x + 100, y, 95, h, "Повторить", func(g *Game) { g.redoEdit() }},
+	)
+	return buttons, swatches
+}
+
+func editorBarRect(buttons []interiorButton) (x, y, w, h int) {
+	last := buttons[len(buttons)-1]
+	return editBarX - 6, editBarY - 4, last.x + last.w - editBarX + 12, editBarH + 28
+}
+
+// updateEditor обрабатывает ввод режима правки. x, y - клетка карты под
+// курсором, ok - курсор над картой.
+func (g *Game) updateEditor(mapKey string, x, y int, ok bool) {
+	e := &g.editor
+	ctrl := ebiten.IsKeyPressed(ebiten.KeyControl) || ebiten.IsKeyPressed(ebiten.KeyMeta)
+	shift := ebiten.IsKeyPressed(ebiten.KeyShift)
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyZ) {
+		if shift {
+			g.redoEdit()
+		} else {
+			g.undoEdit()
+		}
+		return
+	}
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyY) {
+		g.redoEdit()
+		return
+	}
+
+	palette := editPalette(mapKey)
+	for i := 0; i < len(palette) && i < 9; i++ {
+		if inpututil.IsKeyJustPressed(ebiten.KeyDigit1 + ebiten.Key(i)) {
+			e.setBrush(mapKey, palette[i])
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketLeft) {
+		e.Radius = max(e.Radius-1, 0)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketRight) {
+		e.Radius = min(e.Radius+1, maxBrushRadius)
+	}
+
+	mx, my := ebiten.CursorPosition()
+	buttons, swatches := g.editorLayout(mapKey)
+	bx, by, bw, bh := editorBarRect(buttons)
+	overBar := mx >= bx && mx <= bx+bw && my >= by && my <= by+bh
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		for _, btn := range append(buttons, swatches...) {
+			if mx >= btn.x && mx <= btn.x+btn.w && my >= btn.y && my <= btn.y+btn.h {
+				btn.action(g)
+				return
+			}
+		}
+		if overBar {
+			return
+		}
+	}
+	if overBar && e.stroke == nil && e.drag == nil {
+		return
+	}
+
+	switch e.Tool {
+	case ToolBrush:
+		g.updateBrush(mapKey, x, y, ok)
+	case ToolMove:
+		g.updateMoveTool(mapKey, x, y, ok)
+	case ToolErase:
+		if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && ok {
+			if id, found := g.editObjectAt(mapKey, x, y); found {
+				if edit, changed := g.deleteObjectEdit(mapKey, id); changed {
+					g.commitEdit(edit)
+				}
+			}
+		}
+	case ToolReveal, ToolHide:
+		g.updateFogBrush(mapKey, x, y, ok, e.Tool == ToolReveal)
+	}
+}
+
+// updateFogBrush открывает или скрывает клетки под кистью. Туман не правка
+// карты, поэтому в историю отмены не попадает: скрытое снова открывается кистью.
+func (g *Game) updateFogBrush(mapKey string, x, y int, ok, reveal bool) {
+	e := &g.editor
+	if !ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+		e.painted = nil
+		return
+	}
+	if e.painted == nil {
+		if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			return
+		}
+		e.painted = make(map[[2]int]bool)
+	}
+	if !ok {
+		return
+	}
+
+	g.mu.Lock()
+	w, h := g.mapBounds(mapKey)
+	g.mu.Unlock()
+	var cells [][2]int
+	for cy := max(y-e.Radius, 0); cy <= min(y+e.Radius, h-1); cy++ {
+		for cx := max(x-e.Radius, 0); cx <= min(x+e.Radius, w-1); cx++ {
+			if !e.painted[[2]int{cx, cy}] {
+				e.painted[[2]int{cx, cy}] = true
+				cells = append(cells, [2]int{cx, cy})
+			}
+		}
+	}
+	if len(cells) > 0 {
+		g.setFog(mapKey, cells, reveal)
+	}
+}
+
+func (g *Game) updateBrush(mapKey string, x, y int, ok bool) {
+	e := &g.editor
+	pressed := ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft)
+	if !pressed {
+		g.finishStroke()
+		return
+	}
+	if e.stroke == nil {
+		if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			return
+		}
+		e.stroke = &MapEdit{Map: mapKey}
+		e.painted = make(map[[2]int]bool)
+	}
+	if !ok {
+		return
+	}
+
+	value := e.brush(mapKey)
+	for dy := -e.Radius; dy <= e.Radius; dy++ {
+		for dx := -e.Radius; dx <= e.Radius; dx++ {
+			cx, cy := x+dx, y+dy
+			if e.painted[[2]int{cx, cy}] {
+				continue
+			}
+			before, inside := g.cellValue(mapKey, cx, cy)
+			if !inside {
+				continue
+			}
+			e.painted[[2]int{cx, cy}] = true
+			if before == value {
+				continue
+			}
+			c := CellEdit{X: cx, Y: cy, Before: before, After: value}
+			e.stroke.Cells = append(e.stroke.Cells, c)
+			g.paintCell(mapKey, c)
+		}
+	}
+}
+
+func (g *Game) updateMoveTool(mapKey string, x, y int, ok bool) {
+	e := &g.editor
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && ok {
+		if id, found := g.editObjectAt(mapKey, x, y); found {
+			ox, oy, _, _, _ := g.editObjectRect(mapKey, id)
+			e.drag = &editDrag{id: id, grabX: x - ox, grabY: y - oy}
+		} else {
+			g.commitEdit(g.newObjectEdit(mapKey, x, y))
+		}
+		return
+	}
+
+	if e.drag != nil && !ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+		if ok {
+			if edit, changed := g.moveObjectEdit(mapKey, e.drag.id, x-e.drag.grabX, y-e.drag.grabY); changed {
+				g.commitEdit(edit)
+			}
+		}
+		e.drag = nil
+	}
+}
+
+// drawEditor рисует панель инструментов и след кисти или переносимого объекта.
+// size и toScreen задают масштаб и положение карты, как в drawMarkers.
+func (g *Game) drawEditor(screen *ebiten.Image, mapKey string, size float64,
+	toScreen func(x, y int) (float64, float64), x, y int, ok bool) {
+	e := &g.editor
+	if e.Tool == ToolReveal || e.Tool == ToolHide {
+		g.drawFogOverlay(screen, mapKey, size, toScreen)
+	}
+	if ok {
+		cx, cy, w, h := x, y, 1, 1
+		clr := color.RGBA{255, 255, 255, 220}
+		switch e.Tool {
+		case ToolBrush:
+			cx, cy, w, h = x-e.Radius, y-e.Radius, 2*e.Radius+1, 2*e.Radius+1
+		case ToolReveal, ToolHide:
+			cx, cy, w, h = x-e.Radius, y-e.Radius, 2*e.Radius+1, 2*e.Radius+1
+			clr = color.RGBA{120, 200, 255, 255}
+		case ToolMove:
+			if e.drag != nil {
+				if _, _, ow, oh, found := g.editObjectRect(mapKey, e.drag.id); found {
+					cx, cy, w, h = x-e.drag.grabX, y-e.drag.grabY, ow, oh
+				}
+			} else if id, found := g.editObjectAt(mapKey, x, y); found {
+				cx, cy, w, h, _ = g.editObjectRect(mapKey, id)
+			}
+			clr = color.RGBA{255, 255, 0, 255}
+		case ToolErase:
+			if id, found := g.editObjectAt(mapKey, x, y); found {
+				cx, cy, w, h, _ = g.editObjectRect(mapKey, id)
+			}
+			clr = color.RGBA{255, 60, 60, 255}
+		}
+		sx, sy := toScreen(cx, cy)
+		drawRectOutline(screen, sx, sy, float64(w)*size, float64(h)*size, 2, clr)
+	}
+
+	buttons, swatches := g.editorLayout(mapKey)
+	bx, by, bw, bh := editorBarRect(buttons)
+	ebitenutil.DrawRect(screen, float64(bx), float64(by), float64(bw), float64(bh), color.RGBA{30, 30, 40, 230})
+
+	for i, btn := range buttons {
+		clr := color.RGBA{70, 70, 90, 255}
+		if i < int(editToolCount) && EditTool(i) == e.Tool {
+			clr = color.RGBA{120, 110, 40, 255}
+		}
+		ebitenutil.DrawRect(screen, float64(btn.x), float64(btn.y), float64(btn.w), float64(btn.h), clr)
+		text.Draw(screen, btn.label, g.font, btn.x+8, btn.y+19, color.White)
+	}
+	minus := buttons[editToolCount]
+	text.Draw(screen, fmt.Sprintf("Размер %d", 2*e.Radius+1), g.font, minus.x+minus.w+6, minus.y+19, color.White)
+
+	palette := editPalette(mapKey)
+	for i, sw := range swatches {
+		ebitenutil.DrawRect(screen, float64(sw.x), float64(sw.y), float64(sw.w), float64(sw.h), paletteColor(mapKey, palette[i]))
+		if palette[i] == e.brush(mapKey) {
+			drawRectOutline(screen, float64(sw.x-2), float64(sw.y-2), float64(sw.w+4), float64(sw.h+4), 2,
+				color.RGBA{255, 255, 0, 255})
+		}
+	}
+
+	hint := fmt.Sprintf("%s | E - выход, Ctrl+Z/Ctrl+Y - отмена/повтор (%d/%d), 1-9 - цвет, [ ] - размер",
+		paletteName(mapKey, e.brush(mapKey)), len(e.undo), len(e.redo))
+	text.Draw(screen, hint, g.font, editBarX, editBarY+editBarH+18, color.RGBA{220, 220, 220, 255})
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"image/color"
+	"log"
+	"sort"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+const (
+	partyFogKey      = "*" // Клетки, которые мастер открыл всем игрокам
+	worldSightRadius = 5
+	citySightRadius  = 6
+)
+
+// TileUnknown - клетка города, которую игрок ещё не видел. Объявлена отдельно
+// от остальных тайлов: генератор городов её никогда не ставит.
+const TileUnknown = -1
+
+var (
+	fogColor        = color.RGBA{15, 15, 20, 255}
+	fogOverlayColor = color.RGBA{0, 0, 0, 140}
+)
+
+// Exploration - открытые клетки по ключам карт
+type Exploration map[string]map[[2]int]bool
+
+func (e Exploration) has(key string, x, y int) bool {
+	return e[key][[2]int{x, y}]
+}
+
+// add открывает клетку и сообщает, была ли она закрыта
+func (e Exploration) add(key string, x, y int) bool {
+	cells := e[key]
+	if cells == nil {
+		cells = make(map[[2]int]bool)
+		e[key] = cells
+	}
+	if cells[[2]int{x, y}] {
+		return false
+	}
+	cells[[2]int{x, y}] = true
+	return true
+}
+
+func (e Exploration) remove(key string, x, y int) bool {
+	if !e[key][[2]int{x, y}] {
+		return false
+	}
+	delete(e[key], [2]int{x, y})
+	return true
+}
+
+// mapKey возвращает ключ карты, на которой стоит фишка игрока
+func (p Player) mapKey() string {
+	if p.Map == "" {
+		return worldMapKey
+	}
+	return p.Map
+}
+
+func (p Player) mapPos() (int, int) {
+	if p.Map == "" {
+		return p.X, p.Y
+	}
+	return p.MapX, p.MapY
+}
+
+// explorationFor возвращает исследованные игроком клетки. Вызывается под g.mu.
+func (g *Game) explorationFor(pid string) Exploration {
+	e := g.exploration[pid]
+	if e == nil {
+		e = make(Exploration)
+		g.exploration[pid] = e
+	}
+	return e
+}
+
+// explored сообщает, видел ли игрок клетку сам или её открыл мастер. Вызывается под g.mu.
+func (g *Game) explored(pid, key string, x, y int) bool {
+	return g.exploration[pid].has(key, x, y) || g.exploration[partyFogKey].has(key, x, y)
+}
+
+// exploredCells возвращает все открытые игроку клетки карты. Вызывается под g.mu.
+func (g *Game) exploredCells(pid, key string) [][2]int {
+	seen := make(map[[2]int]bool)
+	for _, owner := range []string{pid, partyFogKey} {
+		for p := range g.exploration[owner][key] {
+			seen[p] = true
+		}
+	}
+	cells := make([][2]int, 0, len(seen))
+	for p := range seen {
+		cells = append(cells, p)
+	}
+	sortCells(cells)
+	return cells
+}
+
+func sortCells(cells [][2]int) {
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i][1] != cells[j][1] {
+			return cells[i][1] < cells[j][1]
+		}
+		return cells[i][0] < cells[j][0]
+	})
+}
+
+// rectTouches сообщает, есть ли в прямоугольнике хотя бы одна открытая клетка
+func rectTouches(x, y, w, h int, revealed func(x, y int) bool) bool {
+	for cy := y; cy < y+h; cy++ {
+		for cx := x; cx < x+w; cx++ {
+			if revealed(cx, cy) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func cityRect(c *City) (x, y, w, h int) {
+	return c.X - c.Size, c.Y - c.Size, 2*c.Size + 1, 2*c.Size + 1
+}
+
+// cityKnown сообщает, видел ли игрок хотя бы одну клетку города. Вызывается под g.mu.
+func (g *Game) cityKnown(pid string, c *City) bool {
+	if c == nil {
+		return false
+	}
+	x, y, w, h := cityRect(c)
+	return rectTouches(x, y, w, h, func(x, y int) bool { return g.explored(pid, worldMapKey, x, y) })
+}
+
+func (g *Game) buildingKnown(pid, key string, b *Building) bool {
+	if b == nil {
+		return false
+	}
+	return rectTouches(b.X, b.Y, b.Width, b.Height, func(x, y int) bool { return g.explored(pid, key, x, y) })
+}
+
+// mapBounds возвращает размер карты в клетках. Вызывается под g.mu.
+func (g *Game) mapBounds(key string) (w, h int) {
+	if key == worldMapKey {
+		if len(g.tiles) == 0 {
+			return 0, 0
+		}
+		return len(g.tiles[0]), len(g.tiles)
+	}
+	m := g.cityTemplates[key]
+	if m == nil && g.cityMap != nil && cityMapKey(g.cityMap.City) == key {
+		m = g.cityMap
+	}
+	if m == nil || len(m.Grid) == 0 {
+		return 0, 0
+	}
+	return len(m.Grid[0]), len(m.Grid)
+}
+
+// revealAround открывает игроку клетки в радиусе обзора его фишки и
+// возвращает их содержимое; nil - ничего нового. Вызывается под g.mu.
+func (g *Game) revealAround(pid string, p Player) *FogUpdate {
+	key := p.mapKey()
+	cx, cy := p.mapPos()
+	radius := worldSightRadius
+	if key != worldMapKey {
+		radius = citySightRadius
+	}
+
+	w, h := g.mapBounds(key)
+	e := g.explorationFor(pid)
+	var cells [][2]int
+	for y := max(cy-radius, 0); y <= min(cy+radius, h-1); y++ {
+		for x := max(cx-radius, 0); x <= min(cx+radius, w-1); x++ {
+			dx, dy := x-cx, y-cy
+			if dx*dx+dy*dy > radius*radius || g.explored(pid, key, x, y) {
+				continue
+			}
+			e.add(key, x, y)
+			cells = append(cells, [2]int{x, y})
+		}
+	}
+	if len(cells) == 0 {
+		return nil
+	}
+	g.worldDirty = true
+	update := g.fogCells(key, cells)
+	return &update
+}
+
+// fogCells собирает содержимое открытых клеток: биомы или тайлы, метки, а
+// также города, подземелья и здания, которые их касаются. Вызывается под g.mu.
+func (g *Game) fogCells(key string, cells [][2]int) FogUpdate {
+	update := FogUpdate{Map: key, Cells: make([]RevealedCell, 0, len(cells))}
+	open := make(map[[2]int]bool, len(cells))
+	for _, c := range cells {
+		open[c] = true
+	}
+	touched := func(x, y int) bool { return open[[2]int{x, y}] }
+
+	for _, m := range g.markers {
+		if m.Map == key && !m.GMOnly && touched(m.X, m.Y) {
+			update.Markers = append(update.Markers, *m)
+		}
+	}
+
+	if key == worldMapKey {
+		for _, c := range cells {
+			if g.inWorld(c[0], c[1]) {
+				update.Cells = append(update.Cells, RevealedCell{X: c[0], Y: c[1], Value: int(biomeFromColor(g.tiles[c[1]][c[0]]))})
+			}
+		}
+		for _, city := range g.cityList {
+			if x, y, w, h := cityRect(city); rectTouches(x, y, w, h, touched) {
+				update.Cities = append(update.Cities, *city)
+			}
+		}
+		for _, site := range g.dungeonList {
+			if touched(site.X, site.Y) {
+				update.Dungeons = append(update.Dungeons, playerSite(site))
+			}
+		}
+		return update
+	}
+
+	m := g.cityTemplates[key]
+	if m == nil {
+		return update
+	}
+	for _, c := range cells {
+		if inCity(m.Grid, c[0], c[1]) {
+			update.Cells = append(update.Cells, RevealedCell{X: c[0], Y: c[1], Value: m.Grid[c[1]][c[0]]})
+		}
+	}
+	for _, b := range m.Buildings {
+		if rectTouches(b.X, b.Y, b.Width, b.Height, touched) {
+			update.Buildings = append(update.Buildings, g.withPlanSeed(key, b))
+		}
+	}
+	return update
+}
+
+// worldStateFor собирает мир для игрока: неоткрытые клетки прозрачные, города
+// и подземелья - только те, что он видел. Вызывается под g.mu.
+func (g *Game) worldStateFor(pid string) WorldState {
+	tiles := g.colorToRGBA(g.tiles)
+	cities := make([][]bool, len(g.cities))
+	for y := range tiles {
+		cities[y] = make([]bool, len(g.cities[y]))
+		for x := range tiles[y] {
+			if g.explored(pid, worldMapKey, x, y) {
+				cities[y][x] = g.cities[y][x]
+			} else {
+				tiles[y][x] = color.RGBA{}
+			}
+		}
+	}
+
+	state := WorldState{
+		Tiles:   tiles,
+		Cities:  cities,
+		Markers: g.playerMarkers(pid),
+	}
+	for _, city := range g.cityList {
+		if g.cityKnown(pid, city) {
+			state.CityList = append(state.CityList, *city)
+		}
+	}
+	for _, site := range g.dungeonList {
+		if g.explored(pid, worldMapKey, site.X, site.Y) {
+			state.Dungeons = append(state.Dungeons, playerSite(site))
+		}
+	}
+	return state
+}
+
+// editFor оставляет в правке только то, что игрок видит; false - игроку
+// отправлять нечего. Вызывается под g.mu.
+func (g *Game) editFor(pid string, e MapEdit) (MapEdit, bool) {
+	out := MapEdit{Map: e.Map}
+	for _, c := range e.Cells {
+		if g.explored(pid, e.Map, c.X, c.Y) {
+			out.Cells = append(out.Cells, c)
+		}
+	}
+	// Объект, ушедший в туман, для игрока удаляется, вышедший из тумана - появляется
+	if c := e.City; c != nil {
+		change := CityChange{}
+		if g.cityKnown(pid, c.Before) {
+			change.Before = c.Before
+		}
+		if g.cityKnown(pid, c.After) {
+			change.After = c.After
+		}
+		if change.Before != nil || change.After != nil {
+			out.City = &change
+		}
+	}
+	if b := e.Building; b != nil {
+		change := BuildingChange{}
+		if g.buildingKnown(pid, e.Map, b.Before) {
+			before := g.withPlanSeed(e.Map, *b.Before)
+			change.Before = &before
+		}
+		if g.buildingKnown(pid, e.Map, b.After) {
+			after := g.withPlanSeed(e.Map, *b.After)
+			change.After = &after
+		}
+		if change.Before != nil || change.After != nil {
+			out.Building = &change
+		}
+	}
+	return out, len(out.Cells) > 0 || out.City != nil || out.Building != nil
+}
+
+// setFog открывает или скрывает клетки для всех игроков; инструмент мастера
+func (g *Game) setFog(key string, cells [][2]int, reveal bool) {
+	g.mu.Lock()
+	msgs := make(map[string]NetMessage)
+	party := g.explorationFor(partyFogKey)
+	if reveal {
+		// Сначала смотрим, что нового увидит каждый игрок, потом открываем клетки всем
+		for pid := range g.clients {
+			var fresh [][2]int
+			for _, c := range cells {
+				if !g.explored(pid, key, c[0], c[1]) {
+					fresh = append(fresh, c)
+				}
+			}
+			if len(fresh) > 0 {
+				update := g.fogCells(key, fresh)
+				msgs[pid] = NetMessage{Kind: MsgFog, Fog: &update}
+			}
+		}
+		for _, c := range cells {
+			party.add(key, c[0], c[1])
+		}
+	} else {
+		hidden := make(map[string][][2]int)
+		for _, c := range cells {
+			party.remove(key, c[0], c[1])
+			for pid, e := range g.exploration {
+				if e.remove(key, c[0], c[1]) {
+					hidden[pid] = append(hidden[pid], c)
+				}
+			}
+		}
+		for pid := range g.clients {
+			if len(hidden[pid]) > 0 || len(hidden[partyFogKey]) > 0 {
+				update := FogUpdate{Map: key, Hidden: append(hidden[pid], hidden[partyFogKey]...)}
+				msgs[pid] = NetMessage{Kind: MsgFog, Fog: &update}
+			}
+		}
+	}
+	g.worldDirty = true
+	g.mu.Unlock()
+
+	g.sendTo(msgs)
+	if debugMode {
+		log.Printf("[DEBUG] Туман %s: клеток %d, открыть %v, игроков %d", key, len(cells), reveal, len(msgs))
+	}
+}
+
+// clearFog забывает исследованные клетки и кэш городов: мир сгенерирован заново
+func (g *Game) clearFog() {
+	g.mu.Lock()
+	g.exploration = make(map[string]Exploration)
+	g.cityTemplates = make(map[string]*CityMap)
+	g.worldDirty = true
+	g.mu.Unlock()
+}
+
+// explorationSave переводит исследованные клетки в вид для сохранения. Вызывается под g.mu.
+func (g *Game) explorationSave() map[string]map[string][][2]int {
+	save := make(map[string]map[string][][2]int, len(g.exploration))
+	for pid, e := range g.exploration {
+		maps := make(map[string][][2]int, len(e))
+		for key, set := range e {
+			cells := make([][2]int, 0, len(set))
+			for c := range set {
+				cells = append(cells, c)
+			}
+			sortCells(cells)
+			maps[key] = cells
+		}
+		save[pid] = maps
+	}
+	return save
+}
+
+func loadExploration(save map[string]map[string][][2]int) map[string]Exploration {
+	exploration := make(map[string]Exploration, len(save))
+	for pid, maps := range save {
+		e := make(Exploration, len(maps))
+		for key, cells := range maps {
+			for _, c := range cells {
+				e.add(key, c[0], c[1])
+			}
+		}
+		exploration[pid] = e
+	}
+	return exploration
+}
+
+// worldRevealed сообщает, видна ли клетка мира. Неоткрытые клетки клиент
+// получает прозрачными; у мастера открыто всё.
+func (g *Game) worldRevealed(x, y int) bool {
+	if !g.inWorld(x, y) {
+		return false
+	}
+	_, _, _, a := g.tiles[y][x].RGBA()
+	return a != 0
+}
+
+func (m *CityMap) revealed(x, y int) bool {
+	return inCity(m.Grid, x, y) && m.Grid[y][x] != TileUnknown
+}
+
+// applyFog применяет к клиенту открытые и скрытые мастером клетки
+func (g *Game) applyFog(f FogUpdate) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i := range f.Markers {
+		g.markers[f.Markers[i].ID] = &f.Markers[i]
+	}
+	if len(f.Hidden) > 0 {
+		hidden := make(map[[2]int]bool, len(f.Hidden))
+		for _, c := range f.Hidden {
+			hidden[c] = true
+		}
+		for id, m := range g.markers {
+			if m.Map == f.Map && hidden[[2]int{m.X, m.Y}] {
+				delete(g.markers, id)
+			}
+		}
+	}
+
+	if f.Map == worldMapKey {
+		for _, c := range f.Cells {
+			if g.inWorld(c.X, c.Y) {
+				g.setWorldBiome(c.X, c.Y, Biome(c.Value))
+			}
+		}
+		for _, c := range f.Hidden {
+			if g.inWorld(c[0], c[1]) {
+				g.tiles[c[1]][c[0]] = color.RGBA{}
+			}
+		}
+		for i := range f.Cities {
+			city := f.Cities[i]
+			if known := g.findCityByID(city.ID); known != nil {
+				*known = city
+			} else {
+				g.cityList = append(g.cityList, &city)
+			}
+		}
+		for i := range f.Dungeons {
+			if g.findDungeonAt(f.Dungeons[i].X, f.Dungeons[i].Y) == nil {
+				g.dungeonList = append(g.dungeonList, &f.Dungeons[i])
+			}
+		}
+		if len(f.Hidden) > 0 {
+			g.forgetHiddenSites()
+		}
+		g.rebuildCityCells()
+		return
+	}
+
+	m := g.cityMap
+	if m == nil || cityMapKey(m.City) != f.Map {
+		return // Карту другого города клиент получит заново при входе в него
+	}
+	for _, c := range f.Cells {
+		if inCity(m.Grid, c.X, c.Y) {
+			m.Grid[c.Y][c.X] = c.Value
+			m.Tiles[c.Y][c.X] = g.getEnhancedTileColor(m.Grid, c.X, c.Y)
+		}
+	}
+	for _, c := range f.Hidden {
+		if inCity(m.Grid, c[0], c[1]) {
+			m.Grid[c[1]][c[0]] = TileUnknown
+			m.Tiles[c[1]][c[0]] = fogColor
+		}
+	}
+	for _, b := range f.Buildings {
+		if known := m.building(b.ID); known != nil {
+			*known = b
+		} else {
+			m.Buildings = append(m.Buildings, b)
+		}
+	}
+	if len(f.Hidden) > 0 {
+		buildings := m.Buildings[:0]
+		for _, b := range m.Buildings {
+			if rectTouches(b.X, b.Y, b.Width, b.Height, m.revealed) {
+				buildings = append(buildings, b)
+			}
+		}
+		m.Buildings = buildings
+		if m.building(m.Selected) == nil {
+			m.Selected = -1
+		}
+	}
+}
+
+// forgetHiddenSites убирает у клиента города и подземелья, целиком ушедшие в туман. Вызывается под g.mu.
+func (g *Game) forgetHiddenSites() {
+	cities := g.cityList[:0]
+	for _, city := range g.cityList {
+		if x, y, w, h := cityRect(city); rectTouches(x, y, w, h, g.worldRevealed) {
+			cities = append(cities, city)
+		}
+	}
+	g.cityList = cities
+
+	sites := g.dungeonList[:0]
+	for _, site := range g.dungeonList {
+		if g.worldRevealed(site.X, site.Y) {
+			sites = append(sites, site)
+		}
+	}
+	g.dungeonList = sites
+}
+
+// drawFogOverlay затемняет для мастера клетки, которых не видел ни один игрок
+func (g *Game) drawFogOverlay(screen *ebiten.Image, mapKey string, size float64,
+	toScreen func(x, y int) (float64, float64)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	w, h := g.mapBounds(mapKey)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := toScreen(x, y)
+			if sx+size < 0 || sy+size < 0 || sx > screenWidth || sy > screenHeight {
+				continue
+			}
+			seen := false
+			for _, e := range g.exploration {
+				if e.has(mapKey, x, y) {
+					seen = true
+					break
+				}
+			}
+			if !seen {
+				ebitenutil.DrawRect(screen, sx, sy, size, size, fogOverlayColor)
+			}
+		}
+	}
+}
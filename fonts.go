@@ -9,6 +9,9 @@ import (
 	"golang.org/x/image/font/opentype"
 )
 
+// fontPath - шрифт интерфейса; им же набираются листы в PDF
+const fontPath = "assets/NotoSans-Regular.ttf"
+
 func loadTrueTypeFont(path string, size float64) font.Face {
 	fontData, err := os.ReadFile(path)
 	if err != nil {
@@ -34,3 +37,47 @@ func loadTrueTypeFont(path string, size float64) font.Face {
 
 	return face
 }
+
+// fontSet - начертания для текста с разметкой. Файлов жирного и курсива
+// может не быть рядом с игрой, тогда вместо них используется обычный шрифт.
+type fontSet struct {
+	regular, bold, italic, boldItalic font.Face
+	headings                          [3]font.Face // Заголовки 1, 2 и остальных уровней
+}
+
+func loadFontSet(regular font.Face, size float64) fontSet {
+	bold := loadFontVariant("assets/NotoSans-Bold.ttf", size, regular)
+	fs := fontSet{
+		regular:    regular,
+		bold:       bold,
+		italic:     loadFontVariant("assets/NotoSans-Italic.ttf", size, regular),
+		boldItalic: loadFontVariant("assets/NotoSans-BoldItalic.ttf", size, bold),
+	}
+	for i, scale := range []float64{1.5, 1.3, 1.1} {
+		fallback := loadFontVariant(fontPath, size*scale, regular)
+		fs.headings[i] = loadFontVariant("assets/NotoSans-Bold.ttf", size*scale, fallback)
+	}
+	return fs
+}
+
+// loadFontVariant загружает шрифт или возвращает fallback, если файла нет
+func loadFontVariant(path string, size float64, fallback font.Face) font.Face {
+	fontData, err := os.ReadFile(path)
+	if err != nil {
+		if debugMode {
+			log.Printf("[DEBUG] Нет шрифта %s, используется обычный", path)
+		}
+		return fallback
+	}
+	tt, err := opentype.Parse(fontData)
+	if err != nil {
+		log.Printf("[ERROR] Ошибка парсинга шрифта %s: %v", path, err)
+		return fallback
+	}
+	face, err := opentype.NewFace(tt, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingVertical})
+	if err != nil {
+		log.Printf("[ERROR] Ошибка создания шрифта %s: %v", path, err)
+		return fallback
+	}
+	return face
+}
@@ -0,0 +1,290 @@
+// Package dice разбирает и бросает кости в привычной нотации настольных игр:
+// 4d6kh3, 2d20kl1, 1d8+3, взрывающиеся d6!, перебросы r1, подсчёт успехов
+// 10d10>=8f1 и арифметика со скобками.
+//
+// Сравнения строгие, как в математике: 6d6>4 считает успехом 5 и 6,
+// 6d6>=4 - 4, 5 и 6. Число без знака в сравнении означает равенство: r1 = r=1.
+package dice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaxDice      = 1000 // Больше костей за один бросок не бросаем
+	MaxSides     = 1000
+	MaxRerolls   = 100 // Предел перебросов и взрывов одной кости
+	MaxExpansion = 10000
+)
+
+// Node - узел разобранного выражения
+type Node interface {
+	String() string
+	eval(r *Roller, res *Result) (int, error)
+}
+
+// Number - целое число в выражении
+type Number struct {
+	Value int
+}
+
+func (n *Number) String() string { return strconv.Itoa(n.Value) }
+
+// BinaryOp - сложение, вычитание, умножение или деление (с округлением вниз)
+type BinaryOp struct {
+	Op          byte // '+', '-', '*' или '/'
+	Left, Right Node
+}
+
+// String берёт части в скобки там, где без них поменялся бы порядок действий
+func (b *BinaryOp) String() string {
+	left, right := b.Left.String(), b.Right.String()
+	if precedence(b.Left) < precedence(b) {
+		left = "(" + left + ")"
+	}
+	if p := precedence(b.Right); p < precedence(b) || p == precedence(b) && (b.Op == '-' || b.Op == '/') {
+		right = "(" + right + ")"
+	}
+	return left + string(b.Op) + right
+}
+
+func precedence(n Node) int {
+	b, ok := n.(*BinaryOp)
+	switch {
+	case !ok:
+		return 3
+	case b.Op == '+' || b.Op == '-':
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Negate - унарный минус
+type Negate struct {
+	X Node
+}
+
+func (n *Negate) String() string {
+	if _, ok := n.X.(*BinaryOp); ok {
+		return "-(" + n.X.String() + ")"
+	}
+	return "-" + n.X.String()
+}
+
+// CompareOp - знак сравнения в модификаторах
+type CompareOp int
+
+const (
+	Equal CompareOp = iota
+	Greater
+	GreaterEqual
+	Less
+	LessEqual
+)
+
+var compareSigns = map[CompareOp]string{
+	Equal:        "=",
+	Greater:      ">",
+	GreaterEqual: ">=",
+	Less:         "<",
+	LessEqual:    "<=",
+}
+
+// Compare - условие на значение кости
+type Compare struct {
+	Op    CompareOp
+	Value int
+}
+
+// Match сообщает, выполняется ли условие для значения кости
+func (c Compare) Match(v int) bool {
+	switch c.Op {
+	case Greater:
+		return v > c.Value
+	case GreaterEqual:
+		return v >= c.Value
+	case Less:
+		return v < c.Value
+	case LessEqual:
+		return v <= c.Value
+	default:
+		return v == c.Value
+	}
+}
+
+func (c Compare) String() string {
+	return compareSigns[c.Op] + strconv.Itoa(c.Value)
+}
+
+// Selection - какие кости оставить: kh3 - три старших, dl1 - без младшей
+type Selection struct {
+	Drop    bool // Убрать N костей вместо того, чтобы оставить N
+	Highest bool
+	N       int
+}
+
+func (s Selection) String() string {
+	op := "k"
+	if s.Drop {
+		op = "d"
+	}
+	if s.Highest {
+		op += "h"
+	} else {
+		op += "l"
+	}
+	return op + strconv.Itoa(s.N)
+}
+
+// Dice - бросок группы одинаковых костей с модификаторами
+type Dice struct {
+	Count   int
+	Sides   int  // 100 для d%; у костей Fudge - 3 грани
+	Fudge   bool // dF: грани -1, 0, +1
+	Explode *Compare
+	Reroll  *Compare
+	Once    bool // ro: перебросить не больше одного раза
+	Keep    *Selection
+	Success *Compare // Считать успехи вместо суммы
+	Failure *Compare // Вычитать провалы из числа успехов
+}
+
+func (d *Dice) String() string {
+	var b strings.Builder
+	if d.Count != 1 {
+		b.WriteString(strconv.Itoa(d.Count))
+	}
+	b.WriteByte('d')
+	if d.Fudge {
+		b.WriteByte('F')
+	} else {
+		b.WriteString(strconv.Itoa(d.Sides))
+	}
+	if d.Explode != nil {
+		b.WriteByte('!')
+		if *d.Explode != (Compare{Op: Equal, Value: d.maxFace()}) {
+			b.WriteString(d.Explode.String())
+		}
+	}
+	if d.Reroll != nil {
+		b.WriteByte('r')
+		if d.Once {
+			b.WriteByte('o')
+		}
+		b.WriteString(compareShort(*d.Reroll))
+	}
+	if d.Keep != nil {
+		b.WriteString(d.Keep.String())
+	}
+	if d.Success != nil {
+		b.WriteString(d.Success.String())
+	}
+	if d.Failure != nil {
+		b.WriteByte('f')
+		b.WriteString(compareShort(*d.Failure))
+	}
+	return b.String()
+}
+
+// compareShort пишет равенство без знака, как его обычно и набирают: r1, f1
+func compareShort(c Compare) string {
+	if c.Op == Equal {
+		return strconv.Itoa(c.Value)
+	}
+	return c.String()
+}
+
+func (d *Dice) minFace() int {
+	if d.Fudge {
+		return -1
+	}
+	return 1
+}
+
+func (d *Dice) maxFace() int {
+	if d.Fudge {
+		return 1
+	}
+	return d.Sides
+}
+
+// matchesAll сообщает, что условие выполняется на любой грани: такой взрыв
+// или переброс никогда бы не закончился
+func (d *Dice) matchesAll(c Compare) bool {
+	for v := d.minFace(); v <= d.maxFace(); v++ {
+		if !c.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Die - одна выброшенная кость
+type Die struct {
+	Value    int
+	Dropped  bool // Не вошла в сумму: отброшена kh/dl или заменена перебросом
+	Rerolled bool // Заменена следующей костью
+	Exploded bool // Выпал взрыв, следом добавлена ещё кость
+	Success  bool
+	Failure  bool
+}
+
+func (d Die) String() string {
+	s := strconv.Itoa(d.Value)
+	switch {
+	case d.Rerolled:
+		s += "r"
+	case d.Dropped:
+		s = "~" + s + "~"
+	}
+	if d.Exploded {
+		s += "!"
+	}
+	if d.Success {
+		s += "*"
+	}
+	if d.Failure {
+		s += "f"
+	}
+	return s
+}
+
+// Group - результат одной группы костей выражения
+type Group struct {
+	Notation  string
+	Dice      []Die
+	Value     int  // Сумма оставленных костей или число успехов
+	Successes bool // Value - число успехов за вычетом провалов
+}
+
+func (g Group) String() string {
+	faces := make([]string, len(g.Dice))
+	for i, d := range g.Dice {
+		faces[i] = d.String()
+	}
+	s := fmt.Sprintf("%s [%s] = %d", g.Notation, strings.Join(faces, ", "), g.Value)
+	if g.Successes {
+		s += " усп."
+	}
+	return s
+}
+
+// Result - итог броска выражения с разбивкой по костям
+type Result struct {
+	Expr   Node
+	Total  int
+	Groups []Group // В порядке появления в выражении
+}
+
+// String возвращает разбивку вида: 4d6kh3 [6, 4, 3, ~1~] = 13; 4d6kh3+2 = 15
+func (r *Result) String() string {
+	parts := make([]string, 0, len(r.Groups)+1)
+	for _, g := range r.Groups {
+		parts = append(parts, g.String())
+	}
+	parts = append(parts, fmt.Sprintf("%s = %d", r.Expr, r.Total))
+	return strings.Join(parts, "; ")
+}
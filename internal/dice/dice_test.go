@@ -0,0 +1,419 @@
+package dice
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// constSource всегда возвращает одно и то же число: у кости d2 выпадает 2
+type constSource int64
+
+func (s constSource) Int63() int64 { return int64(s) }
+func (constSource) Seed(int64)     {}
+
+func roll(t *testing.T, r *Roller, notation string) *Result {
+	t.Helper()
+	res, err := r.Roll(notation)
+	if err != nil {
+		t.Fatalf("%s: %v", notation, err)
+	}
+	return res
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"   ",
+		"d",
+		"4d",
+		"1d0",
+		"0d6",
+		"d1001",
+		"1001d6",
+		"(1d6+2",
+		"1d6+2)",
+		"((2)",
+		"1d6 x",
+		"2d6+",
+		"*3",
+		"1d6f1", // Провалы без успехов
+		"1d6!!",
+		"1d6rr1",
+		"1d6kh1kl1",
+		"1d1!", // Взрыв на любой грани
+		"1d6r<7",
+		"99999999999",
+	} {
+		_, err := Parse(s)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("Parse(%q) = %v, ожидалась SyntaxError", s, err)
+		}
+	}
+}
+
+func TestParseString(t *testing.T) {
+	for in, want := range map[string]string{
+		"4d6kh3":       "4d6kh3",
+		"2D20KL1":      "2d20kl1",
+		"4d6k3":        "4d6kh3",
+		"4d6d1":        "4d6dl1",
+		"d%":           "d100",
+		"4dF":          "4dF",
+		"3d6!":         "3d6!",
+		"3d6!>=5":      "3d6!>=5",
+		"2d6ro1":       "2d6ro1",
+		"10d10>=8f1":   "10d10>=8f1",
+		"2 * (3 + 4)":  "2*(3+4)",
+		"10-(2-3)":     "10-(2-3)",
+		"1d8+3 - -2":   "d8+3--2",
+		"(1d4+1)/2+d6": "(d4+1)/2+d6",
+	} {
+		n, err := Parse(in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", in, err)
+			continue
+		}
+		if got := n.String(); got != want {
+			t.Errorf("Parse(%q).String() = %q, ожидалось %q", in, got, want)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	r := New(1)
+	for in, want := range map[string]int{
+		"2+3*4":     14,
+		"(2+3)*4":   20,
+		"10-2-3":    5,
+		"2*-3":      -6,
+		"-(2+3)":    -5,
+		"7/2":       3,
+		"-7/2":      -4,
+		"7/-2":      -4,
+		"-3/2":      -2,
+		"-4/2":      -2,
+		"20/4/2":    2,
+		"1+2*3-4/2": 5,
+	} {
+		if got := roll(t, r, in).Total; got != want {
+			t.Errorf("%s = %d, ожидалось %d", in, got, want)
+		}
+	}
+	if _, err := r.Roll("1/(2-2)"); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("1/(2-2): %v, ожидалось деление на ноль", err)
+	}
+}
+
+// kept возвращает значения костей, вошедших в сумму
+func kept(g Group) []int {
+	var values []int
+	for _, d := range g.Dice {
+		if !d.Dropped {
+			values = append(values, d.Value)
+		}
+	}
+	return values
+}
+
+func sum(values []int) int {
+	s := 0
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
+
+func TestKeepDrop(t *testing.T) {
+	r := New(2)
+	for i := 0; i < 500; i++ {
+		for _, c := range []struct {
+			notation string
+			keep     int
+			highest  bool // Оставлены старшие кости
+		}{
+			{"4d6kh3", 3, true},
+			{"4d6dl1", 3, true},
+			{"2d20kl1", 1, false},
+			{"4d6dh1", 3, false},
+			{"3d6kh5", 3, true}, // Оставить больше, чем выброшено
+		} {
+			res := roll(t, r, c.notation)
+			g := res.Groups[0]
+			values := kept(g)
+			if len(values) != c.keep {
+				t.Fatalf("%s: оставлено %d костей, ожидалось %d: %s", c.notation, len(values), c.keep, res)
+			}
+			if res.Total != sum(values) {
+				t.Fatalf("%s: сумма %d не равна сумме оставленных костей: %s", c.notation, res.Total, res)
+			}
+			for _, d := range g.Dice {
+				if !d.Dropped {
+					continue
+				}
+				for _, v := range values {
+					if c.highest && d.Value > v || !c.highest && d.Value < v {
+						t.Fatalf("%s: отброшена кость %d при оставленной %d: %s", c.notation, d.Value, v, res)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestExplode(t *testing.T) {
+	r := New(3)
+	longest := 0
+	for i := 0; i < 2000; i++ {
+		res := roll(t, r, "5d6!")
+		dice := res.Groups[0].Dice
+		if len(dice) < 5 {
+			t.Fatalf("5d6!: костей %d: %s", len(dice), res)
+		}
+		longest = max(longest, len(dice))
+		last := 0
+		for _, d := range dice {
+			if d.Exploded != (d.Value == 6) {
+				t.Fatalf("5d6!: взрыв отмечен неверно: %s", res)
+			}
+			if !d.Exploded {
+				last++
+			}
+		}
+		if last != 5 {
+			t.Fatalf("5d6!: цепочек взрывов %d, ожидалось 5: %s", last, res)
+		}
+		if res.Total != sum(kept(res.Groups[0])) {
+			t.Fatalf("5d6!: сумма неверна: %s", res)
+		}
+	}
+	if longest == 5 {
+		t.Error("5d6!: за 2000 бросков ни одного взрыва")
+	}
+
+	res := roll(t, r, "20d6!>=5")
+	for _, d := range res.Groups[0].Dice {
+		if d.Exploded != (d.Value >= 5) {
+			t.Fatalf("20d6!>=5: взрыв отмечен неверно: %s", res)
+		}
+	}
+}
+
+func TestExplodeCap(t *testing.T) {
+	// На d2 всегда выпадает 2: взрыв не кончается, пока его не оборвёт предел
+	r := NewWithSource(constSource(math.MaxInt64))
+	if _, err := r.Roll("1d2!"); err == nil || !strings.Contains(err.Error(), "взрывов") {
+		t.Errorf("1d2!: %v, ожидалась ошибка о пределе взрывов", err)
+	}
+	if _, err := r.Roll("1d2r2"); err == nil || !strings.Contains(err.Error(), "перебросов") {
+		t.Errorf("1d2r2: %v, ожидалась ошибка о пределе перебросов", err)
+	}
+	// Однократный переброс заканчивается всегда
+	res := roll(t, r, "1d2ro2")
+	if res.Total != 2 || len(res.Groups[0].Dice) != 2 {
+		t.Errorf("1d2ro2: %s", res)
+	}
+}
+
+func TestReroll(t *testing.T) {
+	r := New(4)
+	for i := 0; i < 1000; i++ {
+		res := roll(t, r, "4d6r<3")
+		g := res.Groups[0]
+		values := kept(g)
+		if len(values) != 4 {
+			t.Fatalf("4d6r<3: оставлено %d костей: %s", len(values), res)
+		}
+		for _, d := range g.Dice {
+			if d.Rerolled != (d.Value < 3) || d.Rerolled != d.Dropped {
+				t.Fatalf("4d6r<3: переброс отмечен неверно: %s", res)
+			}
+		}
+
+		res = roll(t, r, "4d6ro1")
+		g = res.Groups[0]
+		for j, d := range g.Dice {
+			// После однократного переброса следующая кость остаётся, даже если это 1
+			if d.Rerolled && (d.Value != 1 || j+1 >= len(g.Dice) || g.Dice[j+1].Rerolled) {
+				t.Fatalf("4d6ro1: переброс отмечен неверно: %s", res)
+			}
+		}
+		if len(kept(g)) != 4 {
+			t.Fatalf("4d6ro1: оставлено %d костей: %s", len(kept(g)), res)
+		}
+	}
+}
+
+func TestSuccesses(t *testing.T) {
+	r := New(5)
+	for i := 0; i < 500; i++ {
+		res := roll(t, r, "10d10>=8f1")
+		g := res.Groups[0]
+		if !g.Successes {
+			t.Fatal("10d10>=8f1: группа не считает успехи")
+		}
+		want := 0
+		for _, d := range g.Dice {
+			switch {
+			case d.Value >= 8:
+				want++
+				if !d.Success || d.Failure {
+					t.Fatalf("10d10>=8f1: успех не отмечен: %s", res)
+				}
+			case d.Value == 1:
+				want--
+				if !d.Failure || d.Success {
+					t.Fatalf("10d10>=8f1: провал не отмечен: %s", res)
+				}
+			case d.Success || d.Failure:
+				t.Fatalf("10d10>=8f1: лишняя отметка: %s", res)
+			}
+		}
+		if res.Total != want {
+			t.Fatalf("10d10>=8f1 = %d, ожидалось %d: %s", res.Total, want, res)
+		}
+	}
+
+	// Строгие сравнения и равенство
+	for notation, match := range map[string]func(int) bool{
+		"20d6>4":  func(v int) bool { return v > 4 },
+		"20d6<3":  func(v int) bool { return v < 3 },
+		"20d6<=3": func(v int) bool { return v <= 3 },
+		"20d6=6":  func(v int) bool { return v == 6 },
+	} {
+		res := roll(t, r, notation)
+		want := 0
+		for _, d := range res.Groups[0].Dice {
+			if match(d.Value) {
+				want++
+			}
+		}
+		if res.Total != want {
+			t.Errorf("%s = %d, ожидалось %d: %s", notation, res.Total, want, res)
+		}
+	}
+}
+
+func TestFudgeAndPercentile(t *testing.T) {
+	r := New(6)
+	seen := map[int]bool{}
+	for i := 0; i < 1000; i++ {
+		res := roll(t, r, "4dF")
+		if res.Total < -4 || res.Total > 4 {
+			t.Fatalf("4dF = %d", res.Total)
+		}
+		for _, d := range res.Groups[0].Dice {
+			if d.Value < -1 || d.Value > 1 {
+				t.Fatalf("4dF: грань %d", d.Value)
+			}
+			seen[d.Value] = true
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("4dF: выпали не все грани: %v", seen)
+	}
+
+	low, high := 100, 1
+	for i := 0; i < 5000; i++ {
+		v := roll(t, r, "d%").Total
+		if v < 1 || v > 100 {
+			t.Fatalf("d%% = %d", v)
+		}
+		low, high = min(low, v), max(high, v)
+	}
+	if low != 1 || high != 100 {
+		t.Errorf("d%%: за 5000 бросков от %d до %d", low, high)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	a, b := New(42), New(42)
+	for i := 0; i < 100; i++ {
+		x, y := roll(t, a, "4d6kh3+1d20!"), roll(t, b, "4d6kh3+1d20!")
+		if x.String() != y.String() {
+			t.Fatalf("одинаковые сиды дали %s и %s", x, y)
+		}
+	}
+}
+
+// distribution - точное распределение суммы count костей d sides, из
+// которых в сумму идут keep старших
+func distribution(count, sides, keep int) map[int]float64 {
+	dist := map[int]float64{}
+	faces := make([]int, count)
+	total := math.Pow(float64(sides), float64(count))
+	for {
+		sorted := append([]int(nil), faces...)
+		sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+		s := keep
+		for _, f := range sorted[:keep] {
+			s += f
+		}
+		dist[s] += 1 / total
+
+		i := 0
+		for ; i < count; i++ {
+			faces[i]++
+			if faces[i] < sides {
+				break
+			}
+			faces[i] = 0
+		}
+		if i == count {
+			return dist
+		}
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	const rolls = 10000
+	for _, c := range []struct {
+		notation           string
+		count, sides, keep int
+		critical           float64 // Хи-квадрат при p = 0.001
+	}{
+		{"1d20", 1, 20, 1, 43.82},
+		{"3d6", 3, 6, 3, 37.70},
+		{"4d6kh3", 4, 6, 3, 37.70},
+	} {
+		t.Run(c.notation, func(t *testing.T) {
+			want := distribution(c.count, c.sides, c.keep)
+			r := New(7)
+			counts := map[int]int{}
+			total := 0
+			for i := 0; i < rolls; i++ {
+				v := roll(t, r, c.notation).Total
+				if _, ok := want[v]; !ok {
+					t.Fatalf("%s = %d вне возможных значений", c.notation, v)
+				}
+				counts[v]++
+				total += v
+			}
+
+			mean, expectedMean, variance := float64(total)/rolls, 0.0, 0.0
+			for v, p := range want {
+				expectedMean += float64(v) * p
+			}
+			for v, p := range want {
+				variance += (float64(v) - expectedMean) * (float64(v) - expectedMean) * p
+			}
+			// Четыре стандартные ошибки среднего
+			if tolerance := 4 * math.Sqrt(variance/rolls); math.Abs(mean-expectedMean) > tolerance {
+				t.Errorf("среднее %.3f, ожидалось %.3f ± %.3f", mean, expectedMean, tolerance)
+			}
+
+			chi := 0.0
+			for v, p := range want {
+				expected := p * rolls
+				diff := float64(counts[v]) - expected
+				chi += diff * diff / expected
+			}
+			if chi > c.critical {
+				t.Errorf("хи-квадрат %.2f больше критического %.2f", chi, c.critical)
+			}
+		})
+	}
+}
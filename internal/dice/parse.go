@@ -0,0 +1,372 @@
+package dice
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SyntaxError - ошибка разбора с позицией в исходной строке (с нуля, в байтах)
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("позиция %d: %s", e.Pos, e.Msg)
+}
+
+// Parse разбирает выражение. Грамматика:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | dice | "(" expr ")"
+//	dice    = [number] "d" (number | "%" | "F") { modifier }
+//
+// Модификаторы: !, !>=N (взрыв), rN, r<N, roN (переброс), khN, klN, dhN, dlN,
+// kN = khN, dN = dlN (выбор костей), >N, >=N, <N, <=N, =N (успехи), fN (провалы).
+// Пробелы между частями выражения допускаются, внутри нотации костей - нет.
+func Parse(s string) (Node, error) {
+	p := &parser{src: s}
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("пустое выражение")
+	}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("лишний символ %q", p.peek())
+	}
+	return n, nil
+}
+
+type parser struct {
+	src   string
+	pos   int
+	count int // Костей во всём выражении, чтобы не дать заказать миллион бросков
+	depth int
+}
+
+const maxDepth = 64 // Вложенность скобок и унарных минусов
+
+func (p *parser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) peekLower() byte {
+	c := p.peek()
+	if c >= 'A' && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+	return c
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expr() (Node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryOp{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) term() (Node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryOp{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	p.skipSpace()
+	if p.peek() != '-' {
+		return p.primary()
+	}
+	p.pos++
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, p.errorf("слишком глубокая вложенность")
+	}
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return &Negate{X: x}, nil
+}
+
+func (p *parser) primary() (Node, error) {
+	p.skipSpace()
+	switch c := p.peekLower(); {
+	case c == '(':
+		p.pos++
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxDepth {
+			return nil, p.errorf("слишком глубокая вложенность")
+		}
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != ')' {
+			return nil, p.errorf("ожидалась )")
+		}
+		p.pos++
+		return n, nil
+	case c == 'd':
+		return p.dice(1)
+	case isDigit(c):
+		start := p.pos
+		n, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if p.peekLower() == 'd' {
+			if n == 0 {
+				p.pos = start
+				return nil, p.errorf("число костей должно быть больше нуля")
+			}
+			return p.dice(n)
+		}
+		return &Number{Value: n}, nil
+	case c == 0:
+		return nil, p.errorf("неожиданный конец выражения")
+	default:
+		return nil, p.errorf("неожиданный символ %q", p.peek())
+	}
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// number читает неотрицательное целое; слишком длинные числа - ошибка, а не переполнение
+func (p *parser) number() (int, error) {
+	start := p.pos
+	n := 0
+	for !p.eof() && isDigit(p.peek()) {
+		n = n*10 + int(p.peek()-'0')
+		p.pos++
+		if n > 1_000_000_000 {
+			p.pos = start
+			return 0, p.errorf("слишком большое число")
+		}
+	}
+	if p.pos == start {
+		return 0, p.errorf("ожидалось число")
+	}
+	return n, nil
+}
+
+func (p *parser) dice(count int) (Node, error) {
+	start := p.pos
+	p.pos++ // 'd'
+	d := &Dice{Count: count}
+	switch c := p.peekLower(); {
+	case c == '%':
+		p.pos++
+		d.Sides = 100
+	case c == 'f':
+		p.pos++
+		d.Fudge = true
+		d.Sides = 3
+	case isDigit(c):
+		sides, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		d.Sides = sides
+	default:
+		return nil, p.errorf("ожидалось число граней, %% или F")
+	}
+
+	if d.Sides < 1 || d.Sides > MaxSides {
+		p.pos = start
+		return nil, p.errorf("у кости должно быть от 1 до %d граней", MaxSides)
+	}
+	if d.Count > MaxDice {
+		p.pos = start
+		return nil, p.errorf("больше %d костей за бросок", MaxDice)
+	}
+	p.count += d.Count
+	if p.count > MaxDice {
+		p.pos = start
+		return nil, p.errorf("больше %d костей в выражении", MaxDice)
+	}
+
+	if err := p.modifiers(d); err != nil {
+		return nil, err
+	}
+	if d.Failure != nil && d.Success == nil {
+		return nil, p.errorf("провалы считаются только вместе с успехами")
+	}
+	return d, nil
+}
+
+func (p *parser) modifiers(d *Dice) error {
+	for !p.eof() {
+		at := p.pos
+		rest := strings.ToLower(p.src[p.pos:])
+		var err error
+		switch {
+		case rest[0] == '!':
+			if d.Explode != nil {
+				return p.errorf("повторный взрыв")
+			}
+			p.pos++
+			c := Compare{Op: Equal, Value: d.maxFace()}
+			if isCompareStart(p.peek()) {
+				if c, err = p.compare(); err != nil {
+					return err
+				}
+			}
+			if d.matchesAll(c) {
+				p.pos = at
+				return p.errorf("взрыв на любой грани никогда не закончится")
+			}
+			d.Explode = &c
+		case rest[0] == 'r':
+			if d.Reroll != nil {
+				return p.errorf("повторный переброс")
+			}
+			p.pos++
+			if p.peekLower() == 'o' {
+				p.pos++
+				d.Once = true
+			}
+			c, err := p.compare()
+			if err != nil {
+				return err
+			}
+			if d.matchesAll(c) && !d.Once {
+				p.pos = at
+				return p.errorf("переброс на любой грани никогда не закончится")
+			}
+			d.Reroll = &c
+		case strings.HasPrefix(rest, "kh"), strings.HasPrefix(rest, "kl"),
+			strings.HasPrefix(rest, "dh"), strings.HasPrefix(rest, "dl"),
+			rest[0] == 'k', rest[0] == 'd' && len(rest) > 1 && isDigit(rest[1]):
+			if d.Keep != nil {
+				return p.errorf("повторный выбор костей")
+			}
+			s := Selection{Drop: rest[0] == 'd', Highest: rest[0] == 'k'}
+			p.pos++
+			switch p.peekLower() {
+			case 'h':
+				s.Highest = true
+				p.pos++
+			case 'l':
+				s.Highest = false
+				p.pos++
+			}
+			s.N = 1
+			if isDigit(p.peek()) {
+				if s.N, err = p.number(); err != nil {
+					return err
+				}
+			}
+			d.Keep = &s
+		case rest[0] == '>' || rest[0] == '<' || rest[0] == '=':
+			if d.Success != nil {
+				return p.errorf("повторное условие успеха")
+			}
+			c, err := p.compare()
+			if err != nil {
+				return err
+			}
+			d.Success = &c
+		case rest[0] == 'f':
+			if d.Failure != nil {
+				return p.errorf("повторное условие провала")
+			}
+			p.pos++
+			c, err := p.compare()
+			if err != nil {
+				return err
+			}
+			d.Failure = &c
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// isCompareStart сообщает, начинается ли с символа условие после "!". Минус
+// без знака сравнения - это вычитание: d6!-1, а не взрыв на -1.
+func isCompareStart(c byte) bool {
+	return c == '>' || c == '<' || c == '=' || isDigit(c)
+}
+
+// compare читает условие: знак сравнения (необязательный) и число, возможно отрицательное
+func (p *parser) compare() (Compare, error) {
+	c := Compare{Op: Equal}
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], ">="):
+		c.Op, p.pos = GreaterEqual, p.pos+2
+	case strings.HasPrefix(p.src[p.pos:], "<="):
+		c.Op, p.pos = LessEqual, p.pos+2
+	case p.peek() == '>':
+		c.Op, p.pos = Greater, p.pos+1
+	case p.peek() == '<':
+		c.Op, p.pos = Less, p.pos+1
+	case p.peek() == '=':
+		p.pos++
+	}
+	negative := p.peek() == '-'
+	if negative {
+		p.pos++
+	}
+	v, err := p.number()
+	if err != nil {
+		return c, err
+	}
+	if negative {
+		v = -v
+	}
+	c.Value = v
+	return c, nil
+}
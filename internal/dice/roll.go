@@ -0,0 +1,184 @@
+package dice
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Roller бросает кости. С одним и тем же сидом броски повторяются, что
+// нужно для воспроизводимых проверок и повторов на клиентах.
+type Roller struct {
+	rng *rand.Rand
+}
+
+// New возвращает бросатель с заданным сидом
+func New(seed int64) *Roller {
+	return &Roller{rng: rand.New(rand.NewSource(seed))}
+}
+
+// NewWithSource возвращает бросатель поверх готового источника случайности
+func NewWithSource(src rand.Source) *Roller {
+	return &Roller{rng: rand.New(src)}
+}
+
+// Roll разбирает нотацию и бросает кости
+func (r *Roller) Roll(notation string) (*Result, error) {
+	expr, err := Parse(notation)
+	if err != nil {
+		return nil, err
+	}
+	return r.Eval(expr)
+}
+
+// Eval бросает кости уже разобранного выражения
+func (r *Roller) Eval(expr Node) (*Result, error) {
+	res := &Result{Expr: expr}
+	total, err := expr.eval(r, res)
+	if err != nil {
+		return nil, err
+	}
+	res.Total = total
+	return res, nil
+}
+
+// ErrDivisionByZero возвращается при делении на ноль в выражении
+var ErrDivisionByZero = errors.New("деление на ноль")
+
+func (n *Number) eval(*Roller, *Result) (int, error) { return n.Value, nil }
+
+func (n *Negate) eval(r *Roller, res *Result) (int, error) {
+	v, err := n.X.eval(r, res)
+	return -v, err
+}
+
+func (b *BinaryOp) eval(r *Roller, res *Result) (int, error) {
+	left, err := b.Left.eval(r, res)
+	if err != nil {
+		return 0, err
+	}
+	right, err := b.Right.eval(r, res)
+	if err != nil {
+		return 0, err
+	}
+	switch b.Op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	case '/':
+		if right == 0 {
+			return 0, ErrDivisionByZero
+		}
+		q := left / right
+		if (left%right != 0) && ((left < 0) != (right < 0)) {
+			q-- // Округляем вниз, как принято в правилах: -3/2 = -2
+		}
+		return q, nil
+	default:
+		return 0, fmt.Errorf("неизвестная операция %q", b.Op)
+	}
+}
+
+func (d *Dice) face(r *Roller) int {
+	return d.minFace() + r.rng.Intn(d.maxFace()-d.minFace()+1)
+}
+
+func (d *Dice) eval(r *Roller, res *Result) (int, error) {
+	var dice []Die
+	for i := 0; i < d.Count; i++ {
+		chain, err := d.rollOne(r)
+		if err != nil {
+			return 0, err
+		}
+		dice = append(dice, chain...)
+		if len(dice) > MaxExpansion {
+			return 0, fmt.Errorf("%s: больше %d костей после взрывов", d, MaxExpansion)
+		}
+	}
+
+	if d.Keep != nil {
+		d.selectDice(dice)
+	}
+
+	g := Group{Notation: d.String(), Dice: dice, Successes: d.Success != nil}
+	for i := range g.Dice {
+		die := &g.Dice[i]
+		if die.Dropped {
+			continue
+		}
+		if !g.Successes {
+			g.Value += die.Value
+			continue
+		}
+		if d.Success.Match(die.Value) {
+			die.Success = true
+			g.Value++
+		} else if d.Failure != nil && d.Failure.Match(die.Value) {
+			die.Failure = true
+			g.Value--
+		}
+	}
+	res.Groups = append(res.Groups, g)
+	return g.Value, nil
+}
+
+// rollOne бросает одну кость со всеми перебросами и взрывами. Переброшенные
+// кости остаются в разбивке помеченными, взрыв добавляет кости следом.
+func (d *Dice) rollOne(r *Roller) ([]Die, error) {
+	var chain []Die
+	for explosions := 0; ; explosions++ {
+		die := Die{Value: d.face(r)}
+		for rerolls := 0; d.Reroll != nil && d.Reroll.Match(die.Value); rerolls++ {
+			if rerolls >= MaxRerolls {
+				return nil, fmt.Errorf("%s: больше %d перебросов одной кости", d, MaxRerolls)
+			}
+			die.Rerolled, die.Dropped = true, true
+			chain = append(chain, die)
+			die = Die{Value: d.face(r)}
+			if d.Once {
+				break
+			}
+		}
+		if d.Explode == nil || !d.Explode.Match(die.Value) {
+			return append(chain, die), nil
+		}
+		if explosions >= MaxRerolls {
+			return nil, fmt.Errorf("%s: больше %d взрывов одной кости", d, MaxRerolls)
+		}
+		die.Exploded = true
+		chain = append(chain, die)
+	}
+}
+
+// selectDice помечает отброшенными кости, не попавшие в kh/kl/dh/dl.
+// При равных значениях раньше выброшенная кость считается старше.
+func (d *Dice) selectDice(dice []Die) {
+	live := make([]int, 0, len(dice))
+	for i, die := range dice {
+		if !die.Dropped {
+			live = append(live, i)
+		}
+	}
+	sort.SliceStable(live, func(a, b int) bool { return dice[live[a]].Value > dice[live[b]].Value })
+
+	s := d.Keep
+	n := min(s.N, len(live))
+	var drop []int
+	switch {
+	case !s.Drop && s.Highest:
+		drop = live[n:]
+	case !s.Drop:
+		drop = live[:len(live)-n]
+	case s.Highest:
+		drop = live[:n]
+	default:
+		drop = live[len(live)-n:]
+	}
+	for _, i := range drop {
+		dice[i].Dropped = true
+	}
+}
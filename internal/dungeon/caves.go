@@ -0,0 +1,194 @@
+package dungeon
+
+import "math/rand"
+
+const (
+	caveFillChance  = 0.45
+	caveIterations  = 5
+	caveWallLimit   = 5  // Клетка становится стеной, если стен в окрестности 3x3 не меньше
+	minChamberCells = 4  // Открытых клеток в зале, чтобы считать его комнатой
+	caveChamberArea = 90 // Клеток пола пещеры на один зал
+)
+
+// generateCaves выращивает пещеры клеточным автоматом и делит их на залы
+func generateCaves(d *Dungeon, rng *rand.Rand) {
+	for y := 0; y < d.Height; y++ {
+		for x := 0; x < d.Width; x++ {
+			if rng.Float64() >= caveFillChance {
+				d.Tiles[y][x] = TileFloor
+			}
+		}
+	}
+	d.fillBorder()
+
+	for i := 0; i < caveIterations; i++ {
+		next := make([][]Tile, d.Height)
+		for y := range next {
+			next[y] = make([]Tile, d.Width)
+			for x := range next[y] {
+				if d.wallsAround(x, y) >= caveWallLimit {
+					next[y][x] = TileWall
+				} else {
+					next[y][x] = TileFloor
+				}
+			}
+		}
+		d.Tiles = next
+		d.fillBorder()
+	}
+
+	d.connectRegions(rng)
+	d.splitCaves(rng)
+}
+
+// splitCaves делит связную пещеру на залы: центры залов расставляются как можно
+// дальше друг от друга, а каждая клетка пола отходит ближайшему по пути центру
+func (d *Dungeon) splitCaves(rng *rand.Rand) {
+	var floor [][2]int
+	for y := 0; y < d.Height; y++ {
+		for x := 0; x < d.Width; x++ {
+			if d.Walkable(x, y) {
+				floor = append(floor, [2]int{x, y})
+			}
+		}
+	}
+	if len(floor) == 0 {
+		return
+	}
+
+	// Первый центр случайный, каждый следующий - самая дальняя по пути клетка
+	centers := [][2]int{floor[rng.Intn(len(floor))]}
+	nearest := d.distances(centers[0])
+	for len(centers) < max(1, len(floor)/caveChamberArea) {
+		far, farDist := floor[0], -1
+		for _, p := range floor {
+			if dist := nearest[p[1]][p[0]]; dist > farDist {
+				far, farDist = p, dist
+			}
+		}
+		if farDist <= 0 {
+			break
+		}
+		centers = append(centers, far)
+		next := d.distances(far)
+		for _, p := range floor {
+			if next[p[1]][p[0]] < nearest[p[1]][p[0]] {
+				nearest[p[1]][p[0]] = next[p[1]][p[0]]
+			}
+		}
+	}
+
+	// Одновременный обход в ширину от всех центров
+	d.RoomMap = newRoomMap(d.Width, d.Height)
+	queue := make([][2]int, 0, len(floor))
+	for i, c := range centers {
+		d.RoomMap[c[1]][c[0]] = i
+		queue = append(queue, c)
+	}
+	for i := 0; i < len(queue); i++ {
+		p := queue[i]
+		for _, off := range offsets {
+			x, y := p[0]+off[0], p[1]+off[1]
+			if d.Walkable(x, y) && d.RoomMap[y][x] == -1 {
+				d.RoomMap[y][x] = d.RoomMap[p[1]][p[0]]
+				queue = append(queue, [2]int{x, y})
+			}
+		}
+	}
+
+	d.Rooms = make([]Room, len(centers))
+	for i := range d.Rooms {
+		d.Rooms[i] = Room{ID: i, X: d.Width, Y: d.Height}
+	}
+	maxX := make([]int, len(centers))
+	maxY := make([]int, len(centers))
+	for _, p := range floor {
+		id := d.RoomMap[p[1]][p[0]]
+		r := &d.Rooms[id]
+		r.X, r.Y = min(r.X, p[0]), min(r.Y, p[1])
+		maxX[id], maxY[id] = max(maxX[id], p[0]), max(maxY[id], p[1])
+		r.Size++
+	}
+	for i := range d.Rooms {
+		d.Rooms[i].Width = maxX[i] - d.Rooms[i].X + 1
+		d.Rooms[i].Height = maxY[i] - d.Rooms[i].Y + 1
+	}
+}
+
+// wallsAround считает стены в окрестности 3x3, включая саму клетку;
+// клетки за краем карты считаются стенами
+func (d *Dungeon) wallsAround(x, y int) int {
+	count := 0
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if !d.Walkable(x+dx, y+dy) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// findChambers выделяет залы: связные группы открытых клеток (без стен в
+// окрестности 3x3) вместе с их каймой. Остальной пол считается проходами.
+func (d *Dungeon) findChambers() {
+	open := func(x, y int) bool {
+		return d.Walkable(x, y) && d.wallsAround(x, y) == 0
+	}
+
+	d.RoomMap = newRoomMap(d.Width, d.Height)
+	seen := make(map[[2]int]bool)
+	for y := 0; y < d.Height; y++ {
+		for x := 0; x < d.Width; x++ {
+			if seen[[2]int{x, y}] || !open(x, y) {
+				continue
+			}
+
+			seen[[2]int{x, y}] = true
+			core := [][2]int{{x, y}}
+			for i := 0; i < len(core); i++ {
+				p := core[i]
+				for _, off := range offsets {
+					n := [2]int{p[0] + off[0], p[1] + off[1]}
+					if !seen[n] && open(n[0], n[1]) {
+						seen[n] = true
+						core = append(core, n)
+					}
+				}
+			}
+			if len(core) < minChamberCells {
+				continue
+			}
+			d.addChamber(core)
+		}
+	}
+}
+
+// addChamber записывает зал из ядра открытых клеток и окружающего их пола
+func (d *Dungeon) addChamber(core [][2]int) {
+	id := len(d.Rooms)
+	minX, minY, maxX, maxY := d.Width, d.Height, -1, -1
+	size := 0
+	for _, p := range core {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				x, y := p[0]+dx, p[1]+dy
+				if !d.Walkable(x, y) || d.RoomMap[y][x] != -1 {
+					continue
+				}
+				d.RoomMap[y][x] = id
+				size++
+				minX, minY = min(minX, x), min(minY, y)
+				maxX, maxY = max(maxX, x), max(maxY, y)
+			}
+		}
+	}
+	d.Rooms = append(d.Rooms, Room{
+		ID:     id,
+		X:      minX,
+		Y:      minY,
+		Width:  maxX - minX + 1,
+		Height: maxY - minY + 1,
+		Size:   size,
+	})
+}
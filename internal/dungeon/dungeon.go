@@ -0,0 +1,424 @@
+package dungeon
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Tile - клетка подземелья
+type Tile int
+
+const (
+	TileWall Tile = iota
+	TileFloor
+	TileDoor
+	TileSecretDoor
+)
+
+// Style - способ генерации подземелья
+type Style int
+
+const (
+	StyleRooms Style = iota // Комнаты и коридоры
+	StyleCaves              // Пещеры на клеточном автомате
+	StyleWFC                // Overlapping WFC по образцу
+)
+
+var styleNames = map[Style]string{
+	StyleRooms: "Комнаты и коридоры",
+	StyleCaves: "Пещеры",
+	StyleWFC:   "Лабиринт",
+}
+
+func (s Style) String() string {
+	return styleNames[s]
+}
+
+// Tag - назначение комнаты
+type Tag string
+
+const (
+	TagNone     Tag = ""
+	TagEntrance Tag = "entrance"
+	TagBoss     Tag = "boss"
+	TagTreasure Tag = "treasure"
+	TagTrap     Tag = "trap"
+)
+
+// TagNames - русские названия назначений для отображения
+var TagNames = map[Tag]string{
+	TagNone:     "Комната",
+	TagEntrance: "Вход",
+	TagBoss:     "Логово босса",
+	TagTreasure: "Сокровищница",
+	TagTrap:     "Ловушка",
+}
+
+// Options - параметры генерации
+type Options struct {
+	Style            Style
+	Width            int
+	Height           int
+	Seed             int64
+	Rooms            int     // Желаемое число комнат для StyleRooms; 0 - по площади
+	SecretDoorChance float64 // Доля обычных дверей, ставших потайными
+}
+
+// Room - комната или пещерный зал
+type Room struct {
+	ID     int `json:"id"`
+	X      int `json:"x"` // Ограничивающий прямоугольник
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Size   int `json:"size"` // Число клеток пола
+	Tag    Tag `json:"tag,omitempty"`
+}
+
+// Door - дверь между комнатой и коридором
+type Door struct {
+	X      int  `json:"x"`
+	Y      int  `json:"y"`
+	Room   int  `json:"room"` // Комната, в которую ведёт дверь
+	Secret bool `json:"secret,omitempty"`
+}
+
+// Dungeon - результат генерации
+type Dungeon struct {
+	Style    Style    `json:"style"`
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Seed     int64    `json:"seed"`
+	Tiles    [][]Tile `json:"tiles"`   // [y][x]
+	RoomMap  [][]int  `json:"roomMap"` // Индекс комнаты в клетке или -1
+	Rooms    []Room   `json:"rooms"`
+	Doors    []Door   `json:"doors"`
+	Entrance [2]int   `json:"entrance"` // Клетка спуска в подземелье
+}
+
+const (
+	minWidth           = 20
+	minHeight          = 15
+	minRegionSize      = 12 // Меньшие изолированные полости засыпаются
+	treasurePerRooms   = 6  // Одна сокровищница на столько комнат
+	trapChance         = 0.25
+	secretTreasureDoor = 0.5 // Доля дверей сокровищниц, ставших потайными
+)
+
+// Generate строит подземелье; одинаковые Options дают одинаковый результат
+func Generate(opts Options) (*Dungeon, error) {
+	if opts.Width < minWidth || opts.Height < minHeight {
+		return nil, fmt.Errorf("подземелье %dx%d меньше минимального %dx%d",
+			opts.Width, opts.Height, minWidth, minHeight)
+	}
+
+	d := &Dungeon{
+		Style:  opts.Style,
+		Width:  opts.Width,
+		Height: opts.Height,
+		Seed:   opts.Seed,
+	}
+	d.Tiles = make([][]Tile, opts.Height)
+	for y := range d.Tiles {
+		d.Tiles[y] = make([]Tile, opts.Width)
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+
+	var err error
+	switch opts.Style {
+	case StyleRooms:
+		generateRooms(d, opts, rng)
+	case StyleCaves:
+		generateCaves(d, rng)
+	case StyleWFC:
+		err = generateWFC(d, rng)
+	default:
+		err = fmt.Errorf("неизвестный стиль подземелья %d", opts.Style)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(d.Rooms) == 0 {
+		return nil, fmt.Errorf("не удалось выделить ни одной комнаты")
+	}
+
+	d.tagRooms(rng)
+	d.hideDoors(opts.SecretDoorChance, rng)
+	return d, nil
+}
+
+func (d *Dungeon) inBounds(x, y int) bool {
+	return x >= 0 && y >= 0 && x < d.Width && y < d.Height
+}
+
+// Walkable сообщает, можно ли пройти через клетку (потайные двери проходимы)
+func (d *Dungeon) Walkable(x, y int) bool {
+	return d.inBounds(x, y) && d.Tiles[y][x] != TileWall
+}
+
+// RoomAt возвращает комнату в клетке (x, y) или nil
+func (d *Dungeon) RoomAt(x, y int) *Room {
+	if !d.inBounds(x, y) || d.RoomMap == nil || d.RoomMap[y][x] < 0 {
+		return nil
+	}
+	return &d.Rooms[d.RoomMap[y][x]]
+}
+
+var offsets = [4][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}
+
+// distances считает длину пути от клетки start до всех достижимых клеток
+func (d *Dungeon) distances(start [2]int) [][]int {
+	dist := make([][]int, d.Height)
+	for y := range dist {
+		dist[y] = make([]int, d.Width)
+		for x := range dist[y] {
+			dist[y][x] = -1
+		}
+	}
+	dist[start[1]][start[0]] = 0
+	queue := [][2]int{start}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, off := range offsets {
+			x, y := p[0]+off[0], p[1]+off[1]
+			if d.Walkable(x, y) && dist[y][x] == -1 {
+				dist[y][x] = dist[p[1]][p[0]] + 1
+				queue = append(queue, [2]int{x, y})
+			}
+		}
+	}
+	return dist
+}
+
+// roomCenter возвращает клетку пола комнаты, ближайшую к центру её прямоугольника
+func (d *Dungeon) roomCenter(id int) [2]int {
+	r := d.Rooms[id]
+	cx, cy := r.X+r.Width/2, r.Y+r.Height/2
+	best, bestDist := [2]int{cx, cy}, -1
+	for y := r.Y; y < r.Y+r.Height; y++ {
+		for x := r.X; x < r.X+r.Width; x++ {
+			if d.RoomMap[y][x] != id {
+				continue
+			}
+			dist := abs(x-cx) + abs(y-cy)
+			if bestDist == -1 || dist < bestDist {
+				best, bestDist = [2]int{x, y}, dist
+			}
+		}
+	}
+	return best
+}
+
+// tagRooms назначает комнаты: вход у края карты, логово босса дальше всего
+// от входа, сокровищницы в тупиках, остальные могут оказаться ловушками
+func (d *Dungeon) tagRooms(rng *rand.Rand) {
+	entrance := 0
+	bestEdge := -1
+	for i, r := range d.Rooms {
+		edge := min(r.X, r.Y, d.Width-r.X-r.Width, d.Height-r.Y-r.Height)
+		if bestEdge == -1 || edge < bestEdge {
+			entrance, bestEdge = i, edge
+		}
+	}
+	d.Rooms[entrance].Tag = TagEntrance
+	d.Entrance = d.roomCenter(entrance)
+
+	if len(d.Rooms) == 1 {
+		return
+	}
+
+	dist := d.distances(d.Entrance)
+	roomDist := make([]int, len(d.Rooms))
+	for i := range d.Rooms {
+		c := d.roomCenter(i)
+		roomDist[i] = dist[c[1]][c[0]]
+	}
+
+	boss := -1
+	for i := range d.Rooms {
+		if i != entrance && (boss == -1 || roomDist[i] > roomDist[boss]) {
+			boss = i
+		}
+	}
+	d.Rooms[boss].Tag = TagBoss
+
+	// Сокровищницы - комнаты с наименьшим числом дверей, дальние в приоритете
+	doors := make([]int, len(d.Rooms))
+	for _, door := range d.Doors {
+		doors[door.Room]++
+	}
+	var rest []int
+	for i := range d.Rooms {
+		if d.Rooms[i].Tag == TagNone {
+			rest = append(rest, i)
+		}
+	}
+	sort.SliceStable(rest, func(a, b int) bool {
+		if doors[rest[a]] != doors[rest[b]] {
+			return doors[rest[a]] < doors[rest[b]]
+		}
+		return roomDist[rest[a]] > roomDist[rest[b]]
+	})
+	treasures := max(1, len(d.Rooms)/treasurePerRooms)
+	for i := 0; i < treasures && i < len(rest); i++ {
+		d.Rooms[rest[i]].Tag = TagTreasure
+	}
+
+	for i := range d.Rooms {
+		if d.Rooms[i].Tag == TagNone && rng.Float64() < trapChance {
+			d.Rooms[i].Tag = TagTrap
+		}
+	}
+}
+
+// hideDoors превращает часть дверей в потайные; сокровищницы прячут чаще
+func (d *Dungeon) hideDoors(chance float64, rng *rand.Rand) {
+	for i := range d.Doors {
+		door := &d.Doors[i]
+		p := chance
+		switch d.Rooms[door.Room].Tag {
+		case TagTreasure:
+			p = max(p, secretTreasureDoor)
+		case TagEntrance:
+			p = 0 // Вход всегда на виду
+		}
+		if rng.Float64() < p {
+			door.Secret = true
+			d.Tiles[door.Y][door.X] = TileSecretDoor
+		}
+	}
+}
+
+// fillBorder закладывает стеной внешний край карты
+func (d *Dungeon) fillBorder() {
+	for y := 0; y < d.Height; y++ {
+		for x := 0; x < d.Width; x++ {
+			if x == 0 || y == 0 || x == d.Width-1 || y == d.Height-1 {
+				d.Tiles[y][x] = TileWall
+			}
+		}
+	}
+}
+
+// regions возвращает связные области проходимых клеток, от больших к меньшим
+func (d *Dungeon) regions() [][][2]int {
+	seen := make([][]bool, d.Height)
+	for y := range seen {
+		seen[y] = make([]bool, d.Width)
+	}
+
+	var result [][][2]int
+	for y := 0; y < d.Height; y++ {
+		for x := 0; x < d.Width; x++ {
+			if seen[y][x] || !d.Walkable(x, y) {
+				continue
+			}
+			seen[y][x] = true
+			region := [][2]int{{x, y}}
+			for i := 0; i < len(region); i++ {
+				p := region[i]
+				for _, off := range offsets {
+					nx, ny := p[0]+off[0], p[1]+off[1]
+					if d.Walkable(nx, ny) && !seen[ny][nx] {
+						seen[ny][nx] = true
+						region = append(region, [2]int{nx, ny})
+					}
+				}
+			}
+			result = append(result, region)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i]) > len(result[j])
+	})
+	return result
+}
+
+// connectRegions засыпает мелкие полости, а остальные соединяет тоннелями
+// с крупнейшей, чтобы всё подземелье было связным
+func (d *Dungeon) connectRegions(rng *rand.Rand) {
+	regions := d.regions()
+	if len(regions) == 0 {
+		return
+	}
+
+	connected := append([][2]int(nil), regions[0]...)
+	for _, region := range regions[1:] {
+		if len(region) < minRegionSize {
+			for _, p := range region {
+				d.Tiles[p[1]][p[0]] = TileWall
+			}
+			continue
+		}
+
+		// Ближайшая пара клеток; перебираем с шагом, чтобы не было квадратичного взрыва
+		step := max(1, len(connected)/200)
+		var from, to [2]int
+		best := -1
+		for _, a := range region {
+			for i := 0; i < len(connected); i += step {
+				b := connected[i]
+				dist := abs(a[0]-b[0]) + abs(a[1]-b[1])
+				if best == -1 || dist < best {
+					from, to, best = a, b, dist
+				}
+			}
+		}
+		d.carveCorridor(from, to, rng.Intn(2) == 0, nil)
+		connected = append(connected, region...)
+	}
+}
+
+// carveCorridor прорубает Г-образный коридор; horizontalFirst задаёт, с какой оси начать.
+// Прорубленные клетки добавляются в carved, если он не nil.
+func (d *Dungeon) carveCorridor(from, to [2]int, horizontalFirst bool, carved map[[2]int]bool) {
+	x, y := from[0], from[1]
+	carve := func() {
+		if d.Tiles[y][x] == TileWall {
+			d.Tiles[y][x] = TileFloor
+			if carved != nil {
+				carved[[2]int{x, y}] = true
+			}
+		}
+	}
+	walkX := func() {
+		for x != to[0] {
+			x += sign(to[0] - x)
+			carve()
+		}
+	}
+	walkY := func() {
+		for y != to[1] {
+			y += sign(to[1] - y)
+			carve()
+		}
+	}
+
+	if horizontalFirst {
+		walkX()
+		walkY()
+	} else {
+		walkY()
+		walkX()
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func sign(v int) int {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	default:
+		return 0
+	}
+}
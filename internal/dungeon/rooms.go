@@ -0,0 +1,172 @@
+package dungeon
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const (
+	roomMinSide     = 4
+	roomMaxWidth    = 10
+	roomMaxHeight   = 8
+	roomGap         = 2   // Минимальный зазор между комнатами
+	roomAreaPerRoom = 120 // Клеток карты на одну комнату по умолчанию
+	placeAttempts   = 300
+	loopChance      = 0.15 // Лишних коридоров на комнату, дающих петли
+)
+
+// generateRooms расставляет прямоугольные комнаты, соединяет их минимальным
+// остовным деревом с несколькими петлями и ставит двери на входах коридоров
+func generateRooms(d *Dungeon, opts Options, rng *rand.Rand) {
+	target := opts.Rooms
+	if target <= 0 {
+		target = max(2, d.Width*d.Height/roomAreaPerRoom)
+	}
+
+	var rects [][4]int // x, y, w, h
+	for attempt := 0; attempt < placeAttempts && len(rects) < target; attempt++ {
+		w := roomMinSide + rng.Intn(roomMaxWidth-roomMinSide+1)
+		h := roomMinSide + rng.Intn(roomMaxHeight-roomMinSide+1)
+		if w > d.Width-2 || h > d.Height-2 {
+			continue
+		}
+		x := 1 + rng.Intn(d.Width-w-1)
+		y := 1 + rng.Intn(d.Height-h-1)
+
+		overlaps := false
+		for _, r := range rects {
+			if x < r[0]+r[2]+roomGap && r[0] < x+w+roomGap &&
+				y < r[1]+r[3]+roomGap && r[1] < y+h+roomGap {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			rects = append(rects, [4]int{x, y, w, h})
+		}
+	}
+
+	d.RoomMap = newRoomMap(d.Width, d.Height)
+	for i, r := range rects {
+		for y := r[1]; y < r[1]+r[3]; y++ {
+			for x := r[0]; x < r[0]+r[2]; x++ {
+				d.Tiles[y][x] = TileFloor
+				d.RoomMap[y][x] = i
+			}
+		}
+		d.Rooms = append(d.Rooms, Room{ID: i, X: r[0], Y: r[1], Width: r[2], Height: r[3], Size: r[2] * r[3]})
+	}
+
+	corridors := make(map[[2]int]bool)
+	for _, e := range roomEdges(d, rng) {
+		d.carveCorridor(d.roomCenter(e[0]), d.roomCenter(e[1]), rng.Intn(2) == 0, corridors)
+	}
+	d.placeDoors(corridors)
+}
+
+// roomEdges строит минимальное остовное дерево по расстоянию между центрами
+// (Краскал) и добавляет часть оставшихся рёбер для петель
+func roomEdges(d *Dungeon, rng *rand.Rand) [][2]int {
+	type edge struct {
+		a, b, dist int
+	}
+	var edges []edge
+	for a := range d.Rooms {
+		for b := a + 1; b < len(d.Rooms); b++ {
+			ca, cb := d.roomCenter(a), d.roomCenter(b)
+			edges = append(edges, edge{a, b, abs(ca[0]-cb[0]) + abs(ca[1]-cb[1])})
+		}
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		return edges[i].dist < edges[j].dist
+	})
+
+	parent := make([]int, len(d.Rooms))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	var result [][2]int
+	var spare []edge
+	for _, e := range edges {
+		ra, rb := find(e.a), find(e.b)
+		if ra != rb {
+			parent[ra] = rb
+			result = append(result, [2]int{e.a, e.b})
+		} else {
+			spare = append(spare, e)
+		}
+	}
+
+	// Петли берём из коротких лишних рёбер, чтобы коридоры не пересекали всю карту
+	loops := int(float64(len(d.Rooms))*loopChance + 0.5)
+	spare = spare[:min(len(spare), len(d.Rooms)*2)]
+	rng.Shuffle(len(spare), func(i, j int) {
+		spare[i], spare[j] = spare[j], spare[i]
+	})
+	for _, e := range spare[:min(loops, len(spare))] {
+		result = append(result, [2]int{e.a, e.b})
+	}
+	return result
+}
+
+// placeDoors ставит двери там, где коридор входит в комнату через проём в стене
+func (d *Dungeon) placeDoors(corridors map[[2]int]bool) {
+	cells := make([][2]int, 0, len(corridors))
+	for c := range corridors {
+		cells = append(cells, c)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i][1] != cells[j][1] {
+			return cells[i][1] < cells[j][1]
+		}
+		return cells[i][0] < cells[j][0]
+	})
+
+	for _, c := range cells {
+		d.tryDoor(c[0], c[1])
+	}
+}
+
+// tryDoor ставит дверь в клетке, если она примыкает к комнате и по бокам
+// от входа стоят стены
+func (d *Dungeon) tryDoor(x, y int) {
+	for _, off := range offsets {
+		room := d.roomIndex(x+off[0], y+off[1])
+		if room < 0 {
+			continue
+		}
+		sideA := [2]int{x + off[1], y + off[0]}
+		sideB := [2]int{x - off[1], y - off[0]}
+		if !d.Walkable(sideA[0], sideA[1]) && !d.Walkable(sideB[0], sideB[1]) {
+			d.Tiles[y][x] = TileDoor
+			d.Doors = append(d.Doors, Door{X: x, Y: y, Room: room})
+			return
+		}
+	}
+}
+
+func (d *Dungeon) roomIndex(x, y int) int {
+	if !d.inBounds(x, y) {
+		return -1
+	}
+	return d.RoomMap[y][x]
+}
+
+func newRoomMap(w, h int) [][]int {
+	m := make([][]int, h)
+	for y := range m {
+		m[y] = make([]int, w)
+		for x := range m[y] {
+			m[y][x] = -1
+		}
+	}
+	return m
+}
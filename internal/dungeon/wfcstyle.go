@@ -0,0 +1,84 @@
+package dungeon
+
+import (
+	"fmt"
+	"math/rand"
+
+	"test/internal/wfc"
+)
+
+// Образец для overlapping WFC: # - стена, . - пол. Комнаты разного размера,
+// соединённые коридорами шириной в одну клетку. Образец периодический.
+var wfcSample = []string{
+	"################",
+	"#.....####.....#",
+	"#.....####.....#",
+	"#..............#",
+	"#.....####.....#",
+	"###.######.#####",
+	"###.######.#####",
+	"###.##.....#####",
+	"###.##.....#####",
+	"#......#...#####",
+	"#.###..#...#####",
+	"#.###..#######.#",
+	"#.###..........#",
+	"#.###..####.####",
+	"#......####.####",
+	"###.#######.####",
+}
+
+const wfcPatternN = 3
+
+// generateWFC собирает план подземелья overlapping-моделью по образцу,
+// затем соединяет полости, выделяет залы и ставит двери в проёмах
+func generateWFC(d *Dungeon, rng *rand.Rand) error {
+	sample := make([][]int, len(wfcSample))
+	for y, row := range wfcSample {
+		sample[y] = make([]int, len(row))
+		for x, c := range row {
+			if c == '.' {
+				sample[y][x] = int(TileFloor)
+			}
+		}
+	}
+
+	model, err := wfc.NewOverlapping(wfc.OverlappingOptions{
+		Sample:        sample,
+		N:             wfcPatternN,
+		Width:         d.Width,
+		Height:        d.Height,
+		Seed:          rng.Int63(),
+		PeriodicInput: true,
+		Symmetry:      8,
+		MaxAttempts:   10,
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка подготовки WFC: %w", err)
+	}
+
+	result := model.Run()
+	for y, row := range result.Grid {
+		for x, tile := range row {
+			d.Tiles[y][x] = Tile(tile)
+		}
+	}
+	d.fillBorder()
+
+	d.connectRegions(rng)
+	d.findChambers()
+	d.placeChamberDoors()
+	return nil
+}
+
+// placeChamberDoors ставит двери в узких проходах, примыкающих к залам
+func (d *Dungeon) placeChamberDoors() {
+	for y := 0; y < d.Height; y++ {
+		for x := 0; x < d.Width; x++ {
+			if d.Tiles[y][x] != TileFloor || d.RoomMap[y][x] != -1 {
+				continue
+			}
+			d.tryDoor(x, y)
+		}
+	}
+}
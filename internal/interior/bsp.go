@@ -0,0 +1,244 @@
+package interior
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const (
+	minRoomSide = 3  // Минимальная сторона комнаты без стен
+	roomArea    = 16 // Примерная площадь, на которую приходится одна комната
+)
+
+// Предельное число комнат на этаже по типу здания
+var maxRooms = map[string]int{
+	"house":     3,
+	"tavern":    5,
+	"smithy":    3,
+	"temple":    4,
+	"guildhall": 6,
+	"keep":      10,
+}
+
+// rect - прямоугольник пола без учёта окружающих стен
+type rect struct {
+	x, y, w, h int
+}
+
+func (r rect) area() int {
+	return r.w * r.h
+}
+
+func (r rect) contains(p point) bool {
+	return p.x >= r.x && p.x < r.x+r.w && p.y >= r.y && p.y < r.y+r.h
+}
+
+// builder собирает один этаж: разбиение, вход, назначение комнат и мебель
+type builder struct {
+	opts      Options
+	level     int
+	rng       *rand.Rand
+	cells     [][]Cell
+	stairs    *point
+	leaves    []rect
+	rooms     []Room
+	furniture []Furniture
+	occupied  map[point]bool
+	entrance  *point
+}
+
+func newBuilder(opts Options, level int, stairs *point, rng *rand.Rand) *builder {
+	b := &builder{
+		opts:     opts,
+		level:    level,
+		rng:      rng,
+		stairs:   stairs,
+		occupied: make(map[point]bool),
+	}
+
+	b.cells = make([][]Cell, opts.Height)
+	for y := range b.cells {
+		b.cells[y] = make([]Cell, opts.Width)
+		for x := range b.cells[y] {
+			if x == 0 || y == 0 || x == opts.Width-1 || y == opts.Height-1 {
+				b.cells[y][x] = CellWall
+			}
+		}
+	}
+	if stairs != nil {
+		b.cells[stairs.y][stairs.x] = CellStairs
+	}
+	return b
+}
+
+func (b *builder) inBounds(p point) bool {
+	return p.x >= 0 && p.y >= 0 && p.x < b.opts.Width && p.y < b.opts.Height
+}
+
+func (b *builder) at(p point) Cell {
+	return b.cells[p.y][p.x]
+}
+
+// split рекурсивно делит этаж стенами с дверями, каждый раз разрезая
+// самый большой лист, пока не наберётся нужное число комнат
+func (b *builder) split() {
+	root := rect{1, 1, b.opts.Width - 2, b.opts.Height - 2}
+	b.leaves = []rect{root}
+
+	limit, ok := maxRooms[b.opts.Type]
+	if !ok {
+		limit = maxRooms["house"]
+	}
+	target := min(limit, root.area()/roomArea+1)
+
+	// Листья, которые разрезать не получилось, больше не пробуем
+	final := make(map[rect]bool)
+	for len(b.leaves) < target {
+		best := -1
+		for i, leaf := range b.leaves {
+			if !final[leaf] && (best == -1 || leaf.area() > b.leaves[best].area()) {
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		leaf := b.leaves[best]
+		first, second, ok := b.splitRect(leaf)
+		if !ok {
+			final[leaf] = true
+			continue
+		}
+		b.leaves[best] = first
+		b.leaves = append(b.leaves, second)
+	}
+
+	sort.SliceStable(b.leaves, func(i, j int) bool {
+		if b.leaves[i].y != b.leaves[j].y {
+			return b.leaves[i].y < b.leaves[j].y
+		}
+		return b.leaves[i].x < b.leaves[j].x
+	})
+}
+
+// splitRect ставит стену поперёк длинной стороны r и прорезает в ней дверь.
+// Стена не может упираться в существующую дверь или проходить через лестницу.
+func (b *builder) splitRect(r rect) (rect, rect, bool) {
+	vertical := r.w > r.h || (r.w == r.h && b.rng.Intn(2) == 0)
+	length := r.h
+	if !vertical {
+		length = r.w
+	}
+	span := r.w
+	if !vertical {
+		span = r.h
+	}
+
+	var candidates []int
+	for c := minRoomSide; c <= span-minRoomSide-1; c++ {
+		candidates = append(candidates, c)
+	}
+	b.rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	for _, c := range candidates {
+		wallAt := func(i int) point {
+			if vertical {
+				return point{r.x + c, r.y + i}
+			}
+			return point{r.x + i, r.y + c}
+		}
+		if b.blocksDoor(wallAt(-1)) || b.blocksDoor(wallAt(length)) || b.crossesStairs(wallAt, length) {
+			continue
+		}
+
+		for i := 0; i < length; i++ {
+			p := wallAt(i)
+			b.cells[p.y][p.x] = CellWall
+		}
+		door := wallAt(b.rng.Intn(length))
+		b.cells[door.y][door.x] = CellDoor
+
+		if vertical {
+			return rect{r.x, r.y, c, r.h}, rect{r.x + c + 1, r.y, r.w - c - 1, r.h}, true
+		}
+		return rect{r.x, r.y, r.w, c}, rect{r.x, r.y + c + 1, r.w, r.h - c - 1}, true
+	}
+	return r, r, false
+}
+
+func (b *builder) blocksDoor(p point) bool {
+	if !b.inBounds(p) {
+		return false
+	}
+	c := b.at(p)
+	return c == CellDoor || c == CellEntrance
+}
+
+func (b *builder) crossesStairs(wallAt func(int) point, length int) bool {
+	if b.stairs == nil {
+		return false
+	}
+	for i := 0; i < length; i++ {
+		if wallAt(i) == *b.stairs {
+			return true
+		}
+	}
+	return false
+}
+
+// placeEntrance прорезает входную дверь во внешней стене, по возможности на фасаде
+func (b *builder) placeEntrance(front Side) {
+	sides := []Side{SideNorth, SideEast, SideSouth, SideWest}
+	b.rng.Shuffle(len(sides), func(i, j int) {
+		sides[i], sides[j] = sides[j], sides[i]
+	})
+	if front != SideNone {
+		sides = append([]Side{front}, sides...)
+	}
+
+	for _, side := range sides {
+		var candidates []point
+		for _, p := range b.outerWall(side) {
+			inside := point{p.x - sideOffsets[side][0], p.y - sideOffsets[side][1]}
+			if b.at(inside) == CellFloor {
+				candidates = append(candidates, p)
+			}
+		}
+		if len(candidates) > 0 {
+			p := candidates[b.rng.Intn(len(candidates))]
+			b.cells[p.y][p.x] = CellEntrance
+			b.entrance = &p
+			return
+		}
+	}
+}
+
+var sideOffsets = [4][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}
+
+// outerWall возвращает клетки внешней стены стороны side без углов
+func (b *builder) outerWall(side Side) []point {
+	w, h := b.opts.Width, b.opts.Height
+	var cells []point
+	switch side {
+	case SideNorth, SideSouth:
+		y := 0
+		if side == SideSouth {
+			y = h - 1
+		}
+		for x := 1; x < w-1; x++ {
+			cells = append(cells, point{x, y})
+		}
+	default:
+		x := 0
+		if side == SideEast {
+			x = w - 1
+		}
+		for y := 1; y < h-1; y++ {
+			cells = append(cells, point{x, y})
+		}
+	}
+	return cells
+}
@@ -0,0 +1,280 @@
+package interior
+
+import "sort"
+
+// Назначение комнат: первая - главная комната этажа, остальные
+// повторяются по кругу для оставшихся листьев разбиения
+var groundRooms = map[string][]string{
+	"house":     {"living", "kitchen", "bedroom", "storage"},
+	"tavern":    {"common", "kitchen", "storage", "guest"},
+	"smithy":    {"forge", "storage", "bedroom"},
+	"temple":    {"sanctuary", "vestry", "cell"},
+	"guildhall": {"hall", "office", "archive", "storage"},
+	"keep":      {"greathall", "kitchen", "armory", "storage", "barracks"},
+}
+
+var upperRooms = map[string][]string{
+	"house":     {"bedroom", "storage", "bedroom"},
+	"tavern":    {"guest"},
+	"smithy":    {"bedroom", "storage"},
+	"temple":    {"library", "cell"},
+	"guildhall": {"meeting", "office", "archive"},
+	"keep":      {"chambers", "armory", "barracks"},
+}
+
+// RoomNames - русские названия комнат для отображения
+var RoomNames = map[string]string{
+	"living":    "Гостиная",
+	"kitchen":   "Кухня",
+	"bedroom":   "Спальня",
+	"storage":   "Кладовая",
+	"common":    "Общий зал",
+	"guest":     "Комната для гостей",
+	"forge":     "Кузня",
+	"sanctuary": "Святилище",
+	"vestry":    "Ризница",
+	"cell":      "Келья",
+	"library":   "Библиотека",
+	"hall":      "Зал гильдии",
+	"office":    "Кабинет",
+	"archive":   "Архив",
+	"meeting":   "Зал собраний",
+	"greathall": "Тронный зал",
+	"armory":    "Оружейная",
+	"barracks":  "Казарма",
+	"chambers":  "Покои",
+}
+
+// FurnitureNames - русские названия мебели для отображения
+var FurnitureNames = map[string]string{
+	"table":     "Стол",
+	"bar":       "Стойка",
+	"fireplace": "Камин",
+	"hearth":    "Очаг",
+	"barrel":    "Бочка",
+	"crate":     "Ящик",
+	"bed":       "Кровать",
+	"chest":     "Сундук",
+	"shelf":     "Полка",
+	"forge":     "Горн",
+	"anvil":     "Наковальня",
+	"workbench": "Верстак",
+	"altar":     "Алтарь",
+	"bench":     "Скамья",
+	"desk":      "Письменный стол",
+	"throne":    "Трон",
+	"rack":      "Стойка с оружием",
+}
+
+// furnitureSpec - правило расстановки: у стены или в центре комнаты.
+// Количество - fixed плюс по одному предмету на perArea клеток пола.
+type furnitureSpec struct {
+	kind    string
+	wall    bool
+	fixed   int
+	perArea int
+}
+
+var roomFurniture = map[string][]furnitureSpec{
+	"living":    {{"fireplace", true, 1, 0}, {"table", false, 1, 0}, {"shelf", true, 1, 0}},
+	"kitchen":   {{"hearth", true, 1, 0}, {"table", false, 1, 0}, {"barrel", true, 1, 0}},
+	"bedroom":   {{"bed", true, 1, 0}, {"chest", true, 1, 0}},
+	"storage":   {{"crate", true, 1, 4}, {"barrel", true, 1, 0}},
+	"common":    {{"bar", true, 2, 0}, {"fireplace", true, 1, 0}, {"table", false, 0, 6}},
+	"guest":     {{"bed", true, 1, 0}, {"chest", true, 1, 0}},
+	"forge":     {{"forge", true, 1, 0}, {"anvil", false, 1, 0}, {"workbench", true, 1, 0}, {"barrel", true, 1, 0}},
+	"sanctuary": {{"altar", true, 1, 0}, {"bench", false, 0, 4}},
+	"vestry":    {{"chest", true, 1, 0}, {"shelf", true, 1, 0}},
+	"cell":      {{"bed", true, 1, 0}},
+	"library":   {{"shelf", true, 0, 3}, {"table", false, 1, 0}},
+	"hall":      {{"table", false, 1, 8}, {"shelf", true, 1, 0}},
+	"office":    {{"desk", false, 1, 0}, {"shelf", true, 1, 0}, {"chest", true, 1, 0}},
+	"archive":   {{"shelf", true, 0, 3}},
+	"meeting":   {{"table", false, 1, 0}},
+	"greathall": {{"throne", true, 1, 0}, {"fireplace", true, 1, 0}, {"table", false, 0, 6}},
+	"armory":    {{"rack", true, 0, 6}},
+	"barracks":  {{"bed", true, 0, 6}, {"chest", true, 1, 0}},
+	"chambers":  {{"bed", true, 1, 0}, {"chest", true, 1, 0}, {"desk", false, 1, 0}},
+}
+
+// assignRooms превращает листья разбиения в комнаты. Главной становится
+// комната со входом, на верхних этажах - самая большая.
+func (b *builder) assignRooms() {
+	table := groundRooms
+	if b.level > 0 {
+		table = upperRooms
+	}
+	kinds, ok := table[b.opts.Type]
+	if !ok {
+		kinds = table["house"]
+	}
+
+	order := make([]int, len(b.leaves))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return b.leaves[order[i]].area() > b.leaves[order[j]].area()
+	})
+	if b.entrance != nil {
+		for i, leaf := range order {
+			if b.touches(b.leaves[leaf], *b.entrance) {
+				order = append([]int{leaf}, append(order[:i:i], order[i+1:]...)...)
+				break
+			}
+		}
+	}
+
+	b.rooms = make([]Room, len(b.leaves))
+	for rank, leaf := range order {
+		r := b.leaves[leaf]
+		b.rooms[leaf] = Room{
+			ID:     leaf,
+			Kind:   roomKind(kinds, rank),
+			X:      r.x,
+			Y:      r.y,
+			Width:  r.w,
+			Height: r.h,
+		}
+	}
+}
+
+func roomKind(kinds []string, rank int) string {
+	if rank < len(kinds) {
+		return kinds[rank]
+	}
+	if len(kinds) == 1 {
+		return kinds[0]
+	}
+	return kinds[1+(rank-1)%(len(kinds)-1)]
+}
+
+// touches сообщает, примыкает ли клетка стены p к прямоугольнику r
+func (b *builder) touches(r rect, p point) bool {
+	for _, off := range sideOffsets {
+		if r.contains(point{p.x + off[0], p.y + off[1]}) {
+			return true
+		}
+	}
+	return false
+}
+
+// furnish расставляет мебель так, чтобы не загораживать двери, лестницу
+// и не отрезать ни одну клетку этажа от остальных
+func (b *builder) furnish() {
+	for i, room := range b.rooms {
+		r := b.leaves[i]
+		for _, spec := range roomFurniture[room.Kind] {
+			count := spec.fixed
+			if spec.perArea > 0 {
+				count += r.area() / spec.perArea
+			}
+			for n := 0; n < count; n++ {
+				if !b.placeItem(room.ID, r, spec) {
+					break
+				}
+			}
+		}
+	}
+}
+
+func (b *builder) placeItem(room int, r rect, spec furnitureSpec) bool {
+	var candidates []point
+	for y := r.y; y < r.y+r.h; y++ {
+		for x := r.x; x < r.x+r.w; x++ {
+			p := point{x, y}
+			if b.at(p) != CellFloor || b.occupied[p] || b.nearPassage(p) {
+				continue
+			}
+			if b.againstWall(p) == spec.wall {
+				candidates = append(candidates, p)
+			}
+		}
+	}
+	b.rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	for _, p := range candidates {
+		b.occupied[p] = true
+		if b.connected() {
+			b.furniture = append(b.furniture, Furniture{Kind: spec.kind, X: p.x, Y: p.y, Room: room})
+			return true
+		}
+		delete(b.occupied, p)
+	}
+	return false
+}
+
+// nearPassage - рядом дверь или лестница, клетку нужно оставить свободной
+func (b *builder) nearPassage(p point) bool {
+	for _, off := range sideOffsets {
+		n := point{p.x + off[0], p.y + off[1]}
+		if !b.inBounds(n) {
+			continue
+		}
+		switch b.at(n) {
+		case CellDoor, CellEntrance, CellStairs:
+			return true
+		}
+	}
+	return false
+}
+
+func (b *builder) againstWall(p point) bool {
+	for _, off := range sideOffsets {
+		n := point{p.x + off[0], p.y + off[1]}
+		if b.inBounds(n) && b.at(n) == CellWall {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *builder) walkable(p point) bool {
+	return b.inBounds(p) && b.at(p) != CellWall && !b.occupied[p]
+}
+
+// connected проверяет обходом в ширину, что все свободные клетки этажа достижимы
+func (b *builder) connected() bool {
+	var start *point
+	total := 0
+	for y := range b.cells {
+		for x := range b.cells[y] {
+			p := point{x, y}
+			if b.walkable(p) {
+				total++
+				if start == nil {
+					start = &p
+				}
+			}
+		}
+	}
+	if start == nil {
+		return true
+	}
+
+	seen := map[point]bool{*start: true}
+	queue := []point{*start}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, off := range sideOffsets {
+			n := point{p.x + off[0], p.y + off[1]}
+			if b.walkable(n) && !seen[n] {
+				seen[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+	return len(seen) == total
+}
+
+func (b *builder) floor() Floor {
+	return Floor{
+		Level:     b.level,
+		Cells:     b.cells,
+		Rooms:     b.rooms,
+		Furniture: b.furniture,
+	}
+}
@@ -0,0 +1,137 @@
+package interior
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Cell - содержимое клетки плана в масштабе боевой карты (клетка = 5 футов)
+type Cell int
+
+const (
+	CellFloor Cell = iota
+	CellWall
+	CellDoor
+	CellEntrance // Входная дверь во внешней стене
+	CellStairs
+)
+
+// Side - сторона здания, в том же порядке, что и направления в пакете wfc
+type Side int
+
+const (
+	SideNorth Side = iota
+	SideEast
+	SideSouth
+	SideWest
+	SideNone Side = -1
+)
+
+// Options описывает здание, для которого строится план
+type Options struct {
+	Type   string // Тип здания: house/tavern/smithy/temple/guildhall/keep
+	Width  int    // Размер в клетках боевой карты, включая внешние стены
+	Height int
+	Floors int  // Количество этажей, не меньше 1
+	Front  Side // Сторона фасада со входом; SideNone - любая
+	Seed   int64
+}
+
+// Room - комната, выделенная разбиением
+type Room struct {
+	ID     int    `json:"id"`
+	Kind   string `json:"kind"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Furniture - предмет обстановки, занимающий одну клетку
+type Furniture struct {
+	Kind string `json:"kind"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	Room int    `json:"room"`
+}
+
+// Floor - один этаж здания
+type Floor struct {
+	Level     int         `json:"level"`
+	Cells     [][]Cell    `json:"cells"` // [y][x]
+	Rooms     []Room      `json:"rooms"`
+	Furniture []Furniture `json:"furniture"`
+}
+
+// Plan - поэтажный план здания
+type Plan struct {
+	Type   string  `json:"type"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Seed   int64   `json:"seed"`
+	Stairs *[2]int `json:"stairs,omitempty"` // Общая для всех этажей клетка лестницы
+	Floors []Floor `json:"floors"`
+}
+
+const (
+	minPlanSide = 5 // Комната 3x3 внутри внешних стен
+	maxFloors   = 5
+)
+
+// Generate строит детерминированный план: одинаковые Options дают одинаковый план
+func Generate(opts Options) (*Plan, error) {
+	if opts.Width < minPlanSide || opts.Height < minPlanSide {
+		return nil, fmt.Errorf("здание %dx%d слишком мало для планировки", opts.Width, opts.Height)
+	}
+	if opts.Floors < 1 {
+		opts.Floors = 1
+	}
+	if opts.Floors > maxFloors {
+		opts.Floors = maxFloors
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	plan := &Plan{
+		Type:   opts.Type,
+		Width:  opts.Width,
+		Height: opts.Height,
+		Seed:   opts.Seed,
+	}
+
+	// Лестница нужна только многоэтажным зданиям и стоит на одном месте на всех этажах
+	var stairs *point
+	if opts.Floors > 1 {
+		stairs = &point{
+			x: 1 + rng.Intn(opts.Width-2),
+			y: 1 + rng.Intn(opts.Height-2),
+		}
+		plan.Stairs = &[2]int{stairs.x, stairs.y}
+	}
+
+	for level := 0; level < opts.Floors; level++ {
+		b := newBuilder(opts, level, stairs, rng)
+		b.split()
+		if level == 0 {
+			b.placeEntrance(opts.Front)
+		}
+		b.assignRooms()
+		b.furnish()
+		plan.Floors = append(plan.Floors, b.floor())
+	}
+	return plan, nil
+}
+
+// RoomAt возвращает комнату этажа, содержащую клетку (x, y)
+func (f *Floor) RoomAt(x, y int) *Room {
+	for i := range f.Rooms {
+		r := &f.Rooms[i]
+		if x >= r.X && x < r.X+r.Width && y >= r.Y && y < r.Y+r.Height {
+			return r
+		}
+	}
+	return nil
+}
+
+type point struct {
+	x, y int
+}
@@ -0,0 +1,137 @@
+package pdf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Font - шрифт TrueType, встраиваемый целиком как CIDFontType2 с
+// кодировкой Identity-H: в тексте пишутся номера глифов, а ToUnicode
+// позволяет копировать текст из PDF.
+type Font struct {
+	data   []byte
+	sfnt   *sfnt.Font
+	buf    sfnt.Buffer
+	upem   int
+	glyphs map[rune]sfnt.GlyphIndex
+	widths map[sfnt.GlyphIndex]int // Ширина в тысячных долях кегля
+}
+
+func parseFont(data []byte) (*Font, error) {
+	f, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора шрифта: %w", err)
+	}
+	return &Font{
+		data:   data,
+		sfnt:   f,
+		upem:   int(f.UnitsPerEm()),
+		glyphs: make(map[rune]sfnt.GlyphIndex),
+		widths: make(map[sfnt.GlyphIndex]int),
+	}, nil
+}
+
+// glyph находит глиф символа и запоминает его ширину; символы, которых в
+// шрифте нет, печатаются пустым глифом 0
+func (f *Font) glyph(r rune) sfnt.GlyphIndex {
+	if g, ok := f.glyphs[r]; ok {
+		return g
+	}
+	g, err := f.sfnt.GlyphIndex(&f.buf, r)
+	if err != nil {
+		g = 0
+	}
+	adv, err := f.sfnt.GlyphAdvance(&f.buf, g, fixed.I(f.upem), font.HintingNone)
+	if err == nil {
+		f.widths[g] = adv.Round() * 1000 / f.upem
+	}
+	f.glyphs[r] = g
+	return g
+}
+
+func (f *Font) width(s string) float64 {
+	var w int
+	for _, r := range s {
+		w += f.widths[f.glyph(r)]
+	}
+	return float64(w)
+}
+
+// encode переводит строку в номера глифов для оператора Tj
+func (f *Font) encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		fmt.Fprintf(&b, "%04X", uint16(f.glyph(r)))
+	}
+	return b.String()
+}
+
+// write записывает шрифт и возвращает номер объекта Type0
+func (f *Font) write(w *pdfWriter) int {
+	name := "Font"
+	if n, err := f.sfnt.Name(&f.buf, sfnt.NameIDPostScript); err == nil && n != "" {
+		name = n
+	}
+	scale := func(v fixed.Int26_6) int { return v.Round() * 1000 / f.upem }
+	ppem := fixed.I(f.upem)
+	bounds, _ := f.sfnt.Bounds(&f.buf, ppem, font.HintingNone)
+	metrics, _ := f.sfnt.Metrics(&f.buf, ppem, font.HintingNone)
+
+	file := w.stream(fmt.Sprintf("/Length1 %d ", len(f.data)), f.data)
+	descriptor := w.object(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 "+
+		"/FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		name, scale(bounds.Min.X), -scale(bounds.Max.Y), scale(bounds.Max.X), -scale(bounds.Min.Y),
+		scale(metrics.Ascent), -scale(metrics.Descent), scale(metrics.CapHeight), file))
+
+	// Ширины только использованных глифов, по возрастанию номеров
+	gids := make([]int, 0, len(f.widths))
+	for g := range f.widths {
+		gids = append(gids, int(g))
+	}
+	sort.Ints(gids)
+	var widths strings.Builder
+	for _, g := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", g, f.widths[sfnt.GlyphIndex(g)])
+	}
+	cid := w.object(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s "+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+		"/FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW 500 /W [%s] >>", name, descriptor, widths.String()))
+
+	toUnicode := w.stream("", f.toUnicode())
+	return w.object(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H "+
+		"/DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", name, cid, toUnicode))
+}
+
+// toUnicode - CMap из номеров глифов обратно в символы
+func (f *Font) toUnicode() []byte {
+	var b strings.Builder
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+
+	runes := make([]rune, 0, len(f.glyphs))
+	for r, g := range f.glyphs {
+		if g != 0 && r <= 0xFFFF {
+			runes = append(runes, r)
+		}
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	// В одном блоке bfchar не больше 100 записей
+	for len(runes) > 0 {
+		n := min(len(runes), 100)
+		fmt.Fprintf(&b, "%d beginbfchar\n", n)
+		for _, r := range runes[:n] {
+			fmt.Fprintf(&b, "<%04X> <%04X>\n", uint16(f.glyphs[r]), r)
+		}
+		b.WriteString("endbfchar\n")
+		runes = runes[n:]
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,156 @@
+// Package pdf пишет простые PDF-документы: страницы с текстом одним
+// встроенным шрифтом TrueType, линиями и рамками. Этого хватает для печатных
+// листов и таблиц, без зависимостей сверх golang.org/x/image.
+//
+// Координаты - в пунктах (1/72 дюйма) от левого верхнего угла страницы, как
+// на экране; в систему PDF с началом внизу они переводятся при записи.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Размер страницы A4 в пунктах
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+// Document - документ из страниц с общим шрифтом
+type Document struct {
+	font  *Font
+	pages []*Page
+}
+
+// Page - страница; команды рисования копятся в content
+type Page struct {
+	doc     *Document
+	content bytes.Buffer
+}
+
+// New создаёт документ, текст которого набирается шрифтом из файла TrueType
+func New(fontData []byte) (*Document, error) {
+	font, err := parseFont(fontData)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{font: font}, nil
+}
+
+// AddPage добавляет страницу A4
+func (d *Document) AddPage() *Page {
+	p := &Page{doc: d}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// TextWidth - ширина строки в пунктах при кегле size
+func (d *Document) TextWidth(s string, size float64) float64 {
+	return d.font.width(s) * size / 1000
+}
+
+// Text пишет строку; y - базовая линия
+func (p *Page) Text(x, y, size float64, s string) {
+	if s == "" {
+		return
+	}
+	fmt.Fprintf(&p.content, "BT /F1 %s Tf %s %s Td <%s> Tj ET\n", num(size), num(x), num(A4Height-y), p.doc.font.encode(s))
+}
+
+// Line проводит линию толщиной width
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n", num(width), num(x1), num(A4Height-y1), num(x2), num(A4Height-y2))
+}
+
+// Rect обводит прямоугольник с левым верхним углом в x, y
+func (p *Page) Rect(x, y, w, h, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s %s %s re S\n", num(width), num(x), num(A4Height-y-h), num(w), num(h))
+}
+
+// FillRect заливает прямоугольник серым: 0 - чёрный, 1 - белый
+func (p *Page) FillRect(x, y, w, h, gray float64) {
+	fmt.Fprintf(&p.content, "q %s g %s %s %s %s re f Q\n", num(gray), num(x), num(A4Height-y-h), num(w), num(h))
+}
+
+// WriteTo записывает документ
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var out pdfWriter
+	out.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Номера объектов: 1 - каталог, 2 - дерево страниц, дальше шрифт и страницы
+	const catalog, pagesObj = 1, 2
+	out.offsets = make([]int, 2)
+	fontObj := d.font.write(&out)
+
+	var kids []string
+	for _, page := range d.pages {
+		content := out.stream("", page.content.Bytes())
+		obj := out.object(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] "+
+			"/Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, num(A4Width), num(A4Height), fontObj, content))
+		kids = append(kids, fmt.Sprintf("%d 0 R", obj))
+	}
+	out.objectAt(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+	out.objectAt(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+
+	xref := out.buf.Len()
+	fmt.Fprintf(&out.buf, "xref\n0 %d\n0000000000 65535 f \n", len(out.offsets)+1)
+	for _, off := range out.offsets {
+		fmt.Fprintf(&out.buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out.buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(out.offsets)+1, catalog, xref)
+	return out.buf.WriteTo(w)
+}
+
+// Bytes возвращает документ целиком
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	d.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// pdfWriter копит объекты и их смещения для таблицы xref
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int // Смещение объекта n - offsets[n-1]
+}
+
+// object добавляет объект и возвращает его номер
+func (w *pdfWriter) object(body string) int {
+	w.offsets = append(w.offsets, 0)
+	n := len(w.offsets)
+	w.objectAt(n, body)
+	return n
+}
+
+// objectAt записывает объект с заранее занятым номером
+func (w *pdfWriter) objectAt(n int, body string) {
+	w.offsets[n-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+// stream добавляет сжатый поток; dict - дополнительные ключи словаря
+func (w *pdfWriter) stream(dict string, data []byte) int {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(data)
+	zw.Close()
+
+	w.offsets = append(w.offsets, w.buf.Len())
+	n := len(w.offsets)
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< /Length %d /Filter /FlateDecode %s>>\nstream\n", n, z.Len(), dict)
+	w.buf.Write(z.Bytes())
+	w.buf.WriteString("\nendstream\nendobj\n")
+	return n
+}
+
+// num печатает число без лишних нулей
+func num(f float64) string {
+	s := fmt.Sprintf("%.2f", f)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,236 @@
+// Package richtext разбирает документы редактора ProseMirror (TipTap), в
+// которых веб-листы хранят описание, заметки и снаряжение, в простую модель:
+// блоки (абзацы, заголовки, пункты списков, цитаты) из фрагментов текста с
+// начертанием.
+//
+// Пакет ничего не рисует: раскладка по строкам и вывод остаются программе.
+package richtext
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Style - начертание фрагмента, набор флагов
+type Style uint8
+
+const (
+	Bold Style = 1 << iota
+	Italic
+	Underline
+	Strike
+	Code
+)
+
+// Run - фрагмент текста одного начертания; перевод строки внутри абзаца
+// (hardBreak) - символ "\n" в тексте
+type Run struct {
+	Text  string
+	Style Style
+	Link  string // Адрес ссылки, если фрагмент - ссылка
+}
+
+// BlockKind - вид блока
+type BlockKind int
+
+const (
+	Paragraph BlockKind = iota
+	Heading
+	ListItem // Первый абзац пункта списка, с маркером
+	Rule     // Горизонтальная черта
+)
+
+// Block - абзац документа
+type Block struct {
+	Kind   BlockKind
+	Level  int    // Уровень заголовка, 1-6
+	Indent int    // Вложенность списков: 0 - без отступа
+	Marker string // Маркер пункта: "•" или номер "3."
+	Quote  bool   // Абзац внутри цитаты
+	Runs   []Run
+}
+
+// Text - текст блока без начертаний
+func (b Block) Text() string {
+	var s strings.Builder
+	for _, r := range b.Runs {
+		s.WriteString(r.Text)
+	}
+	return s.String()
+}
+
+// Doc - документ: блоки сверху вниз
+type Doc struct {
+	Blocks []Block
+}
+
+// Lines - непустые строки документа без разметки, как их видно в простом
+// тексте: абзацы и переводы строк разбивают текст, маркеры списков опущены
+func (d *Doc) Lines() []string {
+	var lines []string
+	if d == nil {
+		return nil
+	}
+	for _, b := range d.Blocks {
+		for _, line := range strings.Split(b.Text(), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// FromText - документ из простого текста: абзац на строку
+func FromText(s string) *Doc {
+	doc := &Doc{}
+	for _, line := range strings.Split(s, "\n") {
+		b := Block{Kind: Paragraph}
+		if line != "" {
+			b.Runs = []Run{{Text: line}}
+		}
+		doc.Blocks = append(doc.Blocks, b)
+	}
+	return doc
+}
+
+// node - узел JSON документа
+type node struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Attrs struct {
+		Level int  `json:"level"`
+		Start *int `json:"start"`
+	} `json:"attrs"`
+	Marks []struct {
+		Type  string `json:"type"`
+		Attrs struct {
+			Href string `json:"href"`
+		} `json:"attrs"`
+	} `json:"marks"`
+	Content []node `json:"content"`
+}
+
+// Parse разбирает JSON узла документа: {"type": "doc", "content": [...]}.
+// Незнакомые блочные узлы разворачиваются в своё содержимое, незнакомые
+// отметки текста пропускаются.
+func Parse(data []byte) (*Doc, error) {
+	var root node
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	p := &parser{doc: &Doc{}}
+	p.blocks([]node{root}, 0, false)
+	return p.doc, nil
+}
+
+type parser struct {
+	doc *Doc
+}
+
+// blocks разбирает блочные узлы на уровне вложенности indent
+func (p *parser) blocks(nodes []node, indent int, quote bool) {
+	var loose []node // Текст прямо в контейнере собирается в абзац
+	flush := func() {
+		if len(loose) > 0 {
+			p.add(Block{Kind: Paragraph, Indent: indent, Quote: quote}, loose)
+			loose = nil
+		}
+	}
+	for _, n := range nodes {
+		switch n.Type {
+		case "text", "hardBreak":
+			loose = append(loose, n)
+			continue
+		}
+		flush()
+		switch n.Type {
+		case "paragraph":
+			p.add(Block{Kind: Paragraph, Indent: indent, Quote: quote}, n.Content)
+		case "heading":
+			p.add(Block{Kind: Heading, Level: min(max(n.Attrs.Level, 1), 6), Indent: indent, Quote: quote}, n.Content)
+		case "bulletList", "orderedList":
+			number := 1
+			if n.Attrs.Start != nil {
+				number = *n.Attrs.Start
+			}
+			for _, item := range n.Content {
+				marker := "•"
+				if n.Type == "orderedList" {
+					marker = strconv.Itoa(number) + "."
+					number++
+				}
+				p.listItem(item, indent+1, quote, marker)
+			}
+		case "blockquote":
+			p.blocks(n.Content, indent, true)
+		case "horizontalRule":
+			p.doc.Blocks = append(p.doc.Blocks, Block{Kind: Rule, Indent: indent, Quote: quote})
+		default:
+			p.blocks(n.Content, indent, quote)
+		}
+	}
+	flush()
+}
+
+// listItem - пункт списка: маркер получает первый абзац, остальное
+// выравнивается по нему
+func (p *parser) listItem(item node, indent int, quote bool, marker string) {
+	children := item.Content
+	if len(children) > 0 && children[0].Type == "paragraph" {
+		p.add(Block{Kind: ListItem, Indent: indent, Marker: marker, Quote: quote}, children[0].Content)
+		children = children[1:]
+	} else {
+		p.doc.Blocks = append(p.doc.Blocks, Block{Kind: ListItem, Indent: indent, Marker: marker, Quote: quote})
+	}
+	p.blocks(children, indent, quote)
+}
+
+func (p *parser) add(b Block, content []node) {
+	b.Runs = inline(content, 0, "", nil)
+	p.doc.Blocks = append(p.doc.Blocks, b)
+}
+
+// inline собирает фрагменты текста, склеивая соседние одного начертания
+func inline(nodes []node, style Style, link string, runs []Run) []Run {
+	for _, n := range nodes {
+		s, href := style, link
+		for _, m := range n.Marks {
+			switch m.Type {
+			case "bold", "strong":
+				s |= Bold
+			case "italic", "em":
+				s |= Italic
+			case "underline":
+				s |= Underline
+			case "strike", "strikethrough":
+				s |= Strike
+			case "code":
+				s |= Code
+			case "link":
+				href = m.Attrs.Href
+			}
+		}
+		switch n.Type {
+		case "text":
+			runs = appendRun(runs, Run{Text: n.Text, Style: s, Link: href})
+		case "hardBreak":
+			runs = appendRun(runs, Run{Text: "\n", Style: s, Link: href})
+		default:
+			runs = inline(n.Content, s, href, runs)
+		}
+	}
+	return runs
+}
+
+func appendRun(runs []Run, r Run) []Run {
+	if r.Text == "" {
+		return runs
+	}
+	if n := len(runs); n > 0 && runs[n-1].Style == r.Style && runs[n-1].Link == r.Link {
+		runs[n-1].Text += r.Text
+		return runs
+	}
+	return append(runs, r)
+}
@@ -0,0 +1,159 @@
+package richtext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []Block
+	}{
+		{
+			name: "paragraphs and heading",
+			doc: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Прошлое"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"Вырос при храме."}]},
+				{"type":"heading","attrs":{"level":9},"content":[{"type":"text","text":"Глубже"}]},
+				{"type":"paragraph"}]}`,
+			want: []Block{
+				{Kind: Heading, Level: 2, Runs: []Run{{Text: "Прошлое"}}},
+				{Kind: Paragraph, Runs: []Run{{Text: "Вырос при храме."}}},
+				{Kind: Heading, Level: 6, Runs: []Run{{Text: "Глубже"}}},
+				{Kind: Paragraph},
+			},
+		},
+		{
+			name: "mark merging",
+			doc: `{"type":"doc","content":[{"type":"paragraph","content":[
+				{"type":"text","text":"Долг ","marks":[{"type":"bold"}]},
+				{"type":"text","text":"гильдии","marks":[{"type":"strong"}]},
+				{"type":"text","text":": "},
+				{"type":"text","text":"10","marks":[{"type":"bold"},{"type":"italic"}]},
+				{"type":"text","text":" зм","marks":[{"type":"em"},{"type":"strong"}]},
+				{"type":"text","text":" ","marks":[{"type":"highlight"}]},
+				{"type":"text","text":"карта","marks":[{"type":"link","attrs":{"href":"https://example.org"}},{"type":"underline"}]},
+				{"type":"text","text":"","marks":[{"type":"code"}]},
+				{"type":"text","text":"x","marks":[{"type":"strike"},{"type":"code"}]}]}]}`,
+			want: []Block{{Kind: Paragraph, Runs: []Run{
+				{Text: "Долг гильдии", Style: Bold},
+				{Text: ": "},
+				{Text: "10 зм", Style: Bold | Italic},
+				{Text: " "},
+				{Text: "карта", Style: Underline, Link: "https://example.org"},
+				{Text: "x", Style: Strike | Code},
+			}}},
+		},
+		{
+			name: "hard break",
+			doc: `{"type":"doc","content":[{"type":"paragraph","content":[
+				{"type":"text","text":"Кольчуга"},
+				{"type":"hardBreak"},
+				{"type":"text","text":"Щит"},
+				{"type":"text","text":"!","marks":[{"type":"bold"}]},
+				{"type":"hardBreak","marks":[{"type":"bold"}]}]}]}`,
+			want: []Block{{Kind: Paragraph, Runs: []Run{
+				{Text: "Кольчуга\nЩит"},
+				{Text: "!\n", Style: Bold},
+			}}},
+		},
+		{
+			name: "nested lists",
+			doc: `{"type":"doc","content":[{"type":"bulletList","content":[
+				{"type":"listItem","content":[
+					{"type":"paragraph","content":[{"type":"text","text":"Оружие"}]},
+					{"type":"bulletList","content":[
+						{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"Меч"}]}]}]},
+					{"type":"paragraph","content":[{"type":"text","text":"в ножнах"}]}]},
+				{"type":"listItem","content":[]}]}]}`,
+			want: []Block{
+				{Kind: ListItem, Indent: 1, Marker: "•", Runs: []Run{{Text: "Оружие"}}},
+				{Kind: ListItem, Indent: 2, Marker: "•", Runs: []Run{{Text: "Меч"}}},
+				{Kind: Paragraph, Indent: 1, Runs: []Run{{Text: "в ножнах"}}},
+				{Kind: ListItem, Indent: 1, Marker: "•"},
+			},
+		},
+		{
+			name: "ordered start",
+			doc: `{"type":"doc","content":[
+				{"type":"orderedList","attrs":{"start":3},"content":[
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"третий"}]}]},
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"четвёртый"}]},
+						{"type":"orderedList","content":[
+							{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"вложенный"}]}]}]}]}]},
+				{"type":"orderedList","content":[
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"первый"}]}]}]}]}`,
+			want: []Block{
+				{Kind: ListItem, Indent: 1, Marker: "3.", Runs: []Run{{Text: "третий"}}},
+				{Kind: ListItem, Indent: 1, Marker: "4.", Runs: []Run{{Text: "четвёртый"}}},
+				{Kind: ListItem, Indent: 2, Marker: "1.", Runs: []Run{{Text: "вложенный"}}},
+				{Kind: ListItem, Indent: 1, Marker: "1.", Runs: []Run{{Text: "первый"}}},
+			},
+		},
+		{
+			name: "blockquote",
+			doc: `{"type":"doc","content":[
+				{"type":"blockquote","content":[
+					{"type":"paragraph","content":[{"type":"text","text":"Не верь магам."}]},
+					{"type":"bulletList","content":[
+						{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"никогда"}]}]}]}]},
+				{"type":"horizontalRule"},
+				{"type":"paragraph","content":[{"type":"text","text":"После"}]}]}`,
+			want: []Block{
+				{Kind: Paragraph, Quote: true, Runs: []Run{{Text: "Не верь магам."}}},
+				{Kind: ListItem, Indent: 1, Marker: "•", Quote: true, Runs: []Run{{Text: "никогда"}}},
+				{Kind: Rule},
+				{Kind: Paragraph, Runs: []Run{{Text: "После"}}},
+			},
+		},
+		{
+			name: "unknown nodes and loose text",
+			doc: `{"type":"doc","content":[
+				{"type":"details","content":[{"type":"paragraph","content":[{"type":"text","text":"внутри"}]}]},
+				{"type":"text","text":"сам"},
+				{"type":"hardBreak"},
+				{"type":"text","text":"по себе"}]}`,
+			want: []Block{
+				{Kind: Paragraph, Runs: []Run{{Text: "внутри"}}},
+				{Kind: Paragraph, Runs: []Run{{Text: "сам\nпо себе"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc.Blocks, tt.want) {
+				t.Errorf("блоки\n%+v\nожидалось\n%+v", doc.Blocks, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse([]byte(`{"type":"doc","content":`)); err == nil {
+		t.Error("ожидалась ошибка для оборванного JSON")
+	}
+}
+
+func TestLines(t *testing.T) {
+	doc, err := Parse([]byte(`{"type":"doc","content":[
+		{"type":"paragraph","content":[{"type":"text","text":" Кольчуга "},{"type":"hardBreak"},{"type":"hardBreak"},{"type":"text","text":"Щит"}]},
+		{"type":"paragraph"},
+		{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"Верёвка"}]}]}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Кольчуга", "Щит", "Верёвка"}
+	if got := doc.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Lines() = %q, ожидалось %q", got, want)
+	}
+	if got := (*Doc)(nil).Lines(); got != nil {
+		t.Errorf("Lines() пустого документа = %q", got)
+	}
+}
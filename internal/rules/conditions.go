@@ -0,0 +1,36 @@
+package rules
+
+// Condition - состояние из приложения PHB
+type Condition struct {
+	Code string // Код как в листе Long Story Short
+	Name string
+}
+
+// Conditions - состояния 5e в порядке книги
+var Conditions = []Condition{
+	{"blinded", "Ослеплён"},
+	{"charmed", "Очарован"},
+	{"deafened", "Оглох"},
+	{"exhaustion", "Истощение"},
+	{"frightened", "Испуган"},
+	{"grappled", "Схвачен"},
+	{"incapacitated", "Недееспособен"},
+	{"invisible", "Невидим"},
+	{"paralyzed", "Парализован"},
+	{"petrified", "Окаменел"},
+	{"poisoned", "Отравлен"},
+	{"prone", "Сбит с ног"},
+	{"restrained", "Опутан"},
+	{"stunned", "Ошеломлён"},
+	{"unconscious", "Без сознания"},
+}
+
+// ConditionName возвращает название состояния или сам код, если он незнаком
+func ConditionName(code string) string {
+	for _, c := range Conditions {
+		if c.Code == code {
+			return c.Name
+		}
+	}
+	return code
+}
@@ -0,0 +1,185 @@
+// Package rules считает производные числа листа персонажа по правилам D&D 5e:
+// модификаторы характеристик, бонус мастерства, спасброски, навыки,
+// пассивные чувства, сложность спасброска и бонус атаки заклинаниями.
+//
+// Пакет не знает, откуда взят лист: на вход подаётся Sheet, собранный из
+// любого формата персонажа.
+package rules
+
+import "strings"
+
+// Abilities - коды характеристик в порядке листа персонажа
+var Abilities = []string{"str", "dex", "con", "int", "wis", "cha"}
+
+// AbilityNames - названия характеристик по коду
+var AbilityNames = map[string]string{
+	"str": "Сила", "dex": "Ловкость", "con": "Телосложение",
+	"int": "Интеллект", "wis": "Мудрость", "cha": "Харизма",
+}
+
+// Skill - навык и характеристика, от которой он считается
+type Skill struct {
+	Code    string // Код как в листах Long Story Short и D&D Beyond
+	Name    string
+	Ability string
+}
+
+// Skills - навыки 5e в алфавитном порядке русских названий
+var Skills = []Skill{
+	{"acrobatics", "Акробатика", "dex"},
+	{"investigation", "Анализ", "int"},
+	{"athletics", "Атлетика", "str"},
+	{"perception", "Внимательность", "wis"},
+	{"survival", "Выживание", "wis"},
+	{"performance", "Выступление", "cha"},
+	{"intimidation", "Запугивание", "cha"},
+	{"history", "История", "int"},
+	{"sleight of hand", "Ловкость рук", "dex"},
+	{"arcana", "Магия", "int"},
+	{"medicine", "Медицина", "wis"},
+	{"deception", "Обман", "cha"},
+	{"nature", "Природа", "int"},
+	{"insight", "Проницательность", "wis"},
+	{"religion", "Религия", "int"},
+	{"stealth", "Скрытность", "dex"},
+	{"persuasion", "Убеждение", "cha"},
+	{"animal handling", "Уход за животными", "wis"},
+}
+
+// SkillAbility возвращает характеристику навыка по коду
+func SkillAbility(code string) (string, bool) {
+	for _, s := range Skills {
+		if s.Code == code {
+			return s.Ability, true
+		}
+	}
+	return "", false
+}
+
+// Proficiency - владение навыком или спасброском
+type Proficiency int
+
+const (
+	NotProficient  Proficiency = iota
+	HalfProficient             // Мастер на все руки: половина бонуса с округлением вниз
+	Proficient
+	Expertise // Компетентность: двойной бонус
+)
+
+// Bonus возвращает прибавку к броску при данном бонусе мастерства
+func (p Proficiency) Bonus(proficiency int) int {
+	switch p {
+	case HalfProficient:
+		return proficiency / 2
+	case Proficient:
+		return proficiency
+	case Expertise:
+		return 2 * proficiency
+	default:
+		return 0
+	}
+}
+
+// Sheet - то, из чего считаются производные числа
+type Sheet struct {
+	Level  int
+	Class  string
+	Scores map[string]int // Значения характеристик по коду
+	Saves  map[string]Proficiency
+	Skills map[string]Proficiency // По коду навыка
+	// Код характеристики заклинаний; пустой или неизвестный - берётся по
+	// классу, см. SpellcastingAbility
+	SpellAbility string
+}
+
+// Derived - производные числа листа
+type Derived struct {
+	Proficiency  int // Бонус мастерства
+	Modifiers    map[string]int
+	Saves        map[string]int
+	Skills       map[string]int
+	Initiative   int
+	Passive      map[string]int // Пассивные навыки: 10 + бонус навыка
+	MeleeAttack  int            // Рукопашное оружие: Сила + мастерство
+	RangedAttack int            // Дальнобойное и фехтовальное: Ловкость + мастерство
+	SpellAbility string         // Пусто, если персонаж не заклинатель
+	SpellSaveDC  int
+	SpellAttack  int
+}
+
+// Modifier - модификатор характеристики: (значение - 10) / 2 с округлением вниз
+func Modifier(score int) int {
+	d := score - 10
+	if d < 0 {
+		d--
+	}
+	return d / 2
+}
+
+// ProficiencyBonus - бонус мастерства по уровню персонажа: +2 на 1-4, +6 на 17-20
+func ProficiencyBonus(level int) int {
+	level = max(1, min(level, 20))
+	return 2 + (level-1)/4
+}
+
+// Классы, которые колдуют, и их характеристика заклинаний. Названия
+// сравниваются без учёта регистра, подходят и русские, и английские.
+var spellcasters = []struct {
+	names   []string
+	ability string
+}{
+	{[]string{"волшебник", "wizard", "изобретатель", "artificer"}, "int"},
+	{[]string{"жрец", "cleric", "друид", "druid", "следопыт", "ranger"}, "wis"},
+	{[]string{"бард", "bard", "колдун", "warlock", "чародей", "sorcerer", "паладин", "paladin"}, "cha"},
+}
+
+// SpellcastingAbility возвращает характеристику заклинаний класса или пустую
+// строку. Для мультикласса берётся первый найденный в названии класс.
+func SpellcastingAbility(class string) string {
+	class = strings.ToLower(class)
+	best, ability := len(class)+1, ""
+	for _, caster := range spellcasters {
+		for _, name := range caster.names {
+			if i := strings.Index(class, name); i >= 0 && i < best {
+				best, ability = i, caster.ability
+			}
+		}
+	}
+	return ability
+}
+
+// Derive считает все производные числа листа
+func Derive(s Sheet) Derived {
+	d := Derived{
+		Proficiency: ProficiencyBonus(s.Level),
+		Modifiers:   make(map[string]int, len(Abilities)),
+		Saves:       make(map[string]int, len(Abilities)),
+		Skills:      make(map[string]int, len(Skills)),
+		Passive:     make(map[string]int, 3),
+	}
+	for _, ab := range Abilities {
+		mod := Modifier(s.Scores[ab])
+		d.Modifiers[ab] = mod
+		d.Saves[ab] = mod + s.Saves[ab].Bonus(d.Proficiency)
+	}
+	for _, sk := range Skills {
+		d.Skills[sk.Code] = d.Modifiers[sk.Ability] + s.Skills[sk.Code].Bonus(d.Proficiency)
+	}
+	for _, code := range []string{"perception", "investigation", "insight"} {
+		d.Passive[code] = 10 + d.Skills[code]
+	}
+
+	d.Initiative = d.Modifiers["dex"]
+	d.MeleeAttack = d.Modifiers["str"] + d.Proficiency
+	d.RangedAttack = d.Modifiers["dex"] + d.Proficiency
+
+	d.SpellAbility = s.SpellAbility
+	if _, ok := AbilityNames[d.SpellAbility]; !ok {
+		d.SpellAbility = SpellcastingAbility(s.Class)
+	}
+	if d.SpellAbility != "" {
+		d.SpellAttack = d.Modifiers[d.SpellAbility] + d.Proficiency
+		d.SpellSaveDC = 8 + d.SpellAttack
+	}
+	return d
+}
@@ -0,0 +1,121 @@
+package rules
+
+import "testing"
+
+func TestModifier(t *testing.T) {
+	tests := []struct{ score, want int }{
+		{1, -5}, {2, -4}, {3, -4}, {7, -2}, {8, -1}, {9, -1},
+		{10, 0}, {11, 0}, {12, 1}, {15, 2}, {20, 5}, {30, 10},
+		{0, -5}, {-1, -6},
+	}
+	for _, tt := range tests {
+		if got := Modifier(tt.score); got != tt.want {
+			t.Errorf("Modifier(%d) = %d, ожидалось %d", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestProficiencyBonus(t *testing.T) {
+	tests := []struct{ level, want int }{
+		{-3, 2}, {0, 2}, {1, 2}, {4, 2}, {5, 3}, {8, 3}, {9, 4},
+		{12, 4}, {13, 5}, {16, 5}, {17, 6}, {20, 6}, {25, 6},
+	}
+	for _, tt := range tests {
+		if got := ProficiencyBonus(tt.level); got != tt.want {
+			t.Errorf("ProficiencyBonus(%d) = %d, ожидалось %d", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestProficiencyBonusByKind(t *testing.T) {
+	tests := []struct {
+		p           Proficiency
+		proficiency int
+		want        int
+	}{
+		{NotProficient, 3, 0},
+		{HalfProficient, 2, 1},
+		{HalfProficient, 3, 1}, // Половина округляется вниз
+		{HalfProficient, 5, 2},
+		{Proficient, 4, 4},
+		{Expertise, 3, 6},
+	}
+	for _, tt := range tests {
+		if got := tt.p.Bonus(tt.proficiency); got != tt.want {
+			t.Errorf("Proficiency(%d).Bonus(%d) = %d, ожидалось %d", tt.p, tt.proficiency, got, tt.want)
+		}
+	}
+}
+
+func TestSpellcastingAbility(t *testing.T) {
+	tests := []struct{ class, want string }{
+		{"Волшебник", "int"},
+		{"ЖРЕЦ", "wis"},
+		{"Paladin", "cha"},
+		{"Ranger 5", "wis"},
+		{"Воин", ""},
+		{"", ""},
+		// Мультикласс - первый найденный в названии класс
+		{"Паладин 3 / Волшебник 2", "cha"},
+		{"Wizard 2 / Warlock 1", "int"},
+		{"воин 4, друид 1", "wis"},
+	}
+	for _, tt := range tests {
+		if got := SpellcastingAbility(tt.class); got != tt.want {
+			t.Errorf("SpellcastingAbility(%q) = %q, ожидалось %q", tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	d := Derive(Sheet{
+		Level:  5,
+		Class:  "Бард",
+		Scores: map[string]int{"str": 8, "dex": 14, "con": 12, "int": 10, "wis": 13, "cha": 17},
+		Saves:  map[string]Proficiency{"dex": Proficient, "cha": Proficient},
+		Skills: map[string]Proficiency{
+			"perception": Expertise,
+			"insight":    HalfProficient,
+			"stealth":    Proficient,
+		},
+	})
+
+	checks := []struct {
+		name      string
+		got, want int
+	}{
+		{"мастерство", d.Proficiency, 3},
+		{"модификатор str", d.Modifiers["str"], -1},
+		{"спасбросок dex", d.Saves["dex"], 5},
+		{"спасбросок str", d.Saves["str"], -1},
+		{"внимательность", d.Skills["perception"], 7},
+		{"проницательность", d.Skills["insight"], 2},
+		{"скрытность", d.Skills["stealth"], 5},
+		{"пассивная внимательность", d.Passive["perception"], 17},
+		{"пассивный анализ", d.Passive["investigation"], 10},
+		{"инициатива", d.Initiative, 2},
+		{"рукопашная атака", d.MeleeAttack, 2},
+		{"дальнобойная атака", d.RangedAttack, 5},
+		{"атака заклинанием", d.SpellAttack, 6},
+		{"сложность спасброска", d.SpellSaveDC, 14},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %d, ожидалось %d", c.name, c.got, c.want)
+		}
+	}
+	if d.SpellAbility != "cha" {
+		t.Errorf("характеристика заклинаний %q, ожидалась cha", d.SpellAbility)
+	}
+
+	// Явная характеристика заклинаний важнее класса, неизвестная - нет
+	if got := Derive(Sheet{Class: "Бард", SpellAbility: "wis"}).SpellAbility; got != "wis" {
+		t.Errorf("явная характеристика заклинаний: %q, ожидалась wis", got)
+	}
+	if got := Derive(Sheet{Class: "Бард", SpellAbility: "luck"}).SpellAbility; got != "cha" {
+		t.Errorf("неизвестная характеристика заклинаний: %q, ожидалась cha", got)
+	}
+	if d := Derive(Sheet{Class: "Воин"}); d.SpellAbility != "" || d.SpellSaveDC != 0 {
+		t.Errorf("воин не заклинатель: %q, сложность %d", d.SpellAbility, d.SpellSaveDC)
+	}
+}
@@ -0,0 +1,427 @@
+package wfc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	_ "image/png"
+)
+
+// OverlappingOptions описывает параметры overlapping-модели,
+// которая обучается на образце вместо ручных правил соседства.
+type OverlappingOptions struct {
+	Sample        [][]int // Образец: ID тайлов, [y][x]
+	N             int     // Размер паттерна NxN
+	Width         int
+	Height        int
+	Seed          int64
+	PeriodicInput bool // Образец "заворачивается" по краям
+	Symmetry      int  // 1 - без преобразований, до 8 - все повороты и отражения
+	MaxAttempts   int  // Сколько раз перезапускать генерацию при противоречии (по умолчанию 3)
+	Iterations    int  // Общий бюджет коллапсов на все попытки (0 - без ограничений)
+}
+
+// OverlappingModel генерирует сетку в стиле образца по NxN паттернам
+type OverlappingModel struct {
+	options   OverlappingOptions
+	patterns  [][]int   // Паттерны, развёрнутые построчно
+	weights   []float64 // Частоты паттернов в образце
+	agrees    [4][][]int
+	wave      [][]bool
+	count     []int
+	sums      []float64 // Сумма весов оставшихся паттернов клетки
+	logSums   []float64 // Сумма w*log(w) оставшихся паттернов клетки
+	supported []bool    // Буфер propagate, чтобы не выделять память на каждом шаге
+	rng       *rand.Rand
+}
+
+// NewOverlapping извлекает паттерны из образца и готовит модель к запуску
+func NewOverlapping(options OverlappingOptions) (*OverlappingModel, error) {
+	if len(options.Sample) == 0 || len(options.Sample[0]) == 0 {
+		return nil, fmt.Errorf("пустой образец")
+	}
+	if options.N <= 0 {
+		options.N = 3
+	}
+	if options.Symmetry <= 0 || options.Symmetry > 8 {
+		options.Symmetry = 8
+	}
+	if options.Seed == 0 {
+		options.Seed = time.Now().UnixNano()
+	}
+
+	sh, sw := len(options.Sample), len(options.Sample[0])
+	if !options.PeriodicInput && (sw < options.N || sh < options.N) {
+		return nil, fmt.Errorf("образец %dx%d меньше паттерна %d", sw, sh, options.N)
+	}
+
+	m := &OverlappingModel{
+		options: options,
+		rng:     rand.New(rand.NewSource(options.Seed)),
+	}
+	m.extractPatterns()
+	m.buildAgreements()
+
+	cells := options.Width * options.Height
+	m.wave = make([][]bool, cells)
+	m.count = make([]int, cells)
+	m.sums = make([]float64, cells)
+	m.logSums = make([]float64, cells)
+	m.supported = make([]bool, len(m.patterns))
+	for i := range m.wave {
+		m.wave[i] = make([]bool, len(m.patterns))
+	}
+
+	return m, nil
+}
+
+func (m *OverlappingModel) extractPatterns() {
+	n := m.options.N
+	sample := m.options.Sample
+	sh, sw := len(sample), len(sample[0])
+
+	maxX, maxY := sw-n+1, sh-n+1
+	if m.options.PeriodicInput {
+		maxX, maxY = sw, sh
+	}
+
+	index := make(map[string]int)
+	for y := 0; y < maxY; y++ {
+		for x := 0; x < maxX; x++ {
+			base := make([]int, n*n)
+			for dy := 0; dy < n; dy++ {
+				for dx := 0; dx < n; dx++ {
+					base[dy*n+dx] = sample[(y+dy)%sh][(x+dx)%sw]
+				}
+			}
+
+			for _, p := range patternVariants(base, n)[:m.options.Symmetry] {
+				key := fmt.Sprint(p)
+				if i, ok := index[key]; ok {
+					m.weights[i]++
+					continue
+				}
+				index[key] = len(m.patterns)
+				m.patterns = append(m.patterns, p)
+				m.weights = append(m.weights, 1)
+			}
+		}
+	}
+}
+
+// patternVariants возвращает 8 вариантов паттерна: повороты и отражения
+func patternVariants(p []int, n int) [][]int {
+	rotate := func(p []int) []int {
+		r := make([]int, len(p))
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				r[y*n+x] = p[(n-1-x)*n+y]
+			}
+		}
+		return r
+	}
+	reflect := func(p []int) []int {
+		r := make([]int, len(p))
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				r[y*n+x] = p[y*n+n-1-x]
+			}
+		}
+		return r
+	}
+
+	variants := make([][]int, 8)
+	variants[0] = p
+	variants[1] = reflect(p)
+	for i := 2; i < 8; i += 2 {
+		variants[i] = rotate(variants[i-2])
+		variants[i+1] = reflect(variants[i])
+	}
+	return variants
+}
+
+// overlaps проверяет, совпадают ли паттерны a и b при сдвиге b на (dx, dy)
+func (m *OverlappingModel) overlaps(a, b []int, dx, dy int) bool {
+	n := m.options.N
+	for y := max(0, dy); y < min(n, n+dy); y++ {
+		for x := max(0, dx); x < min(n, n+dx); x++ {
+			if a[y*n+x] != b[(y-dy)*n+(x-dx)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *OverlappingModel) buildAgreements() {
+	for d, dir := range directions {
+		m.agrees[d] = make([][]int, len(m.patterns))
+		for a := range m.patterns {
+			for b := range m.patterns {
+				if m.overlaps(m.patterns[a], m.patterns[b], dir[0], dir[1]) {
+					m.agrees[d][a] = append(m.agrees[d][a], b)
+				}
+			}
+		}
+	}
+}
+
+// PatternCount возвращает число уникальных паттернов, найденных в образце
+func (m *OverlappingModel) PatternCount() int {
+	return len(m.patterns)
+}
+
+// Run генерирует сетку. Перезапуски и бюджет работают как у WaveFunction.Run:
+// при противоречии генерация начинается заново с производным сидом, а если
+// все попытки неудачны, клетки без вариантов перечисляются в Result.Forced.
+func (m *OverlappingModel) Run() Result {
+	result := runAttempts(m.options.Seed, m.options.MaxAttempts, m.options.Iterations,
+		func(rng *rand.Rand, budget int) (bool, int, bool) {
+			m.rng = rng
+			m.reset()
+			ok, used := m.observeAll(budget)
+			return ok, used, false
+		})
+	if !result.Success {
+		w := m.options.Width
+		for i, c := range m.count {
+			if c == 0 {
+				result.Forced = append(result.Forced, [2]int{i % w, i / w})
+			}
+		}
+	}
+	result.Grid = m.result()
+	return result
+}
+
+func (m *OverlappingModel) reset() {
+	sum, logSum := 0.0, 0.0
+	for _, w := range m.weights {
+		sum += w
+		logSum += w * math.Log(w)
+	}
+	for i := range m.wave {
+		for p := range m.wave[i] {
+			m.wave[i][p] = true
+		}
+		m.count[i] = len(m.patterns)
+		m.sums[i] = sum
+		m.logSums[i] = logSum
+	}
+}
+
+// ban запрещает паттерн p в клетке и обновляет её суммы для энтропии
+func (m *OverlappingModel) ban(cell, p int) {
+	w := m.weights[p]
+	m.wave[cell][p] = false
+	m.count[cell]--
+	m.sums[cell] -= w
+	m.logSums[cell] -= w * math.Log(w)
+}
+
+// observeAll коллапсирует клетки, пока волна не определится, не возникнет
+// противоречие или не кончится budget (budget < 0 - без ограничений)
+func (m *OverlappingModel) observeAll(budget int) (bool, int) {
+	used := 0
+	for {
+		if budget >= 0 && used >= budget {
+			return false, used
+		}
+		cell := m.minEntropyCell()
+		if cell == -1 {
+			return true, used
+		}
+		if m.count[cell] == 0 {
+			return false, used
+		}
+
+		used++
+		m.observe(cell)
+		if !m.propagate(cell) {
+			return false, used
+		}
+	}
+}
+
+// minEntropyCell выбирает неопределённую клетку с наименьшей энтропией Шеннона.
+// Возвращает -1, если все клетки определены.
+func (m *OverlappingModel) minEntropyCell() int {
+	best := -1
+	minEntropy := math.MaxFloat64
+	for i := range m.wave {
+		if m.count[i] == 0 {
+			return i
+		}
+		if m.count[i] == 1 {
+			continue
+		}
+
+		sum := m.sums[i]
+		entropy := math.Log(sum) - m.logSums[i]/sum + m.rng.Float64()*1e-6
+		if entropy < minEntropy {
+			minEntropy = entropy
+			best = i
+		}
+	}
+	return best
+}
+
+func (m *OverlappingModel) observe(cell int) {
+	total := 0.0
+	for p, ok := range m.wave[cell] {
+		if ok {
+			total += m.weights[p]
+		}
+	}
+
+	r := m.rng.Float64() * total
+	chosen := -1
+	for p, ok := range m.wave[cell] {
+		if !ok {
+			continue
+		}
+		chosen = p
+		r -= m.weights[p]
+		if r <= 0 {
+			break
+		}
+	}
+
+	for p, ok := range m.wave[cell] {
+		if ok && p != chosen {
+			m.ban(cell, p)
+		}
+	}
+}
+
+func (m *OverlappingModel) propagate(start int) bool {
+	w, h := m.options.Width, m.options.Height
+	stack := []int{start}
+
+	for len(stack) > 0 {
+		cell := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		cx, cy := cell%w, cell/w
+
+		for d, dir := range directions {
+			nx, ny := cx+dir[0], cy+dir[1]
+			if nx < 0 || ny < 0 || nx >= w || ny >= h {
+				continue
+			}
+			neighbor := ny*w + nx
+
+			// Паттерн соседа допустим, если его поддерживает хотя бы один паттерн клетки
+			supported := m.supported
+			for q := range supported {
+				supported[q] = false
+			}
+			for p, ok := range m.wave[cell] {
+				if !ok {
+					continue
+				}
+				for _, q := range m.agrees[d][p] {
+					supported[q] = true
+				}
+			}
+
+			changed := false
+			for q, ok := range m.wave[neighbor] {
+				if ok && !supported[q] {
+					m.ban(neighbor, q)
+					changed = true
+				}
+			}
+
+			if m.count[neighbor] == 0 {
+				return false
+			}
+			if changed {
+				stack = append(stack, neighbor)
+			}
+		}
+	}
+	return true
+}
+
+func (m *OverlappingModel) result() [][]int {
+	w := m.options.Width
+	grid := make([][]int, m.options.Height)
+	for y := range grid {
+		grid[y] = make([]int, w)
+		for x := range grid[y] {
+			for p, ok := range m.wave[y*w+x] {
+				if ok {
+					grid[y][x] = m.patterns[p][0]
+					break
+				}
+			}
+		}
+	}
+	return grid
+}
+
+// PaletteFromTiles сопоставляет цвета тайлов их ID для чтения образцов
+func PaletteFromTiles(tiles []Tile) map[color.RGBA]int {
+	palette := make(map[color.RGBA]int, len(tiles))
+	for _, tile := range tiles {
+		palette[tile.Color] = tile.ID
+	}
+	return palette
+}
+
+// SampleFromImage переводит картинку в сетку ID тайлов.
+// Цвета, которых нет в палитре, привязываются к ближайшему цвету.
+func SampleFromImage(img image.Image, palette map[color.RGBA]int) ([][]int, error) {
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("пустая палитра")
+	}
+
+	bounds := img.Bounds()
+	sample := make([][]int, bounds.Dy())
+	for y := range sample {
+		sample[y] = make([]int, bounds.Dx())
+		for x := range sample[y] {
+			c := color.RGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.RGBA)
+			sample[y][x] = nearestPaletteTile(c, palette)
+		}
+	}
+	return sample, nil
+}
+
+func nearestPaletteTile(c color.RGBA, palette map[color.RGBA]int) int {
+	if id, ok := palette[c]; ok {
+		return id
+	}
+
+	best, bestDist := 0, math.MaxInt
+	for pc, id := range palette {
+		dr := int(c.R) - int(pc.R)
+		dg := int(c.G) - int(pc.G)
+		db := int(c.B) - int(pc.B)
+		dist := dr*dr + dg*dg + db*db
+		if dist < bestDist || (dist == bestDist && id < best) {
+			best, bestDist = id, dist
+		}
+	}
+	return best
+}
+
+// LoadSample читает PNG-образец с диска
+func LoadSample(path string, palette map[color.RGBA]int) ([][]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия образца: %v", err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка декодирования образца: %v", err)
+	}
+	return SampleFromImage(img, palette)
+}
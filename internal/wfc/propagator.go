@@ -0,0 +1,144 @@
+package wfc
+
+import (
+	"container/heap"
+	"math"
+)
+
+// Смещения соседей: вверх, вправо, вниз, влево
+var directions = [4][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}
+
+// opposite[d] - направление, противоположное d
+var opposite = [4]int{2, 3, 0, 1}
+
+// buildCompatibility строит индекс совместимости: propagator[d][a] - тайлы,
+// допустимые в направлении d от тайла a. Распространение идёт по этим спискам
+// через счётчики поддержки. Части одного большого тайла всегда совместимы
+// друг с другом.
+func (wf *WaveFunction) buildCompatibility() {
+	n := len(wf.tiles)
+	for d, dir := range directions {
+		wf.propagator[d] = make([][]int, n)
+		for a := 0; a < n; a++ {
+			for b := 0; b < n; b++ {
+				if wf.areCompatible(a, b, dir) || (a == b && wf.tiles[a].Size > 1) {
+					wf.propagator[d][a] = append(wf.propagator[d][a], b)
+				}
+			}
+		}
+	}
+
+	// initialSupport[d][t] - сколько тайлов соседа в направлении d поддерживают t
+	for d := range directions {
+		wf.initialSupport[d] = make([]int32, n)
+		for b := 0; b < n; b++ {
+			for _, t := range wf.propagator[opposite[d]][b] {
+				wf.initialSupport[d][t]++
+			}
+		}
+	}
+}
+
+// supportIndex возвращает позицию счётчика поддержки тайла t клетки cell из направления d
+func (wf *WaveFunction) supportIndex(cell, t, d int) int {
+	return (cell*len(wf.tiles)+t)*4 + d
+}
+
+// neighbor возвращает индекс соседней клетки в направлении d или -1 за краем
+func (wf *WaveFunction) neighbor(cell, d int) int {
+	x := cell%wf.options.Width + directions[d][0]
+	y := cell/wf.options.Width + directions[d][1]
+	if x < 0 || y < 0 || x >= wf.options.Width || y >= wf.options.Height {
+		return -1
+	}
+	return y*wf.options.Width + x
+}
+
+// ban запрещает тайл t в клетке и ставит запрет в очередь распространения
+func (wf *WaveFunction) ban(cell, t int) {
+	i := cell*len(wf.tiles) + t
+	if !wf.wave[i] {
+		return
+	}
+	wf.wave[i] = false
+	wf.stack = append(wf.stack, [2]int{cell, t})
+
+	wf.counts[cell]--
+	if wf.counts[cell] == 0 {
+		wf.contradiction = true
+		return
+	}
+
+	w := wf.weights[t]
+	wf.sumWeights[cell] -= w
+	wf.sumLogWeights[cell] -= w * math.Log(w)
+	sum := wf.sumWeights[cell]
+	wf.entropies[cell] = math.Log(sum) - wf.sumLogWeights[cell]/sum + wf.noise[cell]
+
+	if wf.counts[cell] > 1 {
+		heap.Push(&wf.heap, entropyEntry{cell: cell, entropy: wf.entropies[cell]})
+	}
+}
+
+// propagate разбирает очередь запретов в стиле AC-3: каждый запрет уменьшает
+// счётчики поддержки соседей, и тайлы без поддержки запрещаются следом.
+// Возвращает false при противоречии.
+func (wf *WaveFunction) propagate() bool {
+	for len(wf.stack) > 0 && !wf.contradiction {
+		top := wf.stack[len(wf.stack)-1]
+		wf.stack = wf.stack[:len(wf.stack)-1]
+		cell, t := top[0], top[1]
+
+		for d := range directions {
+			next := wf.neighbor(cell, d)
+			if next == -1 {
+				continue
+			}
+			for _, t2 := range wf.propagator[d][t] {
+				k := wf.supportIndex(next, t2, opposite[d])
+				wf.supports[k]--
+				if wf.supports[k] == 0 {
+					wf.ban(next, t2)
+				}
+			}
+		}
+	}
+	wf.stack = wf.stack[:0]
+	return !wf.contradiction
+}
+
+// entropyEntry - запись кучи; устаревшие записи отбрасываются при извлечении
+type entropyEntry struct {
+	cell    int
+	entropy float64
+}
+
+// entropyHeap - min-куча клеток по энтропии Шеннона
+type entropyHeap []entropyEntry
+
+func (h entropyHeap) Len() int           { return len(h) }
+func (h entropyHeap) Less(i, j int) bool { return h[i].entropy < h[j].entropy }
+func (h entropyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *entropyHeap) Push(x any) {
+	*h = append(*h, x.(entropyEntry))
+}
+
+func (h *entropyHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// popMinEntropyCell возвращает неопределённую клетку с минимальной энтропией
+// или -1, если таких не осталось
+func (wf *WaveFunction) popMinEntropyCell() int {
+	for wf.heap.Len() > 0 {
+		e := heap.Pop(&wf.heap).(entropyEntry)
+		if wf.counts[e.cell] > 1 && wf.collapsed[e.cell] == -1 && e.entropy == wf.entropies[e.cell] {
+			return e.cell
+		}
+	}
+	return -1
+}
@@ -2,6 +2,7 @@
 package wfc
 
 import (
+	"container/heap"
 	"fmt"
 	"image/color"
 	"math"
@@ -21,7 +22,7 @@ type Tile struct {
 	ID          int
 	Name        string
 	Color       color.RGBA
-	Rules       map[int][]int // Базовые правила соседства
+	Rules       map[int][]int // Правила соседства: ключи - ID тайлов, которые могут стоять рядом
 	Size        int           // 1 для 1x1, 2 для 2x2 и т.д.
 	Weight      float64
 	ClusterSize int // Предпочтительный размер кластера
@@ -33,18 +34,73 @@ type WFCOptions struct {
 	Height       int
 	Tiles        []Tile
 	Seed         int64
-	Iterations   int
+	Iterations   int     // Общий бюджет коллапсов на все попытки (0 - без ограничений)
 	UrbanDensity float64 // 0.0 - 1.0
+	MaxAttempts  int     // Сколько раз перезапускать генерацию при противоречии (по умолчанию 3)
+
+	// Ограничения, применяемые до начала коллапса. Тайлы задаются через ID.
+	Fixed      []FixedCell       // Заранее закреплённые клетки
+	Masks      []CellMask        // Допустимые тайлы для отдельных клеток
+	Borders    BorderConstraints // Допустимые тайлы вдоль краёв карты
+	Region     [][]bool          // Форма города: клетки с false получают RegionFill
+	RegionFill int               // ID тайла для клеток вне Region
+}
+
+// FixedCell закрепляет тайл в клетке до начала генерации
+type FixedCell struct {
+	X, Y int
+	Tile int
+}
+
+// CellMask ограничивает набор тайлов, допустимых в клетке
+type CellMask struct {
+	X, Y    int
+	Allowed []int
+}
+
+// BorderConstraints задаёт допустимые тайлы вдоль каждого края; nil - без ограничений
+type BorderConstraints struct {
+	Top    []int
+	Right  []int
+	Bottom []int
+	Left   []int
+}
+
+// Result описывает итог генерации
+type Result struct {
+	Grid      [][]int
+	Success   bool     // Все клетки собраны без нарушения правил
+	Attempts  int      // Сколько попыток потрачено
+	Collapses int      // Сколько коллапсов потрачено из бюджета Iterations
+	Forced    [][2]int // Клетки {x, y}, заполненные принудительно в обход правил
 }
 
 type WaveFunction struct {
-	grid         [][][]bool
-	collapsed    [][]int
-	entropy      [][]float64
 	tiles        []Tile
 	rng          *rand.Rand
 	options      WFCOptions
 	urbanWeights []float64
+	weights      []float64 // Веса для энтропии (urbanWeights, но строго > 0)
+
+	// Индекс совместимости, строится один раз в NewWFC
+	propagator     [4][][]int
+	initialSupport [4][]int32
+
+	// Состояние попытки; клетки хранятся плоско: cell = y*Width + x
+	wave          []bool  // [cell*len(tiles) + t]
+	supports      []int32 // [(cell*len(tiles) + t)*4 + d]
+	counts        []int
+	sumWeights    []float64
+	sumLogWeights []float64
+	entropies     []float64
+	noise         []float64
+	collapsed     []int // Индекс тайла, явно размещённого в клетке, или -1
+	heap          entropyHeap
+	stack         [][2]int
+	contradiction bool
+
+	tileIndex         map[int]int // ID тайла -> индекс в tiles
+	constraintsFailed bool        // Ограничения противоречат друг другу или правилам
 }
 
 func NewWFC(options WFCOptions) *WaveFunction {
@@ -52,36 +108,36 @@ func NewWFC(options WFCOptions) *WaveFunction {
 		options.Seed = time.Now().UnixNano()
 	}
 
+	cells := options.Width * options.Height
+	n := len(options.Tiles)
 	wf := &WaveFunction{
-		grid:      make([][][]bool, options.Height),
-		collapsed: make([][]int, options.Height),
-		entropy:   make([][]float64, options.Height),
-		tiles:     options.Tiles,
-		rng:       rand.New(rand.NewSource(options.Seed)),
-		options:   options,
+		tiles:         options.Tiles,
+		rng:           rand.New(rand.NewSource(options.Seed)),
+		options:       options,
+		wave:          make([]bool, cells*n),
+		supports:      make([]int32, cells*n*4),
+		counts:        make([]int, cells),
+		sumWeights:    make([]float64, cells),
+		sumLogWeights: make([]float64, cells),
+		entropies:     make([]float64, cells),
+		noise:         make([]float64, cells),
+		collapsed:     make([]int, cells),
 	}
 
-	wf.initUrbanWeights()
-
-	for y := 0; y < options.Height; y++ {
-		wf.grid[y] = make([][]bool, options.Width)
-		wf.collapsed[y] = make([]int, options.Width)
-		wf.entropy[y] = make([]float64, options.Width)
-		for x := 0; x < options.Width; x++ {
-			wf.grid[y][x] = make([]bool, len(options.Tiles))
-			for t := range options.Tiles {
-				wf.grid[y][x][t] = true
-			}
-			wf.collapsed[y][x] = -1
-			wf.entropy[y][x] = wf.calculateInitialEntropy(x, y)
-		}
+	wf.tileIndex = make(map[int]int, n)
+	for i, tile := range options.Tiles {
+		wf.tileIndex[tile.ID] = i
 	}
 
+	wf.initUrbanWeights()
+	wf.buildCompatibility()
+
 	return wf
 }
 
 func (wf *WaveFunction) initUrbanWeights() {
 	wf.urbanWeights = make([]float64, len(wf.tiles))
+	wf.weights = make([]float64, len(wf.tiles))
 	for i, tile := range wf.tiles {
 		baseWeight := tile.Weight
 
@@ -91,18 +147,10 @@ func (wf *WaveFunction) initUrbanWeights() {
 		default:
 			wf.urbanWeights[i] = baseWeight * (1.0 - wf.options.UrbanDensity*0.5)
 		}
-	}
-}
 
-func (wf *WaveFunction) calculateInitialEntropy(x, y int) float64 {
-	entropy := 0.0
-	for t := range wf.tiles {
-		if wf.tiles[t].Weight <= 0 {
-			continue
-		}
-		entropy += wf.tiles[t].Weight
+		// Нулевой вес ломает логарифм в энтропии
+		wf.weights[i] = math.Max(wf.urbanWeights[i], 1e-6)
 	}
-	return entropy
 }
 
 func CreateOrganicCityTiles() []Tile {
@@ -178,7 +226,11 @@ func CreateOrganicCityTiles() []Tile {
 	}
 }
 
-func (wf *WaveFunction) areCompatible(tileA, tileB int, dir [2]int) bool {
+// areCompatible проверяет соседство тайлов по их индексам в wf.tiles.
+// Правила в Tile.Rules записаны через ID, поэтому индексы переводим в ID.
+func (wf *WaveFunction) areCompatible(a, b int, dir [2]int) bool {
+	tileA, tileB := wf.tiles[a].ID, wf.tiles[b].ID
+
 	// Специальные правила для воды
 	if tileA == TileWater || tileB == TileWater {
 		// Вода не должна быть рядом с жилыми зданиями
@@ -188,70 +240,91 @@ func (wf *WaveFunction) areCompatible(tileA, tileB int, dir [2]int) bool {
 		}
 	}
 	// Если у тайла нет правил - разрешаем любое соседство
-	if len(wf.tiles[tileA].Rules) == 0 || len(wf.tiles[tileB].Rules) == 0 {
+	if len(wf.tiles[a].Rules) == 0 || len(wf.tiles[b].Rules) == 0 {
 		return true
 	}
 
-	// Проверяем правила для tileA
-	if allowed, exists := wf.tiles[tileA].Rules[tileB]; exists {
-		for _, allowedTile := range allowed {
-			if allowedTile == tileB {
-				return true
-			}
-		}
-	}
-
-	// Проверяем правила для tileB
-	if allowed, exists := wf.tiles[tileB].Rules[tileA]; exists {
-		for _, allowedTile := range allowed {
-			if allowedTile == tileA {
-				return true
-			}
-		}
+	// Соседство разрешено, если хотя бы один из тайлов перечисляет другой в правилах
+	if _, exists := wf.tiles[a].Rules[tileB]; exists {
+		return true
 	}
-
-	return false
+	_, exists := wf.tiles[b].Rules[tileA]
+	return exists
 }
 
-// Улучшенный метод collapseCell с обработкой ошибок
-func (wf *WaveFunction) collapseCell(x, y int) bool {
-	possible := wf.getPossibleTiles(x, y)
+// collapseCell выбирает тайл для клетки и запрещает остальные.
+// Возвращает false при противоречии, когда допустимых тайлов не осталось.
+func (wf *WaveFunction) collapseCell(cell int) bool {
+	possible := wf.getPossibleTiles(cell)
 	if len(possible) == 0 {
-		wf.setTile(x, y, TileGrass)
-		return true
+		return false
 	}
 
-	// Взвешенный случайный выбор
+	// Взвешенный случайный выбор с учётом кластеров и минимальных расстояний
 	totalWeight := 0.0
 	weights := make([]float64, len(possible))
 	for i, t := range possible {
-		weights[i] = wf.tiles[t].Weight
+		weights[i] = wf.placementWeight(cell, t)
 		totalWeight += weights[i]
 	}
 
+	chosen := possible[len(possible)-1]
 	r := wf.rng.Float64() * totalWeight
-	cumWeight := 0.0
 	for i, t := range possible {
-		cumWeight += weights[i]
-		if r <= cumWeight {
-			wf.setTile(x, y, t)
-			return true
+		r -= weights[i]
+		if r <= 0 {
+			chosen = t
+			break
 		}
 	}
 
-	// На всякий случай
-	wf.setTile(x, y, possible[0])
-	return true
+	wf.setTile(cell, chosen)
+	return wf.propagate()
+}
+
+// placementWeight возвращает вес тайла для конкретной клетки
+func (wf *WaveFunction) placementWeight(cell, t int) float64 {
+	tile := wf.tiles[t]
+	weight := wf.urbanWeights[t]
+	x, y := cell%wf.options.Width, cell/wf.options.Width
+
+	// Плавное увеличение веса для больших зданий
+	if tile.Size > 1 {
+		weight *= math.Pow(1.1, float64(tile.Size))
+	}
+
+	// Более мягкое влияние кластеров: бонус растёт до предпочтительного размера кластера
+	if tile.ClusterSize > 1 {
+		sameNeighbors := wf.countNeighbors(x, y, t, 1)
+		weight *= 1.0 + float64(min(sameNeighbors, tile.ClusterSize))*0.15
+	}
+
+	// Более строгое минимальное расстояние
+	if tile.MinDistance > 0 && wf.countNeighbors(x, y, t, tile.MinDistance) > 0 {
+		weight *= 0.1
+	}
+
+	return math.Max(weight, 1e-6)
 }
 
-func (wf *WaveFunction) canPlaceLargeTile(x, y, size int) bool {
+// canPlaceTile проверяет, что весь след тайла помещается в сетку,
+// ещё не занят и допускает этот тайл
+func (wf *WaveFunction) canPlaceTile(cell, t int) bool {
+	size := wf.tiles[t].Size
+	if size <= 1 {
+		return true
+	}
+
+	x, y := cell%wf.options.Width, cell/wf.options.Width
 	if x+size > wf.options.Width || y+size > wf.options.Height {
 		return false
 	}
 
+	n := len(wf.tiles)
 	for dy := 0; dy < size; dy++ {
 		for dx := 0; dx < size; dx++ {
-			if wf.collapsed[y+dy][x+dx] != -1 {
+			c := (y+dy)*wf.options.Width + x + dx
+			if wf.collapsed[c] != -1 || !wf.wave[c*n+t] {
 				return false
 			}
 		}
@@ -259,294 +332,365 @@ func (wf *WaveFunction) canPlaceLargeTile(x, y, size int) bool {
 	return true
 }
 
-func (wf *WaveFunction) setTile(x, y, tileID int) {
-	tile := wf.tiles[tileID]
-
-	// Для больших зданий заполняем всю площадь
-	for dy := 0; dy < tile.Size; dy++ {
-		for dx := 0; dx < tile.Size; dx++ {
-			if y+dy < wf.options.Height && x+dx < wf.options.Width {
-				wf.collapsed[y+dy][x+dx] = tileID
-				wf.entropy[y+dy][x+dx] = 0
-				// Запрещаем все другие тайлы в этой области
-				for t := range wf.grid[y+dy][x+dx] {
-					wf.grid[y+dy][x+dx][t] = (t == tileID)
+// setTile размещает тайл в клетке (и во всём следе большого тайла),
+// запрещая в этих клетках все остальные тайлы
+func (wf *WaveFunction) setTile(cell, t int) {
+	size := wf.tiles[t].Size
+	if size < 1 {
+		size = 1
+	}
+	x, y := cell%wf.options.Width, cell/wf.options.Width
+
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			if y+dy >= wf.options.Height || x+dx >= wf.options.Width {
+				continue
+			}
+			c := (y+dy)*wf.options.Width + x + dx
+			wf.collapsed[c] = t
+			for other := range wf.tiles {
+				if other != t {
+					wf.ban(c, other)
 				}
 			}
 		}
 	}
 }
 
-// isCollapsed checks if a cell is collapsed
-func (wf *WaveFunction) isCollapsed(x, y int) bool {
-	return wf.collapsed[y][x] != -1
+// getPossibleTiles возвращает список тайлов, которые можно поставить в клетку
+func (wf *WaveFunction) getPossibleTiles(cell int) []int {
+	var possible []int
+	n := len(wf.tiles)
+	for t := 0; t < n; t++ {
+		if wf.wave[cell*n+t] && wf.canPlaceTile(cell, t) {
+			possible = append(possible, t)
+		}
+	}
+	return possible
 }
 
-func (wf *WaveFunction) propagate(x, y int) {
-	stack := [][2]int{{x, y}}
-	visited := make(map[[2]int]bool)
+// Run собирает сетку. При противоречии генерация перезапускается с производным
+// сидом, пока не кончатся попытки или бюджет Iterations. Если собрать сетку
+// без нарушений не удалось, оставшиеся клетки заполняются принудительно
+// и перечисляются в Result.Forced.
+func (wf *WaveFunction) Run() Result {
+	result := runAttempts(wf.options.Seed, wf.options.MaxAttempts, wf.options.Iterations,
+		func(rng *rand.Rand, budget int) (bool, int, bool) {
+			wf.rng = rng
+			wf.reset()
+			ok, used := wf.runAttempt(budget)
+			if !ok && wf.constraintsFailed {
+				fmt.Println("WFC: ограничения несовместимы с правилами, перезапуск не поможет")
+				return false, used, true
+			}
+			return ok, used, false
+		})
+	if !result.Success {
+		result.Forced = wf.forceRemaining()
+	}
+	result.Grid = wf.getResultGrid()
+	return result
+}
 
-	for len(stack) > 0 {
-		cx, cy := stack[len(stack)-1][0], stack[len(stack)-1][1]
-		stack = stack[:len(stack)-1]
+// attemptFunc выполняет одну попытку генерации с генератором rng не более чем
+// за budget коллапсов (budget < 0 - без ограничений). Возвращает успех, число
+// потраченных коллапсов и stop, если перезапуск заведомо не поможет.
+type attemptFunc func(rng *rand.Rand, budget int) (ok bool, used int, stop bool)
+
+// runAttempts - общий для обеих моделей цикл перезапусков: попытка attempt
+// получает генератор с сидом deriveSeed(seed, attempt), пока не кончатся
+// maxAttempts попыток (по умолчанию 3) или общий бюджет iterations коллапсов.
+// Grid и Forced заполняет вызывающий.
+func runAttempts(seed int64, maxAttempts, iterations int, attempt attemptFunc) Result {
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	budget := iterations
+	if budget <= 0 {
+		budget = -1
+	}
 
-		if visited[[2]int{cx, cy}] {
-			continue
+	result := Result{}
+	for i := 0; i < maxAttempts && budget != 0; i++ {
+		result.Attempts++
+		ok, used, stop := attempt(rand.New(rand.NewSource(deriveSeed(seed, i))), budget)
+		result.Collapses += used
+		if budget > 0 {
+			budget -= used
 		}
-		visited[[2]int{cx, cy}] = true
-
-		// Проверяем всех 4-х соседей
-		for _, dir := range [][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}} {
-			nx, ny := cx+dir[0], cy+dir[1]
 
-			if nx < 0 || ny < 0 || nx >= wf.options.Width || ny >= wf.options.Height {
-				continue
-			}
+		if ok {
+			result.Success = true
+			return result
+		}
+		if stop {
+			break
+		}
+		fmt.Printf("WFC: противоречие в попытке %d, перезапуск\n", i+1)
+	}
 
-			if wf.collapsed[ny][nx] != -1 {
-				continue
-			}
+	fmt.Println("WFC: попытки или бюджет исчерпаны, заполняем оставшиеся клетки принудительно")
+	return result
+}
 
-			changed := false
-			for t := 0; t < len(wf.tiles); t++ {
-				if !wf.grid[ny][nx][t] {
-					continue
-				}
+// runAttempt выполняет одну попытку генерации не более чем за budget коллапсов
+// (budget < 0 - без ограничений). Возвращает успех и число потраченных коллапсов.
+func (wf *WaveFunction) runAttempt(budget int) (bool, int) {
+	used := 0
+	if !wf.applyConstraints() {
+		wf.constraintsFailed = true
+		return false, used
+	}
 
-				compatible := false
-				// Проверяем совместимость с текущей клеткой
-				for ct := 0; ct < len(wf.tiles); ct++ {
-					if !wf.grid[cy][cx][ct] {
-						continue
-					}
-					if wf.areCompatible(ct, t, dir) {
-						compatible = true
-						break
-					}
-				}
+	for {
+		if budget >= 0 && used >= budget {
+			return false, used
+		}
 
-				if !compatible {
-					wf.grid[ny][nx][t] = false
-					wf.updateEntropy(ny, nx)
-					changed = true
-				}
-			}
+		cell := wf.popMinEntropyCell()
+		if cell == -1 {
+			return true, used
+		}
 
-			if changed {
-				stack = append(stack, [2]int{nx, ny})
-			}
+		used++
+		if !wf.collapseCell(cell) {
+			return false, used
 		}
 	}
 }
 
-func (wf *WaveFunction) Run() [][]int {
-	fmt.Println("Starting generation...")
-	maxAttempts := 3
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		fmt.Printf("Attempt %d\n", attempt+1)
-		wf.reset()
-
-		for !wf.isFullyCollapsed() {
-			x, y := wf.findMinEntropyCell()
-			if x == -1 {
-				fmt.Println("No cell with entropy found, using fallback")
-				// Находим первую неколлапсированную клетку
-				for y := range wf.collapsed {
-					for x := range wf.collapsed[y] {
-						if wf.collapsed[y][x] == -1 {
-							wf.setTile(x, y, TileGrass)
-							break
-						}
-					}
-				}
-				continue
-			}
-
-			if !wf.collapseCell(x, y) {
-				fmt.Printf("Failed to collapse cell (%d,%d)\n", x, y)
-				wf.setTile(x, y, TileGrass) // Фолбэк
-			}
-			wf.propagate(x, y)
+// forceRemaining доводит неудачную попытку до конца: неопределённые клетки
+// коллапсируются как обычно, а клетки без вариантов получают запасной тайл.
+// Возвращает клетки, где пришлось нарушить правила.
+func (wf *WaveFunction) forceRemaining() [][2]int {
+	n := len(wf.tiles)
+	for cell := range wf.counts {
+		if wf.counts[cell] > 1 && wf.collapsed[cell] == -1 {
+			wf.contradiction = false
+			wf.collapseCell(cell)
 		}
-
-		fmt.Println("Generation completed successfully")
-		return wf.getResultGrid()
 	}
 
-	fmt.Println("Max attempts reached, returning partial result")
-	return wf.getResultGrid()
+	var forced [][2]int
+	fallback := wf.fallbackTile()
+	for cell := range wf.counts {
+		if wf.counts[cell] > 0 {
+			continue
+		}
+		wf.wave[cell*n+fallback] = true
+		wf.counts[cell] = 1
+		wf.collapsed[cell] = fallback
+		forced = append(forced, [2]int{cell % wf.options.Width, cell / wf.options.Width})
+	}
+	wf.contradiction = false
+	wf.stack = wf.stack[:0]
+	return forced
 }
 
-func (wf *WaveFunction) reset() {
-	for y := range wf.grid {
-		for x := range wf.grid[y] {
-			for t := range wf.grid[y][x] {
-				wf.grid[y][x][t] = true
-			}
-			wf.collapsed[y][x] = -1
-			wf.entropy[y][x] = wf.calculateInitialEntropy(x, y)
+// fallbackTile возвращает индекс травы или первого одноклеточного тайла
+func (wf *WaveFunction) fallbackTile() int {
+	for i, tile := range wf.tiles {
+		if tile.ID == TileGrass {
+			return i
 		}
 	}
-}
-func (wf *WaveFunction) isFullyCollapsed() bool {
-	for y := range wf.collapsed {
-		for x := range wf.collapsed[y] {
-			if wf.collapsed[y][x] == -1 {
-				return false
-			}
+	for i, tile := range wf.tiles {
+		if tile.Size <= 1 {
+			return i
 		}
 	}
-	return true
+	return 0
 }
 
-func (wf *WaveFunction) countNeighbors(x, y, neighborID, maxDist int) int {
-	count := 0
-	for dy := -maxDist; dy <= maxDist; dy++ {
-		for dx := -maxDist; dx <= maxDist; dx++ {
-			if dx == 0 && dy == 0 {
-				continue
+// deriveSeed получает сид для очередной попытки, чтобы перезапуски
+// оставались детерминированными при фиксированном исходном сиде
+func deriveSeed(seed int64, attempt int) int64 {
+	if attempt == 0 {
+		return seed
+	}
+	z := uint64(seed) + uint64(attempt)*0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return int64(z ^ (z >> 31))
+}
+
+// reset возвращает волну в исходное состояние перед новой попыткой
+func (wf *WaveFunction) reset() {
+	n := len(wf.tiles)
+	sumW, sumLogW := 0.0, 0.0
+	for _, w := range wf.weights {
+		sumW += w
+		sumLogW += w * math.Log(w)
+	}
+	baseEntropy := math.Log(sumW) - sumLogW/sumW
+
+	wf.heap = wf.heap[:0]
+	wf.stack = wf.stack[:0]
+	wf.contradiction = false
+	wf.constraintsFailed = false
+
+	for cell := range wf.counts {
+		for t := 0; t < n; t++ {
+			wf.wave[cell*n+t] = true
+			for d := range directions {
+				wf.supports[wf.supportIndex(cell, t, d)] = wf.initialSupport[d][t]
 			}
-			nx, ny := x+dx, y+dy
-			if nx >= 0 && ny >= 0 && nx < wf.options.Width && ny < wf.options.Height {
-				if wf.collapsed[ny][nx] == neighborID {
-					count++
+		}
+		wf.counts[cell] = n
+		wf.sumWeights[cell] = sumW
+		wf.sumLogWeights[cell] = sumLogW
+		wf.noise[cell] = wf.rng.Float64() * 1e-6
+		wf.entropies[cell] = baseEntropy + wf.noise[cell]
+		wf.collapsed[cell] = -1
+		wf.heap = append(wf.heap, entropyEntry{cell: cell, entropy: wf.entropies[cell]})
+	}
+	heap.Init(&wf.heap)
+
+	// Тайлы, которым изначально не хватает поддержки соседа, запрещаем сразу
+	for cell := range wf.counts {
+		for t := 0; t < n; t++ {
+			for d := range directions {
+				if wf.neighbor(cell, d) != -1 && wf.initialSupport[d][t] == 0 {
+					wf.ban(cell, t)
 				}
 			}
 		}
 	}
-	return count
 }
 
-func (wf *WaveFunction) getResultGrid() [][]int {
-	grid := make([][]int, len(wf.grid))
-	for y := range wf.grid {
-		grid[y] = make([]int, len(wf.grid[y]))
-		for x := range wf.grid[y] {
-			if wf.collapsed[y][x] == -1 {
-				grid[y][x] = 0
-			} else {
-				grid[y][x] = wf.collapsed[y][x]
+// applyConstraints применяет ограничения из WFCOptions и распространяет их
+// до первого коллапса. Возвращает false, если ограничения невыполнимы.
+func (wf *WaveFunction) applyConstraints() bool {
+	w, h := wf.options.Width, wf.options.Height
+
+	if wf.options.Region != nil {
+		fill := []int{wf.options.RegionFill}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if y < len(wf.options.Region) && x < len(wf.options.Region[y]) && !wf.options.Region[y][x] {
+					wf.restrict(y*w+x, fill)
+				}
 			}
 		}
 	}
-	return grid
-}
 
-// updateEntropy пересчитывает энтропию для указанной клетки
-func (wf *WaveFunction) updateEntropy(y, x int) {
-	if wf.collapsed[y][x] != -1 {
-		wf.entropy[y][x] = 0
-		return
+	borders := wf.options.Borders
+	for x := 0; x < w; x++ {
+		wf.restrict(x, borders.Top)
+		wf.restrict((h-1)*w+x, borders.Bottom)
+	}
+	for y := 0; y < h; y++ {
+		wf.restrict(y*w, borders.Left)
+		wf.restrict(y*w+w-1, borders.Right)
 	}
 
-	entropy := 0.0
-	for t, allowed := range wf.grid[y][x] {
-		if allowed {
-			weight := wf.urbanWeights[t]
-
-			// Плавное уменьшение веса для больших зданий
-			if wf.tiles[t].Size > 1 {
-				if !wf.canPlaceLargeTile(x, y, wf.tiles[t].Size) {
-					continue
-				}
-				weight *= math.Pow(1.1, float64(wf.tiles[t].Size))
-			}
+	for _, mask := range wf.options.Masks {
+		if wf.inBounds(mask.X, mask.Y) {
+			wf.restrict(mask.Y*w+mask.X, mask.Allowed)
+		}
+	}
 
-			// Более мягкое влияние кластеров
-			if wf.tiles[t].ClusterSize > 1 {
-				sameNeighbors := wf.countNeighbors(x, y, wf.tiles[t].ID, wf.tiles[t].ClusterSize)
-				weight *= 1.0 + float64(sameNeighbors)*0.15
-			}
+	if !wf.propagate() {
+		return false
+	}
 
-			// Более строгое минимальное расстояние
-			if wf.tiles[t].MinDistance > 0 {
-				sameInRadius := wf.countNeighbors(x, y, wf.tiles[t].ID, wf.tiles[t].MinDistance)
-				if sameInRadius > 0 {
-					weight *= 0.1
-				}
-			}
+	for _, fixed := range wf.options.Fixed {
+		t, ok := wf.tileIndex[fixed.Tile]
+		if !ok || !wf.inBounds(fixed.X, fixed.Y) {
+			fmt.Printf("WFC: пропущена закреплённая клетка (%d,%d) с тайлом %d\n", fixed.X, fixed.Y, fixed.Tile)
+			continue
+		}
 
-			entropy += weight
+		cell := fixed.Y*w + fixed.X
+		if wf.collapsed[cell] != -1 || !wf.canPlaceTile(cell, t) || !wf.wave[cell*len(wf.tiles)+t] {
+			return false
+		}
+		wf.setTile(cell, t)
+		if !wf.propagate() {
+			return false
 		}
 	}
 
-	wf.entropy[y][x] = entropy * (0.9 + wf.rng.Float64()*0.2)
+	return true
 }
 
-// getPossibleTiles возвращает список возможных тайлов для клетки
-func (wf *WaveFunction) getPossibleTiles(x, y int) []int {
-	var possible []int
+// restrict запрещает в клетке все тайлы, кроме перечисленных ID; nil - без ограничений
+func (wf *WaveFunction) restrict(cell int, allowed []int) {
+	if allowed == nil {
+		return
+	}
 
-	for t, allowed := range wf.grid[y][x] {
-		if !allowed {
-			continue
+	keep := make([]bool, len(wf.tiles))
+	for _, id := range allowed {
+		if t, ok := wf.tileIndex[id]; ok {
+			keep[t] = true
 		}
-
-		tile := wf.tiles[t]
-
-		// Для больших зданий проверяем, поместятся ли они
-		if tile.Size > 1 {
-			if !wf.canPlaceLargeTile(x, y, tile.Size) {
-				continue
-			}
+	}
+	for t := range wf.tiles {
+		if !keep[t] {
+			wf.ban(cell, t)
 		}
-
-		possible = append(possible, t)
 	}
+}
 
-	return possible
+func (wf *WaveFunction) inBounds(x, y int) bool {
+	return x >= 0 && y >= 0 && x < wf.options.Width && y < wf.options.Height
 }
 
-// Вспомогательная функция для максимума
-func max(a, b int) int {
-	if a > b {
-		return a
+// resolved возвращает индекс тайла клетки, если он уже однозначен, иначе -1
+func (wf *WaveFunction) resolved(cell int) int {
+	if wf.collapsed[cell] != -1 {
+		return wf.collapsed[cell]
 	}
-	return b
+	if wf.counts[cell] != 1 {
+		return -1
+	}
+	n := len(wf.tiles)
+	for t := 0; t < n; t++ {
+		if wf.wave[cell*n+t] {
+			return t
+		}
+	}
+	return -1
 }
 
-func (wf *WaveFunction) findMinEntropyCell() (int, int) {
-	minEntropy := math.MaxFloat64
-	var candidates [][2]int
-
-	for y := range wf.entropy {
-		for x := range wf.entropy[y] {
-			if wf.collapsed[y][x] != -1 {
+func (wf *WaveFunction) countNeighbors(x, y, tile, maxDist int) int {
+	count := 0
+	for dy := -maxDist; dy <= maxDist; dy++ {
+		for dx := -maxDist; dx <= maxDist; dx++ {
+			if dx == 0 && dy == 0 {
 				continue
 			}
-
-			// Пересчитываем энтропию на лету
-			currentEntropy := 0.0
-			for t := range wf.tiles {
-				if wf.grid[y][x][t] {
-					currentEntropy += wf.tiles[t].Weight
+			nx, ny := x+dx, y+dy
+			if nx >= 0 && ny >= 0 && nx < wf.options.Width && ny < wf.options.Height {
+				if wf.collapsed[ny*wf.options.Width+nx] == tile {
+					count++
 				}
 			}
-
-			if currentEntropy <= 0 {
-				continue
-			}
-
-			if currentEntropy < minEntropy {
-				minEntropy = currentEntropy
-				candidates = [][2]int{{x, y}}
-			} else if currentEntropy == minEntropy {
-				candidates = append(candidates, [2]int{x, y})
-			}
 		}
 	}
+	return count
+}
 
-	if len(candidates) == 0 {
-		// Если не найдено подходящих клеток, выбираем первую незаполненную
-		for y := range wf.collapsed {
-			for x := range wf.collapsed[y] {
-				if wf.collapsed[y][x] == -1 {
-					return x, y
-				}
+func (wf *WaveFunction) getResultGrid() [][]int {
+	grid := make([][]int, wf.options.Height)
+	for y := range grid {
+		grid[y] = make([]int, wf.options.Width)
+		for x := range grid[y] {
+			if t := wf.resolved(y*wf.options.Width + x); t != -1 {
+				grid[y][x] = wf.tiles[t].ID
+			} else {
+				grid[y][x] = TileEmpty
 			}
 		}
-		return -1, -1
 	}
+	return grid
+}
 
-	return candidates[wf.rng.Intn(len(candidates))][0], candidates[wf.rng.Intn(len(candidates))][1]
+// Вспомогательная функция для максимума
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
@@ -0,0 +1,266 @@
+package wfc
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func cityOptions(size int, seed int64) WFCOptions {
+	return WFCOptions{
+		Width:        size,
+		Height:       size,
+		Tiles:        CreateOrganicCityTiles(),
+		Seed:         seed,
+		UrbanDensity: 0.6,
+	}
+}
+
+// checkRules проверяет каждую пару соседних клеток собранной сетки по
+// таблице совместимости и возвращает число нарушений
+func checkRules(t *testing.T, wf *WaveFunction, grid [][]int) int {
+	t.Helper()
+	violations := 0
+	for y, row := range grid {
+		for x, id := range row {
+			a, ok := wf.tileIndex[id]
+			if !ok {
+				t.Fatalf("клетка (%d, %d): неизвестный тайл %d", x, y, id)
+			}
+			for d, dir := range directions {
+				nx, ny := x+dir[0], y+dir[1]
+				if nx < 0 || ny < 0 || ny >= len(grid) || nx >= len(grid[ny]) {
+					continue
+				}
+				b := wf.tileIndex[grid[ny][nx]]
+				if !slices.Contains(wf.propagator[d][a], b) {
+					if violations < 5 {
+						t.Errorf("(%d, %d) %s -> (%d, %d) %s: соседство запрещено правилами",
+							x, y, wf.tiles[a].Name, nx, ny, wf.tiles[b].Name)
+					}
+					violations++
+				}
+			}
+		}
+	}
+	return violations
+}
+
+func TestRunRespectsRules(t *testing.T) {
+	for _, size := range []int{20, 100, 300} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			wf := NewWFC(cityOptions(size, 42))
+			result := wf.Run()
+			if !result.Success || len(result.Forced) > 0 {
+				t.Fatalf("генерация не удалась: попыток %d, принудительно %d клеток", result.Attempts, len(result.Forced))
+			}
+			if len(result.Grid) != size || len(result.Grid[0]) != size {
+				t.Fatalf("размер сетки %dx%d, ожидалось %dx%d", len(result.Grid[0]), len(result.Grid), size, size)
+			}
+			if n := checkRules(t, wf, result.Grid); n > 0 {
+				t.Errorf("нарушений правил: %d", n)
+			}
+		})
+	}
+}
+
+func TestRunDeterministic(t *testing.T) {
+	a := NewWFC(cityOptions(50, 7)).Run()
+	b := NewWFC(cityOptions(50, 7)).Run()
+	for y := range a.Grid {
+		for x := range a.Grid[y] {
+			if a.Grid[y][x] != b.Grid[y][x] {
+				t.Fatalf("сетки с одним сидом различаются в (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+func TestRunConstraints(t *testing.T) {
+	const size = 30
+	region := make([][]bool, size)
+	for y := range region {
+		region[y] = make([]bool, size)
+		for x := range region[y] {
+			region[y][x] = x >= 5 && y >= 5 && x < size-5 && y < size-5
+		}
+	}
+
+	tests := []struct {
+		name  string
+		setup func(o *WFCOptions)
+		check func(t *testing.T, grid [][]int)
+	}{
+		{
+			name: "fixed",
+			setup: func(o *WFCOptions) {
+				o.Fixed = []FixedCell{{X: 3, Y: 4, Tile: TileWater}, {X: 10, Y: 12, Tile: TileResidential2x2}}
+			},
+			check: func(t *testing.T, grid [][]int) {
+				if grid[4][3] != TileWater {
+					t.Errorf("(3, 4) = %d, ожидалась вода", grid[4][3])
+				}
+				// Большой тайл занимает весь свой след
+				for _, c := range [][2]int{{10, 12}, {11, 12}, {10, 13}, {11, 13}} {
+					if grid[c[1]][c[0]] != TileResidential2x2 {
+						t.Errorf("%v = %d, ожидалось большое здание", c, grid[c[1]][c[0]])
+					}
+				}
+			},
+		},
+		{
+			name: "masks",
+			setup: func(o *WFCOptions) {
+				o.Masks = []CellMask{
+					{X: 7, Y: 7, Allowed: []int{TileWater}},
+					{X: 20, Y: 3, Allowed: []int{TileGrass, TileEmpty}},
+					{X: -1, Y: 100, Allowed: []int{TileWater}}, // За сеткой - пропускается
+				}
+			},
+			check: func(t *testing.T, grid [][]int) {
+				if grid[7][7] != TileWater {
+					t.Errorf("(7, 7) = %d, ожидалась вода", grid[7][7])
+				}
+				if v := grid[3][20]; v != TileGrass && v != TileEmpty {
+					t.Errorf("(20, 3) = %d, ожидалась трава или пустырь", v)
+				}
+			},
+		},
+		{
+			name: "borders",
+			setup: func(o *WFCOptions) {
+				o.Borders = BorderConstraints{
+					Top:    []int{TileGrass},
+					Right:  []int{TileGrass},
+					Bottom: []int{TileGrass, TileWater},
+					Left:   []int{TileGrass},
+				}
+			},
+			check: func(t *testing.T, grid [][]int) {
+				for i := 0; i < size; i++ {
+					if grid[0][i] != TileGrass || grid[i][0] != TileGrass || grid[i][size-1] != TileGrass {
+						t.Fatalf("на краю в позиции %d не трава: верх %d, лево %d, право %d",
+							i, grid[0][i], grid[i][0], grid[i][size-1])
+					}
+					if v := grid[size-1][i]; v != TileGrass && v != TileWater {
+						t.Fatalf("низ (%d, %d) = %d, ожидалась трава или вода", i, size-1, v)
+					}
+				}
+			},
+		},
+		{
+			name: "region",
+			setup: func(o *WFCOptions) {
+				o.Region = region
+				o.RegionFill = TileWater
+			},
+			check: func(t *testing.T, grid [][]int) {
+				for y, row := range grid {
+					for x, v := range row {
+						if !region[y][x] && v != TileWater {
+							t.Fatalf("(%d, %d) вне формы города = %d, ожидалась вода", x, y, v)
+						}
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := cityOptions(size, 11)
+			tt.setup(&options)
+			wf := NewWFC(options)
+			result := wf.Run()
+			if !result.Success || len(result.Forced) > 0 {
+				t.Fatalf("генерация не удалась: попыток %d, принудительно %d клеток", result.Attempts, len(result.Forced))
+			}
+			tt.check(t, result.Grid)
+			if n := checkRules(t, wf, result.Grid); n > 0 {
+				t.Errorf("нарушений правил: %d", n)
+			}
+		})
+	}
+}
+
+// Несовместимые ограничения не перезапускаются: одна неудачная попытка,
+// а сетка всё равно заполняется целиком
+func TestRunConstraintsContradiction(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(o *WFCOptions)
+	}{
+		{"fixed vs border", func(o *WFCOptions) {
+			o.Borders.Top = []int{TileGrass}
+			o.Fixed = []FixedCell{{X: 4, Y: 0, Tile: TileWater}}
+		}},
+		{"fixed vs mask", func(o *WFCOptions) {
+			o.Masks = []CellMask{{X: 6, Y: 6, Allowed: []int{TileGrass}}}
+			o.Fixed = []FixedCell{{X: 6, Y: 6, Tile: TileResidential1x1}}
+		}},
+		{"fixed overlap", func(o *WFCOptions) {
+			o.Fixed = []FixedCell{{X: 2, Y: 2, Tile: TileResidential2x2}, {X: 3, Y: 3, Tile: TileWater}}
+		}},
+		{"empty mask", func(o *WFCOptions) {
+			o.Masks = []CellMask{{X: 1, Y: 1, Allowed: []int{}}}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := cityOptions(20, 5)
+			options.MaxAttempts = 5
+			tt.setup(&options)
+			result := NewWFC(options).Run()
+			if result.Success || result.Attempts != 1 {
+				t.Errorf("успех %v, попыток %d; ожидалась одна неудачная попытка", result.Success, result.Attempts)
+			}
+			if len(result.Grid) != 20 || len(result.Grid[0]) != 20 {
+				t.Errorf("размер сетки %dx%d, ожидалось 20x20", len(result.Grid[0]), len(result.Grid))
+			}
+		})
+	}
+}
+
+// Бюджет Iterations общий на все попытки у обеих моделей
+func TestRunBudget(t *testing.T) {
+	options := cityOptions(30, 3)
+	options.Iterations = 1
+	options.MaxAttempts = 5
+	tiled := NewWFC(options).Run()
+
+	model, err := NewOverlapping(OverlappingOptions{
+		Sample:        [][]int{{1, 1, 2}, {1, 2, 2}, {2, 2, 1}},
+		N:             2,
+		Width:         30,
+		Height:        30,
+		Seed:          3,
+		PeriodicInput: true,
+		MaxAttempts:   5,
+		Iterations:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlapping := model.Run()
+
+	for name, r := range map[string]Result{"tiled": tiled, "overlapping": overlapping} {
+		if r.Success || r.Attempts != 1 || r.Collapses != 1 {
+			t.Errorf("%s: успех %v, попыток %d, коллапсов %d; ожидалась одна неудачная попытка",
+				name, r.Success, r.Attempts, r.Collapses)
+		}
+	}
+}
+
+func benchmarkRun(b *testing.B, size int) {
+	for i := 0; i < b.N; i++ {
+		result := NewWFC(cityOptions(size, int64(i+1))).Run()
+		if !result.Success {
+			b.Fatalf("генерация %dx%d не удалась за %d попыток", size, size, result.Attempts)
+		}
+	}
+}
+
+func BenchmarkRun200x200(b *testing.B) { benchmarkRun(b, 200) }
+
+func BenchmarkRun300x300(b *testing.B) { benchmarkRun(b, 300) }
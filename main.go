@@ -13,6 +13,8 @@ import (
 	"sync"
 	"time"
 
+	"test/internal/dice"
+
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
@@ -21,30 +23,53 @@ import (
 )
 
 type Game struct {
-	perlin              *Perlin
-	noiseMap            [][]float64
-	tiles               [][]color.Color
-	cities              [][]bool
-	mode                string
-	conn                net.Conn
-	encoder             *gob.Encoder
-	decoder             *gob.Decoder
-	players             map[string]Player
-	mu                  sync.Mutex
-	seed                int64
-	me                  Player
-	cameraX             int
-	cameraY             int
-	font                font.Face
-	cityList            []*City
-	hoverCity           *City
-	cityWindow          *CityWindow
-	cityMap             *CityMap
-	cityTemplates       map[int64]*CityMap
-	characters          []*Character
-	currentCharacter    *Character
-	characterIndex      int
-	characterWindowOpen bool
+	perlin           *Perlin
+	noiseMap         [][]float64
+	tiles            [][]color.Color
+	cities           [][]bool
+	mode             string
+	conn             net.Conn
+	encoder          *gob.Encoder
+	decoder          *gob.Decoder
+	players          map[string]Player
+	mu               sync.Mutex
+	seed             int64
+	me               Player
+	cameraX          int
+	cameraY          int
+	font             font.Face
+	fonts            fontSet // Начертания для описаний и заметок с разметкой
+	cityList         []*City
+	hoverCity        *City
+	cityWindow       *CityWindow
+	cityMap          *CityMap
+	cityTemplates    map[string]*CityMap // Карты городов для игроков (только на сервере)
+	dungeonList      []*DungeonSite
+	hoverDungeon     *DungeonSite
+	dungeonMap       *DungeonMap
+	characters       []*Character
+	currentCharacter *Character
+	characterIndex   int
+	scenes           SceneStack
+	clients          map[string]*clientConn    // Подключения игроков (только на сервере)
+	accounts         map[string]*playerAccount // Известные серверу игроки (только на сервере)
+	markers          map[string]*Marker
+	editor           Editor
+	worldEdits       map[[2]int]Biome       // Клетки мира, перекрашенные мастером
+	cityEdits        map[string][]MapEdit   // Журналы правок городов по ключу карты
+	cityLayouts      map[string]CityContext // Окрестности, с которыми сгенерированы правленые города
+	inbox            []NetMessage           // Сообщения сервера, ещё не применённые
+	exploration      map[string]Exploration // Исследованные клетки по игрокам (только на сервере)
+	worldDirty       bool                   // Туман или чат изменились с последнего сохранения
+	worldSavedAt     time.Time
+	lastSent         Player                   // Последнее отправленное серверу состояние фишки
+	playerCharacter  *Character               // Персонаж, которым управляет игрок
+	portraitData     map[string][]byte        // Портреты персонажей по игрокам, PNG
+	portraits        map[string]*ebiten.Image // Портреты, готовые к отрисовке
+	partyPanelHidden bool
+	roller           *dice.Roller // Кости бросает только сервер
+	rollLog          []RollRecord
+	chatLog          []ChatMessage // Сообщения чата; на сервере - все, у игрока - видимые ему
 }
 
 type Perlin struct {
@@ -53,13 +78,29 @@ type Perlin struct {
 }
 
 func main() {
+	// Выгрузка листов для печати работает без окна и сети
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
 	rand.Seed(time.Now().UnixNano())
 
 	game := &Game{
-		perlin:   NewPerlin(time.Now().UnixNano()),
-		players:  make(map[string]Player),
-		font:     loadTrueTypeFont("assets/NotoSans-Regular.ttf", 14), // Загружаем наш шрифт вместо basicfont
-		cityList: make([]*City, 0),
+		perlin:        NewPerlin(time.Now().UnixNano()),
+		players:       make(map[string]Player),
+		clients:       make(map[string]*clientConn),
+		accounts:      make(map[string]*playerAccount),
+		markers:       make(map[string]*Marker),
+		worldEdits:    make(map[[2]int]Biome),
+		cityEdits:     make(map[string][]MapEdit),
+		cityLayouts:   make(map[string]CityContext),
+		exploration:   make(map[string]Exploration),
+		cityTemplates: make(map[string]*CityMap),
+		portraitData:  make(map[string][]byte),
+		portraits:     make(map[string]*ebiten.Image),
+		roller:        dice.New(time.Now().UnixNano()),
+		editor:        Editor{Radius: 1, Biome: BiomeGrass, CityTile: TileRoad},
+		font:          loadTrueTypeFont(fontPath, 14), // Загружаем наш шрифт вместо basicfont
+		cityList:      make([]*City, 0),
 		me: Player{
 			ID:    fmt.Sprintf("игрок-%d", rand.Intn(1000)),
 			Color: randomColor(),
@@ -67,7 +108,9 @@ func main() {
 		cameraX: -screenWidth / 4,  // Начальная позиция камеры
 		cameraY: -screenHeight / 4, // чтобы видеть больше карты
 	}
+	game.fonts = loadFontSet(game.font, 14)
 	game.initCityWindow()
+	game.scenes.Push(worldScene{})
 
 	fmt.Println("Запустить как: [s]erver или [c]lient?")
 	var mode string
@@ -79,10 +122,17 @@ func main() {
 	switch mode {
 	case "s":
 		game.mode = "server"
+		game.me.ID = gmPlayerID
 		game.seed = time.Now().UnixNano()
+		save := game.loadWorld()
 		game.perlin = NewPerlin(game.seed)
 		game.generateWorld()
 		game.generateCities()
+		if save != nil {
+			game.restoreWorld(save)
+		}
+		game.generateDungeons()
+		game.saveWorld()
 		go game.startServer()
 
 	case "c":
@@ -184,19 +234,7 @@ func (g *Game) generateWorld() {
 			ny := float64(y) * 0.05
 			g.noiseMap[y][x] = g.perlin.Noise(nx, ny)
 			value := (g.noiseMap[y][x] + 1) / 2
-
-			switch {
-			case value < 0.4:
-				g.tiles[y][x] = color.RGBA{0, 105, 148, 255}
-			case value < 0.5:
-				g.tiles[y][x] = color.RGBA{194, 178, 128, 255}
-			case value < 0.75:
-				g.tiles[y][x] = color.RGBA{34, 139, 34, 255}
-			case value < 0.95:
-				g.tiles[y][x] = color.RGBA{100, 100, 100, 255}
-			default:
-				g.tiles[y][x] = color.RGBA{220, 220, 220, 255}
-			}
+			g.tiles[y][x] = biomeColors[biomeForValue(value)]
 		}
 	}
 }
@@ -226,67 +264,91 @@ func (g *Game) handleConnection(conn net.Conn) {
 	decoder := gob.NewDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
+	// Сначала узнаём игрока: мир ему отправляется только в пределах исследованного
+	var req JoinRequest
+	if err := decoder.Decode(&req); err != nil {
+		log.Println("Ошибка получения данных игрока:", err)
+		return
+	}
+
 	g.mu.Lock()
-	err := encoder.Encode(struct {
-		Seed   int64
-		Tiles  [][]color.RGBA
-		Cities [][]bool
-	}{
-		Seed:   g.seed,
-		Tiles:  g.colorToRGBA(g.tiles),
-		Cities: g.cities,
-	})
+	player, token, err := g.joinPlayer(req)
+	var world WorldState
+	if err == nil {
+		g.revealAround(player.ID, player)
+		world = g.worldStateFor(player.ID)
+		world.You, world.Token = player, token
+	}
 	g.mu.Unlock()
 
 	if err != nil {
-		log.Println("Ошибка отправки мира:", err)
+		log.Printf("[ERROR] Игрок не впущен: %v", err)
+		if err := encoder.Encode(WorldState{Error: err.Error()}); err != nil {
+			log.Println("Ошибка отправки отказа:", err)
+		}
 		return
 	}
-
-	var player Player
-	if err := decoder.Decode(&player); err != nil {
-		log.Println("Ошибка получения данных игрока:", err)
+	if err := encoder.Encode(world); err != nil {
+		log.Println("Ошибка отправки мира:", err)
+		g.mu.Lock()
+		g.leavePlayer(player)
+		g.mu.Unlock()
 		return
 	}
 
+	client := &clientConn{encoder: encoder}
 	g.mu.Lock()
+	others := make([]Player, 0, len(g.players))
+	for _, p := range g.players {
+		others = append(others, p)
+	}
+	portraits := make(map[string][]byte, len(g.portraitData))
+	for id, data := range g.portraitData {
+		portraits[id] = data
+	}
 	g.players[player.ID] = player
+	g.clients[player.ID] = client
+	rolls := g.publicRolls()
+	chat := g.chatHistory(player.ID)
 	g.mu.Unlock()
 
-	g.broadcastPlayerUpdate(player)
+	// Новичок узнаёт об уже подключённых игроках и их портретах, остальные - о нём
+	for i := range others {
+		if err := client.send(NetMessage{Kind: MsgPlayer, Player: &others[i]}); err != nil {
+			log.Println("Ошибка отправки игроков:", err)
+		}
+		if data := portraits[others[i].ID]; data != nil {
+			if err := client.send(NetMessage{Kind: MsgPortrait, ID: others[i].ID, Portrait: data}); err != nil {
+				log.Println("Ошибка отправки портретов:", err)
+			}
+		}
+	}
+	for i := range rolls {
+		if err := client.send(NetMessage{Kind: MsgRoll, Roll: &rolls[i]}); err != nil {
+			log.Println("Ошибка отправки журнала бросков:", err)
+		}
+	}
+	for i := range chat {
+		if err := client.send(NetMessage{Kind: MsgChat, Chat: &chat[i]}); err != nil {
+			log.Println("Ошибка отправки истории чата:", err)
+		}
+	}
+	g.broadcast(NetMessage{Kind: MsgPlayer, Player: &player}, player.ID)
 
 	for {
-		var update Player
-		if err := decoder.Decode(&update); err != nil {
+		var msg NetMessage
+		if err := decoder.Decode(&msg); err != nil {
 			log.Println("Клиент отключился:", err)
 			g.mu.Lock()
-			delete(g.players, update.ID)
+			g.leavePlayer(g.players[player.ID])
+			delete(g.players, player.ID)
+			delete(g.clients, player.ID)
+			g.setPortrait(player.ID, nil)
 			g.mu.Unlock()
-			g.broadcastPlayerUpdate(update)
+			g.broadcast(NetMessage{Kind: MsgPlayerLeft, ID: player.ID}, player.ID)
 			return
 		}
-
-		g.mu.Lock()
-		g.players[update.ID] = update
-		g.mu.Unlock()
-
-		g.broadcastPlayerUpdate(update)
-	}
-}
-
-func (g *Game) broadcastPlayerUpdate(player Player) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-
-	for _, p := range g.players {
-		if p.ID == player.ID {
-			continue
-		}
-		if g.conn != nil {
-			if err := g.encoder.Encode(player); err != nil {
-				log.Println("Ошибка рассылки:", err)
-			}
-		}
+		g.handleClientMessage(player.ID, msg)
 	}
 }
 
@@ -306,47 +368,52 @@ func (g *Game) connectToServer() {
 	g.encoder = gob.NewEncoder(conn)
 	g.decoder = gob.NewDecoder(conn)
 
-	var world struct {
-		Seed   int64
-		Tiles  [][]color.RGBA
-		Cities [][]bool
+	g.chooseCharacter()
+	identity := loadClientIdentity(address)
+	if err := g.encoder.Encode(JoinRequest{ID: identity.ID, Token: identity.Token, Player: g.me}); err != nil {
+		log.Fatal("Ошибка отправки данных игрока:", err)
 	}
+
+	var world WorldState
 	if err := g.decoder.Decode(&world); err != nil {
 		log.Fatal("Ошибка получения мира:", err)
 	}
+	if world.Error != "" {
+		log.Fatal("Сервер не впустил: ", world.Error)
+	}
+	// ID и место фишки назначил сервер; ключ нужен, чтобы вернуться под тем же ID
+	g.me = world.You
+	g.lastSent = g.me
+	saveClientIdentity(address, clientIdentity{ID: world.You.ID, Token: world.Token})
 
-	g.seed = world.Seed
+	// Сида мира у клиента нет: карта приходит от сервера готовой
 	g.tiles = g.rgbaToColor(world.Tiles)
 	g.cities = world.Cities
-
-	g.perlin = NewPerlin(g.seed)
-	g.noiseMap = make([][]float64, len(g.tiles))
-	for y := range g.noiseMap {
-		g.noiseMap[y] = make([]float64, len(g.tiles[0]))
+	// Города и подземелья берём у сервера: он учитывает правки мастера и туман войны
+	g.cityList = make([]*City, len(world.CityList))
+	for i := range world.CityList {
+		g.cityList[i] = &world.CityList[i]
 	}
-
-	g.generateCities()
-
-	if err := g.encoder.Encode(g.me); err != nil {
-		log.Fatal("Ошибка отправки данных игрока:", err)
+	g.dungeonList = make([]*DungeonSite, len(world.Dungeons))
+	for i := range world.Dungeons {
+		g.dungeonList[i] = &world.Dungeons[i]
+	}
+	for i := range world.Markers {
+		g.markers[world.Markers[i].ID] = &world.Markers[i]
 	}
 
+	g.sendPortrait()
 	go g.handleServerUpdates()
 }
 
 func (g *Game) handleServerUpdates() {
 	for {
-		var player Player
-		if err := g.decoder.Decode(&player); err != nil {
+		var msg NetMessage
+		if err := g.decoder.Decode(&msg); err != nil {
 			log.Println("Соединение с сервером разорвано:", err)
 			return
 		}
-
-		g.mu.Lock()
-		if player.ID != g.me.ID {
-			g.players[player.ID] = player
-		}
-		g.mu.Unlock()
+		g.handleServerMessage(msg)
 	}
 }
 
@@ -379,25 +446,15 @@ func (g *Game) rgbaToColor(rgba [][]color.RGBA) [][]color.Color {
 }
 
 func (g *Game) Update() error {
-	g.handleMovementInput()
-	g.handleCameraInput()
-	g.handleCityGenerationInput()
-	g.handleCityWindowToggle()
-	g.updateHoverCity()
-	g.updateCityMap()
-	g.cityWindow.Update()
-
-	if inpututil.IsKeyJustPressed(ebiten.KeyP) { // Обработка нажатия P
-		g.toggleCharacterWindow()
-	}
-
-	if g.characterWindowOpen {
-		g.handleCharacterWindowInput()
-	}
+	g.processInbox()
+	g.scenes.Update(g)
 
 	if g.conn != nil {
 		g.sendPlayerPosition()
 	}
+	if g.isGM() {
+		g.saveWorldIfDirty()
+	}
 
 	return nil
 }
@@ -416,22 +473,24 @@ func (g *Game) handleMovementInput() {
 	if inpututil.IsKeyJustPressed(ebiten.KeyD) || inpututil.IsKeyJustPressed(ebiten.KeyRight) {
 		g.me.X += speed
 	}
-	// В методе handleMovementInput в main.go
-	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
-		mx, my := ebiten.CursorPosition()
-		mapX, mapY := (mx+g.cameraX)/cellSize, (my+g.cameraY)/cellSize
+	// В режиме правки клики принадлежат редактору
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && !g.editor.Active {
+		mapX, mapY := g.screenToWorld(ebiten.CursorPosition())
 
-		// Проверяем клик по городу
-		clickedCity := g.findCityAt(mapX, mapY)
-		if clickedCity != nil {
+		// Метки и подземелья рисуются поверх городов, поэтому проверяем их первыми
+		if marker, ok := g.markerAt(worldMapKey, mapX, mapY); ok {
+			g.openMarker(marker, false)
+		} else if site := g.findDungeonAt(mapX, mapY); site != nil {
+			g.initDungeonMap(site)
+		} else if clickedCity := g.findCityAt(mapX, mapY); clickedCity != nil {
 			g.initCityMap(clickedCity) // Инициализируем карту города
 		}
 	}
 
-	cols := screenWidth / cellSize
-	rows := screenHeight / cellSize
-	g.me.X = clamp(g.me.X, 0, cols-1)
-	g.me.Y = clamp(g.me.Y, 0, rows-1)
+	if len(g.tiles) > 0 {
+		g.me.X = clamp(g.me.X, 0, len(g.tiles[0])-1)
+		g.me.Y = clamp(g.me.Y, 0, len(g.tiles)-1)
+	}
 }
 
 func (g *Game) handleCameraInput() {
@@ -462,22 +521,30 @@ func (g *Game) regenerateWorld() {
 	g.seed = time.Now().UnixNano()
 	g.generateWorld()
 	g.generateCities()
-}
-
-func (g *Game) handleCityWindowToggle() {
-	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
-		g.cityWindow.open = !g.cityWindow.open
-	}
+	g.generateDungeons()
+	g.clearEdits()
+	g.clearMarkers()
+	g.clearFog()
 }
 
 func (g *Game) updateHoverCity() {
-	mouseX, mouseY := ebiten.CursorPosition()
-	mapX, mapY := (mouseX+g.cameraX)/cellSize, (mouseY+g.cameraY)/cellSize
+	mapX, mapY := g.screenToWorld(ebiten.CursorPosition())
 	g.hoverCity = g.findCityAt(mapX, mapY)
+	g.hoverDungeon = g.findDungeonAt(mapX, mapY)
 }
 
+// sendPlayerPosition отправляет фишку, только если она изменилась. Сводка
+// персонажа берётся из листа каждый раз: хиты меняются по ходу игры.
 func (g *Game) sendPlayerPosition() {
-	if err := g.encoder.Encode(g.me); err != nil {
+	if g.playerCharacter != nil {
+		g.me.Character = partyMemberOf(g.playerCharacter)
+	}
+	me := g.me
+	if me == g.lastSent {
+		return
+	}
+	g.lastSent = me
+	if err := g.encoder.Encode(NetMessage{Kind: MsgPlayer, Player: &me}); err != nil {
 		log.Println("Ошибка отправки позиции:", err)
 	}
 }
@@ -486,85 +553,85 @@ func (g *Game) generateNewCities() {
 	g.seed = time.Now().UnixNano()
 	g.generateWorld()
 	g.generateCities()
+	g.generateDungeons()
 	g.cityWindow.cities = g.cityList
+	g.clearEdits()
+	g.clearMarkers()
+	g.clearFog()
 }
 
 func (g *Game) Draw(screen *ebiten.Image) {
+	g.scenes.Draw(g, screen)
+	g.drawRecentRolls(screen)
+	g.drawRecentChat(screen)
+}
+
+// drawWorld рисует карту мира, игроков и отладочную информацию
+func (g *Game) drawWorld(screen *ebiten.Image) {
 	for y := range g.tiles {
 		for x := range g.tiles[y] {
-			screenX := x*cellSize - g.cameraX
-			screenY := y*cellSize - g.cameraY
+			screenX, screenY := g.worldToScreen(x, y)
 
 			if g.isVisible(screenX, screenY, cellSize, cellSize) {
+				clr := g.tiles[y][x]
+				if !g.worldRevealed(x, y) {
+					clr = fogColor
+				}
 				ebitenutil.DrawRect(
 					screen,
 					float64(screenX),
 					float64(screenY),
 					cellSize,
 					cellSize,
-					g.tiles[y][x],
+					clr,
 				)
 			}
 		}
 	}
 
-	if g.characterWindowOpen {
-		g.drawCharacterWindow(screen)
-	}
-
 	debugInfo := fmt.Sprintf(
-		"Городов: %d | Камера: (%d, %d) | Seed: %d",
+		"Городов: %d | Камера: (%d, %d)",
 		len(g.cityList),
 		g.cameraX,
 		g.cameraY,
-		g.seed,
 	)
+	if g.mode != "client" {
+		debugInfo += fmt.Sprintf(" | Seed: %d", g.seed)
+	}
 	text.Draw(screen, debugInfo, g.font, 10, screenHeight-30, color.White)
 
 	g.mu.Lock()
+	tokens := make([]Player, 0, len(g.players)+1)
 	for _, player := range g.players {
-		if player.ID == g.me.ID {
-			continue
+		if player.ID != g.me.ID {
+			tokens = append(tokens, player)
 		}
-		ebitenutil.DrawRect(
-			screen,
-			float64(player.X*cellSize),
-			float64(player.Y*cellSize),
-			cellSize,
-			cellSize,
-			player.Color,
-		)
 	}
 	g.mu.Unlock()
-
-	ebitenutil.DrawRect(
-		screen,
-		float64(g.me.X*cellSize),
-		float64(g.me.Y*cellSize),
-		cellSize,
-		cellSize,
-		color.RGBA{255, 255, 255, 255},
-	)
+	tokens = append(tokens, g.me)
+	for _, player := range tokens {
+		px, py := g.worldToScreenF(player.X, player.Y)
+		if g.isVisible(int(px), int(py), cellSize, cellSize) {
+			g.drawToken(screen, player, px, py, cellSize, true)
+		}
+	}
 
 	info := fmt.Sprintf("Режим: %s | ID: %s\n", g.mode, g.me.ID)
 	if g.mode == "server" {
-		info += "Нажмите R для новой карты\n"
+		info += "Нажмите R для новой карты, N - метка, E - правка карты, O - отряд\n"
 	}
+	info += "L - броски костей, Enter - чат\n"
 	info += fmt.Sprintf("Позиция: %d, %d\nИгроков онлайн: %d\nTPS: %0.2f",
 		g.me.X, g.me.Y, len(g.players), ebiten.ActualTPS())
 	ebitenutil.DebugPrint(screen, info)
 
 	g.drawCities(screen)
-	g.cityWindow.Draw(screen)
-	g.drawCityMap(screen) // Рисуем карту города поверх всего
+	g.drawDungeonSites(screen)
+	g.drawMarkers(screen, worldMapKey, cellSize, g.worldToScreenF)
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
-	// Если открыта карта города, увеличиваем окно
-	if g.cityMap != nil && g.cityMap.Open {
-		return cityMapSize * buildingSize * cityMapScale,
-			cityMapSize*buildingSize*cityMapScale + 40
-	}
+	// Логическое разрешение одно для всех сцен, ebiten масштабирует его под окно
 	return screenWidth, screenHeight
 }
 
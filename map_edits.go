@@ -0,0 +1,276 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+// Inverse возвращает правку, отменяющую эту
+func (e MapEdit) Inverse() MapEdit {
+	inv := MapEdit{Map: e.Map}
+	if len(e.Cells) > 0 {
+		inv.Cells = make([]CellEdit, len(e.Cells))
+		for i, c := range e.Cells {
+			inv.Cells[len(e.Cells)-1-i] = CellEdit{X: c.X, Y: c.Y, Before: c.After, After: c.Before}
+		}
+	}
+	if e.City != nil {
+		inv.City = &CityChange{Before: e.City.After, After: e.City.Before}
+	}
+	if e.Building != nil {
+		inv.Building = &BuildingChange{Before: e.Building.After, After: e.Building.Before}
+	}
+	return inv
+}
+
+// applyEdit применяет правку к миру или городу и запоминает её для
+// сохранения и для новых клиентов. Правки города копятся в журнале и
+// повторяются при каждой генерации его карты. Карта правленого города дальше
+// генерируется с окрестностями, запомненными при первой правке: перенос города
+// и перекраска мира вокруг не меняют план, на который ложатся правки.
+func (g *Game) applyEdit(e MapEdit) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if e.Map == worldMapKey {
+		for _, c := range e.Cells {
+			if g.inWorld(c.X, c.Y) {
+				g.setWorldBiome(c.X, c.Y, Biome(c.After))
+				g.worldEdits[[2]int{c.X, c.Y}] = Biome(c.After)
+			}
+		}
+		if e.City != nil {
+			g.applyCityChange(*e.City)
+			g.forgetCityMap(e.City.Before)
+			g.forgetCityMap(e.City.After)
+			if e.City.Before != nil && e.City.After == nil {
+				g.forgetCity(cityMapKey(e.City.Before))
+			}
+		}
+		return
+	}
+
+	if g.isGM() {
+		if _, ok := g.cityLayouts[e.Map]; !ok {
+			if m := g.cityMapByKey(e.Map); m != nil {
+				g.cityLayouts[e.Map] = m.Context
+			}
+		}
+		g.cityEdits[e.Map] = append(g.cityEdits[e.Map], e)
+	}
+	// У мастера открытый город и кэш - одна и та же карта, правка ложится на неё один раз
+	cached := g.cityTemplates[e.Map]
+	if cached != nil {
+		g.applyCityMapEdit(cached, e)
+	}
+	if g.cityMap != nil && g.cityMap != cached && cityMapKey(g.cityMap.City) == e.Map {
+		g.applyCityMapEdit(g.cityMap, e)
+	}
+}
+
+// forgetCityMap выбрасывает карту перенесённого или удалённого города из
+// кэша: окрестности изменились, и карту нужно сгенерировать заново. Вызывается под g.mu.
+func (g *Game) forgetCityMap(city *City) {
+	if city != nil {
+		delete(g.cityTemplates, cityMapKey(city))
+	}
+}
+
+// forgetCity убирает правки, окрестности и метки удалённого города: у нового
+// города будет другой номер, и к нему они не относятся. Вызывается под g.mu.
+func (g *Game) forgetCity(key string) {
+	delete(g.cityEdits, key)
+	delete(g.cityLayouts, key)
+	for id, m := range g.markers {
+		if m.Map == key {
+			delete(g.markers, id)
+		}
+	}
+}
+
+func (g *Game) setWorldBiome(x, y int, biome Biome) {
+	g.tiles[y][x] = biomeColors[biome]
+}
+
+// applyCityChange добавляет, удаляет или переносит город, сохраняя порядок списка
+func (g *Game) applyCityChange(c CityChange) {
+	index := -1
+	if c.Before != nil {
+		for i, city := range g.cityList {
+			if city.ID == c.Before.ID {
+				index = i
+			}
+		}
+	}
+
+	switch {
+	case c.After == nil && index >= 0:
+		g.cityList = append(g.cityList[:index], g.cityList[index+1:]...)
+	case c.After != nil && index >= 0:
+		city := *c.After
+		g.cityList[index] = &city
+	case c.After != nil:
+		city := *c.After
+		g.cityList = append(g.cityList, &city)
+	}
+	g.rebuildCityCells()
+}
+
+// applyCityMapEdit применяет правку к карте города. Вызывается под g.mu.
+func (g *Game) applyCityMapEdit(m *CityMap, e MapEdit) {
+	for _, c := range e.Cells {
+		if inCity(m.Grid, c.X, c.Y) {
+			m.Grid[c.Y][c.X] = c.After
+			m.Tiles[c.Y][c.X] = g.getEnhancedTileColor(m.Grid, c.X, c.Y)
+		}
+	}
+	if e.Building == nil {
+		return
+	}
+
+	b := e.Building
+	index := -1
+	if b.Before != nil {
+		for i := range m.Buildings {
+			if m.Buildings[i].ID == b.Before.ID {
+				index = i
+			}
+		}
+	}
+	switch {
+	case b.After == nil && index >= 0:
+		m.Buildings = append(m.Buildings[:index], m.Buildings[index+1:]...)
+		if m.Selected == b.Before.ID {
+			m.Selected = -1
+		}
+	case b.After != nil && index >= 0:
+		m.Buildings[index] = *b.After
+	case b.After != nil:
+		m.Buildings = append(m.Buildings, *b.After)
+	}
+}
+
+// commitEdit применяет правку мастера, заносит её в историю, сохраняет мир и
+// рассылает игрокам
+func (g *Game) commitEdit(e MapEdit) {
+	g.applyEdit(e)
+	g.editor.undo = append(g.editor.undo, e)
+	if len(g.editor.undo) > maxEditHistory {
+		g.editor.undo = g.editor.undo[1:]
+	}
+	g.editor.redo = nil
+	g.publishEdit(e)
+}
+
+func (g *Game) undoEdit() {
+	if len(g.editor.undo) == 0 {
+		return
+	}
+	e := g.editor.undo[len(g.editor.undo)-1]
+	g.editor.undo = g.editor.undo[:len(g.editor.undo)-1]
+	g.editor.redo = append(g.editor.redo, e)
+
+	inv := e.Inverse()
+	g.applyEdit(inv)
+	g.publishEdit(inv)
+}
+
+func (g *Game) redoEdit() {
+	if len(g.editor.redo) == 0 {
+		return
+	}
+	e := g.editor.redo[len(g.editor.redo)-1]
+	g.editor.redo = g.editor.redo[:len(g.editor.redo)-1]
+	g.editor.undo = append(g.editor.undo, e)
+
+	g.applyEdit(e)
+	g.publishEdit(e)
+}
+
+// publishEdit сохраняет мир и рассылает правку; каждый игрок получает только
+// то, что видит сквозь туман войны
+func (g *Game) publishEdit(e MapEdit) {
+	g.saveWorld()
+
+	g.mu.Lock()
+	msgs := make(map[string]NetMessage, len(g.clients))
+	for id := range g.clients {
+		if edit, ok := g.editFor(id, e); ok {
+			msgs[id] = NetMessage{Kind: MsgEdit, Edit: &edit}
+		}
+	}
+	g.mu.Unlock()
+	g.sendTo(msgs)
+	if debugMode {
+		log.Printf("[DEBUG] Правка %s: клеток %d, город %v, здание %v",
+			e.Map, len(e.Cells), e.City != nil, e.Building != nil)
+	}
+}
+
+// clearEdits забывает правки и историю: мир сгенерирован заново
+func (g *Game) clearEdits() {
+	g.mu.Lock()
+	g.worldEdits = make(map[[2]int]Biome)
+	g.cityEdits = make(map[string][]MapEdit)
+	g.cityLayouts = make(map[string]CityContext)
+	g.mu.Unlock()
+	g.editor.undo, g.editor.redo = nil, nil
+}
+
+// restoreWorld возвращает сохранённые правки поверх мира, сгенерированного по сиду
+func (g *Game) restoreWorld(save *WorldSave) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, c := range save.WorldTiles {
+		if g.inWorld(c.X, c.Y) {
+			g.setWorldBiome(c.X, c.Y, Biome(c.After))
+			g.worldEdits[[2]int{c.X, c.Y}] = Biome(c.After)
+		}
+	}
+	if save.Cities != nil {
+		g.cityList = make([]*City, len(save.Cities))
+		for i := range save.Cities {
+			g.cityList[i] = &save.Cities[i]
+		}
+		g.rebuildCityCells()
+	}
+	if save.CityEdits != nil {
+		g.cityEdits = save.CityEdits
+	}
+	if save.CityLayouts != nil {
+		g.cityLayouts = save.CityLayouts
+	}
+	// Сохранения без окрестностей: план правленого города закрепляется по
+	// нынешним, чтобы дальше он не менялся
+	for _, city := range g.cityList {
+		key := cityMapKey(city)
+		if _, ok := g.cityLayouts[key]; !ok && len(g.cityEdits[key]) > 0 {
+			g.cityLayouts[key] = g.cityContext(city)
+		}
+	}
+}
+
+// worldTileEdits возвращает изменённые биомы мира в стабильном порядке. Вызывается под g.mu.
+func (g *Game) worldTileEdits() []CellEdit {
+	cells := make([]CellEdit, 0, len(g.worldEdits))
+	for p, biome := range g.worldEdits {
+		cells = append(cells, CellEdit{X: p[0], Y: p[1], After: int(biome)})
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Y != cells[j].Y {
+			return cells[i].Y < cells[j].Y
+		}
+		return cells[i].X < cells[j].X
+	})
+	return cells
+}
+
+// cityListCopy возвращает копию списка городов для отправки и сохранения. Вызывается под g.mu.
+func (g *Game) cityListCopy() []City {
+	cities := make([]City, len(g.cityList))
+	for i, city := range g.cityList {
+		cities[i] = *city
+	}
+	return cities
+}
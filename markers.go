@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+// MarkerIcon - значок метки на карте
+type MarkerIcon int
+
+const (
+	IconNote MarkerIcon = iota
+	IconQuest
+	IconAmbush
+	IconNPC
+	IconTreasure
+	IconDanger
+	markerIconCount
+)
+
+var markerIconNames = map[MarkerIcon]string{
+	IconNote:     "Заметка",
+	IconQuest:    "Задание",
+	IconAmbush:   "Засада",
+	IconNPC:      "Житель",
+	IconTreasure: "Сокровище",
+	IconDanger:   "Опасность",
+}
+
+var markerIconGlyphs = map[MarkerIcon]string{
+	IconNote:     "i",
+	IconQuest:    "!",
+	IconAmbush:   "X",
+	IconNPC:      "Ж",
+	IconTreasure: "$",
+	IconDanger:   "?",
+}
+
+func markerIconColor(icon MarkerIcon) color.RGBA {
+	switch icon {
+	case IconQuest:
+		return color.RGBA{240, 200, 40, 255}
+	case IconAmbush:
+		return color.RGBA{200, 40, 40, 255}
+	case IconNPC:
+		return color.RGBA{60, 140, 220, 255}
+	case IconTreasure:
+		return color.RGBA{230, 160, 30, 255}
+	case IconDanger:
+		return color.RGBA{150, 50, 170, 255}
+	default:
+		return color.RGBA{220, 220, 220, 255}
+	}
+}
+
+const (
+	worldMapKey     = "world"
+	maxMarkerTitle  = 60
+	maxMarkerText   = 500
+	markerPanelW    = 440
+	markerPanelH    = 380
+	markerTextBoxH  = 150
+	markerIconBoxPx = 36
+)
+
+// cityMapKey - ключ карты города для меток и правок. Город можно переименовать
+// и перенести, поэтому ключ строится по его номеру.
+func cityMapKey(city *City) string {
+	return fmt.Sprintf("city:%d", city.ID)
+}
+
+// isGM сообщает, что игрок - мастер. Мастером считается хозяин сервера.
+func (g *Game) isGM() bool {
+	return g.mode == "server"
+}
+
+func newMarkerID() string {
+	return fmt.Sprintf("m%x", rand.Int63())
+}
+
+// markerVisible сообщает, видит ли игрок метку: не скрытую мастером и на
+// открытой ему клетке. Вызывается под g.mu.
+func (g *Game) markerVisible(pid string, m *Marker) bool {
+	return !m.GMOnly && g.explored(pid, m.Map, m.X, m.Y)
+}
+
+// playerMarkers возвращает метки, которые видит игрок. Вызывается под g.mu.
+func (g *Game) playerMarkers(pid string) []Marker {
+	var markers []Marker
+	for _, m := range g.markers {
+		if g.markerVisible(pid, m) {
+			markers = append(markers, *m)
+		}
+	}
+	return markers
+}
+
+// markersOn возвращает копии меток карты в стабильном порядке
+func (g *Game) markersOn(mapKey string) []Marker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var markers []Marker
+	for _, m := range g.markers {
+		if m.Map == mapKey && (!m.GMOnly || g.isGM()) {
+			markers = append(markers, *m)
+		}
+	}
+	sort.Slice(markers, func(i, j int) bool { return markers[i].ID < markers[j].ID })
+	return markers
+}
+
+func (g *Game) markerAt(mapKey string, x, y int) (Marker, bool) {
+	for _, m := range g.markersOn(mapKey) {
+		if m.X == x && m.Y == y {
+			return m, true
+		}
+	}
+	return Marker{}, false
+}
+
+// putMarker создаёт или изменяет метку, сохраняет мир и рассылает изменение.
+// Метку получают игроки, которым открыта её клетка; у остальных она
+// удаляется: её могли скрыть от игроков или перенести в туман.
+func (g *Game) putMarker(m Marker) {
+	if !g.isGM() {
+		return
+	}
+	g.mu.Lock()
+	g.markers[m.ID] = &m
+	msgs := make(map[string]NetMessage, len(g.clients))
+	for pid := range g.clients {
+		if g.markerVisible(pid, &m) {
+			msgs[pid] = NetMessage{Kind: MsgMarker, Marker: &m}
+		} else {
+			msgs[pid] = NetMessage{Kind: MsgMarkerDelete, ID: m.ID}
+		}
+	}
+	g.mu.Unlock()
+	g.saveWorld()
+
+	g.sendTo(msgs)
+	if debugMode {
+		log.Printf("[DEBUG] Метка %s %q на %s (%d, %d)", m.ID, m.Title, m.Map, m.X, m.Y)
+	}
+}
+
+func (g *Game) deleteMarker(id string) {
+	if !g.isGM() {
+		return
+	}
+	g.mu.Lock()
+	delete(g.markers, id)
+	g.mu.Unlock()
+	g.saveWorld()
+	g.broadcast(NetMessage{Kind: MsgMarkerDelete, ID: id}, "")
+}
+
+// clearMarkers убирает все метки: они относятся к миру, который перегенерирован
+func (g *Game) clearMarkers() {
+	g.mu.Lock()
+	ids := make([]string, 0, len(g.markers))
+	for id := range g.markers {
+		ids = append(ids, id)
+	}
+	g.markers = make(map[string]*Marker)
+	g.mu.Unlock()
+
+	for _, id := range ids {
+		g.broadcast(NetMessage{Kind: MsgMarkerDelete, ID: id}, "")
+	}
+	g.saveWorld()
+}
+
+// openMarker открывает метку: мастеру - на редактирование, игроку - на просмотр
+func (g *Game) openMarker(m Marker, isNew bool) {
+	g.scenes.Push(&markerScene{marker: m, isNew: isNew})
+}
+
+// placeMarker открывает редактор новой метки в клетке x, y
+func (g *Game) placeMarker(mapKey string, x, y int) {
+	if !g.isGM() {
+		return
+	}
+	if m, ok := g.markerAt(mapKey, x, y); ok {
+		g.openMarker(m, false)
+		return
+	}
+	g.openMarker(Marker{ID: newMarkerID(), Map: mapKey, X: x, Y: y, Icon: IconQuest}, true)
+}
+
+// drawMarkers рисует метки карты; toScreen переводит клетку карты в пиксели экрана
+func (g *Game) drawMarkers(screen *ebiten.Image, mapKey string, size float64, toScreen func(x, y int) (float64, float64)) {
+	mx, my := ebiten.CursorPosition()
+	var hovered *Marker
+	markers := g.markersOn(mapKey)
+	for i, m := range markers {
+		x, y := toScreen(m.X, m.Y)
+		if x+size < 0 || y+size < 0 || x > screenWidth || y > screenHeight {
+			continue
+		}
+		g.drawMarkerIcon(screen, x, y, size, m)
+		if float64(mx) >= x && float64(mx) < x+size && float64(my) >= y && float64(my) < y+size {
+			hovered = &markers[i]
+		}
+	}
+
+	if hovered != nil {
+		x, y := toScreen(hovered.X, hovered.Y)
+		title := hovered.Title
+		if hovered.GMOnly {
+			title += " (только мастер)"
+		}
+		text.Draw(screen, title, g.font, int(x), int(y)-6, color.RGBA{255, 255, 0, 255})
+	}
+}
+
+func (g *Game) drawMarkerIcon(screen *ebiten.Image, x, y, size float64, m Marker) {
+	inset := size / 8
+	clr := markerIconColor(m.Icon)
+	ebitenutil.DrawRect(screen, x+inset, y+inset, size-2*inset, size-2*inset, color.RGBA{20, 20, 20, 230})
+	ebitenutil.DrawRect(screen, x+2*inset, y+2*inset, size-4*inset, size-4*inset, clr)
+	if m.GMOnly {
+		// Скрытые от игроков метки мастер видит в белой рамке
+		drawRectOutline(screen, x, y, size, size, 1, color.RGBA{255, 255, 255, 160})
+	}
+	if size >= 14 {
+		glyph := markerIconGlyphs[m.Icon]
+		bounds := text.BoundString(g.font, glyph)
+		text.Draw(screen, glyph, g.font, int(x+size/2)-bounds.Dx()/2, int(y+size/2)+bounds.Dy()/2,
+			color.RGBA{0, 0, 0, 255})
+	}
+}
+
+// markerScene - окно метки поверх карты
+type markerScene struct {
+	marker Marker // Редактируемая копия; в мир попадает по кнопке "Сохранить"
+	isNew  bool
+	field  int
+}
+
+const (
+	markerFieldNone = iota
+	markerFieldTitle
+	markerFieldText
+)
+
+func (*markerScene) Overlay() bool { return true }
+
+func markerPanelRect() (x, y, w, h int) {
+	return (screenWidth - markerPanelW) / 2, (screenHeight - markerPanelH) / 2, markerPanelW, markerPanelH
+}
+
+func markerTitleRect() (x, y, w, h int) {
+	px, py, pw, _ := markerPanelRect()
+	return px + 10, py + 55, pw - 20, 26
+}
+
+func markerTextRect() (x, y, w, h int) {
+	px, py, pw, _ := markerPanelRect()
+	return px + 10, py + 105, pw - 20, markerTextBoxH
+}
+
+func markerIconRect(icon MarkerIcon) (x, y, w, h int) {
+	px, py, _, _ := markerPanelRect()
+	return px + 10 + int(icon)*(markerIconBoxPx+8), py + 265, markerIconBoxPx, markerIconBoxPx
+}
+
+func markerGMOnlyRect() (x, y, w, h int) {
+	px, py, _, _ := markerPanelRect()
+	return px + 10, py + 312, 16, 16
+}
+
+func (s *markerScene) buttons(g *Game) []interiorButton {
+	px, py, pw, ph := markerPanelRect()
+	y := py + ph - 35
+	if !g.isGM() {
+		return []interiorButton{{px + pw - 90, y, 80, 25, "Закрыть", func(g *Game) { g.scenes.Pop() }}}
+	}
+
+	buttons := []interiorButton{
+		{px + 10, y, 110, 25, "Сохранить", func(g *Game) {
+			if strings.TrimSpace(s.marker.Title) == "" {
+				s.marker.Title = markerIconNames[s.marker.Icon]
+			}
+			g.putMarker(s.marker)
+			g.scenes.Pop()
+		}},
+		{px + pw - 90, y, 80, 25, "Отмена", func(g *Game) { g.scenes.Pop() }},
+	}
+	if !s.isNew {
+		buttons = append(buttons, interiorButton{px + 130, y, 100, 25, "Удалить", func(g *Game) {
+			g.deleteMarker(s.marker.ID)
+			g.scenes.Pop()
+		}})
+	}
+	return buttons
+}
+
+func (s *markerScene) Update(g *Game) {
+	switch s.field {
+	case markerFieldTitle:
+		editText(&s.marker.Title, maxMarkerTitle, false)
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+			s.field = markerFieldText
+		}
+	case markerFieldText:
+		editText(&s.marker.Text, maxMarkerText, true)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		if s.field != markerFieldNone {
+			s.field = markerFieldNone
+		} else {
+			g.scenes.Pop()
+		}
+		return
+	}
+
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return
+	}
+	mx, my := ebiten.CursorPosition()
+	inside := func(x, y, w, h int) bool {
+		return mx >= x && mx <= x+w && my >= y && my <= y+h
+	}
+
+	for _, btn := range s.buttons(g) {
+		if inside(btn.x, btn.y, btn.w, btn.h) {
+			btn.action(g)
+			return
+		}
+	}
+	if !g.isGM() {
+		return
+	}
+
+	s.field = markerFieldNone
+	switch {
+	case inside(markerTitleRect()):
+		s.field = markerFieldTitle
+	case inside(markerTextRect()):
+		s.field = markerFieldText
+	case inside(markerGMOnlyRect()):
+		s.marker.GMOnly = !s.marker.GMOnly
+	}
+	for icon := MarkerIcon(0); icon < markerIconCount; icon++ {
+		if inside(markerIconRect(icon)) {
+			s.marker.Icon = icon
+		}
+	}
+}
+
+func (s *markerScene) Draw(g *Game, screen *ebiten.Image) {
+	px, py, pw, ph := markerPanelRect()
+	ebitenutil.DrawRect(screen, float64(px), float64(py), float64(pw), float64(ph), color.RGBA{30, 30, 40, 240})
+	drawRectOutline(screen, float64(px), float64(py), float64(pw), float64(ph), 1, color.RGBA{120, 120, 140, 255})
+
+	header := "Метка"
+	if s.isNew {
+		header = "Новая метка"
+	}
+	header += fmt.Sprintf(" (%d, %d)", s.marker.X, s.marker.Y)
+	text.Draw(screen, header, g.font, px+10, py+25, color.RGBA{255, 255, 0, 255})
+
+	editable := g.isGM()
+	title := s.marker.Title
+	if s.field == markerFieldTitle {
+		title += "_"
+	}
+	tx, ty, tw, th := markerTitleRect()
+	text.Draw(screen, "Название:", g.font, tx, ty-6, color.White)
+	g.drawTextBox(screen, tx, ty, tw, th, title, s.field == markerFieldTitle)
+
+	body := s.marker.Text
+	if s.field == markerFieldText {
+		body += "_"
+	} else if body == "" && editable {
+		body = "Щёлкните, чтобы добавить описание"
+	}
+	bx, by, bw, bh := markerTextRect()
+	text.Draw(screen, "Описание:", g.font, bx, by-6, color.White)
+	g.drawTextBox(screen, bx, by, bw, bh, body, s.field == markerFieldText)
+
+	for icon := MarkerIcon(0); icon < markerIconCount; icon++ {
+		if !editable && icon != s.marker.Icon {
+			continue
+		}
+		x, y, w, h := markerIconRect(icon)
+		if !editable {
+			x, _, _, _ = markerIconRect(0)
+		}
+		g.drawMarkerIcon(screen, float64(x), float64(y), float64(w), Marker{Icon: icon})
+		if icon == s.marker.Icon {
+			drawRectOutline(screen, float64(x-2), float64(y-2), float64(w+4), float64(h+4), 2, color.RGBA{255, 255, 0, 255})
+		}
+	}
+	_, iy, _, _ := markerIconRect(s.marker.Icon)
+	ix := px + 10 + int(markerIconCount)*(markerIconBoxPx+8)
+	if !editable {
+		ix = px + 20 + markerIconBoxPx
+	}
+	text.Draw(screen, markerIconNames[s.marker.Icon], g.font, ix, iy+23, color.White)
+
+	if editable {
+		cx, cy, cw, ch := markerGMOnlyRect()
+		ebitenutil.DrawRect(screen, float64(cx), float64(cy), float64(cw), float64(ch), color.RGBA{20, 20, 25, 255})
+		if s.marker.GMOnly {
+			ebitenutil.DrawRect(screen, float64(cx+3), float64(cy+3), float64(cw-6), float64(ch-6), color.RGBA{255, 255, 0, 255})
+		}
+		text.Draw(screen, "Только для мастера", g.font, cx+cw+8, cy+13, color.White)
+	}
+
+	for _, btn := range s.buttons(g) {
+		clr := color.RGBA{70, 70, 90, 255}
+		if btn.label == "Удалить" || btn.label == "Закрыть" {
+			clr = color.RGBA{100, 0, 0, 255}
+		}
+		ebitenutil.DrawRect(screen, float64(btn.x), float64(btn.y), float64(btn.w), float64(btn.h), clr)
+		text.Draw(screen, btn.label, g.font, btn.x+8, btn.y+18, color.White)
+	}
+}
+
+// drawTextBox рисует поле с текстом, перенося строки по ширине поля
+func (g *Game) drawTextBox(screen *ebiten.Image, x, y, w, h int, content string, active bool) {
+	boxColor := color.RGBA{20, 20, 25, 255}
+	if active {
+		boxColor = color.RGBA{40, 40, 55, 255}
+	}
+	ebitenutil.DrawRect(screen, float64(x), float64(y), float64(w), float64(h), boxColor)
+
+	lineY := y + 18
+	for _, paragraph := range strings.Split(content, "\n") {
+		for _, line := range wrapText(paragraph, w-10, g.font) {
+			if lineY > y+h-4 {
+				return
+			}
+			text.Draw(screen, line, g.font, x+5, lineY, color.RGBA{220, 220, 220, 255})
+			lineY += 18
+		}
+	}
+}
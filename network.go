@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/gob"
+	"log"
+	"sync"
+)
+
+// clientConn - подключение игрока на стороне сервера. Отправка идёт из разных
+// горутин, поэтому кодировщик защищён своим мьютексом.
+type clientConn struct {
+	mu      sync.Mutex
+	encoder *gob.Encoder
+}
+
+func (c *clientConn) send(msg NetMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.encoder.Encode(msg)
+}
+
+// broadcast рассылает сообщение всем клиентам, кроме except
+func (g *Game) broadcast(msg NetMessage, except string) {
+	g.mu.Lock()
+	clients := make(map[string]*clientConn, len(g.clients))
+	for id, c := range g.clients {
+		if id != except {
+			clients[id] = c
+		}
+	}
+	g.mu.Unlock()
+
+	for id, c := range clients {
+		if err := c.send(msg); err != nil {
+			log.Printf("[ERROR] Ошибка рассылки игроку %s: %v", id, err)
+		}
+	}
+}
+
+// sendTo отправляет каждому игроку его сообщение; вызывается без g.mu
+func (g *Game) sendTo(msgs map[string]NetMessage) {
+	g.mu.Lock()
+	clients := make(map[string]*clientConn, len(msgs))
+	for id := range msgs {
+		if c := g.clients[id]; c != nil {
+			clients[id] = c
+		}
+	}
+	g.mu.Unlock()
+
+	for id, c := range clients {
+		if err := c.send(msgs[id]); err != nil {
+			log.Printf("[ERROR] Ошибка отправки игроку %s: %v", id, err)
+		}
+	}
+}
+
+// handleClientMessage обрабатывает сообщение клиента на сервере
+func (g *Game) handleClientMessage(from string, msg NetMessage) {
+	switch msg.Kind {
+	case MsgPlayer:
+		if msg.Player == nil {
+			return
+		}
+		update := *msg.Player
+		update.ID = from // Клиент не может двигать чужую фишку
+
+		g.mu.Lock()
+		update = g.checkMove(g.players[from], update)
+		g.players[from] = update
+		fog := g.revealAround(from, update)
+		g.mu.Unlock()
+
+		g.broadcast(NetMessage{Kind: MsgPlayer, Player: &update}, from)
+		if fog != nil {
+			g.sendTo(map[string]NetMessage{from: {Kind: MsgFog, Fog: fog}})
+		}
+	case MsgEnterCity:
+		g.enterCity(from, msg.ID)
+	case MsgEnterDungeon:
+		g.enterDungeon(from, msg.ID)
+	case MsgRoll:
+		if msg.Roll != nil {
+			g.performRoll(from, *msg.Roll)
+		}
+	case MsgChat:
+		if msg.Chat != nil {
+			g.postChat(from, *msg.Chat)
+		}
+	case MsgPortrait:
+		if err := checkPortrait(msg.Portrait); err != nil {
+			log.Printf("[ERROR] Портрет игрока %s отклонён: %v", from, err)
+			return
+		}
+		g.mu.Lock()
+		g.setPortrait(from, msg.Portrait)
+		g.mu.Unlock()
+		g.broadcast(NetMessage{Kind: MsgPortrait, ID: from, Portrait: msg.Portrait}, from)
+	default:
+		log.Printf("[ERROR] Неизвестное сообщение от %s: %q", from, msg.Kind)
+	}
+}
+
+// checkMove проверяет ход фишки, присланный клиентом: за сообщение фишка
+// сдвигается не больше чем на клетку и не выходит за карту, а войти можно
+// только в город, который игрок уже открыл. Вызывается под g.mu.
+func (g *Game) checkMove(prev, next Player) Player {
+	if w, h := g.mapBounds(worldMapKey); w > 0 && h > 0 {
+		next.X = clamp(next.X, max(prev.X-1, 0), min(prev.X+1, w-1))
+		next.Y = clamp(next.Y, max(prev.Y-1, 0), min(prev.Y+1, h-1))
+	}
+	if next.Map == "" {
+		return next
+	}
+
+	m := g.cityMapByKey(next.Map)
+	if m == nil || !g.cityKnown(next.ID, m.City) {
+		log.Printf("[ERROR] Игрок %s идёт в неизвестный ему город %q", next.ID, next.Map)
+		next.Map, next.MapX, next.MapY = prev.Map, prev.MapX, prev.MapY
+		return next
+	}
+	if next.Map != prev.Map {
+		// Фишка входит в город у ворот, как при MsgEnterCity
+		next.MapX, next.MapY = cityEntry(m)
+		return next
+	}
+	next.MapX = clamp(next.MapX, max(prev.MapX-1, 0), min(prev.MapX+1, len(m.Grid[0])-1))
+	next.MapY = clamp(next.MapY, max(prev.MapY-1, 0), min(prev.MapY+1, len(m.Grid)-1))
+	return next
+}
+
+// handleServerMessage обрабатывает сообщение сервера на клиенте
+func (g *Game) handleServerMessage(msg NetMessage) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch msg.Kind {
+	case MsgPlayer:
+		if msg.Player != nil && msg.Player.ID != g.me.ID {
+			g.players[msg.Player.ID] = *msg.Player
+		}
+	case MsgPlayerLeft:
+		delete(g.players, msg.ID)
+		g.setPortrait(msg.ID, nil)
+	case MsgPortrait:
+		g.setPortrait(msg.ID, msg.Portrait)
+	case MsgRoll:
+		if msg.Roll != nil {
+			g.addRoll(*msg.Roll)
+		}
+	case MsgChat:
+		if msg.Chat != nil {
+			g.addChat(*msg.Chat)
+		}
+	case MsgMarker:
+		if msg.Marker != nil {
+			marker := *msg.Marker
+			g.markers[marker.ID] = &marker
+		}
+	case MsgMarkerDelete:
+		delete(g.markers, msg.ID)
+	case MsgEdit, MsgFog, MsgCityState, MsgDungeon:
+		// Карты меняются только между кадрами, поэтому откладываем до Update
+		g.inbox = append(g.inbox, msg)
+	default:
+		log.Printf("[ERROR] Неизвестное сообщение сервера: %q", msg.Kind)
+	}
+}
+
+// processInbox применяет отложенные сообщения сервера
+func (g *Game) processInbox() {
+	g.mu.Lock()
+	msgs := g.inbox
+	g.inbox = nil
+	g.mu.Unlock()
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Edit != nil:
+			g.applyEdit(*msg.Edit)
+		case msg.Fog != nil:
+			g.applyFog(*msg.Fog)
+		case msg.City != nil:
+			g.openCityState(*msg.City)
+		case msg.Dungeon != nil:
+			if site := g.findDungeonByKey(msg.ID); site != nil {
+				g.openDungeon(site, msg.Dungeon)
+			}
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const (
+	portraitSize     = 64        // Сторона портрета, который уходит по сети
+	maxPortraitBytes = 64 * 1024 // Больше сервер не принимает
+	partyPanelW      = 300
+	partyRowH        = 44
+)
+
+// partyMemberOf собирает сводку листа для фишки
+func partyMemberOf(char *Character) PartyMember {
+	if char == nil {
+		return PartyMember{}
+	}
+	return PartyMember{
+		Name:  char.Name,
+		Class: char.Class,
+		Level: char.Level,
+		HP:    char.HP,
+		AC:    char.AC,
+	}
+}
+
+// chooseCharacter спрашивает при подключении, каким персонажем играет игрок
+func (g *Game) chooseCharacter() {
+	if err := g.loadAllCharacters(); err != nil {
+		fmt.Println("Персонажи не загружены, игра без персонажа")
+		return
+	}
+
+	fmt.Println("Выберите персонажа (0 - без персонажа):")
+	for i, ch := range g.characters {
+		fmt.Printf("%d. %s - %s %d уровня\n", i+1, ch.Name, ch.Class, ch.Level)
+	}
+	var n int
+	if _, err := fmt.Scanln(&n); err != nil || n < 1 || n > len(g.characters) {
+		fmt.Println("Игра без персонажа")
+		return
+	}
+
+	g.characterIndex = n - 1
+	g.currentCharacter = g.characters[g.characterIndex]
+	g.playerCharacter = g.currentCharacter
+	g.me.Character = partyMemberOf(g.playerCharacter)
+	if debugMode {
+		log.Printf("[DEBUG] Выбран персонаж: %s", g.playerCharacter.Name)
+	}
+}
+
+// sendPortrait отправляет серверу портрет выбранного персонажа
+func (g *Game) sendPortrait() {
+	if g.playerCharacter == nil || len(g.playerCharacter.Portrait) == 0 {
+		return
+	}
+	thumb, err := portraitThumbnail(g.playerCharacter.Portrait)
+	if err != nil {
+		log.Printf("[ERROR] Ошибка подготовки портрета %s: %v", g.playerCharacter.Name, err)
+		return
+	}
+
+	g.mu.Lock()
+	g.portraitData[g.me.ID] = thumb
+	g.mu.Unlock()
+	if err := g.encoder.Encode(NetMessage{Kind: MsgPortrait, ID: g.me.ID, Portrait: thumb}); err != nil {
+		log.Println("Ошибка отправки портрета:", err)
+	}
+}
+
+// setPortrait запоминает портрет игрока; картинка для отрисовки создаётся
+// заново при следующем кадре. Вызывается под g.mu.
+func (g *Game) setPortrait(id string, data []byte) {
+	if data == nil {
+		delete(g.portraitData, id)
+	} else {
+		g.portraitData[id] = data
+	}
+	delete(g.portraits, id)
+}
+
+// portrait возвращает портрет игрока для отрисовки или nil. Вызывается под g.mu.
+func (g *Game) portrait(id string) *ebiten.Image {
+	if img := g.portraits[id]; img != nil {
+		return img
+	}
+	data := g.portraitData[id]
+	if data == nil {
+		return nil
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[ERROR] Ошибка чтения портрета %s: %v", id, err)
+		delete(g.portraitData, id)
+		return nil
+	}
+	img := ebiten.NewImageFromImage(src)
+	g.portraits[id] = img
+	return img
+}
+
+// checkPortrait проверяет присланный клиентом портрет, не декодируя его целиком
+func checkPortrait(data []byte) error {
+	if len(data) > maxPortraitBytes {
+		return fmt.Errorf("портрет %d байт, допустимо %d", len(data), maxPortraitBytes)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if cfg.Width > portraitSize || cfg.Height > portraitSize {
+		return fmt.Errorf("портрет %dx%d, допустимо %dx%d", cfg.Width, cfg.Height, portraitSize, portraitSize)
+	}
+	return nil
+}
+
+// portraitThumbnail вырезает из картинки центральный квадрат и уменьшает его
+// до portraitSize, чтобы портрет не нагружал сеть
+func portraitThumbnail(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	if side == 0 {
+		return nil, errors.New("пустая картинка")
+	}
+	ox, oy := b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2
+
+	size := min(side, portraitSize)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dst.Set(x, y, src.At(ox+x*side/size, oy+y*side/size))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeDataURL достаёт байты картинки из строки вида data:image/jpeg;base64,...
+func decodeDataURL(url string) ([]byte, error) {
+	comma := strings.IndexByte(url, ',')
+	if !strings.HasPrefix(url, "data:") || comma < 0 || !strings.HasSuffix(url[:comma], ";base64") {
+		return nil, errors.New("ожидается data URL в base64")
+	}
+	return base64.StdEncoding.DecodeString(url[comma+1:])
+}
+
+// loadPortraitFile ищет картинку с тем же именем, что и файл персонажа
+func loadPortraitFile(sheetPath string) []byte {
+	base := strings.TrimSuffix(sheetPath, ".json")
+	for _, ext := range []string{".png", ".jpg", ".jpeg", ".webp"} {
+		data, err := os.ReadFile(base + ext)
+		if err == nil {
+			return data
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[ERROR] Ошибка чтения портрета %s: %v", base+ext, err)
+		}
+	}
+	return nil
+}
+
+// drawToken рисует фишку игрока: портрет или цвет игрока; label добавляет
+// имя персонажа над фишкой
+func (g *Game) drawToken(screen *ebiten.Image, p Player, x, y, size float64, label bool) {
+	g.mu.Lock()
+	img := g.portrait(p.ID)
+	g.mu.Unlock()
+
+	if img != nil {
+		b := img.Bounds()
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(size/float64(b.Dx()), size/float64(b.Dy()))
+		op.GeoM.Translate(x, y)
+		op.Filter = ebiten.FilterLinear
+		screen.DrawImage(img, op)
+		drawRectOutline(screen, x, y, size, size, math.Max(1, size/12), p.Color)
+	} else {
+		ebitenutil.DrawRect(screen, x, y, size, size, p.Color)
+	}
+	if p.ID == g.me.ID {
+		drawRectOutline(screen, x-1, y-1, size+2, size+2, 1, color.White)
+	}
+
+	if !label || p.Character.Name == "" {
+		return
+	}
+	bounds := text.BoundString(g.font, p.Character.Name)
+	lx := int(x+size/2) - bounds.Dx()/2
+	ly := int(y) - 4
+	ebitenutil.DrawRect(screen, float64(lx-3), float64(ly+bounds.Min.Y-2), float64(bounds.Dx()+6), float64(bounds.Dy()+4),
+		color.RGBA{0, 0, 0, 170})
+	text.Draw(screen, p.Character.Name, g.font, lx, ly, color.White)
+}
+
+// updatePartyPanel прячет и показывает панель отряда мастера
+func (g *Game) updatePartyPanel() {
+	if g.isGM() && inpututil.IsKeyJustPressed(ebiten.KeyO) {
+		g.partyPanelHidden = !g.partyPanelHidden
+	}
+}
+
+// drawPartyPanel показывает мастеру всех подключённых игроков: персонажа,
+// хиты, класс доспеха и где стоит фишка
+func (g *Game) drawPartyPanel(screen *ebiten.Image, top int) {
+	if !g.isGM() || g.partyPanelHidden {
+		return
+	}
+
+	g.mu.Lock()
+	party := make([]Player, 0, len(g.players))
+	for _, p := range g.players {
+		party = append(party, p)
+	}
+	g.mu.Unlock()
+	sort.Slice(party, func(i, j int) bool { return party[i].ID < party[j].ID })
+
+	x := screenWidth - partyPanelW - 10
+	h := 30 + max(len(party), 1)*partyRowH
+	ebitenutil.DrawRect(screen, float64(x), float64(top), partyPanelW, float64(h), color.RGBA{30, 30, 40, 220})
+	text.Draw(screen, fmt.Sprintf("Отряд (%d) - O скрыть", len(party)), g.font, x+10, top+20,
+		color.RGBA{255, 255, 0, 255})
+	if len(party) == 0 {
+		text.Draw(screen, "Игроков нет", g.font, x+10, top+50, color.RGBA{180, 180, 180, 255})
+		return
+	}
+
+	for i, p := range party {
+		y := top + 30 + i*partyRowH
+		g.drawToken(screen, p, float64(x+8), float64(y+4), partyRowH-10, false)
+
+		name, stats := p.ID, "без персонажа"
+		if c := p.Character; c.Name != "" {
+			name = c.Name
+			stats = fmt.Sprintf("%s %d | HP %s | AC %s", c.Class, c.Level, orUnknown(c.HP), orUnknown(c.AC))
+		}
+		text.Draw(screen, name+" - "+g.playerLocation(p), g.font, x+partyRowH+4, y+17, color.White)
+		text.Draw(screen, stats, g.font, x+partyRowH+4, y+35, color.RGBA{200, 200, 200, 255})
+	}
+}
+
+// playerLocation описывает, где стоит фишка: в городе или на карте мира
+func (g *Game) playerLocation(p Player) string {
+	if p.Map != "" {
+		for _, city := range g.cityList {
+			if cityMapKey(city) == p.Map {
+				return city.Name
+			}
+		}
+	}
+	return fmt.Sprintf("(%d, %d)", p.X, p.Y)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "?"
+	}
+	return s
+}
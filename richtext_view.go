@@ -0,0 +1,227 @@
+package main
+
+import (
+	"image/color"
+	"strings"
+	"unicode/utf8"
+
+	"test/internal/richtext"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+	"golang.org/x/image/font"
+)
+
+const (
+	richLineH      = 18
+	richIndent     = 24 // Отступ на уровень списка
+	richQuoteW     = 12 // Отступ цитаты, в нём рисуется полоса
+	richGap        = 4  // Между абзацами
+	richScrollbarW = 6
+)
+
+// richSpan - кусок строки одним шрифтом и начертанием
+type richSpan struct {
+	x, w  int
+	text  string
+	face  font.Face
+	style richtext.Style
+	link  bool
+}
+
+// richLine - строка разложенного документа; координаты от верха документа
+type richLine struct {
+	top, height, baseline int
+	left                  int // Отступ строки: списки и цитаты
+	quote                 bool
+	rule                  bool // Горизонтальная черта вместо текста
+	spans                 []richSpan
+}
+
+// face выбирает шрифт фрагмента: у заголовков свой размер, у текста - начертание
+func (fs fontSet) face(b richtext.Block, style richtext.Style) font.Face {
+	if b.Kind == richtext.Heading {
+		return fs.headings[min(b.Level, len(fs.headings))-1]
+	}
+	switch {
+	case style&richtext.Bold != 0 && style&richtext.Italic != 0:
+		return fs.boldItalic
+	case style&richtext.Bold != 0:
+		return fs.bold
+	case style&richtext.Italic != 0:
+		return fs.italic
+	}
+	return fs.regular
+}
+
+// layoutRich раскладывает документ по строкам шириной width с переносом по
+// словам; возвращает строки и высоту всего текста
+func (fs fontSet) layoutRich(doc *richtext.Doc, width int) ([]richLine, int) {
+	var lines []richLine
+	top := 0
+	for i, b := range doc.Blocks {
+		left := b.Indent * richIndent
+		if b.Quote {
+			left += richQuoteW
+		}
+		if b.Kind == richtext.Rule {
+			lines = append(lines, richLine{top: top, height: richLineH, left: left, quote: b.Quote, rule: true})
+			top += richLineH
+			continue
+		}
+		lh := richLineH
+		if b.Kind == richtext.Heading {
+			lh = max(lh, fs.face(b, 0).Metrics().Height.Ceil()+4)
+			if i > 0 {
+				top += richGap * 2
+			}
+		}
+
+		cur := richLine{left: left, quote: b.Quote}
+		x := left
+		newLine := func() {
+			cur.top, cur.height, cur.baseline = top, lh, top+lh-lh/4
+			lines = append(lines, cur)
+			top += lh
+			cur = richLine{left: left, quote: b.Quote}
+			x = left
+		}
+		if b.Marker != "" {
+			w := font.MeasureString(fs.regular, b.Marker).Ceil()
+			cur.spans = append(cur.spans, richSpan{x: max(left-w-6, 0), w: w, text: b.Marker, face: fs.regular})
+		}
+		for _, run := range b.Runs {
+			face := fs.face(b, run.Style)
+			for j, segment := range strings.Split(run.Text, "\n") {
+				if j > 0 {
+					newLine()
+				}
+				for _, word := range strings.SplitAfter(segment, " ") {
+					for word != "" {
+						trimmed := strings.TrimRight(word, " ")
+						if x == left && trimmed == "" {
+							break // Пробелы в начале перенесённой строки не нужны
+						}
+						tw := font.MeasureString(face, trimmed).Ceil()
+						if x > left && x+tw > width {
+							newLine()
+							continue
+						}
+						// Слово длиннее строки режем по буквам
+						part := word
+						if tw > width-left {
+							part = fitRunes(word, width-left, face)
+						}
+						w := font.MeasureString(face, part).Ceil()
+						cur.appendSpan(richSpan{x: x, w: w, text: part, face: face, style: run.Style, link: run.Link != ""})
+						x += w
+						word = word[len(part):]
+						if word != "" {
+							newLine()
+						}
+					}
+				}
+			}
+		}
+		newLine()
+		top += richGap
+	}
+	return lines, top
+}
+
+// appendSpan добавляет кусок к строке, склеивая его с предыдущим того же вида
+func (l *richLine) appendSpan(s richSpan) {
+	if n := len(l.spans); n > 0 {
+		last := &l.spans[n-1]
+		if last.face == s.face && last.style == s.style && last.link == s.link && last.x+last.w == s.x {
+			last.text += s.text
+			last.w += s.w
+			return
+		}
+	}
+	l.spans = append(l.spans, s)
+}
+
+// fitRunes - самое длинное начало строки, которое помещается в ширину w;
+// хотя бы одна буква, чтобы раскладка не зациклилась
+func fitRunes(s string, w int, face font.Face) string {
+	end := 0
+	for i, r := range s {
+		next := i + utf8.RuneLen(r)
+		if end > 0 && font.MeasureString(face, s[:next]).Ceil() > w {
+			break
+		}
+		end = next
+	}
+	return s[:end]
+}
+
+// richLayout - раскладка документа, которая пересчитывается, только когда
+// меняется документ или ширина
+type richLayout struct {
+	doc    *richtext.Doc
+	width  int
+	lines  []richLine
+	height int
+}
+
+func (r *richLayout) update(g *Game, doc *richtext.Doc, width int) {
+	if doc == r.doc && width == r.width {
+		return
+	}
+	r.doc, r.width = doc, width
+	r.lines, r.height = nil, 0
+	if doc != nil {
+		r.lines, r.height = g.fonts.layoutRich(doc, width)
+	}
+}
+
+// drawRichLines рисует разложенный текст с левым верхним углом в x, y;
+// обрезку по области делает dst, если это SubImage
+func drawRichLines(dst *ebiten.Image, lines []richLine, x, y int) {
+	quoteClr := color.RGBA{120, 120, 140, 255}
+	clip := dst.Bounds()
+	for _, l := range lines {
+		top := y + l.top
+		if top+l.height < clip.Min.Y || top > clip.Max.Y {
+			continue
+		}
+		if l.quote {
+			ebitenutil.DrawRect(dst, float64(x+l.left-richQuoteW), float64(top), 3, float64(l.height), quoteClr)
+		}
+		if l.rule {
+			ebitenutil.DrawRect(dst, float64(x+l.left), float64(top+l.height/2), float64(clip.Max.X-x-l.left), 1, quoteClr)
+			continue
+		}
+		base := y + l.baseline
+		for _, s := range l.spans {
+			var clr color.Color = color.White
+			switch {
+			case s.link:
+				clr = color.RGBA{120, 170, 255, 255}
+			case s.style&richtext.Code != 0:
+				clr = color.RGBA{220, 200, 140, 255}
+			}
+			text.Draw(dst, s.text, s.face, x+s.x, base, clr)
+			if s.link || s.style&richtext.Underline != 0 {
+				ebitenutil.DrawRect(dst, float64(x+s.x), float64(base+2), float64(s.w), 1, clr)
+			}
+			if s.style&richtext.Strike != 0 {
+				ebitenutil.DrawRect(dst, float64(x+s.x), float64(base-5), float64(s.w), 1, clr)
+			}
+		}
+	}
+}
+
+// drawScrollbar рисует полосу прокрутки справа от области высотой h, если
+// содержимое выше неё
+func drawScrollbar(screen *ebiten.Image, x, y, h, contentH, scroll int) {
+	if contentH <= h {
+		return
+	}
+	ebitenutil.DrawRect(screen, float64(x), float64(y), richScrollbarW, float64(h), color.RGBA{50, 50, 60, 255})
+	thumb := max(h*h/contentH, 20)
+	thumbY := y + (h-thumb)*scroll/max(contentH-h, 1)
+	ebitenutil.DrawRect(screen, float64(x), float64(thumbY), richScrollbarW, float64(thumb), color.RGBA{150, 150, 170, 255})
+}
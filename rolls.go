@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"strings"
+	"time"
+
+	"test/internal/rules"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const (
+	maxRollLog     = 200 // Столько бросков хранит журнал
+	maxRollFormula = 80
+	rollJoinLog    = 50 // Столько последних бросков получает новый игрок
+	rollToastTime  = 8 * time.Second
+	rollToasts     = 3
+	rollPanelW     = 760
+	rollPanelH     = 640
+	rollLineH      = 18
+)
+
+// rollPreset - готовый бросок в окне бросков
+type rollPreset struct {
+	label   string
+	formula string
+}
+
+// rollCharacter возвращает персонажа, от которого считаются готовые броски:
+// у игрока - его персонаж, у мастера - открытый в окне персонажа
+func (g *Game) rollCharacter() *Character {
+	if g.playerCharacter != nil {
+		return g.playerCharacter
+	}
+	return g.currentCharacter
+}
+
+// rollPresets собирает проверки и спасброски по производным числам персонажа
+func (g *Game) rollPresets() []rollPreset {
+	char := g.rollCharacter()
+	if char == nil {
+		return nil
+	}
+	d := char.Derived()
+	presets := []rollPreset{{"Инициатива", fmt.Sprintf("1d20%+d", d.Initiative)}}
+	for _, stat := range rules.Abilities {
+		presets = append(presets, rollPreset{"Проверка: " + rules.AbilityNames[stat],
+			fmt.Sprintf("1d20%+d", d.Modifiers[stat])})
+	}
+	for _, stat := range rules.Abilities {
+		presets = append(presets, rollPreset{"Спасбросок: " + rules.AbilityNames[stat],
+			fmt.Sprintf("1d20%+d", d.Saves[stat])})
+	}
+	return presets
+}
+
+// requestRoll просит бросок. Кости бросает только сервер, чтобы результат
+// нельзя было подделать; мастер бросает сам, он и есть сервер.
+func (g *Game) requestRoll(label, formula string, hidden bool) {
+	req := RollRecord{Label: label, Formula: strings.TrimSpace(formula), Hidden: hidden}
+	if g.isGM() {
+		g.performRoll(g.me.ID, req)
+		return
+	}
+	req.Hidden = false
+	if err := g.encoder.Encode(NetMessage{Kind: MsgRoll, Roll: &req}); err != nil {
+		log.Println("Ошибка отправки броска:", err)
+	}
+}
+
+// performRoll бросает кости на сервере, записывает бросок в журнал и
+// рассылает его. Скрытый бросок мастера никуда не уходит, а ошибку в формуле
+// видит только тот, кто бросал.
+func (g *Game) performRoll(from string, req RollRecord) {
+	rec := RollRecord{
+		Player:  from,
+		Name:    from,
+		Label:   req.Label,
+		Formula: req.Formula,
+		Hidden:  req.Hidden && from == g.me.ID,
+		Time:    time.Now(),
+	}
+	if len([]rune(rec.Formula)) > maxRollFormula {
+		rec.Error = fmt.Sprintf("формула длиннее %d символов", maxRollFormula)
+	}
+
+	g.mu.Lock()
+	if from == g.me.ID {
+		if char := g.rollCharacter(); char != nil {
+			rec.Name = char.Name
+		}
+	} else if p, ok := g.players[from]; ok && p.Character.Name != "" {
+		rec.Name = p.Character.Name
+	}
+	if rec.Error == "" {
+		if res, err := g.roller.Roll(rec.Formula); err != nil {
+			rec.Error = err.Error()
+		} else {
+			rec.Formula = res.Expr.String()
+			rec.Total = res.Total
+			rec.Breakdown = res.String()
+		}
+	}
+	if rec.Error == "" || from == g.me.ID {
+		g.addRoll(rec)
+	}
+	g.mu.Unlock()
+
+	if debugMode {
+		log.Printf("[DEBUG] Бросок %s: %s = %d %s", rec.Name, rec.Formula, rec.Total, rec.Error)
+	}
+	switch {
+	case rec.Error != "":
+		if from != g.me.ID {
+			g.sendTo(map[string]NetMessage{from: {Kind: MsgRoll, Roll: &rec}})
+		}
+	case !rec.Hidden:
+		g.broadcast(NetMessage{Kind: MsgRoll, Roll: &rec}, "")
+	}
+}
+
+// addRoll добавляет бросок в журнал. Вызывается под g.mu.
+func (g *Game) addRoll(rec RollRecord) {
+	rec.seen = time.Now()
+	g.rollLog = append(g.rollLog, rec)
+	if len(g.rollLog) > maxRollLog {
+		g.rollLog = g.rollLog[len(g.rollLog)-maxRollLog:]
+	}
+}
+
+// publicRolls возвращает последние открытые броски для нового игрока. Вызывается под g.mu.
+func (g *Game) publicRolls() []RollRecord {
+	var rolls []RollRecord
+	for i := len(g.rollLog) - 1; i >= 0 && len(rolls) < rollJoinLog; i-- {
+		if rec := g.rollLog[i]; !rec.Hidden && rec.Error == "" {
+			rolls = append(rolls, rec)
+		}
+	}
+	for i, j := 0, len(rolls)-1; i < j; i, j = i+1, j-1 {
+		rolls[i], rolls[j] = rolls[j], rolls[i]
+	}
+	return rolls
+}
+
+// rollLines - строки записи журнала: кто и что бросал, затем разбивка
+func rollLines(rec RollRecord) (head, body string, clr color.RGBA) {
+	head = fmt.Sprintf("[%s] %s", rec.Time.Format("15:04"), rec.Name)
+	if rec.Label != "" {
+		head += " - " + rec.Label
+	}
+	if rec.Hidden {
+		head += " (скрыто)"
+	}
+	if rec.Error != "" {
+		return head, fmt.Sprintf("%s: %s", rec.Formula, rec.Error), color.RGBA{255, 100, 100, 255}
+	}
+	clr = color.RGBA{255, 255, 0, 255}
+	if rec.Hidden {
+		clr = color.RGBA{180, 140, 255, 255}
+	}
+	return head, fmt.Sprintf("%s => %d", rec.Breakdown, rec.Total), clr
+}
+
+// rollScene - окно бросков: журнал, ввод формулы и готовые броски персонажа
+type rollScene struct {
+	formula string
+	hidden  bool
+}
+
+func (*rollScene) Overlay() bool { return true }
+
+// openRollWindow открывает окно бросков поверх текущей сцены
+func (g *Game) openRollWindow() {
+	g.scenes.Push(&rollScene{formula: "1d20"})
+}
+
+func rollPanelRect() (x, y, w, h int) {
+	return (screenWidth - rollPanelW) / 2, (screenHeight - rollPanelH) / 2, rollPanelW, rollPanelH
+}
+
+func rollInputRect() (x, y, w, h int) {
+	px, py, _, ph := rollPanelRect()
+	return px + 10, py + ph - 240, 360, 26
+}
+
+func rollHiddenRect() (x, y, w, h int) {
+	ix, iy, iw, _ := rollInputRect()
+	return ix + iw + 110, iy + 5, 16, 16
+}
+
+func (s *rollScene) buttons(g *Game) []interiorButton {
+	px, py, pw, ph := rollPanelRect()
+	ix, iy, iw, ih := rollInputRect()
+	buttons := []interiorButton{
+		{ix + iw + 10, iy, 90, ih, "Бросить", func(g *Game) { g.requestRoll("", s.formula, s.hidden) }},
+		{px + pw - 90, py + ph - 35, 80, 25, "Закрыть", func(g *Game) { g.scenes.Pop() }},
+	}
+
+	x, y := px+10, iy+36
+	for _, sides := range []int{4, 6, 8, 10, 12, 20, 100} {
+		formula := fmt.Sprintf("1d%d", sides)
+		buttons = append(buttons, interiorButton{x, y, 60, 25, fmt.Sprintf("d%d", sides), func(g *Game) {
+			g.requestRoll("", formula, s.hidden)
+		}})
+		x += 65
+	}
+
+	x, y = px+10, y+35
+	for i, preset := range g.rollPresets() {
+		p := preset
+		bx := px + 10 + (i%4)*(pw-20)/4
+		by := y + (i/4)*30
+		buttons = append(buttons, interiorButton{bx, by, (pw-20)/4 - 5, 25, p.label, func(g *Game) {
+			g.requestRoll(p.label, p.formula, s.hidden)
+		}})
+	}
+	return buttons
+}
+
+func (s *rollScene) Update(g *Game) {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.scenes.Pop()
+		return
+	}
+	editText(&s.formula, maxRollFormula, false)
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) && strings.TrimSpace(s.formula) != "" {
+		g.requestRoll("", s.formula, s.hidden)
+	}
+
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return
+	}
+	mx, my := ebiten.CursorPosition()
+	for _, btn := range s.buttons(g) {
+		if mx >= btn.x && mx <= btn.x+btn.w && my >= btn.y && my <= btn.y+btn.h {
+			btn.action(g)
+			return
+		}
+	}
+	if cx, cy, cw, ch := rollHiddenRect(); g.isGM() && mx >= cx && mx <= cx+cw && my >= cy && my <= cy+ch {
+		s.hidden = !s.hidden
+	}
+}
+
+func (s *rollScene) Draw(g *Game, screen *ebiten.Image) {
+	px, py, pw, ph := rollPanelRect()
+	ebitenutil.DrawRect(screen, float64(px), float64(py), float64(pw), float64(ph), color.RGBA{30, 30, 40, 240})
+	drawRectOutline(screen, float64(px), float64(py), float64(pw), float64(ph), 1, color.RGBA{120, 120, 140, 255})
+	text.Draw(screen, "Броски костей", g.font, px+10, py+25, color.RGBA{255, 255, 0, 255})
+	if char := g.rollCharacter(); char != nil {
+		text.Draw(screen, "Персонаж: "+char.Name, g.font, px+200, py+25, color.White)
+	}
+
+	// Журнал: последние броски снизу, сколько поместится
+	ix, iy, iw, ih := rollInputRect()
+	logTop, logBottom := py+40, iy-30
+	ebitenutil.DrawRect(screen, float64(px+10), float64(logTop), float64(pw-20), float64(logBottom-logTop+10), color.RGBA{20, 20, 25, 255})
+	g.mu.Lock()
+	rolls := append([]RollRecord(nil), g.rollLog...)
+	g.mu.Unlock()
+	y := logBottom
+	for i := len(rolls) - 1; i >= 0 && y-2*rollLineH > logTop; i-- {
+		head, body, clr := rollLines(rolls[i])
+		text.Draw(screen, truncateText(body, pw-40, g), g.font, px+20, y, clr)
+		text.Draw(screen, head, g.font, px+20, y-rollLineH, color.RGBA{200, 200, 200, 255})
+		y -= 2*rollLineH + 6
+	}
+	if len(rolls) == 0 {
+		text.Draw(screen, "Бросков пока не было", g.font, px+20, logTop+24, color.RGBA{150, 150, 150, 255})
+	}
+
+	text.Draw(screen, "Формула (Enter - бросить): 4d6kh3, 1d20+5, 2d6!, 10d10>=8", g.font, ix, iy-8, color.White)
+	g.drawTextBox(screen, ix, iy, iw, ih, s.formula+"_", true)
+	if g.isGM() {
+		cx, cy, cw, ch := rollHiddenRect()
+		ebitenutil.DrawRect(screen, float64(cx), float64(cy), float64(cw), float64(ch), color.RGBA{20, 20, 25, 255})
+		if s.hidden {
+			ebitenutil.DrawRect(screen, float64(cx+3), float64(cy+3), float64(cw-6), float64(ch-6), color.RGBA{255, 255, 0, 255})
+		}
+		text.Draw(screen, "Скрытый бросок", g.font, cx+cw+8, cy+13, color.White)
+	}
+
+	for _, btn := range s.buttons(g) {
+		clr := color.RGBA{70, 70, 90, 255}
+		if btn.label == "Закрыть" {
+			clr = color.RGBA{100, 0, 0, 255}
+		}
+		ebitenutil.DrawRect(screen, float64(btn.x), float64(btn.y), float64(btn.w), float64(btn.h), clr)
+		text.Draw(screen, truncateText(btn.label, btn.w-12, g), g.font, btn.x+6, btn.y+18, color.White)
+	}
+}
+
+// truncateText обрезает строку с многоточием, чтобы она влезла в ширину
+func truncateText(s string, width int, g *Game) string {
+	if text.BoundString(g.font, s).Dx() <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && text.BoundString(g.font, string(runes)+"...").Dx() > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// drawRecentRolls показывает в углу экрана свежие броски, пока окно бросков закрыто
+func (g *Game) drawRecentRolls(screen *ebiten.Image) {
+	if _, open := g.scenes.Top().(*rollScene); open {
+		return
+	}
+
+	g.mu.Lock()
+	var recent []RollRecord
+	for i := len(g.rollLog) - 1; i >= 0 && len(recent) < rollToasts; i-- {
+		if time.Since(g.rollLog[i].seen) < rollToastTime {
+			recent = append(recent, g.rollLog[i])
+		}
+	}
+	g.mu.Unlock()
+
+	const w = 520
+	x, y := screenWidth-w-10, screenHeight-60
+	for _, rec := range recent {
+		head, body, clr := rollLines(rec)
+		ebitenutil.DrawRect(screen, float64(x), float64(y-2*rollLineH), w, 2*rollLineH+8, color.RGBA{0, 0, 0, 190})
+		text.Draw(screen, truncateText(head, w-16, g), g.font, x+8, y-rollLineH+2, color.RGBA{200, 200, 200, 255})
+		text.Draw(screen, truncateText(body, w-16, g), g.font, x+8, y+2, clr)
+		y -= 2*rollLineH + 14
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Scene - отдельный экран игры (карта мира, карта города, лист персонажа...)
+// со своим вводом и отрисовкой. Все сцены рисуют в логическом разрешении
+// screenWidth x screenHeight и сами переводят координаты курсора.
+type Scene interface {
+	Update(g *Game)
+	Draw(g *Game, screen *ebiten.Image)
+	// Overlay сообщает, что сцена рисуется поверх сцены под ней, а не вместо неё
+	Overlay() bool
+}
+
+// SceneStack - стек сцен. Ввод получает только верхняя сцена, а рисуются
+// верхняя непрозрачная сцена и все оверлеи над ней.
+type SceneStack struct {
+	scenes []Scene
+}
+
+func (s *SceneStack) Push(scene Scene) {
+	s.scenes = append(s.scenes, scene)
+	if debugMode {
+		log.Printf("[DEBUG] Открыта сцена %T, глубина стека %d", scene, len(s.scenes))
+	}
+}
+
+// Pop закрывает верхнюю сцену; нижняя сцена (карта мира) не закрывается
+func (s *SceneStack) Pop() {
+	if len(s.scenes) <= 1 {
+		return
+	}
+	top := s.scenes[len(s.scenes)-1]
+	s.scenes[len(s.scenes)-1] = nil
+	s.scenes = s.scenes[:len(s.scenes)-1]
+	if debugMode {
+		log.Printf("[DEBUG] Закрыта сцена %T, глубина стека %d", top, len(s.scenes))
+	}
+}
+
+func (s *SceneStack) Top() Scene {
+	if len(s.scenes) == 0 {
+		return nil
+	}
+	return s.scenes[len(s.scenes)-1]
+}
+
+func (s *SceneStack) Update(g *Game) {
+	if top := s.Top(); top != nil {
+		top.Update(g)
+	}
+}
+
+func (s *SceneStack) Draw(g *Game, screen *ebiten.Image) {
+	start := 0
+	for i := len(s.scenes) - 1; i >= 0; i-- {
+		if !s.scenes[i].Overlay() {
+			start = i
+			break
+		}
+	}
+	for _, scene := range s.scenes[start:] {
+		scene.Draw(g, screen)
+	}
+}
+
+// worldScene - карта мира: движение, камера, города и входы в подземелья
+type worldScene struct{}
+
+func (worldScene) Overlay() bool { return false }
+
+func (worldScene) Update(g *Game) {
+	g.handleMovementInput()
+	g.handleCameraInput()
+	g.handleCityGenerationInput()
+	g.updateHoverCity()
+	g.updatePartyPanel()
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		g.scenes.Push(cityListScene{})
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		g.openCharacterWindow()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyL) {
+		g.openRollWindow()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.openChat()
+	}
+	x, y := g.screenToWorld(ebiten.CursorPosition())
+	if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+		g.placeMarker(worldMapKey, x, y)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		g.toggleEditor()
+	}
+	if g.editor.Active {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			g.toggleEditor()
+			return
+		}
+		g.updateEditor(worldMapKey, x, y, g.inWorld(x, y))
+	}
+}
+
+func (worldScene) Draw(g *Game, screen *ebiten.Image) {
+	g.drawWorld(screen)
+	if g.editor.Active {
+		x, y := g.screenToWorld(ebiten.CursorPosition())
+		g.drawEditor(screen, worldMapKey, cellSize, g.worldToScreenF, x, y, g.inWorld(x, y))
+	}
+	g.drawPartyPanel(screen, 10)
+}
+
+// worldToScreen переводит клетку мира в пиксели экрана с учётом камеры
+func (g *Game) worldToScreen(x, y int) (int, int) {
+	return x*cellSize - g.cameraX, y*cellSize - g.cameraY
+}
+
+func (g *Game) worldToScreenF(x, y int) (float64, float64) {
+	sx, sy := g.worldToScreen(x, y)
+	return float64(sx), float64(sy)
+}
+
+// screenToWorld переводит пиксели экрана в клетку мира; деление с округлением
+// вниз, чтобы при отрицательном сдвиге камеры клетки не съезжали
+func (g *Game) screenToWorld(sx, sy int) (int, int) {
+	return floorDiv(sx+g.cameraX, cellSize), floorDiv(sy+g.cameraY, cellSize)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
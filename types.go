@@ -1,15 +1,19 @@
 package main
 
-import "image/color"
+import (
+	"image/color"
+	"time"
+
+	"test/internal/dungeon"
+	"test/internal/richtext"
+	"test/internal/rules"
+)
 
 const (
 	screenWidth                 = 1920
 	screenHeight                = 1080
 	cellSize                    = 16
 	serverPort                  = ":8080"
-	cityMapSize                 = 35
-	buildingSize                = 8
-	cityMapScale                = 4
 	TileStone                         // Новый тип тайла для каменных блоков
 	buildingSizeMultiplier      = 1.5 // Увеличиваем размер зданий
 	TileEmpty                   = 0
@@ -21,6 +25,9 @@ const (
 	TilePath                    = 6
 	TileSpecial                 = 7
 	TilePlaza                   = 8
+	TileGate                    = 9  // Городские ворота на въезде дороги
+	TileDock                    = 10 // Причалы на берегу
+	TileBridge                  = 11 // Мост, где улица пересекает реку
 	TileGrass                   = iota
 	minDistanceBetweenBuildings = 3
 	maxBuildingLevel            = 5
@@ -32,28 +39,250 @@ const (
 )
 
 type Player struct {
-	ID    string
+	ID         string
+	X, Y       int // Клетка на карте мира
+	Color      color.RGBA
+	Map        string      // Ключ карты города, где стоит фишка; пусто - фишка на карте мира
+	MapX, MapY int         // Клетка на карте города
+	Character  PartyMember // Персонаж игрока; пустое имя - игрок без персонажа
+}
+
+// PartyMember - сводка листа персонажа, которая ходит по сети вместе с фишкой
+type PartyMember struct {
+	Name  string
+	Class string
+	Level int
+	HP    string
+	AC    string
+}
+
+// Marker - метка мастера на карте мира или города
+type Marker struct {
+	ID     string
+	Map    string // worldMapKey или ключ города, см. cityMapKey
+	X, Y   int    // Клетка мира или тайл города
+	Title  string
+	Text   string
+	Icon   MarkerIcon
+	GMOnly bool // Видна только мастеру
+}
+
+// WorldState - мир, который сервер отправляет клиенту при подключении.
+// Неоткрытые игроку клетки приходят прозрачными, города и подземелья - только открытые.
+// Сид мира не отправляется: по нему игрок восстановил бы всю карту.
+type WorldState struct {
+	Tiles    [][]color.RGBA
+	Cities   [][]bool
+	CityList []City
+	Dungeons []DungeonSite
+	Markers  []Marker
+	You      Player // Фишка игрока: ID и место назначает сервер
+	Token    string // Ключ, чтобы переподключиться под тем же ID
+	Error    string // Почему сервер не впустил игрока; остальные поля пустые
+}
+
+// JoinRequest - первое сообщение клиента. ID и ключ - выданные этим сервером
+// в прошлый раз, для нового игрока пустые. Из Player берутся только цвет и
+// персонаж.
+type JoinRequest struct {
+	ID     string
+	Token  string
+	Player Player
+}
+
+// RevealedCell - открытая клетка: биом на карте мира или тип тайла на карте города
+type RevealedCell struct {
 	X, Y  int
-	Color color.RGBA
+	Value int
+}
+
+// FogUpdate - изменение тумана войны у игрока и содержимое открытых клеток
+type FogUpdate struct {
+	Map       string
+	Cells     []RevealedCell
+	Hidden    [][2]int // Клетки, которые мастер снова скрыл
+	Cities    []City
+	Dungeons  []DungeonSite
+	Buildings []Building
+	Markers   []Marker // Метки на открытых клетках
+}
+
+// CityState - открытая игроку часть карты города, отправляется при входе в город
+type CityState struct {
+	Map           string
+	Width, Height int
+	Entry         [2]int // Клетка, где фишка игрока появляется в городе
+	Fog           FogUpdate
 }
 
+// MapEdit - одна правка мастера: мазок кисти, изменение города или здания.
+// Хранит значения до и после, поэтому её можно отменить обратной правкой.
+type MapEdit struct {
+	Map      string     // worldMapKey или ключ города, см. cityMapKey
+	Cells    []CellEdit // Биомы мира или типы тайлов города
+	City     *CityChange
+	Building *BuildingChange
+}
+
+type CellEdit struct {
+	X, Y          int
+	Before, After int
+}
+
+// CityChange - добавление (Before == nil), удаление (After == nil) или перенос города
+type CityChange struct {
+	Before, After *City
+}
+
+// BuildingChange - то же для здания на карте города
+type BuildingChange struct {
+	Before, After *Building
+}
+
+// Виды сетевых сообщений после начального обмена миром
+const (
+	MsgPlayer       = "player"
+	MsgPlayerLeft   = "player_left"
+	MsgMarker       = "marker"
+	MsgMarkerDelete = "marker_delete"
+	MsgEdit         = "edit"
+	MsgFog          = "fog"
+	MsgEnterCity    = "enter_city" // Клиент просит карту города с ключом ID
+	MsgCityState    = "city_state"
+	MsgEnterDungeon = "enter_dungeon" // Клиент просит карту подземелья с ключом ID
+	MsgDungeon      = "dungeon"
+	MsgPortrait     = "portrait" // Портрет персонажа игрока ID
+	MsgRoll         = "roll"     // Клиент просит бросок, сервер рассылает результат
+	MsgChat         = "chat"
+)
+
+// ChatKind - вид сообщения чата
+type ChatKind int
+
+const (
+	ChatSay      ChatKind = iota // Обычная реплика, видят все
+	ChatEmote                    // /me: действие от третьего лица
+	ChatWhisper                  // /w: видят только отправитель и адресат
+	ChatAnnounce                 // Объявление мастера
+	ChatSystem                   // Подсказка или ошибка, только у того, кому она адресована
+)
+
+// ChatMessage - сообщение чата. Клиент заполняет только Kind и Text, остальное
+// проставляет сервер; в шёпоте текст начинается с адресата, сервер отделяет
+// его и записывает в To.
+type ChatMessage struct {
+	Kind   ChatKind
+	From   string // ID отправителя
+	Name   string // Имя персонажа или ID отправителя
+	To     string // ID адресата шёпота
+	ToName string
+	Text   string
+	Time   time.Time
+	seen   time.Time // Когда сообщение появилось у нас, чтобы гасить его в свёрнутом чате
+}
+
+// RollRecord - бросок костей в журнале. Клиент заполняет только Label,
+// Formula и Hidden, остальное проставляет сервер.
+type RollRecord struct {
+	Player    string // ID бросавшего игрока
+	Name      string // Имя персонажа или ID игрока
+	Label     string // Что бросали, например "Спасбросок: Ловкость"
+	Formula   string
+	Breakdown string // Разбивка по костям
+	Total     int
+	Hidden    bool   // Скрытый бросок мастера, игрокам не отправляется
+	Error     string // Ошибка в формуле; такой бросок видит только бросавший
+	Time      time.Time
+	seen      time.Time // Когда бросок появился у нас, для всплывающих подсказок
+}
+
+// NetMessage - сообщение между сервером и клиентом; заполнены только поля,
+// нужные для Kind
+type NetMessage struct {
+	Kind     string
+	ID       string
+	Player   *Player
+	Marker   *Marker
+	Edit     *MapEdit
+	Fog      *FogUpdate
+	City     *CityState
+	Dungeon  *dungeon.Dungeon // Карта подземелья ID без тайн мастера, см. playerDungeon
+	Portrait []byte           // PNG, уже уменьшенный до размера фишки
+	Roll     *RollRecord
+	Chat     *ChatMessage
+}
+
+// Character - персонаж, к которому приводятся все форматы листов, см.
+// characterFormats. json-теги задают собственный формат, см. nativeFormat.
 type Character struct {
-	Name        string
-	Class       string
-	Level       int
-	Race        string
-	Background  string
-	Stats       map[string]int
-	HP          string
-	AC          string
-	Description string
-	Notes       string
-	Spells      []string
-	Skills      []string
-	Equipment   []string
+	Name        string         `json:"name"`
+	Class       string         `json:"class,omitempty"`
+	Level       int            `json:"level,omitempty"`
+	Race        string         `json:"race,omitempty"`
+	Background  string         `json:"background,omitempty"`
+	Stats       map[string]int `json:"stats,omitempty"`
+	HP          string         `json:"hp,omitempty"`
+	AC          string         `json:"ac,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Notes       string         `json:"notes,omitempty"`
+	Spells      []string       `json:"spells,omitempty"`
+	Skills      []string       `json:"skills,omitempty"`
+	Equipment   []string       `json:"equipment,omitempty"`
+	Portrait    []byte         `json:"portrait,omitempty"` // Картинка из листа или файла рядом с ним, как есть
+	// Владение спасбросками по коду характеристики и навыками по коду навыка,
+	// см. rules.Skills
+	SaveProficiency  map[string]rules.Proficiency `json:"saveProficiency,omitempty"`
+	SkillProficiency map[string]rules.Proficiency `json:"skillProficiency,omitempty"`
+	SpellAbility     string                       `json:"spellAbility,omitempty"`  // Характеристика заклинаний из листа; пусто - по классу
+	Proficiencies    []string                     `json:"proficiencies,omitempty"` // Владения и языки, по строке на абзац листа
+	MaxHP            string                       `json:"maxHp,omitempty"`
+	TempHP           string                       `json:"tempHp,omitempty"`
+	Speed            string                       `json:"speed,omitempty"`
+	HitDice          string                       `json:"hitDice,omitempty"` // Оставшиеся кости хитов, например 3d8
+	DeathSaves       DeathSaves                   `json:"deathSaves"`
+	Weapons          []Weapon                     `json:"weapons,omitempty"`
+	Coins            Coins                        `json:"coins"`
+	SpellSlots       []SpellSlot                  `json:"spellSlots,omitempty"` // Только круги, в которых есть ячейки
+	Conditions       []string                     `json:"conditions,omitempty"` // Коды состояний, см. rules.Conditions
+	DescriptionDoc   *richtext.Doc                `json:"-"`                    // Описание и заметки с разметкой для окна персонажа
+	NotesDoc         *richtext.Doc                `json:"-"`
+	Path             string                       `json:"-"` // Файл листа, куда записываются правки
+	Format           string                       `json:"-"` // Формат файла листа, см. CharacterFormat
+	dirty            bool                         // Лист изменён в игре и ещё не записан
+}
+
+// SpellSlot - ячейки заклинаний одного круга
+type SpellSlot struct {
+	Level int `json:"level"`
+	Total int `json:"total"`
+	Used  int `json:"used"`
+}
+
+// Weapon - строка атаки из листа персонажа
+type Weapon struct {
+	Name   string `json:"name"`
+	Attack string `json:"attack,omitempty"` // Бонус атаки как в листе, например +5
+	Damage string `json:"damage,omitempty"` // Урон и тип, например 1d8+3 рубящий
+	Notes  string `json:"notes,omitempty"`
+}
+
+// Coins - кошелёк персонажа по монетам
+type Coins struct {
+	CP int `json:"cp,omitempty"`
+	SP int `json:"sp,omitempty"`
+	EP int `json:"ep,omitempty"`
+	GP int `json:"gp,omitempty"`
+	PP int `json:"pp,omitempty"`
+}
+
+// DeathSaves - отмеченные спасброски от смерти
+type DeathSaves struct {
+	Successes int `json:"successes,omitempty"`
+	Failures  int `json:"failures,omitempty"`
 }
 
 type City struct {
+	ID         int // Постоянный номер; по нему привязаны метки и правки города
 	Name       string
 	X, Y       int
 	Size       int
@@ -62,7 +291,6 @@ type City struct {
 
 type CityWindow struct {
 	game       *Game
-	open       bool
 	selected   *City
 	scrollY    int
 	hoverIndex int
@@ -73,16 +301,41 @@ type CityMap struct {
 	City      *City
 	Tiles     [][]color.Color
 	Buildings []Building
-	Open      bool
 	Grid      [][]int
+	Context   CityContext
+	Streets   *StreetGraph
+	View      CityView
+	Selected  int    // ID здания в панели сведений или -1
+	Editing   bool   // Идёт ввод заметок выбранного здания
+	Notes     string // Набираемые заметки; в здание попадают правкой, см. finishNotes
+}
+
+// DungeonSite - вход в подземелье на карте мира
+type DungeonSite struct {
+	Name  string
+	X, Y  int
+	Style dungeon.Style
+	Seed  int64 // Только на сервере: по сиду восстанавливаются все тайны подземелья
+}
+
+type DungeonMap struct {
+	Site    *DungeonSite
+	Dungeon *dungeon.Dungeon
 }
 
 type Building struct {
+	ID     int
 	X, Y   int    // Левый верхний угол
 	Width  int    // Ширина в тайлах
 	Height int    // Высота в тайлах
-	Type   string // residential/commercial
+	Size   int    // BuildingSmall/BuildingMedium/BuildingLarge
+	Type   string // house/tavern/smithy/temple/guildhall/keep
 	Level  int    // Этажность (1-5)
+	Owner  string
+	Notes  string // Заметки мастера
+	// Сид плана здания. Заполняется сервером при отправке игроку вместо
+	// сида города, по которому можно восстановить всю карту
+	PlanSeed int64
 }
 
 type point struct {
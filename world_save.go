@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	worldSavePath     = "saves/world.json"
+	worldSaveInterval = 5 * time.Second
+)
+
+// WorldSave - сохранение мира на сервере. Сам мир восстанавливается по сиду,
+// поэтому хранится только то, что нельзя сгенерировать заново.
+type WorldSave struct {
+	Seed       int64                `json:"seed"`
+	Markers    []Marker             `json:"markers"`
+	Cities     []City               `json:"cities,omitempty"`      // Города с учётом правок мастера
+	WorldTiles []CellEdit           `json:"world_tiles,omitempty"` // Перекрашенные клетки мира
+	CityEdits  map[string][]MapEdit `json:"city_edits,omitempty"`
+	// Окрестности правленых городов на момент первой правки, см. applyEdit
+	CityLayouts map[string]CityContext `json:"city_layouts,omitempty"`
+	// Исследованные клетки: игрок -> карта -> клетки; "*" - открытые мастером всем
+	Exploration map[string]map[string][][2]int `json:"exploration,omitempty"`
+	Chat        []ChatMessage                  `json:"chat,omitempty"`
+	Players     []playerAccount                `json:"players,omitempty"` // ID, ключи и места фишек игроков
+}
+
+// loadWorld берёт сид и метки сохранённого мира, если он есть. Правки карты
+// возвращаются через restoreWorld уже после генерации мира по этому сиду.
+func (g *Game) loadWorld() *WorldSave {
+	save, err := readWorldSave(worldSavePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("[ERROR] Ошибка загрузки мира: %v", err)
+		return nil
+	}
+
+	g.mu.Lock()
+	g.seed = save.Seed
+	g.markers = make(map[string]*Marker, len(save.Markers))
+	for i := range save.Markers {
+		g.markers[save.Markers[i].ID] = &save.Markers[i]
+	}
+	g.accounts = loadAccounts(save.Players)
+	g.exploration = loadExploration(save.Exploration)
+	// Туман игроков, которых сервер не знает (сохранения до выдачи ID
+	// сервером), достался бы новичку с тем же ID
+	for pid := range g.exploration {
+		if pid != partyFogKey && g.accounts[pid] == nil {
+			delete(g.exploration, pid)
+		}
+	}
+	g.chatLog = g.loadChat(save.Chat)
+	g.mu.Unlock()
+
+	if debugMode {
+		log.Printf("[DEBUG] Загружен мир %d, меток: %d", save.Seed, len(save.Markers))
+	}
+	return save
+}
+
+// saveWorld записывает мир на диск; клиенты мир не сохраняют
+func (g *Game) saveWorld() {
+	if g.mode != "server" {
+		return
+	}
+
+	g.mu.Lock()
+	save := WorldSave{
+		Seed:        g.seed,
+		Markers:     make([]Marker, 0, len(g.markers)),
+		Cities:      g.cityListCopy(),
+		WorldTiles:  g.worldTileEdits(),
+		CityEdits:   g.cityEdits,
+		CityLayouts: g.cityLayouts,
+		Exploration: g.explorationSave(),
+		Chat:        g.chatSave(),
+		Players:     g.accountsSave(),
+	}
+	for _, m := range g.markers {
+		save.Markers = append(save.Markers, *m)
+	}
+	sort.Slice(save.Markers, func(i, j int) bool { return save.Markers[i].ID < save.Markers[j].ID })
+	// Журналы правок разделяются с сетевой горутиной, поэтому кодируем под блокировкой
+	content, err := json.MarshalIndent(save, "", "  ")
+	g.worldDirty = false
+	g.worldSavedAt = time.Now()
+	g.mu.Unlock()
+
+	if err == nil {
+		err = writeFileAtomic(worldSavePath, content)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Ошибка сохранения мира: %v", err)
+	}
+}
+
+// saveWorldIfDirty сохраняет мир, если игроки что-то исследовали или написали
+// в чат, но не чаще worldSaveInterval: фишки двигаются часто, а сохранение
+// пишет весь файл
+func (g *Game) saveWorldIfDirty() {
+	g.mu.Lock()
+	due := g.worldDirty && time.Since(g.worldSavedAt) >= worldSaveInterval
+	g.mu.Unlock()
+	if due {
+		g.saveWorld()
+	}
+}
+
+func readWorldSave(path string) (*WorldSave, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var save WorldSave
+	if err := json.Unmarshal(data, &save); err != nil {
+		return nil, fmt.Errorf("ошибка разбора %s: %w", path, err)
+	}
+	return &save, nil
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Пишем во временный файл, чтобы сбой не оставил обрезанное сохранение
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}